@@ -0,0 +1,340 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/log"
+	"code.cloudfoundry.org/eirini-persi-broker/usage"
+)
+
+// Instance describes a service instance and the state of its persistent
+// volume claim. Names are only known if the platform sent them.
+type Instance struct {
+	ID               string       `json:"id"`
+	ClaimName        string       `json:"claim_name"`
+	Name             string       `json:"name,omitempty"`
+	ServiceID        string       `json:"service_id"`
+	PlanID           string       `json:"plan_id"`
+	PlanName         string       `json:"plan_name,omitempty"`
+	OrganizationID   string       `json:"organization_id"`
+	OrganizationName string       `json:"organization_name,omitempty"`
+	SpaceID          string       `json:"space_id"`
+	SpaceName        string       `json:"space_name,omitempty"`
+	Size             string       `json:"size"`
+	Capacity         string       `json:"capacity,omitempty"`
+	AccessMode       string       `json:"access_mode,omitempty"`
+	StorageClass     string       `json:"storage_class,omitempty"`
+	Phase            string       `json:"phase"`
+	VolumeName       string       `json:"volume_name,omitempty"`
+	CreatedAt        time.Time    `json:"created_at"`
+	CreatedBy        string       `json:"created_by,omitempty"`
+	LastModifiedBy   string       `json:"last_modified_by,omitempty"`
+	DeletedAt        *time.Time   `json:"deleted_at,omitempty"`
+	Bindings         []Binding    `json:"bindings"`
+	Backups          []Backup     `json:"backups,omitempty"`
+	Usage            *usage.Usage `json:"usage,omitempty"`
+}
+
+// Backup is a backup of an instance in the backup store of its plan
+type Backup struct {
+	ID    string    `json:"id"`
+	Time  time.Time `json:"time"`
+	State string    `json:"state"`
+}
+
+// Binding is a service binding recorded on an instance. Shared is set for
+// bindings in other spaces than the instance. Service keys have no directory
+// but the protocol their access server serves the volume over.
+type Binding struct {
+	ID        string `json:"id"`
+	Directory string `json:"dir"`
+	SpaceID   string `json:"space_id,omitempty"`
+	Shared    bool   `json:"shared"`
+	Protocol  string `json:"protocol,omitempty"`
+}
+
+// InstanceBinding is a binding together with the instance it belongs to
+type InstanceBinding struct {
+	InstanceID string `json:"instance_id"`
+	Binding
+}
+
+// Filter selects instances by their labels. Empty fields match all instances.
+// The plan may be given by ID or name. Deprovisioned instances that are
+// retained are only selected if Deleted is set. Shared only applies to
+// bindings and selects those in other spaces than their instance.
+type Filter struct {
+	Plan           string
+	OrganizationID string
+	SpaceID        string
+	Deleted        bool
+	Shared         bool
+}
+
+// Error is returned for requests about instances that don't exist or are in
+// the wrong state. StatusCode is the HTTP status the admin API responds with.
+type Error struct {
+	Message    string
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &Error{Message: fmt.Sprintf(format, args...), StatusCode: http.StatusNotFound}
+}
+
+func conflict(format string, args ...interface{}) error {
+	return &Error{Message: fmt.Sprintf(format, args...), StatusCode: http.StatusConflict}
+}
+
+// Admin inspects and manages the instances of a broker on behalf of operators
+type Admin struct {
+	Broker *broker.KubeVolumeBroker
+}
+
+// List returns the instances matching filter, sorted by ID
+func (a *Admin) List(ctx context.Context, filter Filter) ([]Instance, error) {
+	pvcs, err := a.listClaims(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]Instance, len(pvcs))
+	for i := range pvcs {
+		instances[i] = a.instance(&pvcs[i])
+	}
+
+	return instances, nil
+}
+
+// Get returns the instance with instanceID, including its volume usage if
+// the broker knows it. Usage is left out if it can't be determined.
+func (a *Admin) Get(ctx context.Context, instanceID string) (Instance, error) {
+	pvc, err := a.claim(ctx, instanceID)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	instance := a.instance(pvc)
+	if a.Broker.Usage != nil {
+		usageCtx, cancel := context.WithTimeout(ctx, a.Broker.KubeTimeout())
+		defer cancel()
+
+		instance.Usage, err = a.Broker.Usage.Usage(usageCtx, pvc)
+		if err != nil {
+			logger := a.Broker.Logger
+			if logger == nil {
+				logger = log.Logger()
+			}
+			logger.Error("get-usage", err, lager.Data{"instance-id": instanceID})
+		}
+	}
+
+	return instance, nil
+}
+
+// Bindings returns the bindings of the instances matching filter
+func (a *Admin) Bindings(ctx context.Context, filter Filter) ([]InstanceBinding, error) {
+	instances, err := a.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := []InstanceBinding{}
+	for _, instance := range instances {
+		for _, binding := range instance.Bindings {
+			if filter.Shared && !binding.Shared {
+				continue
+			}
+			bindings = append(bindings, InstanceBinding{InstanceID: instance.ID, Binding: binding})
+		}
+	}
+
+	return bindings, nil
+}
+
+// Undelete restores a deprovisioned instance whose persistent volume claim
+// is still retained. The platform doesn't know about the instance anymore,
+// so it has to be registered with the platform again to be used.
+func (a *Admin) Undelete(ctx context.Context, instanceID string) error {
+	pvc, err := a.claim(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	if !broker.IsDeleted(pvc) {
+		return conflict("instance %s isn't deleted", instanceID)
+	}
+
+	return a.Broker.Restore(ctx, instanceID)
+}
+
+// ForceUnbind removes a binding from an instance without a request from the
+// platform, e.g. when the platform has forgotten about it. The broker records
+// it like any other unbinding.
+func (a *Admin) ForceUnbind(ctx context.Context, instanceID, bindingID string) error {
+	pvc, err := a.claim(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Broker.Unbind(ctx, instanceID, bindingID, brokerapi.UnbindDetails{
+		PlanID:    pvc.Labels[broker.PlanIDLabel],
+		ServiceID: pvc.Labels[broker.ServiceIDLabel],
+	}, false)
+	if err == brokerapi.ErrBindingDoesNotExist {
+		return notFound("instance %s has no binding %s", instanceID, bindingID)
+	}
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return conflict("instance %s is deleted", instanceID)
+	}
+
+	return err
+}
+
+// claim returns the persistent volume claim of an instance of the broker's service
+func (a *Admin) claim(ctx context.Context, instanceID string) (*corev1.PersistentVolumeClaim, error) {
+	pvc, err := a.Broker.Claim(ctx, instanceID)
+	if apierrors.IsNotFound(err) || (err == nil && pvc.Labels[broker.ServiceIDLabel] != a.Broker.Config.ServiceConfiguration.ServiceID) {
+		return nil, notFound("instance %s doesn't exist", instanceID)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting instance %s", instanceID)
+	}
+
+	return pvc, nil
+}
+
+// listClaims returns the persistent volume claims of the instances matching
+// filter, sorted by instance ID
+func (a *Admin) listClaims(ctx context.Context, filter Filter) ([]corev1.PersistentVolumeClaim, error) {
+	instanceSelector := broker.InstanceSelector(a.Broker.Config)
+	if filter.Deleted {
+		instanceSelector = broker.DeletedSelector(a.Broker.Config)
+	}
+
+	selector, err := labels.Parse(instanceSelector)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing instance selector")
+	}
+	for key, value := range map[string]string{
+		broker.PlanIDLabel:         a.planID(filter.Plan),
+		broker.OrganizationIDLabel: filter.OrganizationID,
+		broker.SpaceIDLabel:        filter.SpaceID,
+	} {
+		if value == "" {
+			continue
+		}
+		requirement, err := labels.NewRequirement(key, selection.Equals, []string{value})
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid filter %s", value)
+		}
+		selector = selector.Add(*requirement)
+	}
+
+	kubeCtx, cancel := context.WithTimeout(ctx, a.Broker.KubeTimeout())
+	defer cancel()
+
+	pvcs, err := a.Broker.KubeClient.CoreV1().PersistentVolumeClaims(a.Broker.Config.Namespace).List(kubeCtx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing instances")
+	}
+
+	sort.Slice(pvcs.Items, func(i, j int) bool {
+		return broker.InstanceID(&pvcs.Items[i]) < broker.InstanceID(&pvcs.Items[j])
+	})
+
+	return pvcs.Items, nil
+}
+
+// planID returns the ID of the plan named nameOrID, or nameOrID itself if
+// there's no plan with that name
+func (a *Admin) planID(nameOrID string) string {
+	for _, plan := range a.Broker.Config.ServiceConfiguration.Plans {
+		if plan.Name == nameOrID {
+			return plan.ID
+		}
+	}
+
+	return nameOrID
+}
+
+func (a *Admin) instance(pvc *corev1.PersistentVolumeClaim) Instance {
+	instance := Instance{
+		ID:               broker.InstanceID(pvc),
+		ClaimName:        pvc.Name,
+		Name:             pvc.Annotations[broker.InstanceNameAnnotation],
+		ServiceID:        pvc.Labels[broker.ServiceIDLabel],
+		PlanID:           pvc.Labels[broker.PlanIDLabel],
+		OrganizationID:   pvc.Labels[broker.OrganizationIDLabel],
+		OrganizationName: pvc.Annotations[broker.OrganizationNameAnnotation],
+		SpaceID:          pvc.Labels[broker.SpaceIDLabel],
+		SpaceName:        pvc.Annotations[broker.SpaceNameAnnotation],
+		Phase:            string(pvc.Status.Phase),
+		VolumeName:       pvc.Spec.VolumeName,
+		CreatedAt:        pvc.CreationTimestamp.Time,
+		CreatedBy:        pvc.Annotations[broker.CreatedByAnnotation],
+		LastModifiedBy:   pvc.Annotations[broker.LastModifiedByAnnotation],
+		Bindings:         []Binding{},
+	}
+
+	if deletedAt, ok := broker.DeletedAt(pvc); ok && broker.IsDeleted(pvc) {
+		instance.DeletedAt = &deletedAt
+	}
+	if plan := a.Broker.Plan(instance.PlanID); plan != nil {
+		instance.PlanName = plan.Name
+	}
+	if requested, ok := pvc.Spec.Resources.Requests["storage"]; ok {
+		instance.Size = requested.String()
+	}
+	if capacity, ok := pvc.Status.Capacity["storage"]; ok {
+		instance.Capacity = capacity.String()
+	}
+	if len(pvc.Spec.AccessModes) > 0 {
+		instance.AccessMode = string(pvc.Spec.AccessModes[0])
+	}
+	if pvc.Spec.StorageClassName != nil {
+		instance.StorageClass = *pvc.Spec.StorageClassName
+	}
+
+	for key, dir := range pvc.Annotations {
+		if broker.IsBindingIDAnnotation(key) {
+			bindingID := broker.BindingIDFromAnnotation(key)
+			space := pvc.Annotations[broker.BindingSpaceAnnotation(bindingID)]
+			instance.Bindings = append(instance.Bindings, Binding{
+				ID:        bindingID,
+				Directory: dir,
+				SpaceID:   space,
+				Shared:    space != "" && space != instance.SpaceID,
+				Protocol:  pvc.Annotations[broker.ServiceKeyAnnotation(bindingID)],
+			})
+		}
+	}
+	sort.Slice(instance.Bindings, func(i, j int) bool {
+		return instance.Bindings[i].ID < instance.Bindings[j].ID
+	})
+
+	for _, backup := range broker.Backups(pvc) {
+		instance.Backups = append(instance.Backups, Backup{ID: backup.ID, Time: backup.Time, State: string(backup.State)})
+	}
+
+	return instance
+}
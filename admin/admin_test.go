@@ -0,0 +1,306 @@
+package admin_test
+
+import (
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/admin"
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/usage"
+)
+
+var gold = "gold"
+
+type fakeUsageSource struct {
+	usage *usage.Usage
+	err   error
+}
+
+func (s *fakeUsageSource) Usage(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*usage.Usage, error) {
+	return s.usage, s.err
+}
+
+func claim(name, planID, spaceID string, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "eirini",
+			Labels: map[string]string{
+				broker.ServiceIDLabel:      "service-id",
+				broker.PlanIDLabel:         planID,
+				broker.OrganizationIDLabel: "org",
+				broker.SpaceIDLabel:        spaceID,
+			},
+			Annotations: annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &gold,
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{"storage": resource.MustParse("1Gi")},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+}
+
+var _ = Describe("Admin", func() {
+	var (
+		objects    []runtime.Object
+		kubeClient *fake.Clientset
+		instances  *admin.Admin
+	)
+
+	BeforeEach(func() {
+		objects = []runtime.Object{
+			claim("instance-b", "gold-plan", "space", map[string]string{
+				"eirini-broker-binding-binding-2":          "/data",
+				broker.BindingSpaceAnnotation("binding-2"): "shared-space",
+				"eirini-broker-binding-binding-1":          "/var/vcap/data/binding-1",
+				"eirini-broker-binding-binding-3":          "",
+				broker.ServiceKeyAnnotation("binding-3"):   "sftp",
+				broker.CreatedByAnnotation:                 "user",
+				broker.InstanceNameAnnotation:              "my volume",
+				broker.SpaceNameAnnotation:                 "dev",
+				"pv.kubernetes.io/bind-completed":          "yes",
+				"volume.beta.kubernetes.io/storage":        "gold",
+			}),
+			claim("instance-a", "gold-plan", "other-space", nil),
+			claim("instance-c", "silver-plan", "space", nil),
+			&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "eirini"},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset(objects...)
+		instances = &admin.Admin{
+			Broker: &broker.KubeVolumeBroker{
+				KubeClient: kubeClient,
+				Config: config.Config{
+					Namespace: "eirini",
+					ServiceConfiguration: config.ServiceConfiguration{
+						ServiceID: "service-id",
+						Plans: []config.Plan{
+							{ID: "gold-plan", Name: "gold", StorageClass: &gold},
+						},
+					},
+				},
+				Context: context.Background(),
+				Logger:  lagertest.NewTestLogger("admin"),
+			},
+		}
+	})
+
+	Describe("List", func() {
+		It("lists the instances of the service sorted by id", func() {
+			list, err := instances.List(context.Background(), admin.Filter{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(list).To(HaveLen(3))
+			Expect(list[0].ID).To(Equal("instance-a"))
+			Expect(list[1].ID).To(Equal("instance-b"))
+			Expect(list[2].ID).To(Equal("instance-c"))
+		})
+
+		It("describes the instances", func() {
+			list, err := instances.List(context.Background(), admin.Filter{})
+			Expect(err).ToNot(HaveOccurred())
+
+			instance := list[1]
+			Expect(instance.PlanID).To(Equal("gold-plan"))
+			Expect(instance.PlanName).To(Equal("gold"))
+			Expect(instance.OrganizationID).To(Equal("org"))
+			Expect(instance.SpaceID).To(Equal("space"))
+			Expect(instance.Name).To(Equal("my volume"))
+			Expect(instance.OrganizationName).To(BeEmpty())
+			Expect(instance.SpaceName).To(Equal("dev"))
+			Expect(instance.Size).To(Equal("1Gi"))
+			Expect(instance.AccessMode).To(Equal("ReadWriteMany"))
+			Expect(instance.StorageClass).To(Equal("gold"))
+			Expect(instance.Phase).To(Equal("Bound"))
+			Expect(instance.CreatedBy).To(Equal("user"))
+			Expect(instance.Bindings).To(Equal([]admin.Binding{
+				{ID: "binding-1", Directory: "/var/vcap/data/binding-1"},
+				{ID: "binding-2", Directory: "/data", SpaceID: "shared-space", Shared: true},
+				{ID: "binding-3", Protocol: "sftp"},
+			}))
+		})
+
+		It("filters by plan name and space", func() {
+			list, err := instances.List(context.Background(), admin.Filter{Plan: "gold", SpaceID: "space"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(list).To(HaveLen(1))
+			Expect(list[0].ID).To(Equal("instance-b"))
+		})
+
+		It("filters by plan id", func() {
+			list, err := instances.List(context.Background(), admin.Filter{Plan: "silver-plan"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(list).To(HaveLen(1))
+			Expect(list[0].ID).To(Equal("instance-c"))
+		})
+	})
+
+	Describe("Get", func() {
+		It("returns the instance", func() {
+			instance, err := instances.Get(context.Background(), "instance-b")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(instance.ID).To(Equal("instance-b"))
+			Expect(instance.Bindings).To(HaveLen(3))
+		})
+
+		It("includes the volume usage", func() {
+			instances.Broker.Usage = &fakeUsageSource{usage: &usage.Usage{UsedBytes: 300, AvailableBytes: 700}}
+
+			instance, err := instances.Get(context.Background(), "instance-b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.Usage).To(Equal(&usage.Usage{UsedBytes: 300, AvailableBytes: 700}))
+		})
+
+		It("leaves out the usage if it can't be determined", func() {
+			instances.Broker.Usage = &fakeUsageSource{err: errors.New("kubelet unreachable")}
+
+			instance, err := instances.Get(context.Background(), "instance-b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.ID).To(Equal("instance-b"))
+			Expect(instance.Usage).To(BeNil())
+		})
+
+		It("leaves out the usage without a broker logger", func() {
+			instances.Broker.Logger = nil
+			instances.Broker.Usage = &fakeUsageSource{err: errors.New("kubelet unreachable")}
+
+			instance, err := instances.Get(context.Background(), "instance-b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.Usage).To(BeNil())
+		})
+
+		It("fails for claims that aren't instances of the service", func() {
+			_, err := instances.Get(context.Background(), "unrelated")
+			Expect(err).To(MatchError("instance unrelated doesn't exist"))
+		})
+	})
+
+	Describe("ForceUnbind", func() {
+		It("removes the binding on behalf of the operator", func() {
+			ctx := broker.WithOriginatingIdentity(context.Background(), broker.OriginatingIdentity{
+				Platform: "persi-admin",
+				UserID:   "operator",
+			})
+
+			Expect(instances.ForceUnbind(ctx, "instance-b", "binding-2")).To(Succeed())
+
+			pvc, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").Get(context.Background(), "instance-b", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pvc.Annotations).ToNot(HaveKey("eirini-broker-binding-binding-2"))
+			Expect(pvc.Annotations).ToNot(HaveKey(broker.BindingSpaceAnnotation("binding-2")))
+			Expect(pvc.Annotations).To(HaveKey("eirini-broker-binding-binding-1"))
+			Expect(pvc.Annotations).To(HaveKeyWithValue(broker.LastModifiedByAnnotation, "operator"))
+		})
+
+		It("fails for unknown bindings", func() {
+			err := instances.ForceUnbind(context.Background(), "instance-a", "binding-1")
+			Expect(err).To(MatchError("instance instance-a has no binding binding-1"))
+		})
+	})
+
+	Describe("Export and Import", func() {
+		It("exports the labels, broker annotations and storage of instances", func() {
+			exported, err := instances.Export(context.Background(), admin.Filter{Plan: "gold"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(exported).To(HaveLen(2))
+			Expect(exported[1]).To(Equal(admin.InstanceMetadata{
+				ID:     "instance-b",
+				Labels: objects[0].(*corev1.PersistentVolumeClaim).Labels,
+				Annotations: map[string]string{
+					"eirini-broker-binding-binding-2":          "/data",
+					broker.BindingSpaceAnnotation("binding-2"): "shared-space",
+					"eirini-broker-binding-binding-1":          "/var/vcap/data/binding-1",
+					"eirini-broker-binding-binding-3":          "",
+					broker.ServiceKeyAnnotation("binding-3"):   "sftp",
+					broker.CreatedByAnnotation:                 "user",
+					broker.InstanceNameAnnotation:              "my volume",
+					broker.SpaceNameAnnotation:                 "dev",
+				},
+				StorageClass: &gold,
+				Size:         "1Gi",
+				AccessModes:  []string{"ReadWriteMany"},
+			}))
+		})
+
+		It("recreates missing instances and restores the metadata of existing ones", func() {
+			exported, err := instances.Export(context.Background(), admin.Filter{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(kubeClient.CoreV1().PersistentVolumeClaims("eirini").Delete(context.Background(), "instance-a", metav1.DeleteOptions{})).To(Succeed())
+			_, err = kubeClient.CoreV1().PersistentVolumeClaims("eirini").Patch(context.Background(), "instance-b", types.MergePatchType,
+				[]byte(`{"metadata":{"annotations":{"eirini-broker-binding-binding-1":null}}}`), metav1.PatchOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			results, err := instances.Import(context.Background(), exported, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(Equal([]admin.ImportResult{
+				{ID: "instance-a", Outcome: admin.ImportCreated},
+				{ID: "instance-b", Outcome: admin.ImportUpdated},
+				{ID: "instance-c", Outcome: admin.ImportUnchanged},
+			}))
+
+			recreated, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").Get(context.Background(), "instance-a", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(recreated.Labels).To(HaveKeyWithValue(broker.PlanIDLabel, "gold-plan"))
+			Expect(recreated.Spec.Resources.Requests.Storage().String()).To(Equal("1Gi"))
+
+			restored, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").Get(context.Background(), "instance-b", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(restored.Annotations).To(HaveKeyWithValue("eirini-broker-binding-binding-1", "/var/vcap/data/binding-1"))
+			Expect(restored.Annotations).To(HaveKey("pv.kubernetes.io/bind-completed"))
+		})
+
+		It("changes nothing in a dry run", func() {
+			results, err := instances.Import(context.Background(), []admin.InstanceMetadata{{
+				ID:     "instance-d",
+				Labels: map[string]string{broker.ServiceIDLabel: "service-id"},
+				Size:   "1Gi",
+			}}, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(Equal([]admin.ImportResult{{ID: "instance-d", Outcome: admin.ImportCreated}}))
+
+			_, err = kubeClient.CoreV1().PersistentVolumeClaims("eirini").Get(context.Background(), "instance-d", metav1.GetOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("refuses instances of other services", func() {
+			_, err := instances.Import(context.Background(), []admin.InstanceMetadata{{
+				ID:     "instance-d",
+				Labels: map[string]string{broker.ServiceIDLabel: "other-service"},
+			}}, false)
+			Expect(err).To(MatchError("instance instance-d doesn't belong to service service-id"))
+		})
+
+		It("refuses annotations the broker doesn't manage", func() {
+			_, err := instances.Import(context.Background(), []admin.InstanceMetadata{{
+				ID:          "instance-d",
+				Labels:      map[string]string{broker.ServiceIDLabel: "service-id"},
+				Annotations: map[string]string{"pv.kubernetes.io/bind-completed": "yes"},
+			}}, false)
+			Expect(err).To(MatchError("annotation pv.kubernetes.io/bind-completed of instance instance-d isn't managed by the broker"))
+		})
+	})
+})
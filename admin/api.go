@@ -0,0 +1,218 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi/auth"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/reconciler"
+)
+
+// APIPrefix is the path the admin API is served under
+const APIPrefix = "/admin/v1"
+
+// API serves the admin operations over HTTP, authenticated with the admin
+// credentials from the configuration. Reconciler may be nil if reconciliation
+// can't be triggered. Reconciliation repairs issues, so it's only triggered
+// on the replica running the background workers, if IsLeader is set.
+type API struct {
+	Admin      *Admin
+	Reconciler *reconciler.Reconciler
+	IsLeader   func() bool
+	Config     config.AdminConfiguration
+	Logger     lager.Logger
+}
+
+// errorResponse is the body of failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler returns the handler of all admin API routes. The OpenAPI document
+// describing them is served without authentication.
+func (a *API) Handler() http.Handler {
+	authenticated := mux.NewRouter()
+	authenticated.HandleFunc(APIPrefix+"/instances", a.listInstances).Methods(http.MethodGet)
+	authenticated.HandleFunc(APIPrefix+"/instances/{instance_id}", a.getInstance).Methods(http.MethodGet)
+	authenticated.HandleFunc(APIPrefix+"/instances/{instance_id}/bindings", a.getInstanceBindings).Methods(http.MethodGet)
+	authenticated.HandleFunc(APIPrefix+"/instances/{instance_id}/events", a.getEvents).Methods(http.MethodGet)
+	authenticated.HandleFunc(APIPrefix+"/instances/{instance_id}/undelete", a.undelete).Methods(http.MethodPost)
+	authenticated.HandleFunc(APIPrefix+"/bindings", a.listBindings).Methods(http.MethodGet)
+	authenticated.HandleFunc(APIPrefix+"/reconcile", a.reconcile).Methods(http.MethodPost)
+
+	router := mux.NewRouter()
+	router.HandleFunc(APIPrefix+"/openapi.json", serveOpenAPIDocument).Methods(http.MethodGet)
+	router.PathPrefix(APIPrefix + "/").Handler(auth.NewWrapper(a.Config.Username, a.Config.Password).Wrap(authenticated))
+
+	return router
+}
+
+func (a *API) listInstances(w http.ResponseWriter, req *http.Request) {
+	filter, err := parseFilter(req)
+	if err != nil {
+		a.respondError(w, req, "list-instances", err)
+		return
+	}
+
+	instances, err := a.Admin.List(req.Context(), filter)
+	if err != nil {
+		a.respondError(w, req, "list-instances", err)
+		return
+	}
+
+	respond(w, http.StatusOK, instances)
+}
+
+func (a *API) getInstance(w http.ResponseWriter, req *http.Request) {
+	instance, err := a.Admin.Get(req.Context(), mux.Vars(req)["instance_id"])
+	if err != nil {
+		a.respondError(w, req, "get-instance", err)
+		return
+	}
+
+	respond(w, http.StatusOK, instance)
+}
+
+func (a *API) getInstanceBindings(w http.ResponseWriter, req *http.Request) {
+	instance, err := a.Admin.Get(req.Context(), mux.Vars(req)["instance_id"])
+	if err != nil {
+		a.respondError(w, req, "get-instance-bindings", err)
+		return
+	}
+
+	respond(w, http.StatusOK, instance.Bindings)
+}
+
+func (a *API) getEvents(w http.ResponseWriter, req *http.Request) {
+	events, err := a.Admin.RecentEvents(req.Context(), mux.Vars(req)["instance_id"])
+	if err != nil {
+		a.respondError(w, req, "get-events", err)
+		return
+	}
+
+	respond(w, http.StatusOK, events)
+}
+
+func (a *API) undelete(w http.ResponseWriter, req *http.Request) {
+	instanceID := mux.Vars(req)["instance_id"]
+	if err := a.Admin.Undelete(operatorContext(req), instanceID); err != nil {
+		a.respondError(w, req, "undelete", err)
+		return
+	}
+
+	instance, err := a.Admin.Get(req.Context(), instanceID)
+	if err != nil {
+		a.respondError(w, req, "undelete", err)
+		return
+	}
+
+	respond(w, http.StatusOK, instance)
+}
+
+func (a *API) listBindings(w http.ResponseWriter, req *http.Request) {
+	filter, err := parseFilter(req)
+	if err != nil {
+		a.respondError(w, req, "list-bindings", err)
+		return
+	}
+
+	bindings, err := a.Admin.Bindings(req.Context(), filter)
+	if err != nil {
+		a.respondError(w, req, "list-bindings", err)
+		return
+	}
+
+	respond(w, http.StatusOK, bindings)
+}
+
+func (a *API) reconcile(w http.ResponseWriter, req *http.Request) {
+	if a.Reconciler == nil {
+		a.respondError(w, req, "reconcile", &Error{Message: "reconciliation isn't available", StatusCode: http.StatusNotImplemented})
+		return
+	}
+	if a.IsLeader != nil && !a.IsLeader() {
+		a.respondError(w, req, "reconcile", &Error{Message: "reconciliation only runs on the leader replica", StatusCode: http.StatusServiceUnavailable})
+		return
+	}
+
+	issues, err := a.Reconciler.Reconcile(req.Context())
+	if err != nil {
+		a.respondError(w, req, "reconcile", err)
+		return
+	}
+	if issues == nil {
+		issues = []reconciler.Issue{}
+	}
+
+	respond(w, http.StatusOK, issues)
+}
+
+// respondError logs err and responds with its status, or an internal server
+// error for unexpected errors
+func (a *API) respondError(w http.ResponseWriter, req *http.Request, action string, err error) {
+	statusCode := http.StatusInternalServerError
+	if adminErr, ok := err.(*Error); ok {
+		statusCode = adminErr.StatusCode
+	}
+
+	a.Logger.Error(action, err, lager.Data{
+		"path":   req.URL.Path,
+		"status": statusCode,
+	})
+	respond(w, statusCode, errorResponse{Error: err.Error()})
+}
+
+// operatorContext returns the context of a request that changes an instance,
+// naming the admin user as the originating identity
+func operatorContext(req *http.Request) context.Context {
+	username, _, _ := req.BasicAuth()
+	return broker.WithOriginatingIdentity(req.Context(), broker.OriginatingIdentity{
+		Platform: "admin-api",
+		UserID:   username,
+	})
+}
+
+// parseFilter reads the plan, org, space, deleted and shared query parameters
+func parseFilter(req *http.Request) (Filter, error) {
+	query := req.URL.Query()
+
+	filter := Filter{
+		Plan:           query.Get("plan"),
+		OrganizationID: query.Get("org"),
+		SpaceID:        query.Get("space"),
+	}
+
+	for name, value := range map[string]*bool{
+		"deleted": &filter.Deleted,
+		"shared":  &filter.Shared,
+	} {
+		if query.Get(name) == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(query.Get(name))
+		if err != nil {
+			return filter, &Error{Message: name + " must be true or false", StatusCode: http.StatusBadRequest}
+		}
+		*value = parsed
+	}
+
+	return filter, nil
+}
+
+func respond(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+func serveOpenAPIDocument(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(OpenAPIDocument))
+}
@@ -0,0 +1,221 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/admin"
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+	"code.cloudfoundry.org/eirini-persi-broker/reconciler"
+)
+
+var _ = Describe("API", func() {
+	var (
+		kubeClient *fake.Clientset
+		api        *admin.API
+		handler    http.Handler
+	)
+
+	BeforeEach(func() {
+		deleted := claim("instance-d", "gold-plan", "space", map[string]string{
+			broker.DeletedAtAnnotation: "2020-08-01T10:00:00Z",
+		})
+		deleted.Labels[broker.DeletedLabel] = "true"
+
+		kubeClient = fake.NewSimpleClientset(
+			claim("instance-a", "gold-plan", "space", map[string]string{
+				"eirini-broker-binding-binding-1": "/data",
+			}),
+			claim("instance-b", "gold-plan", "other-space", nil),
+			deleted,
+			&corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "instance-a.2", Namespace: "eirini"},
+				InvolvedObject: corev1.ObjectReference{Kind: "PersistentVolumeClaim", Name: "instance-a"},
+				Type:           corev1.EventTypeNormal,
+				Reason:         broker.ReasonBound,
+				Message:        "Created service binding binding-1",
+				Count:          1,
+				LastTimestamp:  metav1.NewTime(time.Date(2020, 8, 1, 11, 0, 0, 0, time.UTC)),
+			},
+			&corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "instance-a.1", Namespace: "eirini"},
+				InvolvedObject: corev1.ObjectReference{Kind: "PersistentVolumeClaim", Name: "instance-a"},
+				Type:           corev1.EventTypeNormal,
+				Reason:         broker.ReasonProvisioned,
+				Message:        "Provisioned service instance instance-a",
+				Count:          1,
+				LastTimestamp:  metav1.NewTime(time.Date(2020, 8, 1, 10, 0, 0, 0, time.UTC)),
+			},
+			&corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "instance-b.1", Namespace: "eirini"},
+				InvolvedObject: corev1.ObjectReference{Kind: "PersistentVolumeClaim", Name: "instance-b"},
+				Reason:         broker.ReasonProvisioned,
+			},
+		)
+
+		serviceBroker := &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: config.Config{
+				Namespace: "eirini",
+				ServiceConfiguration: config.ServiceConfiguration{
+					ServiceID: "service-id",
+					Plans:     []config.Plan{{ID: "gold-plan", Name: "gold", StorageClass: &gold}},
+				},
+			},
+			Context: context.Background(),
+			Logger:  lagertest.NewTestLogger("broker"),
+		}
+
+		api = &admin.API{
+			Admin: &admin.Admin{Broker: serviceBroker},
+			Reconciler: &reconciler.Reconciler{
+				Broker:  serviceBroker,
+				Metrics: metrics.New(prometheus.NewRegistry()),
+				Logger:  lagertest.NewTestLogger("reconciler"),
+			},
+			Config: config.AdminConfiguration{Username: "operator", Password: "secret"},
+			Logger: lagertest.NewTestLogger("admin-api"),
+		}
+		handler = api.Handler()
+	})
+
+	request := func(method, path string, body interface{}) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.SetBasicAuth("operator", "secret")
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, req)
+
+		if body != nil {
+			Expect(json.Unmarshal(recorder.Body.Bytes(), body)).To(Succeed())
+		}
+		return recorder.Code
+	}
+
+	It("requires the admin credentials", func() {
+		req := httptest.NewRequest("GET", "/admin/v1/instances", nil)
+		req.SetBasicAuth("admin", "secret")
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("serves the OpenAPI document without authentication", func() {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/v1/openapi.json", nil))
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		var document map[string]interface{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &document)).To(Succeed())
+		Expect(document).To(HaveKeyWithValue("openapi", "3.0.3"))
+		Expect(document["paths"]).To(HaveKey("/instances/{instance_id}/undelete"))
+	})
+
+	It("lists instances with filters", func() {
+		var instances []admin.Instance
+		Expect(request("GET", "/admin/v1/instances?plan=gold&space=space", &instances)).To(Equal(http.StatusOK))
+
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].ID).To(Equal("instance-a"))
+	})
+
+	It("lists deleted instances", func() {
+		var instances []admin.Instance
+		Expect(request("GET", "/admin/v1/instances?deleted=true", &instances)).To(Equal(http.StatusOK))
+
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].ID).To(Equal("instance-d"))
+		Expect(instances[0].DeletedAt).NotTo(BeNil())
+	})
+
+	It("rejects invalid filters", func() {
+		var response map[string]string
+		Expect(request("GET", "/admin/v1/instances?deleted=maybe", &response)).To(Equal(http.StatusBadRequest))
+		Expect(response).To(HaveKeyWithValue("error", "deleted must be true or false"))
+	})
+
+	It("lists bindings", func() {
+		var bindings []admin.InstanceBinding
+		Expect(request("GET", "/admin/v1/bindings", &bindings)).To(Equal(http.StatusOK))
+
+		Expect(bindings).To(Equal([]admin.InstanceBinding{{
+			InstanceID: "instance-a",
+			Binding:    admin.Binding{ID: "binding-1", Directory: "/data"},
+		}}))
+	})
+
+	It("shows an instance", func() {
+		var instance admin.Instance
+		Expect(request("GET", "/admin/v1/instances/instance-a", &instance)).To(Equal(http.StatusOK))
+		Expect(instance.PlanName).To(Equal("gold"))
+
+		var bindings []admin.Binding
+		Expect(request("GET", "/admin/v1/instances/instance-a/bindings", &bindings)).To(Equal(http.StatusOK))
+		Expect(bindings).To(HaveLen(1))
+	})
+
+	It("responds with not found for unknown instances", func() {
+		var response map[string]string
+		Expect(request("GET", "/admin/v1/instances/missing", &response)).To(Equal(http.StatusNotFound))
+		Expect(response).To(HaveKeyWithValue("error", "instance missing doesn't exist"))
+	})
+
+	It("shows the recent events of an instance", func() {
+		var events []admin.Event
+		Expect(request("GET", "/admin/v1/instances/instance-a/events", &events)).To(Equal(http.StatusOK))
+
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Reason).To(Equal(broker.ReasonProvisioned))
+		Expect(events[1].Reason).To(Equal(broker.ReasonBound))
+	})
+
+	It("undeletes instances", func() {
+		var instance admin.Instance
+		Expect(request("POST", "/admin/v1/instances/instance-d/undelete", &instance)).To(Equal(http.StatusOK))
+		Expect(instance.DeletedAt).To(BeNil())
+
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").Get(context.Background(), "instance-d", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pvc.Labels).NotTo(HaveKey(broker.DeletedLabel))
+		Expect(pvc.Annotations).To(HaveKeyWithValue(broker.LastModifiedByAnnotation, "operator"))
+	})
+
+	It("refuses to undelete instances that aren't deleted", func() {
+		var response map[string]string
+		Expect(request("POST", "/admin/v1/instances/instance-a/undelete", &response)).To(Equal(http.StatusConflict))
+		Expect(response).To(HaveKeyWithValue("error", "instance instance-a isn't deleted"))
+	})
+
+	It("triggers reconciliation", func() {
+		var issues []reconciler.Issue
+		Expect(request("POST", "/admin/v1/reconcile", &issues)).To(Equal(http.StatusOK))
+
+		Expect(issues).To(HaveLen(1))
+		Expect(issues[0].Kind).To(Equal(reconciler.KindUnmountedBinding))
+		Expect(issues[0].InstanceID).To(Equal("instance-a"))
+	})
+
+	It("only triggers reconciliation on the leader", func() {
+		api.IsLeader = func() bool { return false }
+		handler = api.Handler()
+
+		var response map[string]string
+		Expect(request("POST", "/admin/v1/reconcile", &response)).To(Equal(http.StatusServiceUnavailable))
+		Expect(response).To(HaveKeyWithValue("error", "reconciliation only runs on the leader replica"))
+	})
+})
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// Event is an event the broker recorded for an operation on an instance
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Reason  string    `json:"reason"`
+	Message string    `json:"message"`
+	Count   int32     `json:"count"`
+}
+
+// RecentEvents returns the events of an instance, oldest first. It isn't a
+// complete history: Kubernetes expires events, by default after an hour.
+func (a *Admin) RecentEvents(ctx context.Context, instanceID string) ([]Event, error) {
+	pvc, err := a.claim(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeCtx, cancel := context.WithTimeout(ctx, a.Broker.KubeTimeout())
+	defer cancel()
+
+	// Failures before the claim is known are recorded for a claim named
+	// after the instance
+	names := []string{pvc.Name}
+	if pvc.Name != instanceID {
+		names = append(names, instanceID)
+	}
+
+	recent := []Event{}
+	for _, name := range names {
+		events, err := a.Broker.KubeClient.CoreV1().Events(a.Broker.Config.Namespace).List(kubeCtx, metav1.ListOptions{
+			FieldSelector: fields.SelectorFromSet(fields.Set{
+				"involvedObject.kind": "PersistentVolumeClaim",
+				"involvedObject.name": name,
+			}).String(),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing events of instance %s", instanceID)
+		}
+
+		for _, event := range events.Items {
+			if event.InvolvedObject.Name != name {
+				continue
+			}
+			recent = append(recent, Event{
+				Time:    eventTime(event),
+				Type:    event.Type,
+				Reason:  event.Reason,
+				Message: event.Message,
+				Count:   event.Count,
+			})
+		}
+	}
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].Time.Before(recent[j].Time)
+	})
+
+	return recent, nil
+}
+
+// eventTime returns when an event last happened
+func eventTime(event corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,208 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+)
+
+// Outcomes of importing an instance
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+)
+
+// InstanceMetadata is what the broker knows about an instance: the name,
+// labels and broker annotations of its persistent volume claim and the
+// storage it requests. Annotations set by Kubernetes aren't exported or
+// imported. Claims are named after their instance if ClaimName is empty.
+type InstanceMetadata struct {
+	ID           string            `json:"id"`
+	ClaimName    string            `json:"claim_name,omitempty"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StorageClass *string           `json:"storage_class,omitempty"`
+	Size         string            `json:"size"`
+	AccessModes  []string          `json:"access_modes"`
+}
+
+// ImportResult is the outcome of importing the metadata of one instance
+type ImportResult struct {
+	ID      string `json:"id"`
+	Outcome string `json:"outcome"`
+}
+
+// Export returns the metadata of the instances matching filter
+func (a *Admin) Export(ctx context.Context, filter Filter) ([]InstanceMetadata, error) {
+	pvcs, err := a.listClaims(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make([]InstanceMetadata, len(pvcs))
+	for i, pvc := range pvcs {
+		metadata := InstanceMetadata{
+			ID:           broker.InstanceID(&pvc),
+			Labels:       pvc.Labels,
+			Annotations:  map[string]string{},
+			StorageClass: pvc.Spec.StorageClassName,
+			AccessModes:  []string{},
+		}
+		if pvc.Name != metadata.ID {
+			metadata.ClaimName = pvc.Name
+		}
+		for key, value := range pvc.Annotations {
+			if broker.IsBrokerAnnotation(key) {
+				metadata.Annotations[key] = value
+			}
+		}
+		if requested, ok := pvc.Spec.Resources.Requests["storage"]; ok {
+			metadata.Size = requested.String()
+		}
+		for _, accessMode := range pvc.Spec.AccessModes {
+			metadata.AccessModes = append(metadata.AccessModes, string(accessMode))
+		}
+		exported[i] = metadata
+	}
+
+	return exported, nil
+}
+
+// Import restores exported instances. Missing instances are provisioned again
+// with a new, empty volume; existing ones get the exported labels and broker
+// annotations. Nothing is changed if dryRun is set.
+func (a *Admin) Import(ctx context.Context, instances []InstanceMetadata, dryRun bool) ([]ImportResult, error) {
+	serviceID := a.Broker.Config.ServiceConfiguration.ServiceID
+
+	for _, instance := range instances {
+		if instance.ID == "" {
+			return nil, errors.New("instance without id")
+		}
+		if instance.Labels[broker.ServiceIDLabel] != serviceID {
+			return nil, errors.Errorf("instance %s doesn't belong to service %s", instance.ID, serviceID)
+		}
+		for key := range instance.Annotations {
+			if !broker.IsBrokerAnnotation(key) {
+				return nil, errors.Errorf("annotation %s of instance %s isn't managed by the broker", key, instance.ID)
+			}
+		}
+	}
+
+	results := make([]ImportResult, 0, len(instances))
+	for _, instance := range instances {
+		outcome, err := a.importInstance(ctx, instance, dryRun)
+		if err != nil {
+			return results, errors.Wrapf(err, "error importing instance %s", instance.ID)
+		}
+		results = append(results, ImportResult{ID: instance.ID, Outcome: outcome})
+	}
+
+	return results, nil
+}
+
+func (a *Admin) importInstance(ctx context.Context, instance InstanceMetadata, dryRun bool) (string, error) {
+	kubeCtx, cancel := context.WithTimeout(ctx, a.Broker.KubeTimeout())
+	defer cancel()
+
+	claims := a.Broker.KubeClient.CoreV1().PersistentVolumeClaims(a.Broker.Config.Namespace)
+
+	pvc, err := broker.FindClaim(kubeCtx, claims, instance.ID)
+	if apierrors.IsNotFound(err) {
+		claim, err := newClaim(instance)
+		if err != nil {
+			return "", err
+		}
+		if !dryRun {
+			if _, err := claims.Create(kubeCtx, claim, metav1.CreateOptions{}); err != nil {
+				return "", errors.Wrap(err, "error creating persistent volume claim")
+			}
+		}
+		return ImportCreated, nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "error getting persistent volume claim")
+	}
+
+	if pvc.Labels[broker.ServiceIDLabel] != instance.Labels[broker.ServiceIDLabel] {
+		return "", errors.Errorf("persistent volume claim %s isn't an instance of service %s", instance.ID, instance.Labels[broker.ServiceIDLabel])
+	}
+
+	labels := map[string]string{}
+	for key, value := range instance.Labels {
+		if pvc.Labels[key] != value {
+			labels[key] = value
+		}
+	}
+	annotations := map[string]string{}
+	for key, value := range instance.Annotations {
+		if current, ok := pvc.Annotations[key]; !ok || current != value {
+			annotations[key] = value
+		}
+	}
+	if len(labels) == 0 && len(annotations) == 0 {
+		return ImportUnchanged, nil
+	}
+	if dryRun {
+		return ImportUpdated, nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling patch")
+	}
+
+	if _, err := claims.Patch(kubeCtx, pvc.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return "", errors.Wrap(err, "error patching persistent volume claim")
+	}
+
+	return ImportUpdated, nil
+}
+
+// newClaim returns the persistent volume claim to provision for an imported instance
+func newClaim(instance InstanceMetadata) (*corev1.PersistentVolumeClaim, error) {
+	size, err := resource.ParseQuantity(instance.Size)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid size")
+	}
+
+	accessModes := make([]corev1.PersistentVolumeAccessMode, len(instance.AccessModes))
+	for i, accessMode := range instance.AccessModes {
+		accessModes[i] = corev1.PersistentVolumeAccessMode(accessMode)
+	}
+
+	name := instance.ClaimName
+	if name == "" {
+		name = instance.ID
+	}
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      instance.Labels,
+			Annotations: instance.Annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: instance.StorageClass,
+			AccessModes:      accessModes,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					"storage": size,
+				},
+			},
+		},
+	}, nil
+}
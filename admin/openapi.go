@@ -0,0 +1,238 @@
+package admin
+
+// OpenAPIDocument describes the admin API
+const OpenAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Eirini Persi Broker admin API",
+    "description": "Inspects and manages the service instances of the broker. It is authenticated with the admin credentials of the broker configuration, not with the credentials the platform uses for the broker API.",
+    "version": "1"
+  },
+  "servers": [{"url": "/admin/v1"}],
+  "security": [{"basicAuth": []}],
+  "paths": {
+    "/instances": {
+      "get": {
+        "summary": "List instances",
+        "parameters": [
+          {"$ref": "#/components/parameters/plan"},
+          {"$ref": "#/components/parameters/org"},
+          {"$ref": "#/components/parameters/space"},
+          {"$ref": "#/components/parameters/deleted"}
+        ],
+        "responses": {
+          "200": {
+            "description": "The instances matching the filters, sorted by ID",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Instance"}}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/instances/{instance_id}": {
+      "get": {
+        "summary": "Show an instance, including its volume usage if known",
+        "parameters": [{"$ref": "#/components/parameters/instance_id"}],
+        "responses": {
+          "200": {
+            "description": "The instance",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Instance"}}}
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/instances/{instance_id}/bindings": {
+      "get": {
+        "summary": "List the bindings of an instance",
+        "parameters": [{"$ref": "#/components/parameters/instance_id"}],
+        "responses": {
+          "200": {
+            "description": "The bindings of the instance",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Binding"}}}}
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/instances/{instance_id}/events": {
+      "get": {
+        "summary": "List the recent events of an instance",
+        "description": "Events are read from Kubernetes, which only keeps them for a limited time, by default an hour. They aren't a complete history of the instance.",
+        "parameters": [{"$ref": "#/components/parameters/instance_id"}],
+        "responses": {
+          "200": {
+            "description": "The events, oldest first",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Event"}}}}
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/instances/{instance_id}/undelete": {
+      "post": {
+        "summary": "Restore a deprovisioned instance",
+        "description": "Only instances whose volume is still retained after deprovisioning can be restored. The platform doesn't know about restored instances until they are registered with it again.",
+        "parameters": [{"$ref": "#/components/parameters/instance_id"}],
+        "responses": {
+          "200": {
+            "description": "The restored instance",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Instance"}}}
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/bindings": {
+      "get": {
+        "summary": "List the bindings of all instances",
+        "parameters": [
+          {"$ref": "#/components/parameters/plan"},
+          {"$ref": "#/components/parameters/org"},
+          {"$ref": "#/components/parameters/space"},
+          {"$ref": "#/components/parameters/deleted"},
+          {"name": "shared", "in": "query", "description": "Only select bindings in other spaces than their instance", "schema": {"type": "boolean", "default": false}}
+        ],
+        "responses": {
+          "200": {
+            "description": "The bindings of the instances matching the filters",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/InstanceBinding"}}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/reconcile": {
+      "post": {
+        "summary": "Look for orphaned and drifted resources",
+        "description": "Issues are repaired if the broker is configured to repair them. With leader election, only the leader replica reconciles, others respond with 503.",
+        "responses": {
+          "200": {
+            "description": "The issues found",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Issue"}}}}
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "501": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "basicAuth": {"type": "http", "scheme": "basic"}
+    },
+    "parameters": {
+      "instance_id": {"name": "instance_id", "in": "path", "required": true, "schema": {"type": "string"}},
+      "plan": {"name": "plan", "in": "query", "description": "Name or ID of a plan", "schema": {"type": "string"}},
+      "org": {"name": "org", "in": "query", "description": "GUID of an organization", "schema": {"type": "string"}},
+      "space": {"name": "space", "in": "query", "description": "GUID of a space", "schema": {"type": "string"}},
+      "deleted": {"name": "deleted", "in": "query", "description": "Only select deprovisioned instances whose volume is retained", "schema": {"type": "boolean", "default": false}}
+    },
+    "responses": {
+      "Error": {
+        "description": "The request failed",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "Unauthorized": {"description": "The admin credentials are missing or wrong"}
+    },
+    "schemas": {
+      "Instance": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "claim_name": {"type": "string", "description": "Name of the persistent volume claim of the instance"},
+          "name": {"type": "string", "description": "Name of the instance in the platform, if it was sent"},
+          "service_id": {"type": "string"},
+          "plan_id": {"type": "string"},
+          "plan_name": {"type": "string"},
+          "organization_id": {"type": "string"},
+          "organization_name": {"type": "string"},
+          "space_id": {"type": "string"},
+          "space_name": {"type": "string"},
+          "size": {"type": "string", "example": "1Gi"},
+          "capacity": {"type": "string", "example": "1Gi"},
+          "access_mode": {"type": "string", "example": "ReadWriteMany"},
+          "storage_class": {"type": "string"},
+          "phase": {"type": "string", "enum": ["Pending", "Bound", "Lost"]},
+          "volume_name": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "created_by": {"type": "string"},
+          "last_modified_by": {"type": "string"},
+          "deleted_at": {"type": "string", "format": "date-time"},
+          "bindings": {"type": "array", "items": {"$ref": "#/components/schemas/Binding"}},
+          "backups": {"type": "array", "items": {"$ref": "#/components/schemas/Backup"}},
+          "usage": {"$ref": "#/components/schemas/Usage"}
+        }
+      },
+      "Backup": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string", "description": "Unix time the backup was started at"},
+          "time": {"type": "string", "format": "date-time"},
+          "state": {"type": "string", "enum": ["in progress", "succeeded", "failed"]}
+        }
+      },
+      "Binding": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "dir": {"type": "string"},
+          "space_id": {"type": "string", "description": "GUID of the space the binding was created in"},
+          "shared": {"type": "boolean", "description": "Whether the binding is in another space than the instance"},
+          "protocol": {"type": "string", "enum": ["webdav", "sftp"], "description": "Protocol the access server of a service key serves the volume over"}
+        }
+      },
+      "InstanceBinding": {
+        "allOf": [
+          {"type": "object", "properties": {"instance_id": {"type": "string"}}},
+          {"$ref": "#/components/schemas/Binding"}
+        ]
+      },
+      "Usage": {
+        "type": "object",
+        "properties": {
+          "used_bytes": {"type": "integer", "format": "int64"},
+          "available_bytes": {"type": "integer", "format": "int64"},
+          "capacity_bytes": {"type": "integer", "format": "int64"}
+        }
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "type": {"type": "string", "enum": ["Normal", "Warning"]},
+          "reason": {"type": "string"},
+          "message": {"type": "string"},
+          "count": {"type": "integer"}
+        }
+      },
+      "Issue": {
+        "type": "object",
+        "properties": {
+          "kind": {"type": "string", "enum": ["MissingLabels", "UnknownPlan", "StuckPending", "ReleasedVolume", "UnmountedBinding"]},
+          "resource": {"type": "string"},
+          "name": {"type": "string"},
+          "instance_id": {"type": "string"},
+          "binding_id": {"type": "string"},
+          "message": {"type": "string"},
+          "repaired": {"type": "boolean"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,101 @@
+package broker
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Annotations recording the platform users that created and last modified an instance
+const (
+	CreatedByAnnotation      = "eirini-broker-created-by"
+	LastModifiedByAnnotation = "eirini-broker-last-modified-by"
+)
+
+// Reasons of the events recorded on instance persistent volume claims
+const (
+	ReasonProvisioned         = "Provisioned"
+	ReasonUpdated             = "Updated"
+	ReasonResized             = "Resized"
+	ReasonBound               = "Bound"
+	ReasonUnbound             = "Unbound"
+	ReasonDeprovisioned       = "Deprovisioned"
+	ReasonRestored            = "Restored"
+	ReasonPurged              = "Purged"
+	ReasonSeeded              = "Seeded"
+	ReasonBackedUp            = "BackedUp"
+	ReasonBackupRestored      = "BackupRestored"
+	ReasonBackupDeleted       = "BackupDeleted"
+	ReasonProvisioningFailed  = "ProvisioningFailed"
+	ReasonUpdateFailed        = "UpdateFailed"
+	ReasonBindingFailed       = "BindingFailed"
+	ReasonUnbindingFailed     = "UnbindingFailed"
+	ReasonDeprovisionFailed   = "DeprovisioningFailed"
+	ReasonRestoreFailed       = "RestoreFailed"
+	ReasonSeedingFailed       = "SeedingFailed"
+	ReasonBackupFailed        = "BackupFailed"
+	ReasonBackupRestoreFailed = "BackupRestoreFailed"
+)
+
+// audit logs the outcome of an operation that changed an instance. The user
+// behind the request is part of the session data of logger.
+func audit(logger lager.Logger, action string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+
+	logger.Info("audit", lager.Data{
+		"action":  action,
+		"outcome": outcome,
+	})
+}
+
+// recordEvent records a normal event on the instance persistent volume claim,
+// naming the user behind the request in ctx
+func (b *KubeVolumeBroker) recordEvent(ctx context.Context, instanceID string, pvc *corev1.PersistentVolumeClaim, reason, message string) {
+	b.record(ctx, instanceID, pvc, corev1.EventTypeNormal, reason, message)
+}
+
+// recordFailure records a warning event with the error of a failed operation.
+// Without pvc, the event refers to the claim the instance would have.
+func (b *KubeVolumeBroker) recordFailure(ctx context.Context, instanceID string, pvc *corev1.PersistentVolumeClaim, reason, message string, err error) {
+	b.record(ctx, instanceID, pvc, corev1.EventTypeWarning, reason, message+": "+err.Error())
+}
+
+func (b *KubeVolumeBroker) record(ctx context.Context, instanceID string, pvc *corev1.PersistentVolumeClaim, eventType, reason, message string) {
+	if b.Recorder == nil {
+		return
+	}
+
+	var object runtime.Object = pvc
+	if pvc == nil {
+		object = &corev1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Namespace:  b.Config.Namespace,
+			Name:       instanceID,
+		}
+	}
+
+	if identity, ok := originatingIdentity(ctx); ok {
+		message += " by " + identity.Platform + " user " + identity.UserID
+	}
+
+	b.Recorder.Event(object, eventType, reason, message)
+}
+
+// modifiedByAnnotations returns the annotations to set on an instance when
+// the user behind the request in ctx modifies it
+func modifiedByAnnotations(ctx context.Context) map[string]*string {
+	identity, ok := originatingIdentity(ctx)
+	if !ok {
+		return map[string]*string{}
+	}
+
+	return map[string]*string{
+		LastModifiedByAnnotation: &identity.UserID,
+	}
+}
@@ -0,0 +1,152 @@
+package broker_test
+
+import (
+	"context"
+	"encoding/base64"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+const DefaultUserID = "683ea748-3092-4ff4-b656-39cacc4d5360"
+
+func originatingIdentityHeader(platform, value string) string {
+	return platform + " " + base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+// withOriginatingIdentity stores the header in the context like brokerapi does
+func withOriginatingIdentity(ctx context.Context, header string) context.Context {
+	return context.WithValue(ctx, "originatingIdentity", header)
+}
+
+var _ = Describe("ParseOriginatingIdentity", func() {
+	It("decodes a cloud foundry identity", func() {
+		identity, err := broker.ParseOriginatingIdentity(originatingIdentityHeader("cloudfoundry", `{"user_id":"`+DefaultUserID+`"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(identity).To(Equal(broker.OriginatingIdentity{Platform: "cloudfoundry", UserID: DefaultUserID}))
+	})
+
+	It("decodes a kubernetes identity", func() {
+		identity, err := broker.ParseOriginatingIdentity(originatingIdentityHeader("kubernetes", `{"username":"jane","uid":"c2dde242"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(identity).To(Equal(broker.OriginatingIdentity{Platform: "kubernetes", UserID: "c2dde242"}))
+	})
+
+	It("rejects malformed headers", func() {
+		_, err := broker.ParseOriginatingIdentity("cloudfoundry")
+		Expect(err).To(HaveOccurred())
+
+		_, err = broker.ParseOriginatingIdentity("cloudfoundry not-base64!")
+		Expect(err).To(HaveOccurred())
+
+		_, err = broker.ParseOriginatingIdentity(originatingIdentityHeader("cloudfoundry", `{}`))
+		Expect(err).To(MatchError(ContainSubstring("user id")))
+	})
+})
+
+var _ = Describe("Audit trail", func() {
+	var (
+		kubeClient *fake.Clientset
+		recorder   *record.FakeRecorder
+		logger     *lagertest.TestLogger
+		testBroker *broker.KubeVolumeBroker
+		ctx        context.Context
+	)
+
+	getPVC := func() map[string]string {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pvc.Annotations
+	}
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		recorder = record.NewFakeRecorder(10)
+		logger = lagertest.NewTestLogger("test")
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration: DefaultServiceConfiguration(),
+				Namespace:            DefaultNamespace,
+			},
+			Logger:   logger,
+			Recorder: recorder,
+		}
+		ctx = withOriginatingIdentity(context.Background(), originatingIdentityHeader("cloudfoundry", `{"user_id":"`+DefaultUserID+`"}`))
+
+		_, err := testBroker.Provision(ctx, DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("annotates the creator of an instance", func() {
+		Expect(getPVC()).To(HaveKeyWithValue(broker.CreatedByAnnotation, DefaultUserID))
+		Expect(getPVC()).To(HaveKeyWithValue(broker.LastModifiedByAnnotation, DefaultUserID))
+		Expect(recorder.Events).To(Receive(Equal("Normal Provisioned Provisioned service instance " + DefaultInstanceID + " with 1Gi ReadWriteMany storage by cloudfoundry user " + DefaultUserID)))
+	})
+
+	It("annotates the last modifier of an instance", func() {
+		otherUser := withOriginatingIdentity(context.Background(), originatingIdentityHeader("cloudfoundry", `{"user_id":"other"}`))
+
+		_, err := testBroker.Bind(otherUser, DefaultInstanceID, DefaultBindingID, DefaultBindDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getPVC()).To(HaveKeyWithValue(broker.CreatedByAnnotation, DefaultUserID))
+		Expect(getPVC()).To(HaveKeyWithValue(broker.LastModifiedByAnnotation, "other"))
+
+		_, err = testBroker.Unbind(ctx, DefaultInstanceID, DefaultBindingID, DefaultUnbindDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getPVC()).To(HaveKeyWithValue(broker.LastModifiedByAnnotation, DefaultUserID))
+
+		_, err = testBroker.Update(otherUser, DefaultInstanceID, DefaultUpdateDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getPVC()).To(HaveKeyWithValue(broker.LastModifiedByAnnotation, "other"))
+	})
+
+	It("records an event for every change", func() {
+		_, err := testBroker.Bind(ctx, DefaultInstanceID, DefaultBindingID, DefaultBindDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = testBroker.Unbind(ctx, DefaultInstanceID, DefaultBindingID, DefaultUnbindDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = testBroker.Update(ctx, DefaultInstanceID, DefaultUpdateDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = testBroker.Deprovision(ctx, DefaultInstanceID, DefaultDeprovisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		var reasons []string
+		for len(recorder.Events) > 0 {
+			var event string
+			Expect(recorder.Events).To(Receive(&event))
+			Expect(event).To(HaveSuffix(" by cloudfoundry user " + DefaultUserID))
+			reasons = append(reasons, event)
+		}
+		Expect(reasons).To(HaveLen(5))
+		Expect(reasons[1]).To(HavePrefix("Normal Bound"))
+		Expect(reasons[2]).To(HavePrefix("Normal Unbound"))
+		Expect(reasons[3]).To(HavePrefix("Normal Updated"))
+		Expect(reasons[4]).To(HavePrefix("Normal Deprovisioned"))
+	})
+
+	It("writes the user to the audit log", func() {
+		_, err := testBroker.Deprovision(ctx, DefaultInstanceID, DefaultDeprovisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		log := findLog(logger, "test.deprovision.audit")
+		Expect(log.Data).To(HaveKeyWithValue("user-id", DefaultUserID))
+		Expect(log.Data).To(HaveKeyWithValue("platform", "cloudfoundry"))
+		Expect(log.Data).To(HaveKeyWithValue("instance-id", DefaultInstanceID))
+		Expect(log.Data).To(HaveKeyWithValue("action", "deprovision"))
+		Expect(log.Data).To(HaveKeyWithValue("outcome", "success"))
+	})
+
+	It("doesn't annotate a user when the platform sends no identity", func() {
+		_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultBindDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getPVC()).To(HaveKeyWithValue(broker.LastModifiedByAnnotation, DefaultUserID))
+	})
+})
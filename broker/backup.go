@@ -0,0 +1,326 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
+)
+
+// RestoreAnnotation records the backup an instance was last restored from
+const RestoreAnnotation = "eirini-broker-restored-from"
+
+const (
+	backupAnnotationPrefix = "eirini-broker-backup-"
+
+	// The names of backup and restore jobs are also the operation data of
+	// the updates that start them
+	backupJobPrefix  = "persi-backup-"
+	restoreJobPrefix = "persi-restore-"
+
+	// backupSpaceMetadata is the object metadata holding the space of the
+	// instance a backup was taken of
+	backupSpaceMetadata = "space-id"
+)
+
+// Backup is a backup of an instance in the backup store of its plan. IDs are
+// the Unix times the backups were started at.
+type Backup struct {
+	ID    string
+	Time  time.Time
+	State brokerapi.LastOperationState
+}
+
+// restoreParameters is the backup users ask to restore with the restore
+// parameter of cf create-service and cf update-service. Instances are
+// restored from their own backups if InstanceID is empty.
+type restoreParameters struct {
+	InstanceID string `json:"instance_id"`
+	Backup     string `json:"backup"`
+}
+
+// BackupAnnotation returns the annotation recording the state of the backup
+// with ID id
+func BackupAnnotation(id string) string {
+	return backupAnnotationPrefix + id
+}
+
+// IsBackupAnnotation returns true if the annotation key records a backup
+func IsBackupAnnotation(annotationKey string) bool {
+	return strings.HasPrefix(annotationKey, backupAnnotationPrefix)
+}
+
+// Backups returns the backups recorded on pvc, oldest first
+func Backups(pvc *corev1.PersistentVolumeClaim) []Backup {
+	var backups []Backup
+	for key, value := range pvc.Annotations {
+		if !IsBackupAnnotation(key) {
+			continue
+		}
+
+		id := strings.TrimPrefix(key, backupAnnotationPrefix)
+		seconds, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{ID: id, Time: time.Unix(seconds, 0).UTC(), State: brokerapi.LastOperationState(value)})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups
+}
+
+func backupKey(instanceID, id string) string {
+	return instanceID + "/" + id + ".tar.gz"
+}
+
+func backupJobName(instanceID, id string) string {
+	return backupJobPrefix + instanceID + "-" + id
+}
+
+// operationID returns the ID at the end of the name of a backup or restore job
+func operationID(jobName string) string {
+	return jobName[strings.LastIndex(jobName, "-")+1:]
+}
+
+// backupStore returns the store instances of plan are backed up to
+func (b *KubeVolumeBroker) backupStore(plan *config.Plan) (*objectstore.Config, error) {
+	if !b.Config.JobConfiguration.Enabled() {
+		return nil, invalidParameters("backups aren't enabled")
+	}
+	if plan == nil || plan.BackupStore == nil {
+		return nil, invalidParameters("instances of this plan can't be backed up")
+	}
+
+	return plan.BackupStore, nil
+}
+
+// checkIdle returns an error if a job of the instance is still running
+func (b *KubeVolumeBroker) checkIdle(ctx context.Context, instanceID string) error {
+	running, err := b.jobRunning(ctx, instanceID)
+	if err != nil {
+		return wrapError(err, "error checking jobs")
+	}
+	if running {
+		return brokerapi.ErrConcurrentInstanceAccess
+	}
+
+	return nil
+}
+
+// backupObject validates the restore parameters and returns the key of the
+// backup in store. Only backups of instances in spaceID can be restored.
+func (b *KubeVolumeBroker) backupObject(ctx context.Context, store *objectstore.Config, restore *restoreParameters, spaceID string) (string, error) {
+	if len(validation.IsDNS1123Subdomain(restore.InstanceID)) > 0 {
+		return "", invalidParameters("the restore needs the instance_id of a service instance")
+	}
+	if _, err := strconv.ParseInt(restore.Backup, 10, 64); err != nil {
+		return "", invalidParameters("the restore needs the id of a backup")
+	}
+
+	storeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	key := backupKey(restore.InstanceID, restore.Backup)
+	object, err := (&objectstore.Client{Config: *store}).Stat(storeCtx, key)
+	if objectstore.IsNotFound(err) || (err == nil && object.Metadata[backupSpaceMetadata] != spaceID) {
+		return "", invalidParameters(fmt.Sprintf("backup %s of instance %s doesn't exist", restore.Backup, restore.InstanceID))
+	}
+	if err != nil {
+		return "", wrapError(err, "error getting backup")
+	}
+
+	return key, nil
+}
+
+// startBackup runs a job that uploads the contents of the volume of pvc to
+// store and records the backup on pvc. It returns the name of the job.
+func (b *KubeVolumeBroker) startBackup(ctx context.Context, pvc *corev1.PersistentVolumeClaim, store *objectstore.Config) (string, error) {
+	id := strconv.FormatInt(time.Now().Unix(), 10)
+	name := backupJobName(InstanceID(pvc), id)
+
+	err := b.runJob(ctx, pvc, name, []string{
+		"backup", "-dir", jobMount, "-key", backupKey(InstanceID(pvc), id),
+		"-metadata", backupSpaceMetadata + "=" + pvc.Labels[SpaceIDLabel],
+	}, store.Env())
+	if err != nil {
+		return "", wrapError(err, "error starting backup job")
+	}
+
+	inProgress := string(brokerapi.InProgress)
+	if _, err := b.patchAnnotations(ctx, pvc.Name, map[string]*string{BackupAnnotation(id): &inProgress}); err != nil {
+		return "", wrapError(err, "error recording backup")
+	}
+
+	return name, nil
+}
+
+// Backup starts a backup of the instance of pvc on behalf of the broker
+// itself, without a request from the platform. It returns the ID of the
+// backup.
+func (b *KubeVolumeBroker) Backup(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (string, error) {
+	store, err := b.backupStore(b.Plan(pvc.Labels[PlanIDLabel]))
+	if err != nil {
+		return "", err
+	}
+	if err := b.checkIdle(ctx, InstanceID(pvc)); err != nil {
+		return "", err
+	}
+
+	name, err := b.startBackup(ctx, pvc, store)
+	if err != nil {
+		return "", err
+	}
+
+	return operationID(name), nil
+}
+
+// startRestore runs a job that replaces the contents of the volume of pvc
+// with the backup key in store. It returns the name of the job.
+func (b *KubeVolumeBroker) startRestore(ctx context.Context, pvc *corev1.PersistentVolumeClaim, store *objectstore.Config, key string, restore *restoreParameters) (string, error) {
+	name := restoreJobPrefix + InstanceID(pvc) + "-" + strconv.FormatInt(time.Now().Unix(), 10)
+
+	err := b.runJob(ctx, pvc, name, []string{"restore", "-dir", jobMount, "-key", key}, store.Env())
+	if err != nil {
+		return "", wrapError(err, "error starting restore job")
+	}
+
+	source := fmt.Sprintf("backup %s of instance %s", restore.Backup, restore.InstanceID)
+	if _, err := b.patchAnnotations(ctx, pvc.Name, map[string]*string{RestoreAnnotation: &source}); err != nil {
+		return "", wrapError(err, "error recording restore")
+	}
+
+	return name, nil
+}
+
+// backupOperation reports the state of the backup job name
+func (b *KubeVolumeBroker) backupOperation(ctx context.Context, instanceID, name string) (brokerapi.LastOperation, error) {
+	volumeExists, pvc, err := b.instanceExists(ctx, instanceID)
+	if err != nil {
+		return brokerapi.LastOperation{}, wrapError(err, "error getting instance")
+	}
+	if !volumeExists {
+		return brokerapi.LastOperation{}, brokerapi.ErrInstanceDoesNotExist
+	}
+
+	return b.BackupState(ctx, pvc, operationID(name))
+}
+
+// BackupState reports the state of the backup id of the instance of pvc from
+// its job and records the state on the instance. Events are recorded when
+// the backup finishes. The state of finished backups is taken from the
+// instance, their jobs may be deleted.
+func (b *KubeVolumeBroker) BackupState(ctx context.Context, pvc *corev1.PersistentVolumeClaim, id string) (brokerapi.LastOperation, error) {
+	switch recorded := brokerapi.LastOperationState(pvc.Annotations[BackupAnnotation(id)]); recorded {
+	case brokerapi.Succeeded:
+		return brokerapi.LastOperation{State: recorded, Description: "Created backup " + id}, nil
+	case brokerapi.Failed:
+		return brokerapi.LastOperation{State: recorded, Description: "Backup " + id + " failed"}, nil
+	}
+
+	instanceID := InstanceID(pvc)
+	state, detail, finished, err := b.jobState(ctx, backupJobName(instanceID, id))
+	if err != nil {
+		return brokerapi.LastOperation{}, wrapError(err, "error getting backup job")
+	}
+
+	// The state is recorded until the claim has it, even if the job finished
+	// before
+	if recorded := pvc.Annotations[BackupAnnotation(id)]; recorded != string(state) {
+		value := string(state)
+		if pvc, err = b.patchAnnotations(ctx, pvc.Name, map[string]*string{BackupAnnotation(id): &value}); err != nil {
+			return brokerapi.LastOperation{}, wrapError(err, "error recording backup")
+		}
+	}
+
+	operation := brokerapi.LastOperation{State: state}
+	switch state {
+	case brokerapi.InProgress:
+		operation.Description = "Creating backup " + id
+	case brokerapi.Succeeded:
+		operation.Description = "Created backup " + id
+		if finished {
+			b.recordEvent(ctx, instanceID, pvc, ReasonBackedUp, "Backed up service instance "+instanceID+" as backup "+id)
+		}
+	case brokerapi.Failed:
+		operation.Description = "Backup " + id + " failed: " + detail
+		if finished {
+			b.record(ctx, instanceID, pvc, corev1.EventTypeWarning, ReasonBackupFailed, "Failed to back up service instance "+instanceID+" as backup "+id+": "+detail)
+		}
+	}
+
+	return operation, nil
+}
+
+// DeleteBackup deletes the backup id of the instance of pvc from the backup
+// store, its job and its record on the instance
+func (b *KubeVolumeBroker) DeleteBackup(ctx context.Context, pvc *corev1.PersistentVolumeClaim, id string) error {
+	store, err := b.backupStore(b.Plan(pvc.Labels[PlanIDLabel]))
+	if err != nil {
+		return err
+	}
+
+	storeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	// Failed backups may not have an object
+	err = (&objectstore.Client{Config: *store}).Delete(storeCtx, backupKey(InstanceID(pvc), id))
+	if err != nil && !objectstore.IsNotFound(err) {
+		return wrapError(err, "error deleting backup")
+	}
+
+	if err := b.deleteJob(ctx, backupJobName(InstanceID(pvc), id)); err != nil {
+		return wrapError(err, "error deleting backup job")
+	}
+
+	if _, err := b.patchAnnotations(ctx, pvc.Name, map[string]*string{BackupAnnotation(id): nil}); err != nil {
+		return wrapError(err, "error deleting backup record")
+	}
+
+	b.recordEvent(ctx, InstanceID(pvc), pvc, ReasonBackupDeleted, "Deleted backup "+id+" of service instance "+InstanceID(pvc))
+	return nil
+}
+
+// restoreOperation reports the state of the restore job name
+func (b *KubeVolumeBroker) restoreOperation(ctx context.Context, instanceID, name string) (brokerapi.LastOperation, error) {
+	volumeExists, pvc, err := b.instanceExists(ctx, instanceID)
+	if err != nil {
+		return brokerapi.LastOperation{}, wrapError(err, "error getting instance")
+	}
+	if !volumeExists {
+		return brokerapi.LastOperation{}, brokerapi.ErrInstanceDoesNotExist
+	}
+
+	source := pvc.Annotations[RestoreAnnotation]
+	state, detail, finished, err := b.jobState(ctx, name)
+	if err != nil {
+		return brokerapi.LastOperation{}, wrapError(err, "error getting restore job")
+	}
+
+	operation := brokerapi.LastOperation{State: state}
+	switch state {
+	case brokerapi.InProgress:
+		operation.Description = "Restoring " + source
+	case brokerapi.Succeeded:
+		operation.Description = "Restored " + source
+		if finished {
+			b.recordEvent(ctx, instanceID, pvc, ReasonBackupRestored, "Restored service instance "+instanceID+" from "+source)
+		}
+	case brokerapi.Failed:
+		operation.Description = "Restoring " + source + " failed: " + detail
+		if finished {
+			b.record(ctx, instanceID, pvc, corev1.EventTypeWarning, ReasonBackupRestoreFailed, "Failed to restore service instance "+instanceID+" from "+source+": "+detail)
+		}
+	}
+
+	return operation, nil
+}
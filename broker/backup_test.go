@@ -0,0 +1,254 @@
+package broker_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
+)
+
+var _ = Describe("Backups", func() {
+	var (
+		kubeClient *fake.Clientset
+		httpServer *httptest.Server
+		plan       brokerconfig.Plan
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	updateDetails := func(parameters string) brokerapi.UpdateDetails {
+		details := DefaultUpdateDetails()
+		details.RawParameters = []byte(parameters)
+		return details
+	}
+
+	getJob := func(name string) *batchv1.Job {
+		job, err := kubeClient.BatchV1().Jobs(DefaultNamespace).Get(context.Background(), name, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return job
+	}
+
+	finishJob := func(name string, conditionType batchv1.JobConditionType) {
+		job := getJob(name)
+		job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: "Job has reached the specified backoff limit"}}
+		_, err := kubeClient.BatchV1().Jobs(DefaultNamespace).UpdateStatus(context.Background(), job, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	lastOperation := func(operationData string) brokerapi.LastOperation {
+		operation, err := testBroker.LastOperation(context.Background(), DefaultInstanceID, brokerapi.PollDetails{OperationData: operationData})
+		Expect(err).NotTo(HaveOccurred())
+		return operation
+	}
+
+	getPVC := func() *corev1.PersistentVolumeClaim {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pvc
+	}
+
+	statusCode := func(err error) int {
+		Expect(err).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+		return err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)
+	}
+
+	BeforeEach(func() {
+		// The store holds one backup of the default instance and one of an
+		// instance in another space
+		httpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/backups/" + DefaultInstanceID + "/1760788800.tar.gz":
+				w.Header().Set("X-Amz-Meta-Space-Id", DefaultSpaceID)
+			case "/backups/other-instance/1760788800.tar.gz":
+				w.Header().Set("X-Amz-Meta-Space-Id", "other-space")
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		plan = DefaultPlanConfiguration()
+		plan.BackupStore = &objectstore.Config{Endpoint: httpServer.URL, Bucket: "backups", AccessKeyID: "key", SecretAccessKey: "secret"}
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+	})
+
+	JustBeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		service := DefaultServiceConfiguration()
+		service.Plans = []brokerconfig.Plan{plan}
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration: service,
+				Namespace:            DefaultNamespace,
+				JobConfiguration:     brokerconfig.JobConfiguration{Image: "eirini/persi-broker"},
+			},
+		}
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("when backing up an instance", func() {
+		var spec brokerapi.UpdateServiceSpec
+
+		JustBeforeEach(func() {
+			var err error
+			spec, err = testBroker.Update(context.Background(), DefaultInstanceID, updateDetails(`{"backup": true}`), true)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("runs a job uploading the volume", func() {
+			Expect(spec.IsAsync).To(BeTrue())
+			Expect(spec.OperationData).To(HavePrefix("persi-backup-" + DefaultInstanceID + "-"))
+			id := spec.OperationData[strings.LastIndex(spec.OperationData, "-")+1:]
+
+			job := getJob(spec.OperationData)
+			Expect(job.Spec.Template.Spec.Containers[0].Args).To(Equal([]string{
+				"backup", "-dir", "/data", "-key", DefaultInstanceID + "/" + id + ".tar.gz", "-metadata", "space-id=" + DefaultSpaceID,
+			}))
+
+			secret, err := kubeClient.CoreV1().Secrets(DefaultNamespace).Get(context.Background(), spec.OperationData, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret.StringData).To(HaveKeyWithValue("PERSI_S3_BUCKET", "backups"))
+
+			Expect(getPVC().Annotations).To(HaveKeyWithValue(broker.BackupAnnotation(id), "in progress"))
+			Expect(*job.Spec.TTLSecondsAfterFinished).To(Equal(int32(24 * 60 * 60)))
+		})
+
+		It("records the backup when the job completes", func() {
+			Expect(lastOperation(spec.OperationData).State).To(Equal(brokerapi.InProgress))
+
+			finishJob(spec.OperationData, batchv1.JobComplete)
+
+			operation := lastOperation(spec.OperationData)
+			Expect(operation.State).To(Equal(brokerapi.Succeeded))
+
+			backups := broker.Backups(getPVC())
+			Expect(backups).To(HaveLen(1))
+			Expect(backups[0].State).To(Equal(brokerapi.Succeeded))
+			Expect(operation.Description).To(Equal("Created backup " + backups[0].ID))
+
+			instance, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instance.Parameters.(map[string]interface{})["backups"]).To(HaveLen(1))
+		})
+
+		It("records failed backups", func() {
+			finishJob(spec.OperationData, batchv1.JobFailed)
+
+			operation := lastOperation(spec.OperationData)
+			Expect(operation.State).To(Equal(brokerapi.Failed))
+			Expect(operation.Description).To(HaveSuffix("failed: Job has reached the specified backoff limit"))
+			Expect(broker.Backups(getPVC())[0].State).To(Equal(brokerapi.Failed))
+		})
+
+		It("reports finished backups once their job is deleted", func() {
+			finishJob(spec.OperationData, batchv1.JobComplete)
+			Expect(lastOperation(spec.OperationData).State).To(Equal(brokerapi.Succeeded))
+
+			Expect(kubeClient.BatchV1().Jobs(DefaultNamespace).Delete(context.Background(), spec.OperationData, metav1.DeleteOptions{})).To(Succeed())
+			Expect(lastOperation(spec.OperationData).State).To(Equal(brokerapi.Succeeded))
+		})
+
+		It("deletes the job with the backup", func() {
+			finishJob(spec.OperationData, batchv1.JobComplete)
+			Expect(lastOperation(spec.OperationData).State).To(Equal(brokerapi.Succeeded))
+
+			Expect(testBroker.DeleteBackup(context.Background(), getPVC(), broker.Backups(getPVC())[0].ID)).To(Succeed())
+
+			jobs, err := kubeClient.BatchV1().Jobs(DefaultNamespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(jobs.Items).To(BeEmpty())
+			Expect(broker.Backups(getPVC())).To(BeEmpty())
+		})
+
+		It("rejects other backups while the job runs", func() {
+			_, err := testBroker.Update(context.Background(), DefaultInstanceID, updateDetails(`{"backup": true}`), true)
+			Expect(err).To(Equal(brokerapi.ErrConcurrentInstanceAccess))
+		})
+	})
+
+	It("requires asynchronous updates", func() {
+		_, err := testBroker.Update(context.Background(), DefaultInstanceID, updateDetails(`{"backup": true}`), false)
+		Expect(err).To(Equal(brokerapi.ErrAsyncRequired))
+	})
+
+	Context("when the plan has no backup store", func() {
+		BeforeEach(func() {
+			plan.BackupStore = nil
+		})
+
+		It("rejects backups", func() {
+			_, err := testBroker.Update(context.Background(), DefaultInstanceID, updateDetails(`{"backup": true}`), true)
+			Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+
+	Context("when restoring a backup into an instance", func() {
+		var spec brokerapi.UpdateServiceSpec
+
+		JustBeforeEach(func() {
+			var err error
+			spec, err = testBroker.Update(context.Background(), DefaultInstanceID, updateDetails(`{"restore": {"backup": "1760788800"}}`), true)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("runs a job replacing the contents of the volume", func() {
+			Expect(spec.IsAsync).To(BeTrue())
+			Expect(spec.OperationData).To(HavePrefix("persi-restore-" + DefaultInstanceID + "-"))
+
+			job := getJob(spec.OperationData)
+			Expect(job.Spec.Template.Spec.Containers[0].Args).To(Equal([]string{
+				"restore", "-dir", "/data", "-key", DefaultInstanceID + "/1760788800.tar.gz",
+			}))
+		})
+
+		It("reports the restore", func() {
+			Expect(lastOperation(spec.OperationData).Description).To(Equal("Restoring backup 1760788800 of instance " + DefaultInstanceID))
+
+			finishJob(spec.OperationData, batchv1.JobComplete)
+
+			Expect(lastOperation(spec.OperationData).State).To(Equal(brokerapi.Succeeded))
+		})
+	})
+
+	It("only restores existing backups of instances in the same space", func() {
+		for _, parameters := range []string{
+			`{"restore": {"backup": "1760000000"}}`,
+			`{"restore": {"instance_id": "other-instance", "backup": "1760788800"}}`,
+			`{"restore": {"instance_id": "../other-instance", "backup": "1760788800"}}`,
+			`{"restore": {"backup": "latest"}}`,
+		} {
+			_, err := testBroker.Update(context.Background(), DefaultInstanceID, updateDetails(parameters), true)
+			Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity), parameters)
+		}
+	})
+
+	It("restores backups into new instances", func() {
+		details := DefaultProvisionDetails()
+		details.RawParameters = []byte(`{"restore": {"instance_id": "` + DefaultInstanceID + `", "backup": "1760788800"}}`)
+
+		spec, err := testBroker.Provision(context.Background(), "restored-instance", details, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.IsAsync).To(BeTrue())
+
+		secret, err := kubeClient.CoreV1().Secrets(DefaultNamespace).Get(context.Background(), spec.OperationData, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.StringData).To(HaveKeyWithValue("PERSI_S3_BUCKET", "backups"))
+		Expect(secret.StringData).To(HaveKeyWithValue("PERSI_SEED_KEY", DefaultInstanceID+"/1760788800.tar.gz"))
+	})
+})
@@ -4,37 +4,84 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
+	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 
 	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
+	"code.cloudfoundry.org/eirini-persi-broker/transfer"
+	"code.cloudfoundry.org/eirini-persi-broker/usage"
 )
 
-// KubeVolumeBroker is a broker for Kubernetes Volumes
+// Labels set on every persistent volume claim provisioned by the broker.
+// Claims provisioned before InstanceIDLabel was set are named after their
+// instance.
+const (
+	ServiceIDLabel      = "service-id"
+	PlanIDLabel         = "plan-id"
+	OrganizationIDLabel = "organization-id"
+	SpaceIDLabel        = "space-id"
+	InstanceIDLabel     = "instance-id"
+)
+
+// InstanceID returns the ID of the instance of pvc
+func InstanceID(pvc *corev1.PersistentVolumeClaim) string {
+	if instanceID := pvc.Labels[InstanceIDLabel]; instanceID != "" {
+		return instanceID
+	}
+
+	return pvc.Name
+}
+
+// KubeVolumeBroker is a broker for Kubernetes Volumes. Instances are read
+// from ClaimLister, if set, when they aren't changed.
 type KubeVolumeBroker struct {
-	KubeClient kubernetes.Interface
-	Config     config.Config
-	Context    context.Context
+	KubeClient  kubernetes.Interface
+	ClaimLister corev1listers.PersistentVolumeClaimLister
+	Config      config.Config
+	Context     context.Context
+	Logger      lager.Logger
+	Recorder    record.EventRecorder
+	Usage       usage.Source
+	// SnapshotClient reads volume snapshots. Instances can only be seeded
+	// from snapshots if it's set.
+	SnapshotClient dynamic.Interface
 }
 
 // userMountConfiguration represents the configuration the
 // user can pass when doing cf bind ...
 type userMountConfiguration struct {
 	Directory string `json:"dir"`
+	Protocol  string `json:"protocol"`
 }
 
 // userConfiguration represents the configuration the
 // user can pass when doing cf create-service ...
 type userConfiguration struct {
-	Size       string `json:"size"`
-	AccessMode string `json:"access_mode"`
+	Size       string             `json:"size"`
+	AccessMode string             `json:"access_mode"`
+	Labels     map[string]string  `json:"labels"`
+	Seed       *seedParameters    `json:"seed"`
+	Restore    *restoreParameters `json:"restore"`
+	Backup     bool               `json:"backup"`
 }
 
 // Services returns a list with one item, the service for provisioning kubernetes volumes
@@ -48,8 +95,17 @@ func (b *KubeVolumeBroker) Services(ctx context.Context) ([]brokerapi.Service, e
 			Free:        &plan.Free,
 			ID:          plan.ID,
 		}
+		if plan.Shareable {
+			planList[idx].Metadata = &brokerapi.ServicePlanMetadata{
+				AdditionalMetadata: map[string]interface{}{"shareable": true},
+			}
+		}
 	}
 
+	// Cloud Foundry only knows shareable services, so the service is shareable
+	// if any plan is; binding checks restrict sharing to the shareable plans.
+	shareable := anyShareable(b.Config.ServiceConfiguration.Plans)
+
 	return []brokerapi.Service{
 		brokerapi.Service{
 			ID:          b.Config.ServiceConfiguration.ServiceID,
@@ -58,6 +114,10 @@ func (b *KubeVolumeBroker) Services(ctx context.Context) ([]brokerapi.Service, e
 			Bindable:    true,
 			Plans:       planList,
 
+			InstancesRetrievable: true,
+			BindingsRetrievable:  true,
+			DashboardClient:      b.dashboardClient(),
+
 			Metadata: &brokerapi.ServiceMetadata{
 				DisplayName:         b.Config.ServiceConfiguration.DisplayName,
 				LongDescription:     b.Config.ServiceConfiguration.LongDescription,
@@ -65,6 +125,7 @@ func (b *KubeVolumeBroker) Services(ctx context.Context) ([]brokerapi.Service, e
 				SupportUrl:          b.Config.ServiceConfiguration.SupportURL,
 				ImageUrl:            fmt.Sprintf("data:image/png;base64,%s", b.Config.ServiceConfiguration.IconImage),
 				ProviderDisplayName: b.Config.ServiceConfiguration.ProviderDisplayName,
+				Shareable:           &shareable,
 			},
 			Tags: []string{
 				"eirini",
@@ -78,38 +139,39 @@ func (b *KubeVolumeBroker) Services(ctx context.Context) ([]brokerapi.Service, e
 	}, nil
 }
 
-// Provision creates a Kubernetes PVC
+// Provision creates a Kubernetes PVC. If a seed archive or a backup to
+// restore is requested, a job extracts it into the volume and the provision
+// completes asynchronously. Claims seeded from snapshots are restored by the
+// storage provisioner.
 func (b *KubeVolumeBroker) Provision(ctx context.Context, instanceID string, serviceDetails brokerapi.ProvisionDetails, asyncAllowed bool) (spec brokerapi.ProvisionedServiceSpec, err error) {
 	spec = brokerapi.ProvisionedServiceSpec{}
 
+	logger := b.session(ctx, "provision", lager.Data{
+		"instance-id":     instanceID,
+		"plan-id":         serviceDetails.PlanID,
+		"organization-id": serviceDetails.OrganizationGUID,
+		"space-id":        serviceDetails.SpaceGUID,
+		"parameters":      parametersData(serviceDetails.RawParameters),
+	})
+	var pvc *corev1.PersistentVolumeClaim
+	defer func() {
+		logResult(logger, "Provisioned instance "+instanceID, err)
+		audit(logger, "provision", err)
+		if err != nil {
+			b.recordFailure(ctx, instanceID, pvc, ReasonProvisioningFailed, "Failed to provision service instance "+instanceID, err)
+		}
+	}()
+
 	// Resolve the plan for this service instance
 	if serviceDetails.PlanID == "" {
 		return spec, errors.New("plan_id required")
 	}
 
-	var plan *config.Plan
-	for _, p := range b.Config.ServiceConfiguration.Plans {
-		if p.ID == serviceDetails.PlanID {
-			plan = &p
-			break
-		}
-	}
-
+	plan := b.Plan(serviceDetails.PlanID)
 	if plan == nil {
 		return spec, errors.New("plan_id not recognized")
 	}
 
-	// See if the instance already exists
-	volumeExists, _, err := b.instanceExists(instanceID)
-	if err != nil {
-		return spec, errors.Wrap(err, "error provisioning")
-	}
-
-	// If the persistent volume claim already exists, return a specific error
-	if volumeExists {
-		return spec, brokerapi.ErrInstanceAlreadyExists
-	}
-
 	// Figure out how much storage to provision
 	var userConfig userConfiguration
 	if len(serviceDetails.RawParameters) > 0 {
@@ -126,7 +188,10 @@ func (b *KubeVolumeBroker) Provision(ctx context.Context, instanceID string, ser
 		return spec, errors.New("plan doesn't have a default size")
 	}
 
-	accessMode := userConfig.AccessMode
+	accessMode, err := sharedAccessMode(plan, userConfig.AccessMode)
+	if err != nil {
+		return spec, err
+	}
 	if accessMode == "" {
 		accessMode = plan.DefaultAccessMode
 	}
@@ -139,15 +204,139 @@ func (b *KubeVolumeBroker) Provision(ctx context.Context, instanceID string, ser
 		return spec, errors.Wrap(err, "invalid quantity string")
 	}
 
-	_, err = b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace).Create(b.Context, &corev1.PersistentVolumeClaim{
+	if err := checkMaxSize(plan, quantity); err != nil {
+		return spec, err
+	}
+
+	if err := b.checkUserLabels(userConfig.Labels); err != nil {
+		return spec, err
+	}
+
+	// Backups are restored into new instances like seed archives. Snapshots
+	// are restored by the storage provisioner, without a job.
+	var seed transfer.Source
+	var seedFormat, seedDescription string
+	var dataSource *corev1.TypedLocalObjectReference
+	fromSnapshot := userConfig.Seed != nil && userConfig.Seed.Snapshot != nil
+	seeded := (userConfig.Seed != nil && !fromSnapshot) || userConfig.Restore != nil
+	if seeded && !asyncAllowed {
+		return spec, brokerapi.ErrAsyncRequired
+	}
+	switch {
+	case userConfig.Seed != nil && userConfig.Restore != nil:
+		return spec, invalidParameters("seed an instance or restore a backup into it, not both")
+	case fromSnapshot:
+		if dataSource, seedDescription, err = b.snapshotSource(ctx, userConfig.Seed, serviceDetails.SpaceGUID, quantity); err != nil {
+			return spec, err
+		}
+	case userConfig.Seed != nil:
+		if seed, seedFormat, err = b.seedSource(userConfig.Seed); err != nil {
+			return spec, err
+		}
+	case userConfig.Restore != nil:
+		store, err := b.backupStore(plan)
+		if err != nil {
+			return spec, err
+		}
+		seed.Store, seedFormat = *store, transfer.FormatTarGzip
+		if seed.Key, err = b.backupObject(ctx, store, userConfig.Restore, serviceDetails.SpaceGUID); err != nil {
+			return spec, err
+		}
+	}
+
+	names := parseContext(serviceDetails.RawContext)
+	values := metadataValues{
+		InstanceID:       instanceID,
+		InstanceName:     names.InstanceName,
+		OrganizationID:   serviceDetails.OrganizationGUID,
+		OrganizationName: names.OrganizationName,
+		SpaceID:          serviceDetails.SpaceGUID,
+		SpaceName:        names.SpaceName,
+		ServiceID:        serviceDetails.ServiceID,
+		PlanID:           plan.ID,
+		PlanName:         plan.Name,
+	}
+	labels, annotations, err := b.configuredMetadata(plan, values)
+	if err != nil {
+		return spec, err
+	}
+	labelKeys, annotationKeys := configuredKeys(labels), configuredKeys(annotations)
+	annotations[ConfiguredLabelsAnnotation] = labelKeys
+	annotations[ConfiguredAnnotationsAnnotation] = annotationKeys
+	claimName, err := b.claimName(plan, values)
+	if err != nil {
+		return spec, err
+	}
+
+	// See if the instance already exists. The claim of a deprovisioned
+	// instance may still be retained.
+	pvc, err = b.Claim(ctx, instanceID)
+	if apierrors.IsNotFound(err) {
+		pvc, err = nil, nil
+	}
+	if err != nil {
+		return spec, wrapError(err, "error provisioning")
+	}
+	if pvc != nil && IsDeleted(pvc) {
+		return spec, brokerapi.ErrInstanceAlreadyExists
+	}
+
+	// If the persistent volume claim already exists with identical details,
+	// this is a repeated request. Otherwise return a specific error.
+	if pvc != nil {
+		if !provisionedWith(pvc, serviceDetails, quantity, accessMode) {
+			return spec, brokerapi.ErrInstanceAlreadyExists
+		}
+
+		logger.Debug("instance-already-exists")
+		markAlreadyExists(ctx)
+		spec.DashboardURL = b.DashboardURL(instanceID)
+		if _, seeded := pvc.Annotations[SeedAnnotation]; seeded && pvc.Spec.DataSource == nil {
+			spec.IsAsync = true
+			spec.OperationData = seedJobName(instanceID)
+		}
+		return spec, nil
+	}
+
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	// The labels and annotations of the broker take precedence over those
+	// configured by operators and users
+	for key, value := range userConfig.Labels {
+		labels[key] = value
+	}
+	labels[ServiceIDLabel] = serviceDetails.ServiceID
+	labels[PlanIDLabel] = serviceDetails.PlanID
+	labels[OrganizationIDLabel] = serviceDetails.OrganizationGUID
+	labels[SpaceIDLabel] = serviceDetails.SpaceGUID
+	labels[InstanceIDLabel] = instanceID
+	if identity, ok := originatingIdentity(ctx); ok {
+		annotations[CreatedByAnnotation] = identity.UserID
+		annotations[LastModifiedByAnnotation] = identity.UserID
+	}
+
+	nameLabels, nameAnnotations := names.nameMetadata()
+	for key, value := range nameLabels {
+		if value != nil {
+			labels[key] = *value
+		}
+	}
+	for key, value := range nameAnnotations {
+		annotations[key] = *value
+	}
+	if seeded {
+		seedDescription = seed.String()
+	}
+	if seedDescription != "" {
+		annotations[SeedAnnotation] = seedDescription
+	}
+
+	created, err := b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace).Create(kubeCtx, &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: instanceID,
-			Labels: map[string]string{
-				"service-id":      serviceDetails.ServiceID,
-				"plan-id":         serviceDetails.PlanID,
-				"organization-id": serviceDetails.OrganizationGUID,
-				"space-id":        serviceDetails.SpaceGUID,
-			},
+			Name:        claimName,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
 			StorageClassName: plan.StorageClass,
@@ -159,27 +348,60 @@ func (b *KubeVolumeBroker) Provision(ctx context.Context, instanceID string, ser
 					"storage": quantity,
 				},
 			},
+			DataSource: dataSource,
 		},
 	}, metav1.CreateOptions{})
 
+	if apierrors.IsAlreadyExists(err) {
+		if claimName == instanceID {
+			return spec, brokerapi.ErrInstanceAlreadyExists
+		}
+		return spec, invalidParameters("the volume name of the instance is already taken")
+	}
 	if err != nil {
-		return spec, errors.Wrap(err, "error provisioning")
+		return spec, kubeError(kubeCtx, err, "error provisioning")
+	}
+
+	pvc = created
+	b.recordEvent(ctx, instanceID, pvc, ReasonProvisioned, fmt.Sprintf("Provisioned service instance %s with %s %s storage", instanceID, quantity.String(), accessMode))
+	if dataSource != nil {
+		b.recordEvent(ctx, instanceID, pvc, ReasonSeeded, "Seeded service instance "+instanceID+" from "+seedDescription)
 	}
 
 	spec.IsAsync = false
-	// TODO: point to a Kubernetes Dashboard URL, if configured
-	spec.DashboardURL = ""
+	spec.DashboardURL = b.DashboardURL(instanceID)
+
+	if seeded {
+		if err := b.startSeeding(ctx, pvc, seed, seedFormat); err != nil {
+			return spec, err
+		}
+
+		spec.IsAsync = true
+		spec.OperationData = seedJobName(instanceID)
+	}
 
 	return spec, nil
 }
 
-// Deprovision deletes a Kubernetes PVC
-func (b *KubeVolumeBroker) Deprovision(ctx context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (brokerapi.DeprovisionServiceSpec, error) {
-	spec := brokerapi.DeprovisionServiceSpec{}
+// Deprovision deletes a Kubernetes PVC. If a retention is configured, the
+// PVC is only marked as deleted and purged once the retention is over.
+func (b *KubeVolumeBroker) Deprovision(ctx context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (spec brokerapi.DeprovisionServiceSpec, err error) {
+	logger := b.session(ctx, "deprovision", lager.Data{
+		"instance-id": instanceID,
+		"plan-id":     details.PlanID,
+	})
+	var pvc *corev1.PersistentVolumeClaim
+	defer func() {
+		logResult(logger, "Deprovisioned instance "+instanceID, err)
+		audit(logger, "deprovision", err)
+		if err != nil {
+			b.recordFailure(ctx, instanceID, pvc, ReasonDeprovisionFailed, "Failed to deprovision service instance "+instanceID, err)
+		}
+	}()
 
-	volumeExists, _, err := b.instanceExists(instanceID)
+	volumeExists, pvc, err := b.instanceExists(ctx, instanceID)
 	if err != nil {
-		return spec, errors.Wrap(err, "error deprovisioning")
+		return spec, wrapError(err, "error deprovisioning")
 	}
 
 	// If the volume doesn't exist, the service instance doesn't exist
@@ -187,22 +409,65 @@ func (b *KubeVolumeBroker) Deprovision(ctx context.Context, instanceID string, d
 		return spec, brokerapi.ErrInstanceDoesNotExist
 	}
 
+	// Jobs still filling or reading the volume are stopped
+	if err := b.deleteJobs(ctx, instanceID); err != nil {
+		return spec, wrapError(err, "error deleting jobs for deprovisioning")
+	}
+
+	if retention := b.Config.SoftDeleteConfiguration.Retention; retention > 0 {
+		pvc, err = b.softDelete(ctx, pvc.Name)
+		if err != nil {
+			return spec, wrapError(err, "error marking persistent volume claim as deleted for deprovisioning")
+		}
+
+		b.recordEvent(ctx, instanceID, pvc, ReasonDeprovisioned, fmt.Sprintf("Deprovisioned service instance %s, its volume is retained for %s", instanceID, retention))
+		return spec, nil
+	}
+
+	// The volume is labelled first, so the reconciler can tell it belonged to
+	// the instance once it's released
+	if err := b.LabelVolume(ctx, pvc); err != nil {
+		logger.Error("label-volume", err)
+	}
+
 	// Delete the PVC
-	err = b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace).Delete(b.Context, instanceID, metav1.DeleteOptions{})
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	err = b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace).Delete(kubeCtx, pvc.Name, metav1.DeleteOptions{})
 	if err != nil {
-		return spec, errors.Wrap(err, "error deleting persistent volume claim for deprovisioning")
+		return spec, kubeError(kubeCtx, err, "error deleting persistent volume claim for deprovisioning")
 	}
 
+	b.recordEvent(ctx, instanceID, pvc, ReasonDeprovisioned, "Deprovisioned service instance "+instanceID)
+
 	return spec, nil
 }
 
-// Bind adds an annotation to the service instance PVC
-func (b *KubeVolumeBroker) Bind(ctx context.Context, instanceID, bindingID string, details brokerapi.BindDetails, asyncAllowed bool) (brokerapi.Binding, error) {
-	spec := brokerapi.Binding{}
+// Bind adds an annotation to the service instance PVC. Service keys also get
+// an access server serving the volume with credentials of their own.
+func (b *KubeVolumeBroker) Bind(ctx context.Context, instanceID, bindingID string, details brokerapi.BindDetails, asyncAllowed bool) (spec brokerapi.Binding, err error) {
+	space := bindingSpace(details)
+	logger := b.session(ctx, "bind", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+		"plan-id":     details.PlanID,
+		"app-id":      details.AppGUID,
+		"space-id":    space,
+		"parameters":  parametersData(details.RawParameters),
+	})
+	var pvc *corev1.PersistentVolumeClaim
+	defer func() {
+		logResult(logger, "Bound instance "+instanceID+" to binding "+bindingID, err)
+		audit(logger, "bind", err)
+		if err != nil {
+			b.recordFailure(ctx, instanceID, pvc, ReasonBindingFailed, "Failed to create service binding "+bindingID+" for service instance "+instanceID, err)
+		}
+	}()
 
-	volumeExists, pvc, err := b.instanceExists(instanceID)
+	volumeExists, pvc, err := b.instanceExists(ctx, instanceID)
 	if err != nil {
-		return spec, errors.Wrap(err, "error binding")
+		return spec, wrapError(err, "error binding")
 	}
 
 	// If the volume doesn't exist, the service instance doesn't exist
@@ -210,9 +475,8 @@ func (b *KubeVolumeBroker) Bind(ctx context.Context, instanceID, bindingID strin
 		return spec, brokerapi.ErrInstanceDoesNotExist
 	}
 
-	// If the annotation already exists on the PVC, we return a specific error
-	if _, ok := pvc.Annotations[bindingIDAnnotation(bindingID)]; ok {
-		return spec, brokerapi.ErrBindingAlreadyExists
+	if err := b.checkSharing(pvc, space); err != nil {
+		return spec, err
 	}
 
 	// Resolve the mount directory
@@ -223,19 +487,56 @@ func (b *KubeVolumeBroker) Bind(ctx context.Context, instanceID, bindingID strin
 			return spec, errors.Wrap(err, "error unmarshaling json user configuration")
 		}
 	}
+
+	// Service keys get an access server instead of a volume mount
+	if isServiceKey(details) && b.Config.ServiceKeyConfiguration.Enabled() {
+		pvc, err = b.bindServiceKey(ctx, logger, pvc, bindingID, space, userMount.Protocol)
+		if err != nil {
+			return spec, err
+		}
+
+		spec.Credentials, err = b.serviceKeyCredentials(ctx, pvc, bindingID)
+		if err != nil {
+			return spec, wrapError(err, "error getting service key credentials")
+		}
+		return spec, nil
+	}
+	if userMount.Protocol != "" {
+		return spec, invalidParameters("the protocol can only be chosen for service keys")
+	}
+
 	containerDir := userMount.Directory
 	if containerDir == "" {
 		containerDir = fmt.Sprintf("/var/vcap/data/%s", bindingID)
 	}
 
-	// Add the annotation
-	if pvc.Annotations == nil {
-		pvc.Annotations = map[string]string{}
-	}
-	pvc.Annotations[bindingIDAnnotation(bindingID)] = containerDir
-	_, err = b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace).Update(b.Context, pvc, metav1.UpdateOptions{})
-	if err != nil {
-		return spec, errors.Wrap(err, "error updating persistent volume claim annotations for binding")
+	// If the annotation already exists on the PVC with the same mount
+	// directory, this is a repeated request. Otherwise return a specific error.
+	if existingDir, ok := pvc.Annotations[bindingIDAnnotation(bindingID)]; ok {
+		if existingDir != containerDir {
+			return spec, brokerapi.ErrBindingAlreadyExists
+		}
+
+		logger.Debug("binding-already-exists")
+		markAlreadyExists(ctx)
+	} else {
+		// Add the annotation
+		annotations := modifiedByAnnotations(ctx)
+		annotations[bindingIDAnnotation(bindingID)] = &containerDir
+		if space != "" {
+			annotations[BindingSpaceAnnotation(bindingID)] = &space
+		}
+
+		pvc, err = b.patchAnnotations(ctx, pvc.Name, annotations)
+		if err != nil {
+			return spec, wrapError(err, "error updating persistent volume claim annotations for binding")
+		}
+
+		message := fmt.Sprintf("Created service binding %s for service instance %s mounted at %s", bindingID, instanceID, containerDir)
+		if space != "" && space != pvc.Labels[SpaceIDLabel] {
+			message += " in shared space " + space
+		}
+		b.recordEvent(ctx, instanceID, pvc, ReasonBound, message)
 	}
 
 	// If there's no storage class on the pvc, something's wrong
@@ -261,13 +562,26 @@ func (b *KubeVolumeBroker) Bind(ctx context.Context, instanceID, bindingID strin
 	return spec, nil
 }
 
-// Unbind removes the binding annotation from the appropriate PVC
-func (b *KubeVolumeBroker) Unbind(ctx context.Context, instanceID, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool) (brokerapi.UnbindSpec, error) {
-	spec := brokerapi.UnbindSpec{}
+// Unbind removes the binding annotation from the appropriate PVC and tears
+// down the access server of service keys
+func (b *KubeVolumeBroker) Unbind(ctx context.Context, instanceID, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool) (spec brokerapi.UnbindSpec, err error) {
+	logger := b.session(ctx, "unbind", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+		"plan-id":     details.PlanID,
+	})
+	var pvc *corev1.PersistentVolumeClaim
+	defer func() {
+		logResult(logger, "Unbound binding "+bindingID+" from instance "+instanceID, err)
+		audit(logger, "unbind", err)
+		if err != nil {
+			b.recordFailure(ctx, instanceID, pvc, ReasonUnbindingFailed, "Failed to delete service binding "+bindingID+" of service instance "+instanceID, err)
+		}
+	}()
 
-	volumeExists, pvc, err := b.instanceExists(instanceID)
+	volumeExists, pvc, err := b.instanceExists(ctx, instanceID)
 	if err != nil {
-		return spec, errors.Wrap(err, "error unbinding")
+		return spec, wrapError(err, "error unbinding")
 	}
 
 	// If the volume doesn't exist, the service instance doesn't exist
@@ -284,23 +598,43 @@ func (b *KubeVolumeBroker) Unbind(ctx context.Context, instanceID, bindingID str
 		return spec, brokerapi.ErrBindingDoesNotExist
 	}
 
+	// Tear down the access server of service keys
+	_, serviceKey := pvc.Annotations[ServiceKeyAnnotation(bindingID)]
+	if serviceKey {
+		if err := b.deleteAccessServer(ctx, bindingID); err != nil {
+			return spec, wrapError(err, "error deleting access server of service key")
+		}
+	}
+
 	// Remove the annotation
-	delete(pvc.Annotations, bindingIDAnnotation(bindingID))
-	_, err = b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace).Update(b.Context, pvc, metav1.UpdateOptions{})
+	annotations := modifiedByAnnotations(ctx)
+	annotations[bindingIDAnnotation(bindingID)] = nil
+	annotations[BindingSpaceAnnotation(bindingID)] = nil
+	annotations[ServiceKeyAnnotation(bindingID)] = nil
+
+	pvc, err = b.patchAnnotations(ctx, pvc.Name, annotations)
 	if err != nil {
-		return spec, errors.Wrap(err, "error updating persistent volume claim annotations for unbinding")
+		return spec, wrapError(err, "error updating persistent volume claim annotations for unbinding")
+	}
+
+	if serviceKey {
+		b.recordEvent(ctx, instanceID, pvc, ReasonUnbound, "Deleted service key "+bindingID+" of service instance "+instanceID)
+	} else {
+		b.recordEvent(ctx, instanceID, pvc, ReasonUnbound, "Deleted service binding "+bindingID+" of service instance "+instanceID)
 	}
 
 	return spec, nil
 }
 
-// GetInstance finds the correct PVC and reconstructs an instance spec
-func (b *KubeVolumeBroker) GetInstance(ctx context.Context, instanceID string) (brokerapi.GetInstanceDetailsSpec, error) {
-	spec := brokerapi.GetInstanceDetailsSpec{}
+// GetInstance finds the correct PVC and reconstructs an instance spec,
+// including its size, state and volume usage
+func (b *KubeVolumeBroker) GetInstance(ctx context.Context, instanceID string) (spec brokerapi.GetInstanceDetailsSpec, err error) {
+	logger := b.session(ctx, "get-instance", lager.Data{"instance-id": instanceID})
+	defer func() { logResult(logger, "Fetched instance "+instanceID, err) }()
 
-	volumeExists, pvc, err := b.instanceExists(instanceID)
+	volumeExists, pvc, err := b.cachedInstance(ctx, instanceID)
 	if err != nil {
-		return spec, errors.Wrap(err, "error getting instance")
+		return spec, wrapError(err, "error getting instance")
 	}
 
 	// If the volume doesn't exist, the service instance doesn't exist
@@ -310,24 +644,30 @@ func (b *KubeVolumeBroker) GetInstance(ctx context.Context, instanceID string) (
 
 	var ok bool
 
-	// TODO: set dashboard URL
-	if spec.PlanID, ok = pvc.Labels["plan-id"]; !ok {
+	spec.DashboardURL = b.DashboardURL(instanceID)
+	if spec.PlanID, ok = pvc.Labels[PlanIDLabel]; !ok {
 		return spec, errors.New("plan-id label missing from pvc")
 	}
-	if spec.ServiceID, ok = pvc.Labels["service-id"]; !ok {
+	if spec.ServiceID, ok = pvc.Labels[ServiceIDLabel]; !ok {
 		return spec, errors.New("service-id label missing from pvc")
 	}
 
+	spec.Parameters = b.instanceParameters(ctx, logger, pvc)
+
 	return spec, nil
 }
 
 // GetBinding finds the correct PVC and its binding annotation and reconstructs a binding spec
-func (b *KubeVolumeBroker) GetBinding(ctx context.Context, instanceID, bindingID string) (brokerapi.GetBindingSpec, error) {
-	spec := brokerapi.GetBindingSpec{}
-
-	volumeExists, pvc, err := b.instanceExists(instanceID)
+func (b *KubeVolumeBroker) GetBinding(ctx context.Context, instanceID, bindingID string) (spec brokerapi.GetBindingSpec, err error) {
+	logger := b.session(ctx, "get-binding", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+	})
+	defer func() { logResult(logger, "Fetched binding "+bindingID+" of instance "+instanceID, err) }()
+
+	volumeExists, pvc, err := b.cachedInstance(ctx, instanceID)
 	if err != nil {
-		return spec, errors.Wrap(err, "error getting binding")
+		return spec, wrapError(err, "error getting binding")
 	}
 
 	// If the volume doesn't exist, the service instance doesn't exist
@@ -345,6 +685,14 @@ func (b *KubeVolumeBroker) GetBinding(ctx context.Context, instanceID, bindingID
 		return spec, brokerapi.ErrBindingDoesNotExist
 	}
 
+	if _, ok := pvc.Annotations[ServiceKeyAnnotation(bindingID)]; ok {
+		spec.Credentials, err = b.serviceKeyCredentials(ctx, pvc, bindingID)
+		if err != nil {
+			return spec, wrapError(err, "error getting service key credentials")
+		}
+		return spec, nil
+	}
+
 	// If there's no storage class on the pvc, something's wrong
 	if pvc.Spec.StorageClassName == nil {
 		return spec, errors.New("pvc has a nil storage class")
@@ -368,37 +716,430 @@ func (b *KubeVolumeBroker) GetBinding(ctx context.Context, instanceID, bindingID
 
 // LastBindingOperation is currently a noop
 func (b *KubeVolumeBroker) LastBindingOperation(ctx context.Context, instanceID, bindingID string, details brokerapi.PollDetails) (brokerapi.LastOperation, error) {
+	b.session(ctx, "last-binding-operation", lager.Data{
+		"instance-id":    instanceID,
+		"binding-id":     bindingID,
+		"plan-id":        details.PlanID,
+		"operation-data": details.OperationData,
+	}).Debug("noop")
+
 	return brokerapi.LastOperation{}, nil
 }
 
-// LastOperation is currently a noop
-func (b *KubeVolumeBroker) LastOperation(ctx context.Context, instanceID string, details brokerapi.PollDetails) (brokerapi.LastOperation, error) {
-	return brokerapi.LastOperation{}, nil
+// LastOperation reports the state of seeding, backing up and restoring an
+// instance. Other operations complete synchronously.
+func (b *KubeVolumeBroker) LastOperation(ctx context.Context, instanceID string, details brokerapi.PollDetails) (operation brokerapi.LastOperation, err error) {
+	logger := b.session(ctx, "last-operation", lager.Data{
+		"instance-id":    instanceID,
+		"plan-id":        details.PlanID,
+		"operation-data": details.OperationData,
+	})
+	defer func() { logResult(logger, "Polled operation of instance "+instanceID, err) }()
+
+	switch {
+	case strings.HasPrefix(details.OperationData, seedJobPrefix):
+		return b.seedOperation(ctx, instanceID)
+	case strings.HasPrefix(details.OperationData, backupJobPrefix):
+		return b.backupOperation(ctx, instanceID, details.OperationData)
+	case strings.HasPrefix(details.OperationData, restoreJobPrefix):
+		return b.restoreOperation(ctx, instanceID, details.OperationData)
+	}
+
+	return brokerapi.LastOperation{State: brokerapi.Succeeded}, nil
+}
+
+// Update resizes the Kubernetes PVC if a larger size is requested and
+// records the user who updated the instance and the names from the context.
+// Backups and restores requested with the update run as jobs and complete
+// asynchronously.
+func (b *KubeVolumeBroker) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (spec brokerapi.UpdateServiceSpec, err error) {
+	logger := b.session(ctx, "update", lager.Data{
+		"instance-id":     instanceID,
+		"plan-id":         details.PlanID,
+		"organization-id": details.PreviousValues.OrgID,
+		"space-id":        details.PreviousValues.SpaceID,
+		"parameters":      parametersData(details.RawParameters),
+	})
+	var pvc *corev1.PersistentVolumeClaim
+	defer func() {
+		logResult(logger, "Updated instance "+instanceID, err)
+		audit(logger, "update", err)
+		if err != nil {
+			b.recordFailure(ctx, instanceID, pvc, ReasonUpdateFailed, "Failed to update service instance "+instanceID, err)
+		}
+	}()
+
+	var userConfig userConfiguration
+	if len(details.RawParameters) > 0 {
+		err = json.Unmarshal(details.RawParameters, &userConfig)
+		if err != nil {
+			return spec, errors.Wrap(err, "error unmarshaling json user configuration")
+		}
+	}
+
+	volumeExists, pvc, err := b.instanceExists(ctx, instanceID)
+	if err != nil {
+		return spec, wrapError(err, "error updating")
+	}
+
+	// If the volume doesn't exist, the service instance doesn't exist
+	if !volumeExists {
+		return spec, brokerapi.ErrInstanceDoesNotExist
+	}
+
+	if err := checkAccessModeUnchanged(pvc, userConfig.AccessMode); err != nil {
+		return spec, err
+	}
+
+	if err := b.checkUserLabels(userConfig.Labels); err != nil {
+		return spec, err
+	}
+
+	var store *objectstore.Config
+	var restoreKey string
+	if userConfig.Backup || userConfig.Restore != nil {
+		if userConfig.Backup && userConfig.Restore != nil {
+			return spec, invalidParameters("back up an instance or restore a backup into it, not both")
+		}
+		if !asyncAllowed {
+			return spec, brokerapi.ErrAsyncRequired
+		}
+		if store, err = b.backupStore(b.Plan(pvc.Labels[PlanIDLabel])); err != nil {
+			return spec, err
+		}
+		if userConfig.Restore != nil {
+			if userConfig.Restore.InstanceID == "" {
+				userConfig.Restore.InstanceID = instanceID
+			}
+			if restoreKey, err = b.backupObject(ctx, store, userConfig.Restore, pvc.Labels[SpaceIDLabel]); err != nil {
+				return spec, err
+			}
+		}
+		if err := b.checkIdle(ctx, instanceID); err != nil {
+			return spec, err
+		}
+	}
+
+	// Cloud Foundry sends the current names with every update, so renamed
+	// instances, organizations and spaces are picked up here, as well as
+	// changes to the configured labels and annotations. Those dropped from
+	// the configuration are removed, unless users may set them.
+	names := parseContext(details.RawContext)
+	plan := b.Plan(pvc.Labels[PlanIDLabel])
+	configuredLabels, configuredAnnotations, err := b.configuredMetadata(plan, claimValues(pvc, plan, names))
+	if err != nil {
+		return spec, err
+	}
+
+	labels, annotations := names.nameMetadata()
+	for _, key := range removedKeys(pvc.Annotations[ConfiguredLabelsAnnotation], configuredLabels) {
+		if !isBrokerLabel(key) && !b.isUserLabel(key) {
+			labels[key] = nil
+		}
+	}
+	for _, key := range removedKeys(pvc.Annotations[ConfiguredAnnotationsAnnotation], configuredAnnotations) {
+		if !IsBrokerAnnotation(key) {
+			annotations[key] = nil
+		}
+	}
+	setPatchValues(labels, configuredLabels)
+	setPatchValues(labels, userConfig.Labels)
+	setPatchValues(annotations, configuredAnnotations)
+	setPatchValues(annotations, map[string]string{
+		ConfiguredLabelsAnnotation:      configuredKeys(configuredLabels),
+		ConfiguredAnnotationsAnnotation: configuredKeys(configuredAnnotations),
+	})
+	for key, value := range modifiedByAnnotations(ctx) {
+		annotations[key] = value
+	}
+
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": annotations,
+		},
+	}
+
+	current := pvc.Spec.Resources.Requests["storage"]
+	size, err := resizedTo(plan, pvc, userConfig.Size)
+	if err != nil {
+		return spec, err
+	}
+	if size != nil {
+		patch["spec"] = storageRequestPatch(*size)
+	}
+
+	updated, err := b.patchInstance(ctx, pvc.Name, patch)
+	if err != nil {
+		return spec, wrapError(err, "error updating persistent volume claim")
+	}
+	pvc = updated
+
+	if size != nil {
+		requested := pvc.Spec.Resources.Requests["storage"]
+		b.recordEvent(ctx, instanceID, pvc, ReasonResized, fmt.Sprintf("Resized service instance %s from %s to %s", instanceID, current.String(), requested.String()))
+	} else {
+		b.recordEvent(ctx, instanceID, pvc, ReasonUpdated, "Updated service instance "+instanceID)
+	}
+
+	switch {
+	case userConfig.Backup:
+		spec.OperationData, err = b.startBackup(ctx, pvc, store)
+	case userConfig.Restore != nil:
+		spec.OperationData, err = b.startRestore(ctx, pvc, store, restoreKey, userConfig.Restore)
+	}
+	if err != nil {
+		return spec, err
+	}
+	spec.IsAsync = spec.OperationData != ""
+
+	return spec, nil
 }
 
-// Update is currently a noop
-func (b *KubeVolumeBroker) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
-	return brokerapi.UpdateServiceSpec{}, nil
+// Plan returns the configured plan with planID, or nil if there is none
+func (b *KubeVolumeBroker) Plan(planID string) *config.Plan {
+	for _, p := range b.Config.ServiceConfiguration.Plans {
+		if p.ID == planID {
+			plan := p
+			return &plan
+		}
+	}
+
+	return nil
 }
 
-func (b *KubeVolumeBroker) instanceExists(instanceID string) (bool, *corev1.PersistentVolumeClaim, error) {
-	pvc, err := b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace).Get(b.Context, instanceID, metav1.GetOptions{})
+func (b *KubeVolumeBroker) instanceExists(ctx context.Context, instanceID string) (bool, *corev1.PersistentVolumeClaim, error) {
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	pvc, err := FindClaim(kubeCtx, b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace), instanceID)
 
 	if apierrors.IsNotFound(err) {
 		return false, nil, nil
 	}
 
 	if err != nil {
-		return false, nil, errors.Wrap(err, "error listing persistent volumes")
+		return false, nil, kubeError(kubeCtx, err, "error listing persistent volumes")
+	}
+
+	// Retained claims of deprovisioned instances only exist for operators
+	if IsDeleted(pvc) {
+		return false, nil, nil
 	}
 
 	return true, pvc, nil
 }
 
+// Claim returns the persistent volume claim of an instance, including the
+// retained claims of deprovisioned instances. It returns a not found error
+// if there is none.
+func (b *KubeVolumeBroker) Claim(ctx context.Context, instanceID string) (*corev1.PersistentVolumeClaim, error) {
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	pvc, err := FindClaim(kubeCtx, b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace), instanceID)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, kubeError(kubeCtx, err, "error getting persistent volume claim")
+	}
+
+	return pvc, err
+}
+
+// FindClaim gets the claim of an instance from claims. It gets the claim
+// named after the instance, or looks the claim up by its instance label if it
+// has a templated name. It returns a not found error if there is none.
+func FindClaim(ctx context.Context, claims typedcorev1.PersistentVolumeClaimInterface, instanceID string) (*corev1.PersistentVolumeClaim, error) {
+	pvc, err := claims.Get(ctx, instanceID, metav1.GetOptions{})
+	if err == nil && InstanceID(pvc) == instanceID {
+		return pvc, nil
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	notFound := apierrors.NewNotFound(corev1.Resource("persistentvolumeclaims"), instanceID)
+	if len(validation.IsValidLabelValue(instanceID)) > 0 {
+		return nil, notFound
+	}
+
+	list, err := claims.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{InstanceIDLabel: instanceID}).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Items) == 0 {
+		return nil, notFound
+	}
+
+	return &list.Items[0], nil
+}
+
+// provisionedWith returns true if pvc was provisioned with the given details
+func provisionedWith(pvc *corev1.PersistentVolumeClaim, details brokerapi.ProvisionDetails, quantity resource.Quantity, accessMode string) bool {
+	if pvc.Labels[ServiceIDLabel] != details.ServiceID ||
+		pvc.Labels[PlanIDLabel] != details.PlanID ||
+		pvc.Labels[OrganizationIDLabel] != details.OrganizationGUID ||
+		pvc.Labels[SpaceIDLabel] != details.SpaceGUID {
+		return false
+	}
+
+	if len(pvc.Spec.AccessModes) != 1 || string(pvc.Spec.AccessModes[0]) != accessMode {
+		return false
+	}
+
+	requested, ok := pvc.Spec.Resources.Requests["storage"]
+	return ok && requested.Cmp(quantity) == 0
+}
+
+// patchAnnotations sets annotations on the instance PVC named claimName,
+// removing those whose value is nil. A JSON merge patch only touches these
+// keys, so concurrent binds and unbinds on the same instance never overwrite
+// each other.
+func (b *KubeVolumeBroker) patchAnnotations(ctx context.Context, claimName string, annotations map[string]*string) (*corev1.PersistentVolumeClaim, error) {
+	return b.patchInstance(ctx, claimName, map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+}
+
+// patchInstance applies a JSON merge patch to the instance PVC named claimName
+func (b *KubeVolumeBroker) patchInstance(ctx context.Context, claimName string, mergePatch map[string]interface{}) (*corev1.PersistentVolumeClaim, error) {
+	patch, err := json.Marshal(mergePatch)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling patch")
+	}
+
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	pvc, err := b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace).Patch(kubeCtx, claimName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return nil, kubeError(kubeCtx, err, "error patching persistent volume claim")
+	}
+
+	return pvc, nil
+}
+
 func bindingIDAnnotation(bindingID string) string {
 	return "eirini-broker-binding-" + bindingID
 }
 
-func isBindingIDAnnotation(annotationKey string) bool {
+// IsBindingIDAnnotation returns true if the annotation key records a binding
+func IsBindingIDAnnotation(annotationKey string) bool {
 	return strings.HasPrefix(annotationKey, "eirini-broker-binding-")
 }
+
+// IsBrokerAnnotation returns true for the annotations the broker sets on
+// instances, as opposed to those set by Kubernetes
+func IsBrokerAnnotation(annotationKey string) bool {
+	switch annotationKey {
+	case CreatedByAnnotation, LastModifiedByAnnotation, DeletedAtAnnotation,
+		InstanceNameAnnotation, OrganizationNameAnnotation, SpaceNameAnnotation,
+		SeedAnnotation, RestoreAnnotation,
+		ConfiguredLabelsAnnotation, ConfiguredAnnotationsAnnotation:
+		return true
+	}
+
+	return IsBindingIDAnnotation(annotationKey) || IsBindingSpaceAnnotation(annotationKey) ||
+		IsServiceKeyAnnotation(annotationKey) || IsBackupAnnotation(annotationKey)
+}
+
+// BindingIDFromAnnotation returns the ID of the binding recorded by a binding annotation key
+func BindingIDFromAnnotation(annotationKey string) string {
+	return strings.TrimPrefix(annotationKey, "eirini-broker-binding-")
+}
+
+// InstanceSelector returns the label selector matching all persistent volume
+// claims provisioned for the configured service, except those of deprovisioned
+// instances that are retained
+func InstanceSelector(c config.Config) string {
+	return serviceSelector(c, selection.DoesNotExist).String()
+}
+
+// InstanceIDSelector returns the label selector matching the persistent
+// volume claims of instances by their instance label, including claims that
+// lost their other labels. Deleted instances aren't matched.
+func InstanceIDSelector() string {
+	// The requirements are always valid for these operators
+	instance, _ := labels.NewRequirement(InstanceIDLabel, selection.Exists, nil)
+	deleted, _ := labels.NewRequirement(DeletedLabel, selection.DoesNotExist, nil)
+
+	return labels.NewSelector().Add(*instance, *deleted).String()
+}
+
+// invalidParameters is returned for user parameters the broker can't act on
+func invalidParameters(message string) error {
+	return brokerapi.NewFailureResponse(errors.New(message), http.StatusUnprocessableEntity, "invalid-parameters")
+}
+
+// instanceParameters describes the volume of an instance. Usage is left out
+// unless it's enabled for instance parameters, or if it's unknown or can't be
+// determined.
+func (b *KubeVolumeBroker) instanceParameters(ctx context.Context, logger lager.Logger, pvc *corev1.PersistentVolumeClaim) map[string]interface{} {
+	parameters := map[string]interface{}{
+		"phase": string(pvc.Status.Phase),
+	}
+
+	if requested, ok := pvc.Spec.Resources.Requests["storage"]; ok {
+		parameters["requested_size"] = requested.String()
+	}
+	if capacity, ok := pvc.Status.Capacity["storage"]; ok {
+		parameters["capacity"] = capacity.String()
+	}
+	if len(pvc.Spec.AccessModes) > 0 {
+		parameters["access_mode"] = string(pvc.Spec.AccessModes[0])
+	}
+	if pvc.Spec.StorageClassName != nil {
+		parameters["storage_class"] = *pvc.Spec.StorageClassName
+	}
+	if backups := Backups(pvc); len(backups) > 0 {
+		list := make([]map[string]interface{}, 0, len(backups))
+		for _, backup := range backups {
+			list = append(list, map[string]interface{}{
+				"id":    backup.ID,
+				"time":  backup.Time.Format(time.RFC3339),
+				"state": string(backup.State),
+			})
+		}
+		parameters["backups"] = list
+	}
+
+	if b.Usage == nil || !b.Config.UsageConfiguration.InstanceParameters {
+		return parameters
+	}
+
+	usageCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	volumeUsage, err := b.Usage.Usage(usageCtx, pvc)
+	if err != nil {
+		logger.Error("get-usage", err)
+		return parameters
+	}
+	if volumeUsage != nil {
+		parameters["used_bytes"] = volumeUsage.UsedBytes
+		parameters["available_bytes"] = volumeUsage.AvailableBytes
+	}
+
+	return parameters
+}
+
+// checkMaxSize returns an error if quantity exceeds the max size of plan
+func checkMaxSize(plan *config.Plan, quantity resource.Quantity) error {
+	if plan == nil || plan.MaxSize == "" {
+		return nil
+	}
+
+	maxSize, err := resource.ParseQuantity(plan.MaxSize)
+	if err != nil {
+		return errors.Wrapf(err, "invalid max size of plan %s", plan.ID)
+	}
+
+	if quantity.Cmp(maxSize) > 0 {
+		return invalidParameters(fmt.Sprintf("size %s exceeds the max size %s of plan %s", quantity.String(), maxSize.String(), plan.Name))
+	}
+
+	return nil
+}
@@ -3,15 +3,24 @@ package broker_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/pivotal-cf/brokerapi"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	k8stesting "k8s.io/client-go/testing"
 
 	"code.cloudfoundry.org/eirini-persi-broker/broker"
 	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
@@ -48,6 +57,8 @@ var _ = Describe("broker", func() {
 				Expect(services[0].Bindable).To(Equal(true))
 				Expect(services[0].Tags).To(Equal([]string{"eirini", "kubernetes", "storage"}))
 				Expect(services[0].Requires).To(Equal([]brokerapi.RequiredPermission{brokerapi.PermissionVolumeMount}))
+				Expect(services[0].InstancesRetrievable).To(BeTrue())
+				Expect(services[0].BindingsRetrievable).To(BeTrue())
 			})
 
 			It("returns the configured plans", func() {
@@ -181,13 +192,59 @@ var _ = Describe("broker", func() {
 					Expect(err).NotTo(HaveOccurred())
 				})
 
-				It("returns an error", func() {
-					_, err := testBroker.Provision(
+				It("accepts an identical request", func() {
+					spec, err := testBroker.Provision(
 						context.Background(),
 						DefaultInstanceID,
 						DefaultProvisionDetails(),
 						true,
 					)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(spec.IsAsync).To(Equal(false))
+
+					pvcList, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).List(context.TODO(), metav1.ListOptions{})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(len(pvcList.Items)).To(Equal(1))
+				})
+
+				It("returns an error for a request with a different size", func() {
+					provisionDetails := DefaultProvisionDetails()
+					provisionDetails.RawParameters = json.RawMessage(`{"size": "2Gi"}`)
+
+					_, err := testBroker.Provision(
+						context.Background(),
+						DefaultInstanceID,
+						provisionDetails,
+						true,
+					)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("instance already exists"))
+				})
+
+				It("returns an error for a request with a different access mode", func() {
+					provisionDetails := DefaultProvisionDetails()
+					provisionDetails.RawParameters = json.RawMessage(`{"access_mode": "ReadWriteOnce"}`)
+
+					_, err := testBroker.Provision(
+						context.Background(),
+						DefaultInstanceID,
+						provisionDetails,
+						true,
+					)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("instance already exists"))
+				})
+
+				It("returns an error for a request from a different space", func() {
+					provisionDetails := DefaultProvisionDetails()
+					provisionDetails.SpaceGUID = "other-space"
+
+					_, err := testBroker.Provision(
+						context.Background(),
+						DefaultInstanceID,
+						provisionDetails,
+						true,
+					)
 					Expect(err).To(HaveOccurred())
 					Expect(err.Error()).To(ContainSubstring("instance already exists"))
 				})
@@ -342,8 +399,8 @@ var _ = Describe("broker", func() {
 			})
 
 			Context("when the binding already exists", func() {
-				It("returns an error", func() {
-					_, err := testBroker.Bind(
+				It("returns the existing binding for an identical request", func() {
+					repeated, err := testBroker.Bind(
 						context.Background(),
 						DefaultInstanceID,
 						DefaultBindingID,
@@ -351,12 +408,76 @@ var _ = Describe("broker", func() {
 						true,
 					)
 
+					Expect(err).NotTo(HaveOccurred())
+					Expect(repeated).To(Equal(binding))
+				})
+
+				It("returns an error for a request with a different mount directory", func() {
+					bindDetails := DefaultBindDetails()
+					bindDetails.RawParameters = json.RawMessage(`{"dir": "/elsewhere"}`)
+
+					_, err := testBroker.Bind(
+						context.Background(),
+						DefaultInstanceID,
+						DefaultBindingID,
+						bindDetails,
+						true,
+					)
+
 					Expect(err).To(HaveOccurred())
 					Expect(err.Error()).To(ContainSubstring("binding already exists"))
 				})
 			})
 		})
 
+		Context("when binding and unbinding concurrently", func() {
+			const parallelism = 50
+
+			var existingBindings []string
+
+			BeforeEach(func() {
+				existingBindings = nil
+				for i := 0; i < parallelism; i++ {
+					bindingID := fmt.Sprintf("existing-%d", i)
+					_, err := testBroker.Bind(context.Background(), DefaultInstanceID, bindingID, DefaultBindDetails(), true)
+					Expect(err).NotTo(HaveOccurred())
+					existingBindings = append(existingBindings, bindingID)
+				}
+			})
+
+			It("doesn't lose any binding annotation", func() {
+				// Delay every read so that requests interleave between reading
+				// the claim and writing it back
+				testBroker.KubeClient = slowReadsClientset{kubeClient.(*fake.Clientset)}
+
+				var wg sync.WaitGroup
+				for i := 0; i < parallelism; i++ {
+					wg.Add(2)
+					go func(bindingID string) {
+						defer GinkgoRecover()
+						defer wg.Done()
+						_, err := testBroker.Bind(context.Background(), DefaultInstanceID, bindingID, DefaultBindDetails(), true)
+						Expect(err).NotTo(HaveOccurred())
+					}(fmt.Sprintf("new-%d", i))
+					go func(bindingID string) {
+						defer GinkgoRecover()
+						defer wg.Done()
+						_, err := testBroker.Unbind(context.Background(), DefaultInstanceID, bindingID, DefaultUnbindDetails(), true)
+						Expect(err).NotTo(HaveOccurred())
+					}(existingBindings[i])
+				}
+				wg.Wait()
+
+				pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.TODO(), DefaultInstanceID, metav1.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+
+				for i := 0; i < parallelism; i++ {
+					Expect(pvc.Annotations).To(HaveKey("eirini-broker-binding-" + fmt.Sprintf("new-%d", i)))
+					Expect(pvc.Annotations).NotTo(HaveKey("eirini-broker-binding-" + existingBindings[i]))
+				}
+			})
+		})
+
 		Context("when the service instance doesn't exist", func() {
 			It("binding returns an error", func() {
 				_, err := testBroker.Bind(
@@ -385,4 +506,124 @@ var _ = Describe("broker", func() {
 			})
 		})
 	})
+
+	Describe("kubernetes calls", func() {
+		BeforeEach(func() {
+			testBroker.Config.KubeTimeout = 10 * time.Millisecond
+		})
+
+		failingGet := func(delay time.Duration, err error) {
+			kubeClient.(*fake.Clientset).PrependReactor("get", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				time.Sleep(delay)
+				return true, nil, err
+			})
+		}
+
+		statusCode := func(err error) int {
+			failure, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue(), "expected a failure response, got %v", err)
+			return failure.ValidatedStatusCode(nil)
+		}
+
+		Context("when a call exceeds the timeout", func() {
+			BeforeEach(func() {
+				failingGet(50*time.Millisecond, context.DeadlineExceeded)
+			})
+
+			It("returns a gateway timeout", func() {
+				_, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+
+				Expect(statusCode(err)).To(Equal(http.StatusGatewayTimeout))
+				Expect(err.Error()).To(ContainSubstring("error getting instance"))
+				Expect(err.Error()).To(ContainSubstring("timed out waiting for kubernetes"))
+			})
+		})
+
+		Context("when the api server reports a timeout", func() {
+			BeforeEach(func() {
+				failingGet(0, apierrors.NewServerTimeout(corev1.Resource("persistentvolumeclaims"), "get", 1))
+			})
+
+			It("returns a gateway timeout", func() {
+				_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), true)
+
+				Expect(statusCode(err)).To(Equal(http.StatusGatewayTimeout))
+			})
+		})
+
+		Context("when the request is cancelled", func() {
+			BeforeEach(func() {
+				failingGet(0, context.Canceled)
+			})
+
+			It("returns service unavailable", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				_, err := testBroker.Deprovision(ctx, DefaultInstanceID, DefaultDeprovisionDetails(), true)
+
+				Expect(statusCode(err)).To(Equal(http.StatusServiceUnavailable))
+			})
+		})
+
+		Context("when the broker is shutting down", func() {
+			BeforeEach(func() {
+				failingGet(50*time.Millisecond, context.Canceled)
+				testBroker.Config.KubeTimeout = time.Minute
+			})
+
+			It("aborts the call", func() {
+				brokerCtx, cancel := context.WithCancel(context.Background())
+				cancel()
+				testBroker.Context = brokerCtx
+
+				_, err := testBroker.GetBinding(context.Background(), DefaultInstanceID, DefaultBindingID)
+
+				Expect(statusCode(err)).To(Equal(http.StatusServiceUnavailable))
+			})
+		})
+
+		Context("when a call fails for another reason", func() {
+			BeforeEach(func() {
+				failingGet(0, errors.New("connection refused"))
+			})
+
+			It("returns a generic error", func() {
+				_, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+
+				Expect(err).To(HaveOccurred())
+				_, ok := err.(*brokerapi.FailureResponse)
+				Expect(ok).To(BeFalse())
+				Expect(err.Error()).To(ContainSubstring("connection refused"))
+			})
+		})
+	})
 })
+
+// slowReadsClientset delays persistent volume claim reads after they have
+// been served by the fake clientset
+type slowReadsClientset struct {
+	*fake.Clientset
+}
+
+func (c slowReadsClientset) CoreV1() typedcorev1.CoreV1Interface {
+	return slowReadsCoreV1{c.Clientset.CoreV1()}
+}
+
+type slowReadsCoreV1 struct {
+	typedcorev1.CoreV1Interface
+}
+
+func (c slowReadsCoreV1) PersistentVolumeClaims(namespace string) typedcorev1.PersistentVolumeClaimInterface {
+	return slowReadsPVCs{c.CoreV1Interface.PersistentVolumeClaims(namespace)}
+}
+
+type slowReadsPVCs struct {
+	typedcorev1.PersistentVolumeClaimInterface
+}
+
+func (c slowReadsPVCs) Get(ctx context.Context, name string, options metav1.GetOptions) (*corev1.PersistentVolumeClaim, error) {
+	pvc, err := c.PersistentVolumeClaimInterface.Get(ctx, name, options)
+	time.Sleep(time.Millisecond)
+	return pvc, err
+}
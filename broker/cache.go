@@ -0,0 +1,65 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+// NewInformerFactory creates a shared informer factory that only watches the
+// broker's namespace and the persistent volume claims of its instances
+func NewInformerFactory(kubeClient kubernetes.Interface, c config.Config) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		informers.WithNamespace(c.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = InstanceSelector(c)
+		}),
+	)
+}
+
+// cachedInstance looks up the instance PVC in the informer cache. A cache
+// miss falls back to the API server before reporting that the instance
+// doesn't exist, since the cache may not have seen a new PVC yet. Cached
+// claims of deprovisioned instances are treated like misses, so the API
+// server decides whether the instance exists, like it does for instanceExists.
+// Operations
+// that change an instance use instanceExists instead so they never act on a
+// stale copy.
+func (b *KubeVolumeBroker) cachedInstance(ctx context.Context, instanceID string) (bool, *corev1.PersistentVolumeClaim, error) {
+	if b.ClaimLister == nil {
+		return b.instanceExists(ctx, instanceID)
+	}
+
+	claims := b.ClaimLister.PersistentVolumeClaims(b.Config.Namespace)
+	pvc, err := claims.Get(instanceID)
+	if err == nil && InstanceID(pvc) == instanceID && !IsDeleted(pvc) {
+		return true, pvc.DeepCopy(), nil
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, nil, errors.Wrap(err, "error reading persistent volume claim cache")
+	}
+
+	// Claims with a templated name are found by their instance label
+	if len(validation.IsValidLabelValue(instanceID)) == 0 {
+		pvcs, err := claims.List(labels.SelectorFromSet(labels.Set{InstanceIDLabel: instanceID}))
+		if err != nil {
+			return false, nil, errors.Wrap(err, "error reading persistent volume claim cache")
+		}
+		for _, pvc := range pvcs {
+			if !IsDeleted(pvc) {
+				return true, pvc.DeepCopy(), nil
+			}
+		}
+	}
+
+	return b.instanceExists(ctx, instanceID)
+}
@@ -0,0 +1,113 @@
+package broker_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Instance cache", func() {
+	var (
+		kubeClient *fake.Clientset
+		indexer    cache.Indexer
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	gets := func() int {
+		count := 0
+		for _, action := range kubeClient.Actions() {
+			if action.Matches("get", "persistentvolumeclaims") {
+				count++
+			}
+		}
+		return count
+	}
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		indexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient:  kubeClient,
+			ClaimLister: corev1listers.NewPersistentVolumeClaimLister(indexer),
+			Config: brokerconfig.Config{
+				ServiceConfiguration: DefaultServiceConfiguration(),
+				Namespace:            DefaultNamespace,
+			},
+		}
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultBindDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		kubeClient.ClearActions()
+	})
+
+	addToCache := func() {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(indexer.Add(pvc)).To(Succeed())
+		kubeClient.ClearActions()
+	}
+
+	It("reads instances and bindings from the cache", func() {
+		addToCache()
+
+		instance, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.PlanID).To(Equal(DefaultPlanID))
+
+		binding, err := testBroker.GetBinding(context.Background(), DefaultInstanceID, DefaultBindingID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binding.VolumeMounts[0].ContainerDir).To(Equal(DefaultMountLocation))
+
+		Expect(gets()).To(Equal(0))
+	})
+
+	It("falls back to the API server on a cache miss", func() {
+		instance, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.PlanID).To(Equal(DefaultPlanID))
+
+		Expect(gets()).To(Equal(1))
+	})
+
+	It("reports missing instances after checking the API server", func() {
+		_, err := testBroker.GetInstance(context.Background(), "missing")
+		Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+
+		Expect(gets()).To(Equal(1))
+	})
+
+	It("doesn't report deprovisioned instances from the cache", func() {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		pvc.Labels[broker.DeletedLabel] = "true"
+		pvc, err = kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Update(context.Background(), pvc, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(indexer.Add(pvc)).To(Succeed())
+
+		_, err = testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+	})
+
+	It("doesn't change instances based on the cache", func() {
+		addToCache()
+
+		_, err := testBroker.Unbind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultUnbindDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		// The cache still has the binding, the API server doesn't
+		_, err = testBroker.Unbind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultUnbindDetails(), false)
+		Expect(err).To(Equal(brokerapi.ErrBindingDoesNotExist))
+	})
+})
@@ -77,3 +77,16 @@ func DefaultUnbindDetails() brokerapi.UnbindDetails {
 		ServiceID: DefaultServiceID,
 	}
 }
+
+func DefaultUpdateDetails() brokerapi.UpdateDetails {
+	return brokerapi.UpdateDetails{
+		PlanID:    DefaultPlanID,
+		ServiceID: DefaultServiceID,
+		PreviousValues: brokerapi.PreviousValues{
+			PlanID:    DefaultPlanID,
+			ServiceID: DefaultServiceID,
+			OrgID:     DefaultOrgID,
+			SpaceID:   DefaultSpaceID,
+		},
+	}
+}
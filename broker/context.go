@@ -0,0 +1,96 @@
+package broker
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Labels and annotations recording the names Cloud Foundry knows an instance,
+// its organization and its space by. Names are kept verbatim in the
+// annotations; the labels hold them as valid label values, so claims can be
+// listed with e.g. kubectl get pvc -L space-name.
+const (
+	InstanceNameLabel     = "instance-name"
+	OrganizationNameLabel = "organization-name"
+	SpaceNameLabel        = "space-name"
+
+	InstanceNameAnnotation     = "eirini-broker-context-instance-name"
+	OrganizationNameAnnotation = "eirini-broker-context-organization-name"
+	SpaceNameAnnotation        = "eirini-broker-context-space-name"
+)
+
+// platformContext is the context object platforms send with provision,
+// update and bind requests. Fields the platform doesn't send are empty.
+type platformContext struct {
+	SpaceGUID        string `json:"space_guid"`
+	InstanceName     string `json:"instance_name"`
+	OrganizationName string `json:"organization_name"`
+	SpaceName        string `json:"space_name"`
+}
+
+// parseContext decodes a raw context object. Contexts that aren't objects
+// are ignored, the broker works without them.
+func parseContext(raw json.RawMessage) platformContext {
+	var c platformContext
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &c)
+	}
+
+	return c
+}
+
+// nameMetadata returns the labels and annotations recording the names in
+// the context. Names the platform didn't send are left out. The label of a
+// name that has no valid label value is nil, so patching removes a stale one.
+func (c platformContext) nameMetadata() (labels, annotations map[string]*string) {
+	labels = map[string]*string{}
+	annotations = map[string]*string{}
+
+	for _, name := range []struct {
+		value, label, annotation string
+	}{
+		{c.InstanceName, InstanceNameLabel, InstanceNameAnnotation},
+		{c.OrganizationName, OrganizationNameLabel, OrganizationNameAnnotation},
+		{c.SpaceName, SpaceNameLabel, SpaceNameAnnotation},
+	} {
+		if name.value == "" {
+			continue
+		}
+
+		value := name.value
+		annotations[name.annotation] = &value
+		labels[name.label] = nil
+		if labelValue := sanitizeLabelValue(value); labelValue != "" {
+			labels[name.label] = &labelValue
+		}
+	}
+
+	return labels, annotations
+}
+
+// sanitizeLabelValue turns name into a valid label value by replacing the
+// characters labels can't hold with dashes. It returns an empty string if
+// nothing of name is left.
+func sanitizeLabelValue(name string) string {
+	value := strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (isAlphanumeric(r) || r == '-' || r == '_' || r == '.') {
+			return r
+		}
+		return '-'
+	}, name)
+
+	if len(value) > validation.LabelValueMaxLength {
+		value = value[:validation.LabelValueMaxLength]
+	}
+
+	return strings.TrimFunc(value, func(r rune) bool {
+		return !isAlphanumeric(r)
+	})
+}
+
+func isAlphanumeric(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
@@ -0,0 +1,118 @@
+package broker_test
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Context", func() {
+	var (
+		kubeClient *fake.Clientset
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	getClaim := func() *corev1.PersistentVolumeClaim {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pvc
+	}
+
+	provision := func(rawContext string) {
+		details := DefaultProvisionDetails()
+		details.RawContext = []byte(rawContext)
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, details, false)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration: DefaultServiceConfiguration(),
+				Namespace:            DefaultNamespace,
+			},
+		}
+	})
+
+	It("records the names in the context", func() {
+		provision(`{"platform": "cloudfoundry", "instance_name": "uploads", "organization_name": "acme", "space_name": "production"}`)
+
+		pvc := getClaim()
+		Expect(pvc.Annotations).To(HaveKeyWithValue(broker.InstanceNameAnnotation, "uploads"))
+		Expect(pvc.Annotations).To(HaveKeyWithValue(broker.OrganizationNameAnnotation, "acme"))
+		Expect(pvc.Annotations).To(HaveKeyWithValue(broker.SpaceNameAnnotation, "production"))
+		Expect(pvc.Labels).To(HaveKeyWithValue(broker.InstanceNameLabel, "uploads"))
+		Expect(pvc.Labels).To(HaveKeyWithValue(broker.OrganizationNameLabel, "acme"))
+		Expect(pvc.Labels).To(HaveKeyWithValue(broker.SpaceNameLabel, "production"))
+	})
+
+	It("sanitizes names for labels", func() {
+		provision(`{"instance_name": "my uploads (old)", "organization_name": "Ünïcode", "space_name": "@@@"}`)
+
+		pvc := getClaim()
+		Expect(pvc.Annotations).To(HaveKeyWithValue(broker.InstanceNameAnnotation, "my uploads (old)"))
+		Expect(pvc.Labels).To(HaveKeyWithValue(broker.InstanceNameLabel, "my-uploads--old"))
+		Expect(pvc.Labels).To(HaveKeyWithValue(broker.OrganizationNameLabel, "n-code"))
+		Expect(pvc.Annotations).To(HaveKeyWithValue(broker.SpaceNameAnnotation, "@@@"))
+		Expect(pvc.Labels).NotTo(HaveKey(broker.SpaceNameLabel))
+	})
+
+	It("truncates long names for labels", func() {
+		provision(`{"instance_name": "` + strings.Repeat("a", 100) + `"}`)
+
+		Expect(getClaim().Labels).To(HaveKeyWithValue(broker.InstanceNameLabel, strings.Repeat("a", 63)))
+	})
+
+	It("provisions without a context", func() {
+		provision("")
+
+		Expect(getClaim().Annotations).NotTo(HaveKey(broker.InstanceNameAnnotation))
+	})
+
+	It("refreshes renamed names on update", func() {
+		provision(`{"instance_name": "uploads", "organization_name": "acme", "space_name": "production"}`)
+
+		details := DefaultUpdateDetails()
+		details.RawContext = []byte(`{"instance_name": "uploads", "organization_name": "acme", "space_name": "prod & staging"}`)
+		_, err := testBroker.Update(context.Background(), DefaultInstanceID, details, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		pvc := getClaim()
+		Expect(pvc.Annotations).To(HaveKeyWithValue(broker.SpaceNameAnnotation, "prod & staging"))
+		Expect(pvc.Labels).To(HaveKeyWithValue(broker.SpaceNameLabel, "prod---staging"))
+		Expect(pvc.Labels).To(HaveKeyWithValue(broker.InstanceNameLabel, "uploads"))
+	})
+
+	It("removes labels of names that can't be labels anymore", func() {
+		provision(`{"space_name": "production"}`)
+
+		details := DefaultUpdateDetails()
+		details.RawContext = []byte(`{"space_name": "???"}`)
+		_, err := testBroker.Update(context.Background(), DefaultInstanceID, details, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		pvc := getClaim()
+		Expect(pvc.Annotations).To(HaveKeyWithValue(broker.SpaceNameAnnotation, "???"))
+		Expect(pvc.Labels).NotTo(HaveKey(broker.SpaceNameLabel))
+	})
+
+	It("keeps the names if the update has no context", func() {
+		provision(`{"space_name": "production"}`)
+
+		_, err := testBroker.Update(context.Background(), DefaultInstanceID, DefaultUpdateDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(getClaim().Labels).To(HaveKeyWithValue(broker.SpaceNameLabel, "production"))
+	})
+})
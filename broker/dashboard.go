@@ -0,0 +1,43 @@
+package broker
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Paths of the instance dashboards, relative to the dashboard URL
+const (
+	DashboardInstancesPath = "/dashboard/instances/"
+	DashboardCallbackPath  = "/dashboard/callback"
+)
+
+// DashboardURL returns the URL of the dashboard of an instance, or an empty
+// string if the dashboard isn't enabled
+func (b *KubeVolumeBroker) DashboardURL(instanceID string) string {
+	if !b.Config.DashboardConfiguration.Enabled() {
+		return ""
+	}
+
+	return b.dashboardBaseURL() + DashboardInstancesPath + url.PathEscape(instanceID)
+}
+
+// dashboardClient returns the UAA client the platform registers for the
+// dashboard, or nil if the dashboard isn't enabled
+func (b *KubeVolumeBroker) dashboardClient() *brokerapi.ServiceDashboardClient {
+	dashboard := b.Config.DashboardConfiguration
+	if !dashboard.Enabled() {
+		return nil
+	}
+
+	return &brokerapi.ServiceDashboardClient{
+		ID:          dashboard.ClientID,
+		Secret:      dashboard.ClientSecret,
+		RedirectURI: b.dashboardBaseURL() + DashboardCallbackPath,
+	}
+}
+
+func (b *KubeVolumeBroker) dashboardBaseURL() string {
+	return strings.TrimSuffix(b.Config.DashboardConfiguration.URL, "/")
+}
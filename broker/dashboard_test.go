@@ -0,0 +1,74 @@
+package broker_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Dashboard", func() {
+	var (
+		dashboardConfig brokerconfig.DashboardConfiguration
+		testBroker      *broker.KubeVolumeBroker
+	)
+
+	BeforeEach(func() {
+		dashboardConfig = brokerconfig.DashboardConfiguration{
+			URL:          "https://persi-broker.example.com/",
+			ClientID:     "persi-dashboard",
+			ClientSecret: "secret",
+		}
+	})
+
+	JustBeforeEach(func() {
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: fake.NewSimpleClientset(),
+			Config: brokerconfig.Config{
+				ServiceConfiguration:   DefaultServiceConfiguration(),
+				Namespace:              DefaultNamespace,
+				DashboardConfiguration: dashboardConfig,
+			},
+		}
+	})
+
+	It("registers the dashboard client in the catalog", func() {
+		services, err := testBroker.Services(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		client := services[0].DashboardClient
+		Expect(client.ID).To(Equal("persi-dashboard"))
+		Expect(client.Secret).To(Equal("secret"))
+		Expect(client.RedirectURI).To(Equal("https://persi-broker.example.com/dashboard/callback"))
+	})
+
+	It("returns the dashboard URL of instances", func() {
+		spec, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.DashboardURL).To(Equal("https://persi-broker.example.com/dashboard/instances/" + DefaultInstanceID))
+
+		instance, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.DashboardURL).To(Equal(spec.DashboardURL))
+	})
+
+	Context("when the dashboard isn't configured", func() {
+		BeforeEach(func() {
+			dashboardConfig = brokerconfig.DashboardConfiguration{}
+		})
+
+		It("has no dashboard", func() {
+			services, err := testBroker.Services(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(services[0].DashboardClient).To(BeNil())
+
+			spec, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.DashboardURL).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,95 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Events", func() {
+	var (
+		kubeClient *fake.Clientset
+		recorder   *record.FakeRecorder
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	drainEvents := func() []string {
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		return events
+	}
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		recorder = record.NewFakeRecorder(10)
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration: DefaultServiceConfiguration(),
+				Namespace:            DefaultNamespace,
+			},
+			Recorder: recorder,
+		}
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		drainEvents()
+	})
+
+	It("includes the instance and binding GUIDs", func() {
+		_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultBindDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(drainEvents()).To(ConsistOf(
+			"Normal Bound Created service binding " + DefaultBindingID + " for service instance " + DefaultInstanceID + " mounted at " + DefaultMountLocation,
+		))
+	})
+
+	It("records a warning when an operation fails", func() {
+		kubeClient.PrependReactor("patch", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("admission webhook denied the request")
+		})
+
+		_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultBindDetails(), false)
+		Expect(err).To(HaveOccurred())
+
+		events := drainEvents()
+		Expect(events).To(HaveLen(1))
+		Expect(events[0]).To(HavePrefix("Warning BindingFailed Failed to create service binding " + DefaultBindingID + " for service instance " + DefaultInstanceID + ": "))
+		Expect(events[0]).To(ContainSubstring("admission webhook denied the request"))
+	})
+
+	It("records a warning when an update fails", func() {
+		kubeClient.PrependReactor("patch", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("admission webhook denied the request")
+		})
+
+		_, err := testBroker.Update(context.Background(), DefaultInstanceID, DefaultUpdateDetails(), false)
+		Expect(err).To(HaveOccurred())
+
+		Expect(drainEvents()).To(ConsistOf(And(
+			HavePrefix("Warning UpdateFailed Failed to update service instance "+DefaultInstanceID+": "),
+			ContainSubstring("admission webhook denied the request"),
+		)))
+	})
+
+	It("records a warning for instances that don't exist", func() {
+		_, err := testBroker.Unbind(context.Background(), "missing", DefaultBindingID, DefaultUnbindDetails(), false)
+		Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+
+		Expect(drainEvents()).To(ConsistOf(HavePrefix("Warning UnbindingFailed Failed to delete service binding " + DefaultBindingID + " of service instance missing")))
+	})
+
+})
@@ -0,0 +1,42 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+type contextKey string
+
+const alreadyExistsKey contextKey = "already-exists"
+
+// IdempotentResponses wraps the broker API so that repeated provision and bind
+// requests with identical details are answered with 200 OK instead of
+// 201 Created, as required by the Open Service Broker API.
+func IdempotentResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		alreadyExists := new(int32)
+		ctx := context.WithValue(req.Context(), alreadyExistsKey, alreadyExists)
+
+		next.ServeHTTP(&idempotentResponseWriter{ResponseWriter: w, alreadyExists: alreadyExists}, req.WithContext(ctx))
+	})
+}
+
+// markAlreadyExists records that the resource requested in ctx already existed
+func markAlreadyExists(ctx context.Context) {
+	if alreadyExists, ok := ctx.Value(alreadyExistsKey).(*int32); ok {
+		atomic.StoreInt32(alreadyExists, 1)
+	}
+}
+
+type idempotentResponseWriter struct {
+	http.ResponseWriter
+	alreadyExists *int32
+}
+
+func (w *idempotentResponseWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusCreated && atomic.LoadInt32(w.alreadyExists) == 1 {
+		statusCode = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
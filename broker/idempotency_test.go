@@ -0,0 +1,84 @@
+package broker_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("IdempotentResponses", func() {
+	var handler http.Handler
+
+	BeforeEach(func() {
+		testBroker := &broker.KubeVolumeBroker{
+			KubeClient: fake.NewSimpleClientset(),
+			Config: brokerconfig.Config{
+				ServiceConfiguration: DefaultServiceConfiguration(),
+				Namespace:            DefaultNamespace,
+			},
+		}
+
+		credentials := brokerapi.BrokerCredentials{Username: "user", Password: "pass"}
+		handler = broker.IdempotentResponses(brokerapi.New(testBroker, lagertest.NewTestLogger("broker"), credentials))
+	})
+
+	request := func(method, path string, body interface{}) int {
+		payload, err := json.Marshal(body)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.SetBasicAuth("user", "pass")
+		req.Header.Set("X-Broker-API-Version", "2.14")
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	provision := func(details brokerapi.ProvisionDetails) int {
+		return request("PUT", "/v2/service_instances/"+DefaultInstanceID, details)
+	}
+
+	bind := func(details brokerapi.BindDetails) int {
+		return request("PUT", "/v2/service_instances/"+DefaultInstanceID+"/service_bindings/"+DefaultBindingID, details)
+	}
+
+	It("returns 200 for a repeated identical provision", func() {
+		Expect(provision(DefaultProvisionDetails())).To(Equal(http.StatusCreated))
+		Expect(provision(DefaultProvisionDetails())).To(Equal(http.StatusOK))
+	})
+
+	It("returns 409 for a conflicting provision", func() {
+		Expect(provision(DefaultProvisionDetails())).To(Equal(http.StatusCreated))
+
+		details := DefaultProvisionDetails()
+		details.RawParameters = json.RawMessage(`{"size": "5Gi"}`)
+		Expect(provision(details)).To(Equal(http.StatusConflict))
+	})
+
+	It("returns 200 for a repeated identical binding", func() {
+		Expect(provision(DefaultProvisionDetails())).To(Equal(http.StatusCreated))
+
+		Expect(bind(DefaultBindDetails())).To(Equal(http.StatusCreated))
+		Expect(bind(DefaultBindDetails())).To(Equal(http.StatusOK))
+	})
+
+	It("returns 409 for a conflicting binding", func() {
+		Expect(provision(DefaultProvisionDetails())).To(Equal(http.StatusCreated))
+		Expect(bind(DefaultBindDetails())).To(Equal(http.StatusCreated))
+
+		details := DefaultBindDetails()
+		details.RawParameters = json.RawMessage(`{"dir": "/elsewhere"}`)
+		Expect(bind(details)).To(Equal(http.StatusConflict))
+	})
+})
@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// originatingIdentityKey is the context key brokerapi stores the
+// X-Broker-API-Originating-Identity header under
+const originatingIdentityKey = "originatingIdentity"
+
+// OriginatingIdentity is the platform user a request is made on behalf of
+type OriginatingIdentity struct {
+	Platform string
+	UserID   string
+}
+
+// ParseOriginatingIdentity decodes an X-Broker-API-Originating-Identity
+// header, which consists of the platform and a base64 encoded JSON object.
+// Cloud Foundry sends the user GUID as user_id, Kubernetes as uid.
+func ParseOriginatingIdentity(header string) (OriginatingIdentity, error) {
+	identity := OriginatingIdentity{}
+
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return identity, errors.New("originating identity must consist of a platform and a value")
+	}
+
+	value, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return identity, errors.Wrap(err, "error decoding originating identity value")
+	}
+
+	var properties struct {
+		UserID string `json:"user_id"`
+		UID    string `json:"uid"`
+	}
+	if err := json.Unmarshal(value, &properties); err != nil {
+		return identity, errors.Wrap(err, "error unmarshaling originating identity value")
+	}
+
+	identity.Platform = parts[0]
+	identity.UserID = properties.UserID
+	if identity.UserID == "" {
+		identity.UserID = properties.UID
+	}
+	if identity.UserID == "" {
+		return identity, errors.New("originating identity doesn't contain a user id")
+	}
+
+	return identity, nil
+}
+
+// originatingIdentity returns the identity of the user behind the request in
+// ctx. It returns false if the platform didn't send a valid identity.
+func originatingIdentity(ctx context.Context) (OriginatingIdentity, bool) {
+	header, _ := ctx.Value(originatingIdentityKey).(string)
+	if header == "" {
+		return OriginatingIdentity{}, false
+	}
+
+	identity, err := ParseOriginatingIdentity(header)
+	if err != nil {
+		return OriginatingIdentity{}, false
+	}
+
+	return identity, true
+}
+
+// WithOriginatingIdentity returns a context for operations made on behalf of
+// identity outside of a platform request, e.g. by an operator tool
+func WithOriginatingIdentity(ctx context.Context, identity OriginatingIdentity) context.Context {
+	value, _ := json.Marshal(map[string]string{"user_id": identity.UserID})
+	header := identity.Platform + " " + base64.StdEncoding.EncodeToString(value)
+
+	return context.WithValue(ctx, originatingIdentityKey, header)
+}
@@ -0,0 +1,275 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// JobLabel is set on the jobs the broker runs for an instance to its ID
+const JobLabel = "persi-job-for"
+
+const (
+	defaultJobTimeout = time.Hour
+	defaultJobTTL     = 24 * time.Hour
+	jobMount          = "/data"
+	jobLogTailLines   = 10
+
+	// jobBackoffLimit retries jobs that fail, for example on network errors
+	jobBackoffLimit = 2
+)
+
+// defaultJobCommand runs persi-transfer from the broker image
+var defaultJobCommand = []string{"/bin/persi-transfer"}
+
+// runJob starts a job that mounts the volume of pvc at /data and runs the
+// configured command with args. env is passed to the job in a secret of the
+// same name. The job and secret belong to the claim.
+func (b *KubeVolumeBroker) runJob(ctx context.Context, pvc *corev1.PersistentVolumeClaim, name string, args []string, env map[string]string) error {
+	jobs := b.Config.JobConfiguration
+
+	timeout := jobs.Timeout
+	if timeout == 0 {
+		timeout = defaultJobTimeout
+	}
+	deadline := int64(timeout.Seconds())
+	ttlAfterFinished := jobs.TTLAfterFinished
+	if ttlAfterFinished == 0 {
+		ttlAfterFinished = defaultJobTTL
+	}
+	ttl := int32(ttlAfterFinished.Seconds())
+	command := jobs.Command
+	if len(command) == 0 {
+		command = defaultJobCommand
+	}
+	backoffLimit := int32(jobBackoffLimit)
+	automountToken := false
+
+	meta := metav1.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{JobLabel: InstanceID(pvc)},
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Name:       pvc.Name,
+			UID:        pvc.UID,
+		}},
+	}
+
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	if _, err := b.KubeClient.CoreV1().Secrets(b.Config.Namespace).Create(kubeCtx, &corev1.Secret{
+		ObjectMeta: meta,
+		StringData: env,
+	}, metav1.CreateOptions{}); err != nil {
+		return kubeError(kubeCtx, err, "error creating job secret")
+	}
+
+	_, err := b.KubeClient.BatchV1().Jobs(b.Config.Namespace).Create(kubeCtx, &batchv1.Job{
+		ObjectMeta: meta,
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: meta.Labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:                corev1.RestartPolicyNever,
+					AutomountServiceAccountToken: &automountToken,
+					Containers: []corev1.Container{{
+						Name:    "transfer",
+						Image:   jobs.Image,
+						Command: command,
+						Args:    args,
+						EnvFrom: []corev1.EnvFromSource{{
+							SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}},
+						}},
+						VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: jobMount}},
+						// The kubelet keeps the end of the log of failed containers
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.Name},
+						},
+					}},
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		_ = b.KubeClient.CoreV1().Secrets(b.Config.Namespace).Delete(kubeCtx, name, metav1.DeleteOptions{})
+		return kubeError(kubeCtx, err, "error creating job")
+	}
+
+	return nil
+}
+
+// jobState returns the state of the job name as the state of an operation.
+// For failed jobs, it also returns why they failed and the tail of their log.
+// The secret of finished jobs is deleted; finished is only true the first
+// time a finished job is seen. The jobs are kept for operators until their
+// TTL after finishing is over.
+func (b *KubeVolumeBroker) jobState(ctx context.Context, name string) (state brokerapi.LastOperationState, detail string, finished bool, err error) {
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	job, err := b.KubeClient.BatchV1().Jobs(b.Config.Namespace).Get(kubeCtx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return brokerapi.Failed, "the job " + name + " doesn't exist anymore", false, nil
+	}
+	if err != nil {
+		return "", "", false, kubeError(kubeCtx, err, "error getting job")
+	}
+
+	state = brokerapi.InProgress
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch condition.Type {
+		case batchv1.JobComplete:
+			state = brokerapi.Succeeded
+		case batchv1.JobFailed:
+			state, detail = brokerapi.Failed, condition.Message
+			if tail := b.jobLogTail(kubeCtx, name); tail != "" {
+				detail += "\n" + tail
+			}
+		}
+	}
+	if state == brokerapi.InProgress {
+		return state, "", false, nil
+	}
+
+	err = b.KubeClient.CoreV1().Secrets(b.Config.Namespace).Delete(kubeCtx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return state, detail, false, nil
+	}
+	if err != nil {
+		return "", "", false, kubeError(kubeCtx, err, "error deleting job secret")
+	}
+
+	return state, detail, true, nil
+}
+
+// jobLogTail returns the last lines logged by the latest pod of a job. The
+// kubelet records them as termination message when the container fails.
+func (b *KubeVolumeBroker) jobLogTail(ctx context.Context, name string) string {
+	pods, err := b.KubeClient.CoreV1().Pods(b.Config.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{"job-name": name}).String(),
+	})
+	if err != nil || len(pods.Items) == 0 {
+		return ""
+	}
+
+	latest := pods.Items[0]
+	for _, pod := range pods.Items[1:] {
+		if pod.CreationTimestamp.After(latest.CreationTimestamp.Time) {
+			latest = pod
+		}
+	}
+
+	for _, status := range latest.Status.ContainerStatuses {
+		if terminated := status.State.Terminated; terminated != nil && terminated.Message != "" {
+			lines := strings.Split(strings.TrimSpace(terminated.Message), "\n")
+			if len(lines) > jobLogTailLines {
+				lines = lines[len(lines)-jobLogTailLines:]
+			}
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	return ""
+}
+
+// jobRunning returns true if a job of the instance hasn't finished yet
+func (b *KubeVolumeBroker) jobRunning(ctx context.Context, instanceID string) (bool, error) {
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	jobs, err := b.KubeClient.BatchV1().Jobs(b.Config.Namespace).List(kubeCtx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{JobLabel: instanceID}).String(),
+	})
+	if err != nil {
+		return false, kubeError(kubeCtx, err, "error listing jobs")
+	}
+
+	for _, job := range jobs.Items {
+		if !jobFinished(&job) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// jobFinished returns true if job completed or failed
+func jobFinished(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue &&
+			(condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deleteJobs stops the jobs of an instance and deletes them with their
+// secrets
+func (b *KubeVolumeBroker) deleteJobs(ctx context.Context, instanceID string) error {
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	selector := labels.SelectorFromSet(labels.Set{JobLabel: instanceID}).String()
+	propagation := metav1.DeletePropagationBackground
+	options := metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	jobs, err := b.KubeClient.BatchV1().Jobs(b.Config.Namespace).List(kubeCtx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return kubeError(kubeCtx, err, "error listing jobs")
+	}
+
+	for _, job := range jobs.Items {
+		err := b.KubeClient.BatchV1().Jobs(b.Config.Namespace).Delete(kubeCtx, job.Name, options)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return kubeError(kubeCtx, err, "error deleting job")
+		}
+	}
+
+	err = b.KubeClient.CoreV1().Secrets(b.Config.Namespace).DeleteCollection(kubeCtx, metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return kubeError(kubeCtx, err, "error deleting job secrets")
+	}
+
+	return nil
+}
+
+// deleteJob deletes the job name and its secret, if they still exist
+func (b *KubeVolumeBroker) deleteJob(ctx context.Context, name string) error {
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	propagation := metav1.DeletePropagationBackground
+	err := b.KubeClient.BatchV1().Jobs(b.Config.Namespace).Delete(kubeCtx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return kubeError(kubeCtx, err, "error deleting job")
+	}
+
+	err = b.KubeClient.CoreV1().Secrets(b.Config.Namespace).Delete(kubeCtx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return kubeError(kubeCtx, err, "error deleting job secret")
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// DefaultKubeTimeout bounds a single Kubernetes API call when no timeout is configured
+const DefaultKubeTimeout = 10 * time.Second
+
+// kubeContext derives the context for a single Kubernetes API call from the
+// request context. It is done when the request is cancelled, when the call
+// times out or when the broker context is cancelled during shutdown.
+func (b *KubeVolumeBroker) kubeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	kubeCtx, cancel := context.WithTimeout(ctx, b.KubeTimeout())
+	if b.Context == nil {
+		return kubeCtx, cancel
+	}
+
+	go func() {
+		select {
+		case <-b.Context.Done():
+			cancel()
+		case <-kubeCtx.Done():
+		}
+	}()
+
+	return kubeCtx, cancel
+}
+
+// KubeTimeout returns the configured timeout of a single Kubernetes API call
+func (b *KubeVolumeBroker) KubeTimeout() time.Duration {
+	if b.Config.KubeTimeout == 0 {
+		return DefaultKubeTimeout
+	}
+
+	return b.Config.KubeTimeout
+}
+
+// kubeError wraps an error returned by a Kubernetes call made with ctx.
+// Timeouts and cancellations become failure responses with a matching status
+// code instead of a generic internal server error.
+func kubeError(ctx context.Context, err error, message string) error {
+	switch {
+	case ctx.Err() == context.DeadlineExceeded, apierrors.IsTimeout(err), apierrors.IsServerTimeout(err):
+		return brokerapi.NewFailureResponse(
+			errors.Wrap(err, message+": timed out waiting for kubernetes"),
+			http.StatusGatewayTimeout,
+			"kubernetes-timeout",
+		)
+	case ctx.Err() == context.Canceled:
+		return brokerapi.NewFailureResponse(
+			errors.Wrap(err, message+": request cancelled"),
+			http.StatusServiceUnavailable,
+			"kubernetes-request-cancelled",
+		)
+	}
+
+	return errors.Wrap(err, message)
+}
+
+// wrapError adds context to err while keeping the status code of failure responses
+func wrapError(err error, message string) error {
+	if failure, ok := err.(*brokerapi.FailureResponse); ok {
+		return brokerapi.NewFailureResponse(
+			errors.Wrap(err, message),
+			failure.ValidatedStatusCode(nil),
+			failure.LoggerAction(),
+		)
+	}
+
+	return errors.Wrap(err, message)
+}
@@ -0,0 +1,84 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+
+	"code.cloudfoundry.org/eirini-persi-broker/log"
+)
+
+// RequestIdentityHeader is sent by platforms to correlate broker requests
+const RequestIdentityHeader = "X-Broker-API-Request-Identity"
+
+const requestIdentityKey contextKey = "request-identity"
+
+// WithRequestIdentity stores the request identity header of every request in
+// its context, so the broker can log it.
+func WithRequestIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if identity := req.Header.Get(RequestIdentityHeader); identity != "" {
+			req = req.WithContext(context.WithValue(req.Context(), requestIdentityKey, identity))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// RequestIdentity returns the request identity stored in ctx, if any
+func RequestIdentity(ctx context.Context) string {
+	identity, _ := ctx.Value(requestIdentityKey).(string)
+	return identity
+}
+
+// session starts a logging session for a broker operation. Empty values are
+// left out of the session data.
+func (b *KubeVolumeBroker) session(ctx context.Context, action string, data lager.Data) lager.Logger {
+	logger := b.Logger
+	if logger == nil {
+		logger = log.Logger()
+	}
+
+	sessionData := lager.Data{}
+	for key, value := range data {
+		if value != "" && value != nil {
+			sessionData[key] = value
+		}
+	}
+	if identity := RequestIdentity(ctx); identity != "" {
+		sessionData["request-identity"] = identity
+	}
+	if identity, ok := originatingIdentity(ctx); ok {
+		sessionData["platform"] = identity.Platform
+		sessionData["user-id"] = identity.UserID
+	}
+
+	return logger.Session(action, sessionData)
+}
+
+// logResult logs the outcome of a broker operation. The event is shown by the
+// cli log format.
+func logResult(logger lager.Logger, event string, err error) {
+	if err != nil {
+		logger.Error("failed", err, lager.Data{"event": event + " failed"})
+		return
+	}
+	logger.Info("done", lager.Data{"event": event})
+}
+
+// parametersData decodes user parameters for logging, so that sensitive keys
+// can be redacted by the log sinks. Parameters that aren't a JSON object
+// are left out.
+func parametersData(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var parameters map[string]interface{}
+	if err := json.Unmarshal(raw, &parameters); err != nil {
+		return nil
+	}
+
+	return parameters
+}
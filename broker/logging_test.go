@@ -0,0 +1,87 @@
+package broker_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Logging", func() {
+	var (
+		logger     *lagertest.TestLogger
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: fake.NewSimpleClientset(),
+			Config: brokerconfig.Config{
+				ServiceConfiguration: DefaultServiceConfiguration(),
+				Namespace:            DefaultNamespace,
+			},
+			Logger: logger,
+		}
+	})
+
+	It("logs a session with the instance, plan, organization and space", func() {
+		details := DefaultProvisionDetails()
+		details.RawParameters = []byte(`{"size":"2Gi"}`)
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, details, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		log := findLog(logger, "test.provision.done")
+		Expect(log.LogLevel).To(Equal(lager.INFO))
+		Expect(log.Data).To(HaveKeyWithValue("instance-id", DefaultInstanceID))
+		Expect(log.Data).To(HaveKeyWithValue("plan-id", DefaultPlanID))
+		Expect(log.Data).To(HaveKeyWithValue("organization-id", DefaultOrgID))
+		Expect(log.Data).To(HaveKeyWithValue("space-id", DefaultSpaceID))
+		Expect(log.Data).To(HaveKeyWithValue("parameters", HaveKeyWithValue("size", "2Gi")))
+		Expect(log.Data).To(HaveKeyWithValue("event", "Provisioned instance "+DefaultInstanceID))
+	})
+
+	It("logs failures as errors", func() {
+		_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultBindDetails(), false)
+		Expect(err).To(HaveOccurred())
+
+		log := findLog(logger, "test.bind.failed")
+		Expect(log.LogLevel).To(Equal(lager.ERROR))
+		Expect(log.Data).To(HaveKeyWithValue("instance-id", DefaultInstanceID))
+		Expect(log.Data).To(HaveKeyWithValue("binding-id", DefaultBindingID))
+	})
+
+	It("logs the request identity of the request", func() {
+		handler := broker.WithRequestIdentity(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_, err := testBroker.GetInstance(req.Context(), DefaultInstanceID)
+			Expect(err).To(HaveOccurred())
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/v2/service_instances/"+DefaultInstanceID, nil)
+		req.Header.Set(broker.RequestIdentityHeader, "e26cea57-b96e-4a0d-a3e6-4c4fca0c2bd7")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		log := findLog(logger, "test.get-instance.failed")
+		Expect(log.Data).To(HaveKeyWithValue("request-identity", "e26cea57-b96e-4a0d-a3e6-4c4fca0c2bd7"))
+	})
+})
+
+func findLog(logger *lagertest.TestLogger, message string) lager.LogFormat {
+	for _, log := range logger.Logs() {
+		if log.Message == message {
+			return log
+		}
+	}
+
+	Fail("no log with message " + message)
+	return lager.LogFormat{}
+}
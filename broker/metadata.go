@@ -0,0 +1,256 @@
+package broker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+// Annotations recording the keys of the labels and annotations the
+// configuration set on a claim, so they're removed once they're dropped
+// from the configuration
+const (
+	ConfiguredLabelsAnnotation      = "eirini-broker-configured-labels"
+	ConfiguredAnnotationsAnnotation = "eirini-broker-configured-annotations"
+)
+
+// metadataValues are the values the label and annotation templates of the
+// configuration can refer to
+type metadataValues struct {
+	InstanceID       string
+	InstanceName     string
+	OrganizationID   string
+	OrganizationName string
+	SpaceID          string
+	SpaceName        string
+	ServiceID        string
+	PlanID           string
+	PlanName         string
+}
+
+// claimValues returns the values for the instance pvc, taking names from
+// names if the platform sent them and from pvc otherwise
+func claimValues(pvc *corev1.PersistentVolumeClaim, plan *config.Plan, names platformContext) metadataValues {
+	values := metadataValues{
+		InstanceID:       InstanceID(pvc),
+		InstanceName:     pvc.Annotations[InstanceNameAnnotation],
+		OrganizationID:   pvc.Labels[OrganizationIDLabel],
+		OrganizationName: pvc.Annotations[OrganizationNameAnnotation],
+		SpaceID:          pvc.Labels[SpaceIDLabel],
+		SpaceName:        pvc.Annotations[SpaceNameAnnotation],
+		ServiceID:        pvc.Labels[ServiceIDLabel],
+		PlanID:           pvc.Labels[PlanIDLabel],
+	}
+	if plan != nil {
+		values.PlanName = plan.Name
+	}
+	if names.InstanceName != "" {
+		values.InstanceName = names.InstanceName
+	}
+	if names.OrganizationName != "" {
+		values.OrganizationName = names.OrganizationName
+	}
+	if names.SpaceName != "" {
+		values.SpaceName = names.SpaceName
+	}
+
+	return values
+}
+
+// configuredMetadata renders the labels and annotations the service and plan
+// configure for an instance. Plan values override those of the service, plan
+// may be nil. Rendered labels are sanitized into valid label values.
+func (b *KubeVolumeBroker) configuredMetadata(plan *config.Plan, values metadataValues) (labels, annotations map[string]string, err error) {
+	labels = map[string]string{}
+	annotations = map[string]string{}
+
+	service := b.Config.ServiceConfiguration
+	if err := renderTemplates(service.Labels, values, labels); err != nil {
+		return nil, nil, errors.Wrap(err, "error rendering labels of service")
+	}
+	if err := renderTemplates(service.Annotations, values, annotations); err != nil {
+		return nil, nil, errors.Wrap(err, "error rendering annotations of service")
+	}
+	if plan != nil {
+		if err := renderTemplates(plan.Labels, values, labels); err != nil {
+			return nil, nil, errors.Wrapf(err, "error rendering labels of plan %s", plan.Name)
+		}
+		if err := renderTemplates(plan.Annotations, values, annotations); err != nil {
+			return nil, nil, errors.Wrapf(err, "error rendering annotations of plan %s", plan.Name)
+		}
+	}
+
+	// Operators can't change what the broker records about instances
+	for key, value := range labels {
+		labels[key] = sanitizeLabelValue(value)
+		if isBrokerLabel(key) {
+			delete(labels, key)
+		}
+	}
+	for key := range annotations {
+		if IsBrokerAnnotation(key) {
+			delete(annotations, key)
+		}
+	}
+
+	return labels, annotations, nil
+}
+
+// renderTemplates executes the templates keyed by label or annotation and
+// stores the results in rendered
+func renderTemplates(templates map[string]string, values metadataValues, rendered map[string]string) error {
+	for key, text := range templates {
+		tmpl, err := template.New(key).Parse(text)
+		if err != nil {
+			return errors.Wrapf(err, "invalid template for %s", key)
+		}
+
+		var value strings.Builder
+		if err := tmpl.Execute(&value, values); err != nil {
+			return errors.Wrapf(err, "error executing template for %s", key)
+		}
+		rendered[key] = value.String()
+	}
+
+	return nil
+}
+
+// isBrokerLabel returns true for the labels the broker sets on instances
+func isBrokerLabel(key string) bool {
+	switch key {
+	case ServiceIDLabel, PlanIDLabel, OrganizationIDLabel, SpaceIDLabel, InstanceIDLabel,
+		InstanceNameLabel, OrganizationNameLabel, SpaceNameLabel, DeletedLabel:
+		return true
+	}
+
+	return false
+}
+
+// setPatchValues sets values in the labels or annotations of a merge patch
+func setPatchValues(patch map[string]*string, values map[string]string) {
+	for key, value := range values {
+		value := value
+		patch[key] = &value
+	}
+}
+
+// configuredKeys returns the sorted keys of configured as the value of a
+// ConfiguredLabelsAnnotation or ConfiguredAnnotationsAnnotation
+func configuredKeys(configured map[string]string) string {
+	keys := make([]string, 0, len(configured))
+	for key := range configured {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ",")
+}
+
+// removedKeys returns the keys in recorded, the value of a
+// ConfiguredLabelsAnnotation or ConfiguredAnnotationsAnnotation, that are no
+// longer in configured
+func removedKeys(recorded string, configured map[string]string) []string {
+	var removed []string
+	for _, key := range strings.Split(recorded, ",") {
+		if _, ok := configured[key]; key != "" && !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	return removed
+}
+
+// isUserLabel returns true if users may set the label key
+func (b *KubeVolumeBroker) isUserLabel(key string) bool {
+	for _, userLabel := range b.Config.ServiceConfiguration.UserLabels {
+		if userLabel == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkUserLabels returns an error unless the service allows users to set
+// the requested labels and their values are valid
+func (b *KubeVolumeBroker) checkUserLabels(requested map[string]string) error {
+	allowed := map[string]bool{}
+	for _, key := range b.Config.ServiceConfiguration.UserLabels {
+		allowed[key] = true
+	}
+
+	for key, value := range requested {
+		if !allowed[key] || isBrokerLabel(key) {
+			return invalidParameters(fmt.Sprintf("label %s can't be set, %s", key, allowedLabels(b.Config.ServiceConfiguration.UserLabels)))
+		}
+		if problems := validation.IsValidLabelValue(value); len(problems) > 0 {
+			return invalidParameters(fmt.Sprintf("invalid value for label %s: %s", key, strings.Join(problems, ", ")))
+		}
+	}
+
+	return nil
+}
+
+// allowedLabels describes the labels users may set
+func allowedLabels(keys []string) string {
+	if len(keys) == 0 {
+		return "no labels are allowed"
+	}
+
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	return "allowed labels are " + strings.Join(sorted, ", ")
+}
+
+// claimName renders the claim name template of plan, or of the service if
+// plan has none, into the name of the claim of an instance. Claims are named
+// after their instance ID if there's no template or it renders an empty name.
+func (b *KubeVolumeBroker) claimName(plan *config.Plan, values metadataValues) (string, error) {
+	text := b.Config.ServiceConfiguration.ClaimName
+	if plan != nil && plan.ClaimName != "" {
+		text = plan.ClaimName
+	}
+	if text == "" {
+		return values.InstanceID, nil
+	}
+
+	rendered := map[string]string{}
+	if err := renderTemplates(map[string]string{"claim name": text}, values, rendered); err != nil {
+		return "", errors.Wrap(err, "error rendering claim name")
+	}
+
+	name := sanitizeClaimName(rendered["claim name"])
+	if name == "" {
+		return values.InstanceID, nil
+	}
+
+	return name, nil
+}
+
+// sanitizeClaimName turns a rendered claim name into a valid DNS-1123 label
+func sanitizeClaimName(name string) string {
+	value := strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && isAlphanumeric(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, name)
+
+	if len(value) > validation.DNS1123LabelMaxLength {
+		value = value[:validation.DNS1123LabelMaxLength]
+	}
+
+	return strings.TrimFunc(value, func(r rune) bool {
+		return !isAlphanumeric(r)
+	})
+}
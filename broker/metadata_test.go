@@ -0,0 +1,261 @@
+package broker_test
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Metadata", func() {
+	var (
+		kubeClient           *fake.Clientset
+		serviceConfiguration brokerconfig.ServiceConfiguration
+		testBroker           *broker.KubeVolumeBroker
+		details              brokerapi.ProvisionDetails
+		provisionErr         error
+	)
+
+	getClaim := func() *corev1.PersistentVolumeClaim {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pvc
+	}
+
+	BeforeEach(func() {
+		serviceConfiguration = DefaultServiceConfiguration()
+		serviceConfiguration.Labels = map[string]string{
+			"cost-center": "storage",
+			"tier":        "default",
+			"space":       "{{.SpaceName}}",
+		}
+		serviceConfiguration.Annotations = map[string]string{
+			"example.com/owner": "{{.OrganizationID}}/{{.SpaceID}}",
+		}
+		serviceConfiguration.Plans[0].Labels = map[string]string{
+			"tier": "{{.PlanName}}",
+		}
+		serviceConfiguration.Plans[0].Annotations = map[string]string{
+			"backup.example.com/schedule": "daily",
+		}
+		serviceConfiguration.UserLabels = []string{"team"}
+
+		details = DefaultProvisionDetails()
+		details.RawContext = []byte(`{"instance_name": "uploads", "space_name": "my space"}`)
+	})
+
+	JustBeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration: serviceConfiguration,
+				Namespace:            DefaultNamespace,
+			},
+		}
+
+		_, provisionErr = testBroker.Provision(context.Background(), DefaultInstanceID, details, false)
+	})
+
+	It("sets the configured labels and annotations", func() {
+		Expect(provisionErr).NotTo(HaveOccurred())
+
+		pvc := getClaim()
+		Expect(pvc.Labels).To(HaveKeyWithValue("cost-center", "storage"))
+		Expect(pvc.Labels).To(HaveKeyWithValue("tier", DefaultPlanName))
+		Expect(pvc.Labels).To(HaveKeyWithValue("space", "my-space"))
+		Expect(pvc.Annotations).To(HaveKeyWithValue("example.com/owner", DefaultOrgID+"/"+DefaultSpaceID))
+		Expect(pvc.Annotations).To(HaveKeyWithValue("backup.example.com/schedule", "daily"))
+	})
+
+	Context("when the configuration sets labels of the broker", func() {
+		BeforeEach(func() {
+			serviceConfiguration.Labels[broker.PlanIDLabel] = "other-plan"
+			serviceConfiguration.Annotations[broker.CreatedByAnnotation] = "operator"
+		})
+
+		It("keeps the labels of the broker", func() {
+			Expect(provisionErr).NotTo(HaveOccurred())
+
+			pvc := getClaim()
+			Expect(pvc.Labels).To(HaveKeyWithValue(broker.PlanIDLabel, DefaultPlanID))
+			Expect(pvc.Annotations).NotTo(HaveKey(broker.CreatedByAnnotation))
+		})
+	})
+
+	Context("when a template is invalid", func() {
+		BeforeEach(func() {
+			serviceConfiguration.Labels["space"] = "{{.SpaceNam}}"
+		})
+
+		It("fails", func() {
+			Expect(provisionErr).To(MatchError(ContainSubstring("error rendering labels of service")))
+		})
+	})
+
+	Context("when the user passes labels", func() {
+		BeforeEach(func() {
+			details.RawParameters = []byte(`{"labels": {"team": "billing"}}`)
+		})
+
+		It("sets them", func() {
+			Expect(provisionErr).NotTo(HaveOccurred())
+			Expect(getClaim().Labels).To(HaveKeyWithValue("team", "billing"))
+		})
+
+		It("updates them", func() {
+			updateDetails := DefaultUpdateDetails()
+			updateDetails.RawParameters = []byte(`{"labels": {"team": "payments"}}`)
+
+			_, err := testBroker.Update(context.Background(), DefaultInstanceID, updateDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getClaim().Labels).To(HaveKeyWithValue("team", "payments"))
+		})
+	})
+
+	Context("when the user passes labels that aren't allowed", func() {
+		BeforeEach(func() {
+			details.RawParameters = []byte(`{"labels": {"cost-center": "free"}}`)
+		})
+
+		It("fails", func() {
+			Expect(provisionErr).To(HaveOccurred())
+			Expect(provisionErr.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+			Expect(provisionErr.Error()).To(Equal("label cost-center can't be set, allowed labels are team"))
+		})
+	})
+
+	Context("when the user passes invalid label values", func() {
+		BeforeEach(func() {
+			details.RawParameters = []byte(`{"labels": {"team": "billing & payments"}}`)
+		})
+
+		It("fails", func() {
+			Expect(provisionErr).To(HaveOccurred())
+			Expect(provisionErr.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+
+	It("labels the claim with the instance ID", func() {
+		Expect(provisionErr).NotTo(HaveOccurred())
+
+		pvc := getClaim()
+		Expect(pvc.Labels).To(HaveKeyWithValue(broker.InstanceIDLabel, DefaultInstanceID))
+		Expect(broker.InstanceID(pvc)).To(Equal(DefaultInstanceID))
+	})
+
+	Context("when a claim name is configured", func() {
+		BeforeEach(func() {
+			serviceConfiguration.ClaimName = "{{.SpaceName}}-{{.InstanceName}}"
+		})
+
+		getNamedClaim := func(name string) *corev1.PersistentVolumeClaim {
+			pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return pvc
+		}
+
+		It("names the claim after the rendered template", func() {
+			Expect(provisionErr).NotTo(HaveOccurred())
+
+			pvc := getNamedClaim("my-space-uploads")
+			Expect(pvc.Labels).To(HaveKeyWithValue(broker.InstanceIDLabel, DefaultInstanceID))
+			Expect(broker.InstanceID(pvc)).To(Equal(DefaultInstanceID))
+		})
+
+		It("finds the instance by its ID", func() {
+			_, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultBindDetails(), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getNamedClaim("my-space-uploads").Annotations).To(HaveKey(ContainSubstring(DefaultBindingID)))
+
+			_, err = testBroker.Deprovision(context.Background(), DefaultInstanceID, DefaultDeprovisionDetails(), false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = testBroker.GetInstance(context.Background(), DefaultInstanceID)
+			Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+		})
+
+		It("accepts an identical request", func() {
+			_, err := testBroker.Provision(context.Background(), DefaultInstanceID, details, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			pvcs, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pvcs.Items).To(HaveLen(1))
+		})
+
+		It("fails when another instance has the name", func() {
+			_, err := testBroker.Provision(context.Background(), "other-instance", details, false)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+			Expect(err.Error()).NotTo(ContainSubstring("my-space-uploads"))
+		})
+
+		Context("when the plan has a claim name", func() {
+			BeforeEach(func() {
+				serviceConfiguration.Plans[0].ClaimName = "Gold_{{.InstanceName}}"
+			})
+
+			It("overrides the claim name of the service", func() {
+				Expect(provisionErr).NotTo(HaveOccurred())
+				getNamedClaim("gold-uploads")
+			})
+		})
+
+		Context("when the template renders an empty name", func() {
+			BeforeEach(func() {
+				details.RawContext = nil
+				serviceConfiguration.ClaimName = "{{.InstanceName}}"
+			})
+
+			It("names the claim after the instance ID", func() {
+				Expect(provisionErr).NotTo(HaveOccurred())
+				getClaim()
+			})
+		})
+	})
+
+	It("renders the templates again on update", func() {
+		updateDetails := DefaultUpdateDetails()
+		updateDetails.RawContext = []byte(`{"space_name": "renamed"}`)
+
+		_, err := testBroker.Update(context.Background(), DefaultInstanceID, updateDetails, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		pvc := getClaim()
+		Expect(pvc.Labels).To(HaveKeyWithValue("space", "renamed"))
+		Expect(pvc.Labels).To(HaveKeyWithValue("tier", DefaultPlanName))
+	})
+
+	It("removes the labels and annotations dropped from the configuration", func() {
+		updateDetails := DefaultUpdateDetails()
+		updateDetails.RawParameters = []byte(`{"labels": {"team": "storage"}}`)
+		_, err := testBroker.Update(context.Background(), DefaultInstanceID, updateDetails, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		testBroker.Config.ServiceConfiguration.Labels = map[string]string{"space": "{{.SpaceName}}"}
+		testBroker.Config.ServiceConfiguration.Annotations = nil
+		testBroker.Config.ServiceConfiguration.Plans[0].Labels = nil
+		_, err = testBroker.Update(context.Background(), DefaultInstanceID, DefaultUpdateDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		pvc := getClaim()
+		Expect(pvc.Labels).NotTo(HaveKey("cost-center"))
+		Expect(pvc.Labels).NotTo(HaveKey("tier"))
+		Expect(pvc.Labels).To(HaveKeyWithValue("space", "my-space"))
+		Expect(pvc.Labels).To(HaveKeyWithValue("team", "storage"))
+		Expect(pvc.Labels).To(HaveKeyWithValue(broker.InstanceIDLabel, DefaultInstanceID))
+		Expect(pvc.Annotations).NotTo(HaveKey("example.com/owner"))
+		Expect(pvc.Annotations).To(HaveKeyWithValue("backup.example.com/schedule", "daily"))
+	})
+})
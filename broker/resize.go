@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+// resizedTo validates the size users ask to resize the instance of pvc to
+// with cf update-service. It returns nil if the size doesn't change.
+// Persistent volume claims can only grow, up to the max size of plan.
+func resizedTo(plan *config.Plan, pvc *corev1.PersistentVolumeClaim, size string) (*resource.Quantity, error) {
+	if size == "" {
+		return nil, nil
+	}
+
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return nil, invalidParameters("invalid quantity string")
+	}
+	if err := checkMaxSize(plan, quantity); err != nil {
+		return nil, err
+	}
+
+	current := pvc.Spec.Resources.Requests["storage"]
+	switch quantity.Cmp(current) {
+	case -1:
+		return nil, invalidParameters(fmt.Sprintf("can't shrink instance from %s to %s", current.String(), quantity.String()))
+	case 0:
+		return nil, nil
+	}
+
+	return &quantity, nil
+}
+
+// checkAccessModeUnchanged returns an error if users ask for another access
+// mode than the one of pvc, which can't be changed once it's provisioned
+func checkAccessModeUnchanged(pvc *corev1.PersistentVolumeClaim, accessMode string) error {
+	if accessMode == "" {
+		return nil
+	}
+	if len(pvc.Spec.AccessModes) != 1 || string(pvc.Spec.AccessModes[0]) != accessMode {
+		return invalidParameters("the access mode of an instance can't be changed")
+	}
+
+	return nil
+}
+
+// Grow expands the instance PVC to size on behalf of the broker itself,
+// without a request from the platform
+func (b *KubeVolumeBroker) Grow(ctx context.Context, pvc *corev1.PersistentVolumeClaim, size resource.Quantity) (*corev1.PersistentVolumeClaim, error) {
+	instanceID := InstanceID(pvc)
+	current := pvc.Spec.Resources.Requests["storage"]
+
+	grown, err := b.patchInstance(ctx, pvc.Name, map[string]interface{}{
+		"spec": storageRequestPatch(size),
+	})
+	if err != nil {
+		b.recordFailure(ctx, instanceID, pvc, ReasonUpdateFailed, "Failed to automatically resize service instance "+instanceID, err)
+		return nil, wrapError(err, "error growing persistent volume claim")
+	}
+
+	b.recordEvent(ctx, instanceID, grown, ReasonResized, fmt.Sprintf("Automatically resized service instance %s from %s to %s", instanceID, current.String(), size.String()))
+
+	return grown, nil
+}
+
+// storageRequestPatch returns the part of a merge patch setting the storage
+// requested by a PVC
+func storageRequestPatch(size resource.Quantity) map[string]interface{} {
+	return map[string]interface{}{
+		"resources": map[string]interface{}{
+			"requests": map[string]interface{}{
+				"storage": size.String(),
+			},
+		},
+	}
+}
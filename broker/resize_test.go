@@ -0,0 +1,125 @@
+package broker_test
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Resizing", func() {
+	var (
+		kubeClient *fake.Clientset
+		recorder   *record.FakeRecorder
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	drainEvents := func() []string {
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		return events
+	}
+
+	update := func(parameters string) error {
+		details := DefaultUpdateDetails()
+		details.RawParameters = []byte(parameters)
+		_, err := testBroker.Update(context.Background(), DefaultInstanceID, details, false)
+		return err
+	}
+
+	requestedSize := func() resource.Quantity {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pvc.Spec.Resources.Requests["storage"]
+	}
+
+	statusCode := func(err error) int {
+		Expect(err).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+		return err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)
+	}
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		recorder = record.NewFakeRecorder(10)
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration: DefaultServiceConfiguration(),
+				Namespace:            DefaultNamespace,
+			},
+			Recorder: recorder,
+		}
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		drainEvents()
+	})
+
+	It("grows the persistent volume claim", func() {
+		Expect(update(`{"size": "2Gi"}`)).To(Succeed())
+
+		Expect(requestedSize()).To(Equal(resource.MustParse("2Gi")))
+		Expect(drainEvents()).To(ConsistOf("Normal Resized Resized service instance " + DefaultInstanceID + " from 1Gi to 2Gi"))
+	})
+
+	It("doesn't shrink the persistent volume claim", func() {
+		err := update(`{"size": "512Mi"}`)
+		Expect(err).To(MatchError(ContainSubstring("can't shrink instance from 1Gi to 512Mi")))
+		Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+
+		Expect(requestedSize()).To(Equal(resource.MustParse("1Gi")))
+	})
+
+	It("leaves the size alone when it doesn't change", func() {
+		Expect(update(`{"size": "1024Mi"}`)).To(Succeed())
+
+		Expect(requestedSize()).To(Equal(resource.MustParse("1Gi")))
+		Expect(drainEvents()).To(ConsistOf("Normal Updated Updated service instance " + DefaultInstanceID))
+	})
+
+	It("rejects invalid sizes", func() {
+		err := update(`{"size": "a lot"}`)
+		Expect(err).To(MatchError("invalid quantity string"))
+		Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("doesn't exceed the plan's max size", func() {
+		testBroker.Config.ServiceConfiguration.Plans[0].MaxSize = "4Gi"
+
+		Expect(update(`{"size": "4Gi"}`)).To(Succeed())
+		err := update(`{"size": "5Gi"}`)
+		Expect(err).To(MatchError(ContainSubstring("size 5Gi exceeds the max size 4Gi of plan " + DefaultPlanName)))
+		Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+		Expect(requestedSize()).To(Equal(resource.MustParse("4Gi")))
+	})
+
+	It("doesn't provision beyond the plan's max size", func() {
+		testBroker.Config.ServiceConfiguration.Plans[0].MaxSize = "4Gi"
+
+		details := DefaultProvisionDetails()
+		details.RawParameters = []byte(`{"size": "8Gi"}`)
+		_, err := testBroker.Provision(context.Background(), "other-instance", details, false)
+		Expect(err).To(MatchError(ContainSubstring("exceeds the max size")))
+	})
+
+	It("doesn't change the access mode", func() {
+		err := update(`{"access_mode": "ReadWriteOnce"}`)
+		Expect(err).To(MatchError(ContainSubstring("the access mode of an instance can't be changed")))
+		Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("accepts the access mode the instance already has", func() {
+		Expect(update(`{"access_mode": "ReadWriteMany"}`)).To(Succeed())
+	})
+})
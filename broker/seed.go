@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+	corev1 "k8s.io/api/core/v1"
+
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
+	"code.cloudfoundry.org/eirini-persi-broker/transfer"
+)
+
+// SeedAnnotation records the archive an instance was seeded from
+const SeedAnnotation = "eirini-broker-seed"
+
+// seedJobPrefix starts the names of seed jobs, which are also the operation
+// data of provisions that seed the volume
+const seedJobPrefix = "persi-seed-"
+
+// seedParameters is the archive or snapshot users ask to seed a new
+// instance from with the seed parameter of cf create-service
+type seedParameters struct {
+	URL      string              `json:"url"`
+	S3       *s3Object           `json:"s3"`
+	Snapshot *snapshotParameters `json:"snapshot"`
+	Format   string              `json:"format"`
+}
+
+// s3Object is an object in an S3 compatible store
+type s3Object struct {
+	objectstore.Config
+	Key string `json:"key"`
+}
+
+func seedJobName(instanceID string) string {
+	return seedJobPrefix + instanceID
+}
+
+// seedSource validates the seed parameters and returns where to seed from
+// and the format of the archive
+func (b *KubeVolumeBroker) seedSource(seed *seedParameters) (transfer.Source, string, error) {
+	if !b.Config.JobConfiguration.Enabled() {
+		return transfer.Source{}, "", invalidParameters("seeding instances isn't enabled")
+	}
+
+	var source transfer.Source
+	switch {
+	case seed.URL != "" && seed.S3 != nil:
+		return source, "", invalidParameters("seed from either a url or an s3 object, not both")
+	case seed.URL != "":
+		u, err := url.Parse(seed.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return source, "", invalidParameters("the seed url must be an http or https url")
+		}
+		source.URL = seed.URL
+	case seed.S3 != nil:
+		if seed.S3.Endpoint == "" || seed.S3.Bucket == "" || seed.S3.Key == "" {
+			return source, "", invalidParameters("the seed s3 object needs an endpoint, bucket and key")
+		}
+		source.Store, source.Key = seed.S3.Config, seed.S3.Key
+	default:
+		return source, "", invalidParameters("the seed needs a url, an s3 object or a snapshot")
+	}
+
+	format := seed.Format
+	if format == "" {
+		var err error
+		if format, err = transfer.FormatOf(source.Name()); err != nil {
+			return source, "", invalidParameters(err.Error() + ", set the seed format")
+		}
+	}
+	for _, known := range transfer.Formats {
+		if format == known {
+			return source, format, nil
+		}
+	}
+
+	return source, "", invalidParameters(fmt.Sprintf("unknown seed format %s, formats are %s", format, strings.Join(transfer.Formats, ", ")))
+}
+
+// startSeeding runs the job that extracts the seed archive into the volume
+// of pvc
+func (b *KubeVolumeBroker) startSeeding(ctx context.Context, pvc *corev1.PersistentVolumeClaim, source transfer.Source, format string) error {
+	instanceID := InstanceID(pvc)
+	err := b.runJob(ctx, pvc, seedJobName(instanceID), []string{"seed", "-dir", jobMount, "-format", format}, source.Env())
+	if err != nil {
+		return wrapError(err, "error starting seed job")
+	}
+
+	b.recordEvent(ctx, instanceID, pvc, ReasonProvisioned, "Seeding service instance "+instanceID+" from "+source.String())
+	return nil
+}
+
+// seedOperation reports the state of seeding an instance
+func (b *KubeVolumeBroker) seedOperation(ctx context.Context, instanceID string) (brokerapi.LastOperation, error) {
+	volumeExists, pvc, err := b.instanceExists(ctx, instanceID)
+	if err != nil {
+		return brokerapi.LastOperation{}, wrapError(err, "error getting instance")
+	}
+	if !volumeExists {
+		return brokerapi.LastOperation{}, brokerapi.ErrInstanceDoesNotExist
+	}
+
+	source := pvc.Annotations[SeedAnnotation]
+	state, detail, finished, err := b.jobState(ctx, seedJobName(instanceID))
+	if err != nil {
+		return brokerapi.LastOperation{}, wrapError(err, "error getting seed job")
+	}
+
+	operation := brokerapi.LastOperation{State: state}
+	switch state {
+	case brokerapi.InProgress:
+		operation.Description = "Seeding from " + source
+	case brokerapi.Succeeded:
+		operation.Description = "Seeded from " + source
+		if finished {
+			b.recordEvent(ctx, instanceID, pvc, ReasonSeeded, "Seeded service instance "+instanceID+" from "+source)
+		}
+	case brokerapi.Failed:
+		operation.Description = "Seeding from " + source + " failed: " + detail
+		if finished {
+			b.record(ctx, instanceID, pvc, corev1.EventTypeWarning, ReasonSeedingFailed, "Failed to seed service instance "+instanceID+" from "+source+": "+detail)
+		}
+	}
+
+	return operation, nil
+}
@@ -0,0 +1,322 @@
+package broker_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Seeding", func() {
+	var (
+		kubeClient *fake.Clientset
+		jobs       brokerconfig.JobConfiguration
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	jobName := "persi-seed-" + DefaultInstanceID
+
+	seedDetails := func(parameters string) brokerapi.ProvisionDetails {
+		details := DefaultProvisionDetails()
+		details.RawParameters = []byte(parameters)
+		return details
+	}
+
+	getJob := func() *batchv1.Job {
+		job, err := kubeClient.BatchV1().Jobs(DefaultNamespace).Get(context.Background(), jobName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return job
+	}
+
+	setJobCondition := func(conditionType batchv1.JobConditionType, message string) {
+		job := getJob()
+		job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: message}}
+		_, err := kubeClient.BatchV1().Jobs(DefaultNamespace).UpdateStatus(context.Background(), job, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	lastOperation := func(operationData string) brokerapi.LastOperation {
+		operation, err := testBroker.LastOperation(context.Background(), DefaultInstanceID, brokerapi.PollDetails{OperationData: operationData})
+		Expect(err).NotTo(HaveOccurred())
+		return operation
+	}
+
+	statusCode := func(err error) int {
+		Expect(err).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+		return err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)
+	}
+
+	BeforeEach(func() {
+		jobs = brokerconfig.JobConfiguration{Image: "eirini/persi-broker"}
+	})
+
+	JustBeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration: DefaultServiceConfiguration(),
+				Namespace:            DefaultNamespace,
+				JobConfiguration:     jobs,
+			},
+		}
+	})
+
+	Context("when seeding from a url", func() {
+		var spec brokerapi.ProvisionedServiceSpec
+
+		JustBeforeEach(func() {
+			var err error
+			spec, err = testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"seed": {"url": "https://data.example.com/cities.tar.gz?token=secret"}}`), true)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("provisions asynchronously", func() {
+			Expect(spec.IsAsync).To(BeTrue())
+			Expect(spec.OperationData).To(Equal(jobName))
+		})
+
+		It("runs a job filling the volume", func() {
+			job := getJob()
+			Expect(job.Labels).To(HaveKeyWithValue(broker.JobLabel, DefaultInstanceID))
+			Expect(job.OwnerReferences[0].Name).To(Equal(DefaultInstanceID))
+
+			pod := job.Spec.Template.Spec
+			Expect(pod.Containers[0].Image).To(Equal("eirini/persi-broker"))
+			Expect(pod.Containers[0].Command).To(Equal([]string{"/bin/persi-transfer"}))
+			Expect(pod.Containers[0].Args).To(Equal([]string{"seed", "-dir", "/data", "-format", "tar.gz"}))
+			Expect(pod.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(DefaultInstanceID))
+
+			secret, err := kubeClient.CoreV1().Secrets(DefaultNamespace).Get(context.Background(), jobName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret.StringData).To(HaveKeyWithValue("PERSI_SEED_URL", "https://data.example.com/cities.tar.gz?token=secret"))
+		})
+
+		It("records the source without credentials", func() {
+			pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pvc.Annotations).To(HaveKeyWithValue(broker.SeedAnnotation, "https://data.example.com/cities.tar.gz"))
+		})
+
+		It("reports the operation in progress until the job completes", func() {
+			operation := lastOperation(spec.OperationData)
+			Expect(operation.State).To(Equal(brokerapi.InProgress))
+			Expect(operation.Description).To(Equal("Seeding from https://data.example.com/cities.tar.gz"))
+
+			setJobCondition(batchv1.JobComplete, "")
+
+			operation = lastOperation(spec.OperationData)
+			Expect(operation.State).To(Equal(brokerapi.Succeeded))
+
+			_, err := kubeClient.CoreV1().Secrets(DefaultNamespace).Get(context.Background(), jobName, metav1.GetOptions{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("reports failed jobs with their log tail", func() {
+			var log string
+			for i := 0; i < 15; i++ {
+				log += "downloading\n"
+			}
+			log += "error downloading https://data.example.com/cities.tar.gz: 404 Not Found\n"
+
+			_, err := kubeClient.CoreV1().Pods(DefaultNamespace).Create(context.Background(), &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: jobName + "-x7k2p", Labels: map[string]string{"job-name": jobName}},
+				Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: log}},
+				}}},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			setJobCondition(batchv1.JobFailed, "Job has reached the specified backoff limit")
+
+			operation := lastOperation(spec.OperationData)
+			Expect(operation.State).To(Equal(brokerapi.Failed))
+			Expect(operation.Description).To(HavePrefix("Seeding from https://data.example.com/cities.tar.gz failed: Job has reached the specified backoff limit\ndownloading\n"))
+			Expect(operation.Description).To(HaveSuffix("\nerror downloading https://data.example.com/cities.tar.gz: 404 Not Found"))
+			Expect(strings.Count(operation.Description, "\n")).To(Equal(10))
+		})
+
+		It("answers repeated requests asynchronously", func() {
+			repeated, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"seed": {"url": "https://data.example.com/cities.tar.gz?token=secret"}}`), true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repeated.IsAsync).To(BeTrue())
+			Expect(repeated.OperationData).To(Equal(jobName))
+		})
+
+		It("stops the job on deprovision", func() {
+			_, err := testBroker.Deprovision(context.Background(), DefaultInstanceID, DefaultDeprovisionDetails(), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = kubeClient.BatchV1().Jobs(DefaultNamespace).Get(context.Background(), jobName, metav1.GetOptions{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	It("seeds from s3 objects", func() {
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"seed": {"s3": {
+			"endpoint": "https://minio.example.com", "bucket": "reference", "key": "cities.zip",
+			"access_key_id": "key", "secret_access_key": "secret"}}}`), true)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(getJob().Spec.Template.Spec.Containers[0].Args).To(ContainElement("zip"))
+
+		secret, err := kubeClient.CoreV1().Secrets(DefaultNamespace).Get(context.Background(), jobName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.StringData).To(HaveKeyWithValue("PERSI_S3_ENDPOINT", "https://minio.example.com"))
+		Expect(secret.StringData).To(HaveKeyWithValue("PERSI_S3_SECRET_ACCESS_KEY", "secret"))
+		Expect(secret.StringData).To(HaveKeyWithValue("PERSI_SEED_KEY", "cities.zip"))
+	})
+
+	It("requires asynchronous provisioning", func() {
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"seed": {"url": "https://data.example.com/cities.tar"}}`), false)
+		Expect(err).To(Equal(brokerapi.ErrAsyncRequired))
+	})
+
+	It("rejects invalid seeds", func() {
+		for _, parameters := range []string{
+			`{"seed": {}}`,
+			`{"seed": {"url": "file:///etc/passwd"}}`,
+			`{"seed": {"url": "https://data.example.com/cities"}}`,
+			`{"seed": {"url": "https://data.example.com/cities", "format": "rar"}}`,
+			`{"seed": {"s3": {"bucket": "reference"}}}`,
+			`{"seed": {"url": "https://data.example.com/cities.tar", "snapshot": {"instance_id": "source-instance"}}}`,
+		} {
+			_, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(parameters), true)
+			Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity), parameters)
+		}
+
+		_, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("reports other operations as succeeded", func() {
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), true)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(lastOperation("").State).To(Equal(brokerapi.Succeeded))
+	})
+
+	Context("when seeding from a snapshot", func() {
+		var (
+			snapshotClient *dynamicfake.FakeDynamicClient
+			sourceSpace    string
+		)
+
+		volumeSnapshot := func(name, claim string, created string, ready bool) *unstructured.Unstructured {
+			return &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "snapshot.storage.k8s.io/v1beta1",
+				"kind":       "VolumeSnapshot",
+				"metadata":   map[string]interface{}{"name": name, "namespace": DefaultNamespace},
+				"spec": map[string]interface{}{
+					"source": map[string]interface{}{"persistentVolumeClaimName": claim},
+				},
+				"status": map[string]interface{}{"creationTime": created, "readyToUse": ready, "restoreSize": "1Gi"},
+			}}
+		}
+
+		BeforeEach(func() {
+			sourceSpace = DefaultSpaceID
+		})
+
+		JustBeforeEach(func() {
+			snapshotClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+				volumeSnapshot("source-nightly-1", "source-instance", "2026-10-16T02:00:00Z", true),
+				volumeSnapshot("source-nightly-2", "source-instance", "2026-10-17T02:00:00Z", true),
+				volumeSnapshot("source-nightly-3", "source-instance", "2026-10-18T02:00:00Z", false),
+				volumeSnapshot("other-nightly", "other-instance", "2026-10-18T03:00:00Z", true),
+			)
+			testBroker.SnapshotClient = snapshotClient
+
+			source := DefaultProvisionDetails()
+			source.SpaceGUID = sourceSpace
+			_, err := testBroker.Provision(context.Background(), "source-instance", source, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		getPVC := func() *corev1.PersistentVolumeClaim {
+			pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return pvc
+		}
+
+		It("restores the latest ready snapshot of the instance into the claim", func() {
+			spec, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"seed": {"snapshot": {"instance_id": "source-instance"}}}`), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.IsAsync).To(BeFalse())
+
+			pvc := getPVC()
+			Expect(pvc.Spec.DataSource).NotTo(BeNil())
+			Expect(*pvc.Spec.DataSource.APIGroup).To(Equal("snapshot.storage.k8s.io"))
+			Expect(pvc.Spec.DataSource.Kind).To(Equal("VolumeSnapshot"))
+			Expect(pvc.Spec.DataSource.Name).To(Equal("source-nightly-2"))
+			Expect(pvc.Annotations).To(HaveKeyWithValue(broker.SeedAnnotation, "snapshot source-nightly-2 of instance source-instance"))
+
+			jobs, err := kubeClient.BatchV1().Jobs(DefaultNamespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(jobs.Items).To(BeEmpty())
+
+			repeated, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"seed": {"snapshot": {"instance_id": "source-instance"}}}`), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repeated.IsAsync).To(BeFalse())
+		})
+
+		It("restores snapshots by name", func() {
+			_, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"seed": {"snapshot": {"instance_id": "source-instance", "name": "source-nightly-1"}}}`), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getPVC().Spec.DataSource.Name).To(Equal("source-nightly-1"))
+		})
+
+		It("rejects unknown, unready and too large snapshots", func() {
+			for _, parameters := range []string{
+				`{"seed": {"snapshot": {}}}`,
+				`{"seed": {"snapshot": {"instance_id": "missing-instance"}}}`,
+				`{"seed": {"snapshot": {"instance_id": "source-instance", "name": "other-nightly"}}}`,
+				`{"seed": {"snapshot": {"instance_id": "source-instance", "name": "source-nightly-3"}}}`,
+			} {
+				_, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(parameters), false)
+				Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity), parameters)
+			}
+
+			_, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"size": "512Mi", "seed": {"snapshot": {"instance_id": "source-instance"}}}`), false)
+			Expect(err).To(MatchError(ContainSubstring("the size must be at least 1Gi")))
+		})
+
+		Context("when the instance is in another space", func() {
+			BeforeEach(func() {
+				sourceSpace = "other-space"
+			})
+
+			It("rejects its snapshots", func() {
+				_, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"seed": {"snapshot": {"instance_id": "source-instance"}}}`), false)
+				Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+				Expect(err.Error()).To(ContainSubstring("instance source-instance doesn't have a ready snapshot"))
+			})
+		})
+	})
+
+	Context("when jobs aren't configured", func() {
+		BeforeEach(func() {
+			jobs = brokerconfig.JobConfiguration{}
+		})
+
+		It("rejects seeds", func() {
+			_, err := testBroker.Provision(context.Background(), DefaultInstanceID, seedDetails(`{"seed": {"url": "https://data.example.com/cities.tar"}}`), true)
+			Expect(statusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+			Expect(err.Error()).To(ContainSubstring("seeding instances isn't enabled"))
+		})
+	})
+})
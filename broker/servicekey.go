@@ -0,0 +1,485 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Protocols the access servers of service keys serve volumes over
+const (
+	ProtocolWebDAV = "webdav"
+	ProtocolSFTP   = "sftp"
+)
+
+// ServiceKeyLabel is set on the objects of the access server of a service
+// key to its binding ID
+const ServiceKeyLabel = "persi-service-key"
+
+// serviceKeyPrefix starts the keys of the annotations recording the protocol
+// of service keys. It must not start with the binding annotation prefix.
+const serviceKeyPrefix = "eirini-broker-key-"
+
+const (
+	webDAVPort  = 8080
+	webDAVMount = "/data"
+	webDAVTLS   = "/etc/persi-files/tls"
+	sftpPort    = 22
+
+	// atmoz/sftp creates users with this ID in the users group. Volumes are
+	// mounted with the users group, so the user can write to them.
+	sftpUserID  = 1000
+	sftpGroupID = 100
+
+	sftpUsersFile = "/etc/sftp/users.conf"
+)
+
+// Keys of the secrets holding the credentials of service keys
+const (
+	usernameKey  = "username"
+	passwordKey  = "password"
+	usersConfKey = "users.conf"
+	schemeKey    = "scheme"
+)
+
+// defaultWebDAVCommand runs persi-files from the broker image
+var defaultWebDAVCommand = []string{"/bin/persi-files"}
+
+// ServiceKeyAnnotation returns the key of the annotation recording the
+// protocol of a service key
+func ServiceKeyAnnotation(bindingID string) string {
+	return serviceKeyPrefix + bindingID
+}
+
+// IsServiceKeyAnnotation returns true if the annotation key records the protocol of a service key
+func IsServiceKeyAnnotation(annotationKey string) bool {
+	return strings.HasPrefix(annotationKey, serviceKeyPrefix)
+}
+
+// isServiceKey returns true for bindings without an app, which Cloud Foundry
+// creates for service keys
+func isServiceKey(details brokerapi.BindDetails) bool {
+	return details.AppGUID == "" && (details.BindResource == nil || details.BindResource.AppGuid == "")
+}
+
+// serviceKeyName returns the name of the objects of the access server of a
+// service key
+func serviceKeyName(bindingID string) (string, error) {
+	name := "persi-key-" + strings.ToLower(bindingID)
+	if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+		return "", invalidParameters(fmt.Sprintf("binding ID %s can't be used for a service key: %s", bindingID, strings.Join(errs, ", ")))
+	}
+
+	return name, nil
+}
+
+// accessProtocol returns the protocol a service key is served over. Users
+// may request one of the configured protocols; WebDAV is preferred.
+func (b *KubeVolumeBroker) accessProtocol(requested string) (string, error) {
+	keys := b.Config.ServiceKeyConfiguration
+
+	var offered []string
+	if keys.WebDAV.Image != "" {
+		offered = append(offered, ProtocolWebDAV)
+	}
+	if keys.SFTP.Image != "" {
+		offered = append(offered, ProtocolSFTP)
+	}
+
+	if requested == "" {
+		return offered[0], nil
+	}
+	for _, protocol := range offered {
+		if protocol == requested {
+			return protocol, nil
+		}
+	}
+
+	return "", invalidParameters(fmt.Sprintf("protocol %s isn't offered, offered protocols are %s", requested, strings.Join(offered, ", ")))
+}
+
+// bindServiceKey starts the access server of a service key and records the
+// key on the instance PVC
+func (b *KubeVolumeBroker) bindServiceKey(ctx context.Context, logger lager.Logger, pvc *corev1.PersistentVolumeClaim, bindingID, space, requested string) (*corev1.PersistentVolumeClaim, error) {
+	protocol, err := b.accessProtocol(requested)
+	if err != nil {
+		return pvc, err
+	}
+
+	// If the key already exists with the same protocol, this is a repeated
+	// request. Bindings of apps can't be turned into keys.
+	if _, ok := pvc.Annotations[bindingIDAnnotation(bindingID)]; ok {
+		if existing, ok := pvc.Annotations[ServiceKeyAnnotation(bindingID)]; !ok || existing != protocol {
+			return pvc, brokerapi.ErrBindingAlreadyExists
+		}
+
+		logger.Debug("service-key-already-exists")
+		markAlreadyExists(ctx)
+		return pvc, nil
+	}
+
+	if err := b.startAccessServer(ctx, pvc, bindingID, protocol); err != nil {
+		b.cleanUpAccessServer(ctx, logger, bindingID)
+		return pvc, wrapError(err, "error starting access server for service key")
+	}
+
+	// Service keys aren't mounted, so their binding annotation has no directory
+	noDir := ""
+	annotations := modifiedByAnnotations(ctx)
+	annotations[bindingIDAnnotation(bindingID)] = &noDir
+	annotations[ServiceKeyAnnotation(bindingID)] = &protocol
+	if space != "" {
+		annotations[BindingSpaceAnnotation(bindingID)] = &space
+	}
+
+	updated, err := b.patchAnnotations(ctx, pvc.Name, annotations)
+	if err != nil {
+		b.cleanUpAccessServer(ctx, logger, bindingID)
+		return pvc, wrapError(err, "error updating persistent volume claim annotations for service key")
+	}
+
+	instanceID := InstanceID(pvc)
+	b.recordEvent(ctx, instanceID, updated, ReasonBound, fmt.Sprintf("Created service key %s for service instance %s with %s access", bindingID, instanceID, protocol))
+
+	return updated, nil
+}
+
+// startAccessServer creates the secret with the credentials of a service key
+// and the deployment and service of its access server. They belong to the
+// claim, so they are removed together with the instance.
+func (b *KubeVolumeBroker) startAccessServer(ctx context.Context, pvc *corev1.PersistentVolumeClaim, bindingID, protocol string) error {
+	name, err := serviceKeyName(bindingID)
+	if err != nil {
+		return err
+	}
+
+	username, err := randomHex(4)
+	if err != nil {
+		return err
+	}
+	username = "persi-" + username
+	password, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{ServiceKeyLabel: bindingID},
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Name:       pvc.Name,
+			UID:        pvc.UID,
+		}},
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: meta,
+		StringData: map[string]string{
+			usernameKey: username,
+			passwordKey: password,
+		},
+	}
+	switch protocol {
+	case ProtocolWebDAV:
+		// The scheme is recorded so keys keep their URI if TLS is configured
+		// once their server runs
+		secret.StringData[schemeKey] = "http"
+		if b.Config.ServiceKeyConfiguration.WebDAV.TLSSecret != "" {
+			secret.StringData[schemeKey] = "https"
+		}
+	case ProtocolSFTP:
+		secret.StringData[usersConfKey] = fmt.Sprintf("%s:%s:%d:%d\n", username, password, sftpUserID, sftpGroupID)
+	}
+
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	if _, err := b.KubeClient.CoreV1().Secrets(b.Config.Namespace).Create(kubeCtx, secret, metav1.CreateOptions{}); err != nil {
+		return kubeError(kubeCtx, err, "error creating secret")
+	}
+
+	port := accessPort(protocol)
+	replicas := int32(1)
+	if _, err := b.KubeClient.AppsV1().Deployments(b.Config.Namespace).Create(kubeCtx, &appsv1.Deployment{
+		ObjectMeta: meta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: meta.Labels},
+			// Volumes may be ReadWriteOnce, so the old pod has to go first
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: meta.Labels},
+				Spec:       b.accessPodSpec(pvc, name, username, protocol),
+			},
+		},
+	}, metav1.CreateOptions{}); err != nil {
+		return kubeError(kubeCtx, err, "error creating deployment")
+	}
+
+	serviceType := corev1.ServiceType(b.Config.ServiceKeyConfiguration.ServiceType)
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
+	if _, err := b.KubeClient.CoreV1().Services(b.Config.Namespace).Create(kubeCtx, &corev1.Service{
+		ObjectMeta: meta,
+		Spec: corev1.ServiceSpec{
+			Type:     serviceType,
+			Selector: meta.Labels,
+			Ports: []corev1.ServicePort{{
+				Name:       protocol,
+				Port:       port,
+				TargetPort: intstr.FromInt(int(port)),
+			}},
+		},
+	}, metav1.CreateOptions{}); err != nil {
+		return kubeError(kubeCtx, err, "error creating service")
+	}
+
+	return nil
+}
+
+// accessPodSpec returns the pod running the access server of a service key
+func (b *KubeVolumeBroker) accessPodSpec(pvc *corev1.PersistentVolumeClaim, name, username, protocol string) corev1.PodSpec {
+	automountToken := false
+	port := accessPort(protocol)
+	spec := corev1.PodSpec{
+		AutomountServiceAccountToken: &automountToken,
+		Volumes: []corev1.Volume{{
+			Name: "data",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.Name},
+			},
+		}},
+	}
+	container := corev1.Container{
+		Name:  protocol,
+		Ports: []corev1.ContainerPort{{ContainerPort: port}},
+		ReadinessProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(int(port))},
+			},
+		},
+	}
+
+	switch protocol {
+	case ProtocolWebDAV:
+		server := b.Config.ServiceKeyConfiguration.WebDAV
+		container.Image = server.Image
+		container.Command = server.Command
+		if len(container.Command) == 0 {
+			container.Command = defaultWebDAVCommand
+		}
+		container.Args = []string{"-webdav", "-root", webDAVMount, "-listen", ":" + strconv.Itoa(webDAVPort)}
+		container.Env = []corev1.EnvVar{
+			secretEnv("PERSI_FILES_USERNAME", name, usernameKey),
+			secretEnv("PERSI_FILES_PASSWORD", name, passwordKey),
+		}
+		container.VolumeMounts = []corev1.VolumeMount{{Name: "data", MountPath: webDAVMount}}
+		if server.TLSSecret != "" {
+			container.Args = append(container.Args, "-cert", webDAVTLS+"/"+corev1.TLSCertKey, "-key", webDAVTLS+"/"+corev1.TLSPrivateKeyKey)
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "tls", MountPath: webDAVTLS, ReadOnly: true})
+			spec.Volumes = append(spec.Volumes, corev1.Volume{
+				Name: "tls",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{SecretName: server.TLSSecret},
+				},
+			})
+		}
+	case ProtocolSFTP:
+		server := b.Config.ServiceKeyConfiguration.SFTP
+		container.Image = server.Image
+		container.Command = server.Command
+		container.VolumeMounts = []corev1.VolumeMount{
+			{Name: "data", MountPath: sftpMount(username)},
+			{Name: "users", MountPath: sftpUsersFile, SubPath: usersConfKey, ReadOnly: true},
+		}
+		fsGroup := int64(sftpGroupID)
+		spec.SecurityContext = &corev1.PodSecurityContext{FSGroup: &fsGroup}
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: "users",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: name},
+			},
+		})
+	}
+
+	spec.Containers = []corev1.Container{container}
+	return spec
+}
+
+// serviceKeyCredentials returns the credentials of a service key from its
+// secret and service
+func (b *KubeVolumeBroker) serviceKeyCredentials(ctx context.Context, pvc *corev1.PersistentVolumeClaim, bindingID string) (map[string]interface{}, error) {
+	name, err := serviceKeyName(bindingID)
+	if err != nil {
+		return nil, err
+	}
+	protocol := pvc.Annotations[ServiceKeyAnnotation(bindingID)]
+
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	secret, err := b.KubeClient.CoreV1().Secrets(b.Config.Namespace).Get(kubeCtx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, kubeError(kubeCtx, err, "error getting secret")
+	}
+	service, err := b.KubeClient.CoreV1().Services(b.Config.Namespace).Get(kubeCtx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, kubeError(kubeCtx, err, "error getting service")
+	}
+
+	username := secretValue(secret, usernameKey)
+	password := secretValue(secret, passwordKey)
+	host := b.accessHost(service)
+	var port int32
+	if len(service.Spec.Ports) > 0 {
+		port = service.Spec.Ports[0].Port
+		if service.Spec.Type == corev1.ServiceTypeNodePort {
+			port = service.Spec.Ports[0].NodePort
+		}
+	}
+
+	uri := url.URL{
+		User: url.UserPassword(username, password),
+		Host: net.JoinHostPort(host, strconv.Itoa(int(port))),
+	}
+	switch protocol {
+	case ProtocolWebDAV:
+		uri.Scheme, uri.Path = secretValue(secret, schemeKey), "/"
+		if uri.Scheme == "" {
+			uri.Scheme = "http"
+		}
+	case ProtocolSFTP:
+		uri.Scheme, uri.Path = "sftp", "/data"
+	}
+
+	return map[string]interface{}{
+		"volume_id": pvc.Name,
+		"protocol":  protocol,
+		"host":      host,
+		"port":      port,
+		"username":  username,
+		"password":  password,
+		"uri":       uri.String(),
+	}, nil
+}
+
+// accessHost returns the host clients reach the access server behind service
+// at: the configured host, the address of its load balancer or its cluster
+// DNS name
+func (b *KubeVolumeBroker) accessHost(service *corev1.Service) string {
+	if host := b.Config.ServiceKeyConfiguration.Host; host != "" {
+		return host
+	}
+
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.Hostname != "" {
+			return ingress.Hostname
+		}
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+	}
+
+	return service.Name + "." + service.Namespace + ".svc"
+}
+
+// deleteAccessServer deletes the deployment, service and secret of a service
+// key. Objects that are already gone are skipped.
+func (b *KubeVolumeBroker) deleteAccessServer(ctx context.Context, bindingID string) error {
+	name, err := serviceKeyName(bindingID)
+	if err != nil {
+		return err
+	}
+
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	propagation := metav1.DeletePropagationBackground
+	options := metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	err = b.KubeClient.AppsV1().Deployments(b.Config.Namespace).Delete(kubeCtx, name, options)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return kubeError(kubeCtx, err, "error deleting deployment")
+	}
+	err = b.KubeClient.CoreV1().Services(b.Config.Namespace).Delete(kubeCtx, name, options)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return kubeError(kubeCtx, err, "error deleting service")
+	}
+	err = b.KubeClient.CoreV1().Secrets(b.Config.Namespace).Delete(kubeCtx, name, options)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return kubeError(kubeCtx, err, "error deleting secret")
+	}
+
+	return nil
+}
+
+// cleanUpAccessServer deletes what was created of the access server of a
+// service key that couldn't be bound
+func (b *KubeVolumeBroker) cleanUpAccessServer(ctx context.Context, logger lager.Logger, bindingID string) {
+	if err := b.deleteAccessServer(ctx, bindingID); err != nil {
+		logger.Error("clean-up-access-server", err)
+	}
+}
+
+func accessPort(protocol string) int32 {
+	if protocol == ProtocolSFTP {
+		return sftpPort
+	}
+	return webDAVPort
+}
+
+// sftpMount is where atmoz/sftp serves the volume to username. The home
+// directory is the chroot of the user, the volume is a directory in it.
+func sftpMount(username string) string {
+	return "/home/" + username + "/data"
+}
+
+func secretEnv(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+// secretValue returns a value of secret. Secrets created with string data
+// only have data once the API server stored them.
+func secretValue(secret *corev1.Secret, key string) string {
+	if value, ok := secret.Data[key]; ok {
+		return string(value)
+	}
+	return secret.StringData[key]
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) (string, error) {
+	value := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, value); err != nil {
+		return "", errors.Wrap(err, "error creating random credentials")
+	}
+
+	return hex.EncodeToString(value), nil
+}
@@ -0,0 +1,253 @@
+package broker_test
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Service keys", func() {
+	var (
+		kubeClient *fake.Clientset
+		keys       brokerconfig.ServiceKeyConfiguration
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	keyName := "persi-key-" + DefaultBindingID
+
+	keyDetails := func(parameters string) brokerapi.BindDetails {
+		return brokerapi.BindDetails{
+			PlanID:        DefaultPlanID,
+			ServiceID:     DefaultServiceID,
+			RawParameters: []byte(parameters),
+		}
+	}
+
+	getClaim := func() *corev1.PersistentVolumeClaim {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pvc
+	}
+
+	getDeployment := func() *appsv1.Deployment {
+		deployment, err := kubeClient.AppsV1().Deployments(DefaultNamespace).Get(context.Background(), keyName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return deployment
+	}
+
+	BeforeEach(func() {
+		keys = brokerconfig.ServiceKeyConfiguration{
+			WebDAV: brokerconfig.AccessServerConfiguration{Image: "eirini/persi-broker"},
+			SFTP:   brokerconfig.AccessServerConfiguration{Image: "atmoz/sftp"},
+		}
+	})
+
+	JustBeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration:    DefaultServiceConfiguration(),
+				Namespace:               DefaultNamespace,
+				ServiceKeyConfiguration: keys,
+			},
+		}
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("starts a WebDAV server with credentials of the key", func() {
+		binding, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(""), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(binding.VolumeMounts).To(BeEmpty())
+		Expect(binding.Credentials).To(HaveKeyWithValue("volume_id", DefaultInstanceID))
+		Expect(binding.Credentials).To(HaveKeyWithValue("protocol", "webdav"))
+		Expect(binding.Credentials).To(HaveKeyWithValue("host", keyName+"."+DefaultNamespace+".svc"))
+		Expect(binding.Credentials).To(HaveKeyWithValue("port", int32(8080)))
+
+		credentials := binding.Credentials.(map[string]interface{})
+		username, password := credentials["username"].(string), credentials["password"].(string)
+		Expect(username).To(HavePrefix("persi-"))
+		Expect(password).To(HaveLen(32))
+		Expect(credentials["uri"]).To(Equal("http://" + username + ":" + password + "@" + keyName + "." + DefaultNamespace + ".svc:8080/"))
+
+		deployment := getDeployment()
+		Expect(deployment.Labels).To(HaveKeyWithValue(broker.ServiceKeyLabel, DefaultBindingID))
+		Expect(deployment.OwnerReferences).To(HaveLen(1))
+		Expect(deployment.OwnerReferences[0].Name).To(Equal(DefaultInstanceID))
+
+		pod := deployment.Spec.Template.Spec
+		Expect(pod.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(DefaultInstanceID))
+		Expect(pod.Containers[0].Image).To(Equal("eirini/persi-broker"))
+		Expect(pod.Containers[0].Args).To(ContainElement("-webdav"))
+		Expect(pod.Containers[0].Env[0].ValueFrom.SecretKeyRef.Name).To(Equal(keyName))
+
+		_, err = kubeClient.CoreV1().Services(DefaultNamespace).Get(context.Background(), keyName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		pvc := getClaim()
+		Expect(pvc.Annotations).To(HaveKeyWithValue(DefaultAnnotationKey, ""))
+		Expect(pvc.Annotations).To(HaveKeyWithValue(broker.ServiceKeyAnnotation(DefaultBindingID), "webdav"))
+	})
+
+	It("starts an SFTP server on request", func() {
+		binding, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(`{"protocol": "sftp"}`), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		credentials := binding.Credentials.(map[string]interface{})
+		Expect(credentials["protocol"]).To(Equal("sftp"))
+		Expect(credentials["port"]).To(Equal(int32(22)))
+		Expect(credentials["uri"]).To(HavePrefix("sftp://"))
+
+		pod := getDeployment().Spec.Template.Spec
+		Expect(pod.Containers[0].Image).To(Equal("atmoz/sftp"))
+		Expect(pod.Containers[0].VolumeMounts[0].MountPath).To(Equal("/home/" + credentials["username"].(string) + "/data"))
+		Expect(pod.Containers[0].VolumeMounts[1].MountPath).To(Equal("/etc/sftp/users.conf"))
+
+		secret, err := kubeClient.CoreV1().Secrets(DefaultNamespace).Get(context.Background(), keyName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.StringData["users.conf"]).To(HavePrefix(credentials["username"].(string) + ":" + credentials["password"].(string) + ":"))
+	})
+
+	It("rejects protocols that aren't offered", func() {
+		_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(`{"protocol": "ftp"}`), false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+		Expect(err.Error()).To(ContainSubstring("offered protocols are webdav, sftp"))
+	})
+
+	It("rejects protocols for app bindings", func() {
+		details := DefaultBindDetails()
+		details.RawParameters = []byte(`{"protocol": "sftp"}`)
+
+		_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, details, false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("returns the same credentials for repeated requests and GetBinding", func() {
+		first, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(""), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		second, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(""), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.Credentials).To(Equal(first.Credentials))
+
+		fetched, err := testBroker.GetBinding(context.Background(), DefaultInstanceID, DefaultBindingID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fetched.Credentials).To(Equal(first.Credentials))
+		Expect(fetched.VolumeMounts).To(BeEmpty())
+	})
+
+	It("refuses a repeated request with another protocol", func() {
+		_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(""), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(`{"protocol": "sftp"}`), false)
+		Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+	})
+
+	It("tears the access server down on unbind", func() {
+		_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(""), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = testBroker.Unbind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultUnbindDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = kubeClient.AppsV1().Deployments(DefaultNamespace).Get(context.Background(), keyName, metav1.GetOptions{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		_, err = kubeClient.CoreV1().Services(DefaultNamespace).Get(context.Background(), keyName, metav1.GetOptions{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		_, err = kubeClient.CoreV1().Secrets(DefaultNamespace).Get(context.Background(), keyName, metav1.GetOptions{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		pvc := getClaim()
+		Expect(pvc.Annotations).NotTo(HaveKey(DefaultAnnotationKey))
+		Expect(pvc.Annotations).NotTo(HaveKey(broker.ServiceKeyAnnotation(DefaultBindingID)))
+	})
+
+	Context("with a configured host and node ports", func() {
+		BeforeEach(func() {
+			keys.ServiceType = "NodePort"
+			keys.Host = "nodes.example.com"
+		})
+
+		It("reports the node port at the host", func() {
+			_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(""), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			// The fake clientset doesn't allocate node ports
+			service, err := kubeClient.CoreV1().Services(DefaultNamespace).Get(context.Background(), keyName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(service.Spec.Type).To(Equal(corev1.ServiceTypeNodePort))
+			service.Spec.Ports[0].NodePort = 30022
+			_, err = kubeClient.CoreV1().Services(DefaultNamespace).Update(context.Background(), service, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			binding, err := testBroker.GetBinding(context.Background(), DefaultInstanceID, DefaultBindingID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("host", "nodes.example.com"))
+			Expect(binding.Credentials).To(HaveKeyWithValue("port", int32(30022)))
+		})
+	})
+
+	Context("with a tls secret for webdav", func() {
+		BeforeEach(func() {
+			keys.ServiceType = "LoadBalancer"
+			keys.WebDAV.TLSSecret = "persi-webdav-tls"
+		})
+
+		It("serves webdav over tls", func() {
+			binding, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(""), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials.(map[string]interface{})["uri"]).To(HavePrefix("https://"))
+
+			pod := getDeployment().Spec.Template.Spec
+			Expect(pod.Containers[0].Args).To(ContainElement("/etc/persi-files/tls/tls.crt"))
+			Expect(pod.Containers[0].VolumeMounts[1].MountPath).To(Equal("/etc/persi-files/tls"))
+			Expect(pod.Volumes[1].Secret.SecretName).To(Equal("persi-webdav-tls"))
+		})
+
+		It("keeps the uri of keys created before tls was configured", func() {
+			testBroker.Config.ServiceKeyConfiguration.WebDAV.TLSSecret = ""
+			_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(""), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			testBroker.Config.ServiceKeyConfiguration.WebDAV.TLSSecret = "persi-webdav-tls"
+			binding, err := testBroker.GetBinding(context.Background(), DefaultInstanceID, DefaultBindingID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials.(map[string]interface{})["uri"]).To(HavePrefix("http://"))
+		})
+	})
+
+	Context("when service keys aren't configured", func() {
+		BeforeEach(func() {
+			keys = brokerconfig.ServiceKeyConfiguration{}
+		})
+
+		It("binds service keys like apps", func() {
+			binding, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, keyDetails(""), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(binding.Credentials).To(Equal(map[string]interface{}{"volume_id": DefaultInstanceID}))
+			Expect(binding.VolumeMounts).To(HaveLen(1))
+
+			deployments, err := kubeClient.AppsV1().Deployments(DefaultNamespace).List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deployments.Items).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+	corev1 "k8s.io/api/core/v1"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+// bindingSpacePrefix starts the keys of the annotations recording the space
+// of each binding. It must not start with the binding annotation prefix.
+const bindingSpacePrefix = "eirini-broker-space-"
+
+// IsBindingSpaceAnnotation returns true if the annotation key records the space of a binding
+func IsBindingSpaceAnnotation(annotationKey string) bool {
+	return strings.HasPrefix(annotationKey, bindingSpacePrefix)
+}
+
+// BindingSpaceAnnotation returns the key of the annotation recording the space
+// a binding was created in
+func BindingSpaceAnnotation(bindingID string) string {
+	return bindingSpacePrefix + bindingID
+}
+
+// bindingSpace returns the GUID of the space a binding is created in. Cloud
+// Foundry sends it as bind resource and in the context; it's empty if the
+// platform sends neither.
+func bindingSpace(details brokerapi.BindDetails) string {
+	if details.BindResource != nil && details.BindResource.SpaceGuid != "" {
+		return details.BindResource.SpaceGuid
+	}
+
+	return parseContext(details.RawContext).SpaceGUID
+}
+
+// sharedAccessMode returns the access mode to provision an instance of plan
+// with. Instances of shareable plans are mounted by apps in several spaces,
+// so they are always ReadWriteMany.
+func sharedAccessMode(plan *config.Plan, requested string) (string, error) {
+	if !plan.Shareable {
+		return requested, nil
+	}
+
+	if requested != "" && requested != string(corev1.ReadWriteMany) {
+		return "", invalidParameters(fmt.Sprintf("instances of shareable plan %s must be %s, not %s", plan.Name, corev1.ReadWriteMany, requested))
+	}
+
+	return string(corev1.ReadWriteMany), nil
+}
+
+// checkSharing returns an error if pvc may not be bound in space
+func (b *KubeVolumeBroker) checkSharing(pvc *corev1.PersistentVolumeClaim, space string) error {
+	instanceSpace := pvc.Labels[SpaceIDLabel]
+	if space == "" || instanceSpace == "" || space == instanceSpace {
+		return nil
+	}
+
+	plan := b.Plan(pvc.Labels[PlanIDLabel])
+	if plan == nil || !plan.Shareable {
+		return invalidParameters(fmt.Sprintf("service instance %s isn't shareable and can only be bound in space %s", InstanceID(pvc), instanceSpace))
+	}
+
+	if len(pvc.Spec.AccessModes) != 1 || pvc.Spec.AccessModes[0] != corev1.ReadWriteMany {
+		return invalidParameters(fmt.Sprintf("service instance %s can't be bound in other spaces unless it is %s", InstanceID(pvc), corev1.ReadWriteMany))
+	}
+
+	return nil
+}
+
+// anyShareable returns true if any plan of the service is shareable
+func anyShareable(plans []config.Plan) bool {
+	for _, plan := range plans {
+		if plan.Shareable {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+package broker_test
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Sharing", func() {
+	var (
+		kubeClient *fake.Clientset
+		plan       brokerconfig.Plan
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	otherSpaceID := "4a4a5c1b-ad69-4d5e-8ee2-9eb54ed4b4d1"
+
+	bindDetailsInSpace := func(spaceID string) brokerapi.BindDetails {
+		details := DefaultBindDetails()
+		details.BindResource = &brokerapi.BindResource{AppGuid: DefaultAppID, SpaceGuid: spaceID}
+		return details
+	}
+
+	getClaim := func() *corev1.PersistentVolumeClaim {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pvc
+	}
+
+	BeforeEach(func() {
+		plan = DefaultPlanConfiguration()
+		plan.Shareable = true
+	})
+
+	JustBeforeEach(func() {
+		serviceConfiguration := DefaultServiceConfiguration()
+		serviceConfiguration.Plans = []brokerconfig.Plan{plan}
+
+		kubeClient = fake.NewSimpleClientset()
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration: serviceConfiguration,
+				Namespace:            DefaultNamespace,
+			},
+		}
+	})
+
+	It("advertises shareable plans in the catalog", func() {
+		services, err := testBroker.Services(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(*services[0].Metadata.Shareable).To(BeTrue())
+		Expect(services[0].Plans[0].Metadata.AdditionalMetadata).To(HaveKeyWithValue("shareable", true))
+	})
+
+	It("provisions instances of shareable plans as ReadWriteMany", func() {
+		plan.DefaultAccessMode = string(corev1.ReadWriteOnce)
+		testBroker.Config.ServiceConfiguration.Plans = []brokerconfig.Plan{plan}
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(getClaim().Spec.AccessModes).To(Equal([]corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}))
+	})
+
+	It("rejects other access modes for shareable plans", func() {
+		details := DefaultProvisionDetails()
+		details.RawParameters = []byte(`{"access_mode": "ReadWriteOnce"}`)
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, details, false)
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	Context("when the instance is provisioned", func() {
+		JustBeforeEach(func() {
+			_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("records the space of bindings in other spaces", func() {
+			_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, bindDetailsInSpace(otherSpaceID), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(getClaim().Annotations).To(HaveKeyWithValue(broker.BindingSpaceAnnotation(DefaultBindingID), otherSpaceID))
+		})
+
+		It("reads the binding space from the context", func() {
+			details := DefaultBindDetails()
+			details.RawContext = []byte(`{"platform": "cloudfoundry", "space_guid": "` + otherSpaceID + `"}`)
+
+			_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, details, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(getClaim().Annotations).To(HaveKeyWithValue(broker.BindingSpaceAnnotation(DefaultBindingID), otherSpaceID))
+		})
+
+		It("removes the binding space when unbinding", func() {
+			_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, bindDetailsInSpace(otherSpaceID), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = testBroker.Unbind(context.Background(), DefaultInstanceID, DefaultBindingID, DefaultUnbindDetails(), false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(getClaim().Annotations).NotTo(HaveKey(broker.BindingSpaceAnnotation(DefaultBindingID)))
+		})
+
+		Context("and the plan isn't shareable", func() {
+			BeforeEach(func() {
+				plan.Shareable = false
+			})
+
+			It("binds in the space of the instance", func() {
+				_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, bindDetailsInSpace(DefaultSpaceID), false)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("refuses bindings in other spaces", func() {
+				_, err := testBroker.Bind(context.Background(), DefaultInstanceID, DefaultBindingID, bindDetailsInSpace(otherSpaceID), false)
+
+				Expect(err).To(HaveOccurred())
+				Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusUnprocessableEntity))
+				Expect(err.Error()).To(Equal("service instance " + DefaultInstanceID + " isn't shareable and can only be bound in space " + DefaultSpaceID))
+				Expect(getClaim().Annotations).NotTo(HaveKey(DefaultAnnotationKey))
+			})
+		})
+	})
+})
@@ -0,0 +1,149 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// VolumeSnapshotResource is the resource of CSI volume snapshots
+var VolumeSnapshotResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1beta1",
+	Resource: "volumesnapshots",
+}
+
+// Snapshot is a CSI volume snapshot of the claim of an instance
+type Snapshot struct {
+	Name  string
+	Time  time.Time
+	Ready bool
+	// Size is the minimum size of volumes restored from the snapshot, if known
+	Size string
+}
+
+// snapshotParameters is the snapshot users ask to seed a new instance from.
+// The latest ready snapshot of the instance is used if Name is empty.
+type snapshotParameters struct {
+	InstanceID string `json:"instance_id"`
+	Name       string `json:"name"`
+}
+
+// Snapshots returns the volume snapshots of the instance claim named
+// claimName, oldest first. It returns no snapshots if volume snapshots aren't
+// available.
+func (b *KubeVolumeBroker) Snapshots(ctx context.Context, claimName string) ([]Snapshot, error) {
+	if b.SnapshotClient == nil {
+		return nil, nil
+	}
+
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	list, err := b.SnapshotClient.Resource(VolumeSnapshotResource).Namespace(b.Config.Namespace).List(kubeCtx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		// The snapshot CRDs aren't installed
+		return nil, nil
+	}
+	if err != nil {
+		return nil, kubeError(kubeCtx, err, "error listing volume snapshots")
+	}
+
+	var snapshots []Snapshot
+	for i := range list.Items {
+		if snapshot, ok := snapshotOf(&list.Items[i], claimName); ok {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// snapshotOf returns the snapshot of object if it's a snapshot of the claim
+// named claimName
+func snapshotOf(object *unstructured.Unstructured, claimName string) (Snapshot, bool) {
+	claim, _, _ := unstructured.NestedString(object.Object, "spec", "source", "persistentVolumeClaimName")
+	if claim != claimName {
+		return Snapshot{}, false
+	}
+
+	snapshot := Snapshot{Name: object.GetName(), Time: object.GetCreationTimestamp().Time}
+	snapshot.Ready, _, _ = unstructured.NestedBool(object.Object, "status", "readyToUse")
+	snapshot.Size, _, _ = unstructured.NestedString(object.Object, "status", "restoreSize")
+	if created, _, _ := unstructured.NestedString(object.Object, "status", "creationTime"); created != "" {
+		if t, err := time.Parse(time.RFC3339, created); err == nil {
+			snapshot.Time = t
+		}
+	}
+
+	return snapshot, true
+}
+
+// snapshotSource validates seeding from a snapshot and returns the data
+// source of the claim of the new instance and a description of the snapshot.
+// Only snapshots of instances in spaceID can be seeded from, into volumes of
+// at least the size of the snapshot.
+func (b *KubeVolumeBroker) snapshotSource(ctx context.Context, seed *seedParameters, spaceID string, size resource.Quantity) (*corev1.TypedLocalObjectReference, string, error) {
+	if seed.URL != "" || seed.S3 != nil {
+		return nil, "", invalidParameters("seed from either a url, an s3 object or a snapshot")
+	}
+	if b.SnapshotClient == nil {
+		return nil, "", invalidParameters("seeding from snapshots isn't enabled")
+	}
+
+	source := seed.Snapshot
+	if len(validation.IsDNS1123Subdomain(source.InstanceID)) > 0 {
+		return nil, "", invalidParameters("the seed snapshot needs the instance_id of a service instance")
+	}
+
+	notFound := invalidParameters(fmt.Sprintf("instance %s doesn't have a snapshot %s", source.InstanceID, source.Name))
+	if source.Name == "" {
+		notFound = invalidParameters(fmt.Sprintf("instance %s doesn't have a ready snapshot", source.InstanceID))
+	}
+
+	exists, pvc, err := b.instanceExists(ctx, source.InstanceID)
+	if err != nil {
+		return nil, "", wrapError(err, "error getting snapshot instance")
+	}
+	if !exists || pvc.Labels[SpaceIDLabel] != spaceID {
+		return nil, "", notFound
+	}
+
+	snapshots, err := b.Snapshots(ctx, pvc.Name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var snapshot *Snapshot
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if source.Name == snapshots[i].Name || (source.Name == "" && snapshots[i].Ready) {
+			snapshot = &snapshots[i]
+			break
+		}
+	}
+	if snapshot == nil {
+		return nil, "", notFound
+	}
+	if !snapshot.Ready {
+		return nil, "", invalidParameters(fmt.Sprintf("snapshot %s of instance %s isn't ready yet", snapshot.Name, source.InstanceID))
+	}
+	if snapshot.Size != "" {
+		if restoreSize, err := resource.ParseQuantity(snapshot.Size); err == nil && size.Cmp(restoreSize) < 0 {
+			return nil, "", invalidParameters(fmt.Sprintf("the size must be at least %s, the size of snapshot %s", restoreSize.String(), snapshot.Name))
+		}
+	}
+
+	apiGroup := VolumeSnapshotResource.Group
+	return &corev1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: "VolumeSnapshot", Name: snapshot.Name},
+		fmt.Sprintf("snapshot %s of instance %s", snapshot.Name, source.InstanceID), nil
+}
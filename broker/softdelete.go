@@ -0,0 +1,159 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+// DeletedLabel marks the persistent volume claims of deprovisioned instances
+// that are retained so operators can restore them
+const DeletedLabel = "deleted"
+
+// DeletedAtAnnotation records when a retained instance was deprovisioned
+const DeletedAtAnnotation = "eirini-broker-deleted-at"
+
+// IsDeleted returns true if pvc belongs to a deprovisioned instance that is retained
+func IsDeleted(pvc *corev1.PersistentVolumeClaim) bool {
+	return pvc.Labels[DeletedLabel] == "true"
+}
+
+// DeletedAt returns when the instance of a retained pvc was deprovisioned
+func DeletedAt(pvc *corev1.PersistentVolumeClaim) (time.Time, bool) {
+	deletedAt, err := time.Parse(time.RFC3339, pvc.Annotations[DeletedAtAnnotation])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return deletedAt, true
+}
+
+// DeletedSelector returns the label selector matching the persistent volume
+// claims of deprovisioned instances of the configured service
+func DeletedSelector(c config.Config) string {
+	return serviceSelector(c, selection.Equals, "true").String()
+}
+
+// Restore makes a deprovisioned instance whose persistent volume claim is
+// still retained available again
+func (b *KubeVolumeBroker) Restore(ctx context.Context, instanceID string) (err error) {
+	logger := b.session(ctx, "restore", lager.Data{"instance-id": instanceID})
+	var pvc *corev1.PersistentVolumeClaim
+	defer func() {
+		logResult(logger, "Restored instance "+instanceID, err)
+		audit(logger, "restore", err)
+		if err != nil {
+			b.recordFailure(ctx, instanceID, pvc, ReasonRestoreFailed, "Failed to restore service instance "+instanceID, err)
+		}
+	}()
+
+	claim, err := b.Claim(ctx, instanceID)
+	if err != nil {
+		return wrapError(err, "error getting instance")
+	}
+
+	annotations := map[string]interface{}{DeletedAtAnnotation: nil}
+	for key, value := range modifiedByAnnotations(ctx) {
+		annotations[key] = value
+	}
+
+	pvc, err = b.patchInstance(ctx, claim.Name, map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      map[string]interface{}{DeletedLabel: nil},
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return wrapError(err, "error restoring instance")
+	}
+
+	b.recordEvent(ctx, instanceID, pvc, ReasonRestored, "Restored service instance "+instanceID)
+
+	return nil
+}
+
+// PurgeDeleted deletes the retained persistent volume claims of deprovisioned
+// instances once the configured retention is over. It returns the IDs of
+// the purged instances. Nothing is purged without a retention, and claims
+// without a valid deletion time are left for operators to look at.
+func (b *KubeVolumeBroker) PurgeDeleted(ctx context.Context) ([]string, error) {
+	retention := b.Config.SoftDeleteConfiguration.Retention
+	if retention <= 0 {
+		return nil, nil
+	}
+
+	logger := b.session(ctx, "purge-deleted", nil)
+
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	claims := b.KubeClient.CoreV1().PersistentVolumeClaims(b.Config.Namespace)
+
+	pvcs, err := claims.List(kubeCtx, metav1.ListOptions{LabelSelector: DeletedSelector(b.Config)})
+	if err != nil {
+		return nil, kubeError(kubeCtx, err, "error listing deleted persistent volume claims")
+	}
+
+	var purged []string
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		deletedAt, ok := DeletedAt(pvc)
+		if !ok {
+			logger.Info("invalid-deleted-at", lager.Data{"instance-id": InstanceID(pvc), "deleted-at": pvc.Annotations[DeletedAtAnnotation]})
+			continue
+		}
+		if time.Since(deletedAt) < retention {
+			continue
+		}
+
+		if err := b.LabelVolume(ctx, pvc); err != nil {
+			logger.Error("label-volume", err, lager.Data{"instance-id": InstanceID(pvc)})
+		}
+		if err := claims.Delete(kubeCtx, pvc.Name, metav1.DeleteOptions{}); err != nil {
+			return purged, kubeError(kubeCtx, err, "error purging persistent volume claim "+pvc.Name)
+		}
+
+		instanceID := InstanceID(pvc)
+		b.recordEvent(ctx, instanceID, pvc, ReasonPurged, fmt.Sprintf("Purged service instance %s after its retention of %s", instanceID, retention))
+		purged = append(purged, instanceID)
+	}
+
+	return purged, nil
+}
+
+// softDelete marks the instance PVC named claimName as deleted instead of
+// deleting it
+func (b *KubeVolumeBroker) softDelete(ctx context.Context, claimName string) (*corev1.PersistentVolumeClaim, error) {
+	annotations := map[string]interface{}{
+		DeletedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}
+	for key, value := range modifiedByAnnotations(ctx) {
+		annotations[key] = value
+	}
+
+	return b.patchInstance(ctx, claimName, map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      map[string]interface{}{DeletedLabel: "true"},
+			"annotations": annotations,
+		},
+	})
+}
+
+// serviceSelector selects the persistent volume claims of the configured
+// service by the value of their deleted label
+func serviceSelector(c config.Config, deleted selection.Operator, values ...string) labels.Selector {
+	// The requirement is always valid for the operators used by the broker
+	requirement, _ := labels.NewRequirement(DeletedLabel, deleted, values)
+
+	return labels.SelectorFromSet(labels.Set{
+		ServiceIDLabel: c.ServiceConfiguration.ServiceID,
+	}).Add(*requirement)
+}
@@ -0,0 +1,157 @@
+package broker_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var _ = Describe("Soft delete", func() {
+	var (
+		kubeClient *fake.Clientset
+		recorder   *record.FakeRecorder
+		testBroker *broker.KubeVolumeBroker
+	)
+
+	getClaim := func() (*metav1.ObjectMeta, error) {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &pvc.ObjectMeta, nil
+	}
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		recorder = record.NewFakeRecorder(10)
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration:    DefaultServiceConfiguration(),
+				Namespace:               DefaultNamespace,
+				SoftDeleteConfiguration: brokerconfig.SoftDeleteConfiguration{Retention: time.Hour},
+			},
+			Recorder: recorder,
+		}
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = testBroker.Deprovision(context.Background(), DefaultInstanceID, DefaultDeprovisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("retains the claim of a deprovisioned instance", func() {
+		claim, err := getClaim()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(claim.Labels).To(HaveKeyWithValue(broker.DeletedLabel, "true"))
+		Expect(claim.Annotations).To(HaveKey(broker.DeletedAtAnnotation))
+		Expect(recorder.Events).To(Receive())
+		Expect(recorder.Events).To(Receive(Equal("Normal Deprovisioned Deprovisioned service instance " + DefaultInstanceID + ", its volume is retained for 1h0m0s")))
+	})
+
+	It("treats the instance as deleted", func() {
+		_, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+
+		_, err = testBroker.Deprovision(context.Background(), DefaultInstanceID, DefaultDeprovisionDetails(), false)
+		Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+
+		_, err = testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+	})
+
+	It("restores the instance", func() {
+		Expect(testBroker.Restore(context.Background(), DefaultInstanceID)).To(Succeed())
+
+		claim, err := getClaim()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claim.Labels).NotTo(HaveKey(broker.DeletedLabel))
+		Expect(claim.Annotations).NotTo(HaveKey(broker.DeletedAtAnnotation))
+
+		_, err = testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("purges claims once their retention is over", func() {
+		purged, err := testBroker.PurgeDeleted(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(purged).To(BeEmpty())
+
+		_, err = kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Patch(context.Background(), DefaultInstanceID, types.MergePatchType,
+			[]byte(`{"metadata":{"annotations":{"`+broker.DeletedAtAnnotation+`":"`+time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339)+`"}}}`), metav1.PatchOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		purged, err = testBroker.PurgeDeleted(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(purged).To(Equal([]string{DefaultInstanceID}))
+
+		_, err = getClaim()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("labels the volumes of purged claims", func() {
+		_, err := kubeClient.CoreV1().PersistentVolumes().Create(context.Background(), &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-instance"},
+		}, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Patch(context.Background(), DefaultInstanceID, types.MergePatchType,
+			[]byte(`{"metadata":{"annotations":{"`+broker.DeletedAtAnnotation+`":"`+time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339)+`"}},"spec":{"volumeName":"pv-instance"}}`), metav1.PatchOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = testBroker.PurgeDeleted(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), "pv-instance", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pv.Labels).To(HaveKeyWithValue(broker.InstanceIDLabel, DefaultInstanceID))
+		Expect(pv.Labels).To(HaveKeyWithValue(broker.ServiceIDLabel, DefaultServiceConfiguration().ServiceID))
+	})
+
+	It("doesn't purge claims without a valid deletion time", func() {
+		_, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Patch(context.Background(), DefaultInstanceID, types.MergePatchType,
+			[]byte(`{"metadata":{"annotations":{"`+broker.DeletedAtAnnotation+`":"yesterday"}}}`), metav1.PatchOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		purged, err := testBroker.PurgeDeleted(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(purged).To(BeEmpty())
+
+		_, err = kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Patch(context.Background(), DefaultInstanceID, types.MergePatchType,
+			[]byte(`{"metadata":{"annotations":{"`+broker.DeletedAtAnnotation+`":null}}}`), metav1.PatchOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		purged, err = testBroker.PurgeDeleted(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(purged).To(BeEmpty())
+
+		_, err = getClaim()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("doesn't purge without a retention", func() {
+		_, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Patch(context.Background(), DefaultInstanceID, types.MergePatchType,
+			[]byte(`{"metadata":{"annotations":{"`+broker.DeletedAtAnnotation+`":"`+time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339)+`"}}}`), metav1.PatchOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		testBroker.Config.SoftDeleteConfiguration.Retention = 0
+
+		purged, err := testBroker.PurgeDeleted(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(purged).To(BeEmpty())
+
+		_, err = getClaim()
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
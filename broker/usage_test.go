@@ -0,0 +1,101 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/usage"
+)
+
+type fakeUsageSource struct {
+	usage *usage.Usage
+	err   error
+}
+
+func (s *fakeUsageSource) Usage(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*usage.Usage, error) {
+	return s.usage, s.err
+}
+
+var _ = Describe("Instance usage", func() {
+	var (
+		kubeClient  *fake.Clientset
+		usageSource *fakeUsageSource
+		testBroker  *broker.KubeVolumeBroker
+	)
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		usageSource = &fakeUsageSource{usage: &usage.Usage{UsedBytes: 300, AvailableBytes: 700, CapacityBytes: 1000}}
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: brokerconfig.Config{
+				ServiceConfiguration: DefaultServiceConfiguration(),
+				Namespace:            DefaultNamespace,
+				UsageConfiguration:   brokerconfig.UsageConfiguration{InstanceParameters: true},
+			},
+			Usage: usageSource,
+		}
+
+		_, err := testBroker.Provision(context.Background(), DefaultInstanceID, DefaultProvisionDetails(), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Bind the claim like the persistent volume controller would
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).Get(context.Background(), DefaultInstanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		pvc.Status.Phase = corev1.ClaimBound
+		pvc.Status.Capacity = corev1.ResourceList{"storage": resource.MustParse("2Gi")}
+		_, err = kubeClient.CoreV1().PersistentVolumeClaims(DefaultNamespace).UpdateStatus(context.Background(), pvc, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("returns the volume state and usage as parameters", func() {
+		spec, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(spec.Parameters).To(Equal(map[string]interface{}{
+			"phase":           "Bound",
+			"requested_size":  "1Gi",
+			"capacity":        "2Gi",
+			"access_mode":     "ReadWriteMany",
+			"storage_class":   DefaultStorageClass,
+			"used_bytes":      int64(300),
+			"available_bytes": int64(700),
+		}))
+	})
+
+	It("leaves out the usage unless it's enabled for instance parameters", func() {
+		testBroker.Config.UsageConfiguration.InstanceParameters = false
+
+		spec, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Parameters).To(HaveKeyWithValue("capacity", "2Gi"))
+		Expect(spec.Parameters).NotTo(HaveKey("used_bytes"))
+	})
+
+	It("leaves out unknown usage", func() {
+		usageSource.usage = nil
+
+		spec, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Parameters).To(HaveKeyWithValue("phase", "Bound"))
+		Expect(spec.Parameters).NotTo(HaveKey("used_bytes"))
+	})
+
+	It("doesn't fail when the usage can't be determined", func() {
+		usageSource.err = errors.New("kubelet unreachable")
+
+		spec, err := testBroker.GetInstance(context.Background(), DefaultInstanceID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Parameters).To(HaveKeyWithValue("capacity", "2Gi"))
+		Expect(spec.Parameters).NotTo(HaveKey("available_bytes"))
+	})
+})
@@ -0,0 +1,58 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+// VolumeSelector returns the label selector matching the persistent volumes
+// labelled by LabelVolume for the configured service
+func VolumeSelector(c config.Config) string {
+	// The requirement is always valid for the exists operator
+	requirement, _ := labels.NewRequirement(InstanceIDLabel, selection.Exists, nil)
+
+	return labels.SelectorFromSet(labels.Set{
+		ServiceIDLabel: c.ServiceConfiguration.ServiceID,
+	}).Add(*requirement).String()
+}
+
+// LabelVolume labels the persistent volume bound to pvc with the IDs of the
+// service and the instance, so the volume is still known to belong to the
+// broker once pvc is deleted. Unbound claims are skipped.
+func (b *KubeVolumeBroker) LabelVolume(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	if pvc.Spec.VolumeName == "" {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{
+				ServiceIDLabel:  b.Config.ServiceConfiguration.ServiceID,
+				InstanceIDLabel: InstanceID(pvc),
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "error marshaling patch")
+	}
+
+	kubeCtx, cancel := b.kubeContext(ctx)
+	defer cancel()
+
+	_, err = b.KubeClient.CoreV1().PersistentVolumes().Patch(kubeCtx, pvc.Spec.VolumeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return kubeError(kubeCtx, err, "error labelling persistent volume "+pvc.Spec.VolumeName)
+	}
+
+	return nil
+}
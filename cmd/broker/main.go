@@ -2,63 +2,139 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/brokerapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc" // from https://github.com/kubernetes/client-go/issues/345
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/transport"
 
+	"code.cloudfoundry.org/eirini-persi-broker/admin"
 	"code.cloudfoundry.org/eirini-persi-broker/broker"
 	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/dashboard"
+	"code.cloudfoundry.org/eirini-persi-broker/health"
+	"code.cloudfoundry.org/eirini-persi-broker/kube"
+	"code.cloudfoundry.org/eirini-persi-broker/leader"
+	"code.cloudfoundry.org/eirini-persi-broker/log"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+	"code.cloudfoundry.org/eirini-persi-broker/monitor"
+	"code.cloudfoundry.org/eirini-persi-broker/reconciler"
+	"code.cloudfoundry.org/eirini-persi-broker/scheduler"
+	"code.cloudfoundry.org/eirini-persi-broker/server"
+	"code.cloudfoundry.org/eirini-persi-broker/usage"
 )
 
+const cacheSyncTimeout = 30 * time.Second
+
 func main() {
 
 	brokerConfigPath := configPath()
 
-	brokerLogger := lager.NewLogger("eirini-persi-broker")
-	brokerLogger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.DEBUG))
-	brokerLogger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.ERROR))
-
-	brokerLogger.Info("Starting Eirini Persi Broker broker")
-
-	brokerLogger.Info("Config File: " + brokerConfigPath)
-
 	config, err := config.ParseConfig(brokerConfigPath)
 	if err != nil {
-		brokerLogger.Fatal("Loading config file", err, lager.Data{
+		bootstrapLogger().Fatal("Loading config file", err, lager.Data{
 			"broker-config-path": brokerConfigPath,
 		})
 	}
 
+	brokerLogger := log.Logger()
+	if err := log.Configure(config.LogConfiguration); err != nil {
+		bootstrapLogger().Fatal("Configuring logging", err)
+	}
+
+	brokerLogger.Info("Starting Eirini Persi Broker broker")
+
+	brokerLogger.Info("Config File: " + brokerConfigPath)
+
 	// Try to configure the connection to Kubernetes
-	configGetter := NewKubeConfigGetter(brokerLogger)
+	configGetter := kube.NewKubeConfigGetter(brokerLogger)
 	kubeConfig, err := configGetter.Get(os.Getenv("KUBECONFIG"))
 	if err != nil {
 		brokerLogger.Fatal("Couldn't configure Kubernetes client", err)
 	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	brokerMetrics := metrics.New(registry)
+	kubeConfig.WrapTransport = transport.Wrappers(kubeConfig.WrapTransport, brokerMetrics.InstrumentRoundTripper)
+
 	clientset, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
-		log.Fatal(err)
+		brokerLogger.Fatal("Couldn't create Kubernetes client", err)
 	}
 
-	sigChannel := make(chan os.Signal, 1)
-	signal.Notify(sigChannel, syscall.SIGTERM)
-	go func() {
-		<-sigChannel
-		brokerLogger.Info("Starting Eirini Persi Broker shutdown")
-		os.Exit(0)
-	}()
+	snapshotClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		brokerLogger.Fatal("Couldn't create Kubernetes volume snapshot client", err)
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: clientset.CoreV1().Events(config.Namespace),
+	})
+	defer eventBroadcaster.Shutdown()
+
+	usageSource, err := usage.NewSource(config.UsageConfiguration, clientset)
+	if err != nil {
+		brokerLogger.Fatal("Couldn't configure volume usage reporting", err)
+	}
+
+	ctx := shutdownContext(brokerLogger)
+
+	// The broker context outlives ctx so in-flight requests can finish while
+	// draining; it is cancelled once draining is over to abort Kubernetes calls.
+	brokerContext, cancelBroker := context.WithCancel(context.Background())
+	defer cancelBroker()
+
+	informerFactory := broker.NewInformerFactory(clientset, config)
+	claimLister := informerFactory.Core().V1().PersistentVolumeClaims().Lister()
+	informerFactory.Start(brokerContext.Done())
+	waitForCacheSync(ctx, informerFactory, brokerLogger)
+
+	// The kubelet usage source finds the pods mounting a claim in a cache
+	// instead of listing all pods for every claim. Pods don't carry the
+	// instance labels, so they're watched by a factory of their own.
+	if kubeletSource, ok := usageSource.(*usage.KubeletSource); ok {
+		podInformerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(config.Namespace))
+		podInformer := podInformerFactory.Core().V1().Pods().Informer()
+		if err := usage.IndexPodsByClaim(podInformer); err != nil {
+			brokerLogger.Fatal("Couldn't index pods by claim", err)
+		}
+		podInformerFactory.Start(brokerContext.Done())
+		waitForCacheSync(ctx, podInformerFactory, brokerLogger)
+		kubeletSource.Pods = podInformer.GetIndexer()
+	}
 
 	serviceBroker := &broker.KubeVolumeBroker{
-		KubeClient: clientset,
-		Config:     config,
-		Context:    context.Background(),
+		KubeClient:  clientset,
+		ClaimLister: claimLister,
+		Config:      config,
+		Context:     brokerContext,
+		Logger:      brokerLogger.Session("broker"),
+		Recorder:    eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "eirini-persi-broker"}),
+		Usage:       usageSource,
+
+		SnapshotClient: snapshotClient,
 	}
 
 	brokerCredentials := brokerapi.BrokerCredentials{
@@ -66,12 +142,214 @@ func main() {
 		Password: config.AuthConfiguration.Password,
 	}
 
-	brokerAPI := brokerapi.New(serviceBroker, brokerLogger, brokerCredentials)
+	instrumentedBroker := &metrics.InstrumentedBroker{
+		Broker:  serviceBroker,
+		Metrics: brokerMetrics,
+	}
+	registry.MustRegister(metrics.NewInventoryCollector(claimLister, config, brokerLogger.Session("inventory")))
+
+	brokerAPI := brokerapi.New(instrumentedBroker, brokerLogger, brokerCredentials)
 	//authWrapper := auth.NewWrapper(brokerCredentials.Username, brokerCredentials.Password)
 
-	http.Handle("/", brokerAPI)
+	healthChecker := &health.Checker{
+		KubeClient: clientset,
+		Namespace:  config.Namespace,
+		CacheTTL:   config.HealthConfiguration.CacheTTL,
+		Logger:     brokerLogger.Session("health"),
+	}
+
+	// Probes and scrapes can't present client certificates, so health checks
+	// and metrics get a plain listener of their own if a port is configured
+	healthMux := http.DefaultServeMux
+	if config.HealthConfiguration.Port != "" {
+		healthMux = http.NewServeMux()
+	}
+	healthMux.Handle("/healthz", healthChecker.LivenessHandler())
+	healthMux.Handle("/readyz", healthChecker.ReadinessHandler())
+	healthMux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	http.Handle("/", broker.WithRequestIdentity(broker.IdempotentResponses(brokerAPI)))
+
+	brokerServer := &server.Server{
+		HTTPServer: &http.Server{
+			Addr: config.Host + ":" + config.Port,
+		},
+		ShutdownTimeout: config.ShutdownTimeout,
+		Logger:          brokerLogger.Session("server"),
+	}
+
+	if config.TLSConfiguration.Enabled() {
+		brokerServer.HTTPServer.TLSConfig, err = server.NewTLSConfig(config.TLSConfiguration, brokerLogger.Session("tls"))
+		if err != nil {
+			brokerLogger.Fatal("Couldn't configure TLS", err)
+		}
+	}
+
+	volumeMonitor := &monitor.Monitor{
+		Broker:  serviceBroker,
+		Metrics: brokerMetrics,
+		Config:  config.MonitorConfiguration,
+		Logger:  brokerLogger.Session("monitor"),
+	}
+
+	driftReconciler := &reconciler.Reconciler{
+		Broker:  serviceBroker,
+		Metrics: brokerMetrics,
+		Config:  config.ReconcilerConfiguration,
+		Logger:  brokerLogger.Session("reconciler"),
+	}
+
+	backupScheduler := &scheduler.Scheduler{
+		Broker:  serviceBroker,
+		Metrics: brokerMetrics,
+		Logger:  brokerLogger.Session("backup-scheduler"),
+	}
+
+	elector := &leader.Elector{
+		KubeClient: clientset,
+		Namespace:  config.Namespace,
+		Identity:   leaderIdentity(),
+		Config:     config.LeaderElectionConfiguration,
+		Metrics:    brokerMetrics,
+		Logger:     brokerLogger.Session("leader-election"),
+		Workers:    []func(context.Context){volumeMonitor.Run, driftReconciler.Run, backupScheduler.Run},
+	}
+
+	if config.AdminConfiguration.Enabled() {
+		adminAPI := &admin.API{
+			Admin:      &admin.Admin{Broker: serviceBroker},
+			Reconciler: driftReconciler,
+			IsLeader:   elector.IsLeader,
+			Config:     config.AdminConfiguration,
+			Logger:     brokerLogger.Session("admin-api"),
+		}
+		http.Handle(admin.APIPrefix+"/", adminAPI.Handler())
+	}
+
+	var dashboardServer *server.Server
+	if config.DashboardConfiguration.Enabled() {
+		instanceDashboard := &dashboard.Dashboard{
+			Admin:  &admin.Admin{Broker: serviceBroker},
+			Config: config.DashboardConfiguration,
+			Logger: brokerLogger.Session("dashboard"),
+		}
+		if config.DashboardConfiguration.FileBrowser.Enabled {
+			instanceDashboard.Browser = &dashboard.Browser{
+				KubeClient: clientset,
+				Namespace:  config.Namespace,
+				Config:     config.DashboardConfiguration.FileBrowser,
+				Logger:     brokerLogger.Session("file-browser"),
+			}
+		}
+		dashboardHandler, err := instanceDashboard.Handler()
+		if err != nil {
+			brokerLogger.Fatal("Couldn't configure the dashboard", err)
+		}
+		if config.DashboardConfiguration.Port == "" {
+			http.Handle("/dashboard/", dashboardHandler)
+		} else {
+			dashboardMux := http.NewServeMux()
+			dashboardMux.Handle("/dashboard/", dashboardHandler)
+			dashboardServer = &server.Server{
+				HTTPServer: &http.Server{
+					Addr:    config.Host + ":" + config.DashboardConfiguration.Port,
+					Handler: dashboardMux,
+				},
+				ShutdownTimeout: config.ShutdownTimeout,
+				Logger:          brokerLogger.Session("dashboard-server"),
+			}
+			// Browsers have no client certificates, so the dashboard only
+			// shares the certificate of the broker API
+			if tlsConfig := brokerServer.HTTPServer.TLSConfig; tlsConfig != nil {
+				dashboardServer.HTTPServer.TLSConfig = tlsConfig.Clone()
+				dashboardServer.HTTPServer.TLSConfig.ClientAuth = tls.NoClientCert
+				dashboardServer.HTTPServer.TLSConfig.ClientCAs = nil
+			}
+		}
+	}
+
+	brokerServer.Go(ctx, elector.Run)
+
+	if dashboardServer != nil {
+		dashboardListener, err := net.Listen("tcp", dashboardServer.HTTPServer.Addr)
+		if err != nil {
+			brokerLogger.Fatal("Couldn't listen for the dashboard", err)
+		}
+		brokerServer.Go(ctx, func(ctx context.Context) {
+			if err := dashboardServer.Serve(ctx, dashboardListener); err != nil {
+				brokerLogger.Error("dashboard-serve", err)
+			}
+		})
+	}
+
+	if config.HealthConfiguration.Port != "" {
+		healthListener, err := net.Listen("tcp", config.Host+":"+config.HealthConfiguration.Port)
+		if err != nil {
+			brokerLogger.Fatal("Couldn't listen for health checks", err)
+		}
+		healthServer := &server.Server{
+			HTTPServer:      &http.Server{Handler: healthMux},
+			ShutdownTimeout: config.ShutdownTimeout,
+			Logger:          brokerLogger.Session("health-server"),
+		}
+		brokerServer.Go(ctx, func(ctx context.Context) {
+			if err := healthServer.Serve(ctx, healthListener); err != nil {
+				brokerLogger.Error("health-serve", err)
+			}
+		})
+	}
+
+	err = brokerServer.Run(ctx)
+	cancelBroker()
+	if err != nil {
+		brokerLogger.Fatal("http-serve", err)
+	}
+
+	brokerLogger.Info("Eirini Persi Broker stopped")
+}
+
+// waitForCacheSync waits a limited time for the informer caches to fill.
+// Lookups fall back to the API server until they are.
+func waitForCacheSync(ctx context.Context, informerFactory informers.SharedInformerFactory, logger lager.Logger) {
+	syncCtx, cancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer cancel()
+
+	for informerType, synced := range informerFactory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			logger.Error("cache-sync", errors.New("informer cache didn't sync"), lager.Data{"type": informerType.String()})
+		}
+	}
+}
+
+// leaderIdentity identifies this replica in the leader election lease
+func leaderIdentity() string {
+	if podName := os.Getenv("POD_NAME"); podName != "" {
+		return podName
+	}
+
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// bootstrapLogger logs errors that happen before logging is configured
+func bootstrapLogger() lager.Logger {
+	logger := lager.NewLogger("eirini-persi-broker")
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.ERROR))
+	return logger
+}
+
+// shutdownContext returns a context that is cancelled on SIGTERM or SIGINT
+func shutdownContext(logger lager.Logger) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigChannel := make(chan os.Signal, 1)
+	signal.Notify(sigChannel, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigChannel
+		logger.Info("Starting Eirini Persi Broker shutdown", lager.Data{"signal": sig.String()})
+		cancel()
+	}()
 
-	brokerLogger.Fatal("http-listen", http.ListenAndServe(config.Host+":"+config.Port, nil))
+	return ctx
 }
 
 func configPath() string {
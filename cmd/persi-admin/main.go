@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc" // from https://github.com/kubernetes/client-go/issues/345
+
+	"code.cloudfoundry.org/eirini-persi-broker/admin"
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/kube"
+	"code.cloudfoundry.org/eirini-persi-broker/log"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+	"code.cloudfoundry.org/eirini-persi-broker/reconciler"
+	"code.cloudfoundry.org/eirini-persi-broker/usage"
+)
+
+// adminPlatform is the platform named in the originating identity of changes
+// made with this tool
+const adminPlatform = "persi-admin"
+
+const usageText = `Usage: persi-admin [options] <command> [arguments]
+
+Commands:
+  list [filters]                            list instances
+  show <instance-id>                        show an instance in detail
+  events <instance-id>                      show the recent events of an instance
+  orphans                                   find orphaned and drifted resources
+  force-unbind <instance-id> <binding-id>   remove a stale binding
+  undelete <instance-id>                    restore a retained deprovisioned instance
+  export [filters]                          export instance metadata as JSON
+  import [-dry-run] <file>                  import instance metadata, - reads stdin
+
+Filters:
+  -plan <name or id> -org <guid> -space <guid> -deleted
+
+Options:
+`
+
+type command struct {
+	admin     *admin.Admin
+	broker    *broker.KubeVolumeBroker
+	output    string
+	logger    lager.Logger
+	arguments []string
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usageText)
+		flag.PrintDefaults()
+	}
+	configPath := flag.String("config", os.Getenv("BROKER_CONFIG_PATH"), "path of the broker configuration")
+	kubeConfigPath := flag.String("kubeconfig", os.Getenv("KUBECONFIG"), "path of the kube config")
+	output := flag.String("o", outputTable, "output format, table or json")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output != outputTable && *output != outputJSON {
+		fail(errors.Errorf("unknown output format %s", *output))
+	}
+
+	run, ok := map[string]func(*command) error{
+		"list":         list,
+		"show":         show,
+		"events":       events,
+		"orphans":      orphans,
+		"force-unbind": forceUnbind,
+		"undelete":     undelete,
+		"export":       export,
+		"import":       importInstances,
+	}[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Broker events are shown on the console, unless the output is JSON
+	logger := lager.NewLogger(adminPlatform)
+	if *output == outputTable {
+		logger.RegisterSink(log.NewCliSink(lager.INFO))
+	}
+
+	serviceBroker, err := newBroker(*configPath, *kubeConfigPath, logger)
+	if err != nil {
+		fail(err)
+	}
+
+	err = run(&command{
+		admin:     &admin.Admin{Broker: serviceBroker},
+		broker:    serviceBroker,
+		output:    *output,
+		logger:    logger,
+		arguments: flag.Args()[1:],
+	})
+	if err != nil {
+		fail(err)
+	}
+}
+
+func list(c *command) error {
+	filter, err := c.parseFilter("list")
+	if err != nil {
+		return err
+	}
+
+	instances, err := c.admin.List(c.broker.Context, filter)
+	if err != nil {
+		return err
+	}
+
+	return c.print(instances, printInstances)
+}
+
+func show(c *command) error {
+	if len(c.arguments) != 1 {
+		return errors.New("show requires an instance id")
+	}
+
+	instance, err := c.admin.Get(c.broker.Context, c.arguments[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(instance, printInstance)
+}
+
+func events(c *command) error {
+	if len(c.arguments) != 1 {
+		return errors.New("events requires an instance id")
+	}
+
+	recent, err := c.admin.RecentEvents(c.broker.Context, c.arguments[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(recent, printEvents)
+}
+
+func orphans(c *command) error {
+	if len(c.arguments) != 0 {
+		return errors.New("orphans takes no arguments")
+	}
+
+	// Only report issues; repairs are left to the broker's reconciler
+	reconcilerConfig := c.broker.Config.ReconcilerConfiguration
+	reconcilerConfig.Repair = false
+
+	driftReconciler := &reconciler.Reconciler{
+		Broker:  c.broker,
+		Metrics: metrics.New(prometheus.NewRegistry()),
+		Config:  reconcilerConfig,
+		Logger:  c.logger.Session("reconciler"),
+	}
+
+	issues, err := driftReconciler.Reconcile(c.broker.Context)
+	if err != nil {
+		return err
+	}
+	if issues == nil {
+		issues = []reconciler.Issue{}
+	}
+
+	return c.print(issues, printIssues)
+}
+
+func forceUnbind(c *command) error {
+	if len(c.arguments) != 2 {
+		return errors.New("force-unbind requires an instance id and a binding id")
+	}
+
+	return c.admin.ForceUnbind(c.operatorContext(), c.arguments[0], c.arguments[1])
+}
+
+func undelete(c *command) error {
+	if len(c.arguments) != 1 {
+		return errors.New("undelete requires an instance id")
+	}
+
+	return c.admin.Undelete(c.operatorContext(), c.arguments[0])
+}
+
+func export(c *command) error {
+	filter, err := c.parseFilter("export")
+	if err != nil {
+		return err
+	}
+
+	instances, err := c.admin.Export(c.broker.Context, filter)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(os.Stdout, instances)
+}
+
+func importInstances(c *command) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only show what would be imported")
+	if err := flags.Parse(c.arguments); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("import requires a file")
+	}
+
+	var input io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "error opening import file")
+		}
+		defer file.Close()
+		input = file
+	}
+
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return errors.Wrap(err, "error reading import file")
+	}
+
+	var instances []admin.InstanceMetadata
+	if err := json.Unmarshal(data, &instances); err != nil {
+		return errors.Wrap(err, "error unmarshaling import file")
+	}
+
+	results, err := c.admin.Import(c.broker.Context, instances, *dryRun)
+	if printErr := c.print(results, printImportResults); printErr != nil && err == nil {
+		err = printErr
+	}
+
+	return err
+}
+
+// parseFilter parses the flags selecting instances
+func (c *command) parseFilter(name string) (admin.Filter, error) {
+	var filter admin.Filter
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&filter.Plan, "plan", "", "only instances of the plan with this name or id")
+	flags.StringVar(&filter.OrganizationID, "org", "", "only instances in the organization with this guid")
+	flags.StringVar(&filter.SpaceID, "space", "", "only instances in the space with this guid")
+	flags.BoolVar(&filter.Deleted, "deleted", false, "only deprovisioned instances that are retained")
+	if err := flags.Parse(c.arguments); err != nil {
+		return filter, err
+	}
+	if flags.NArg() != 0 {
+		return filter, errors.Errorf("%s takes no arguments", name)
+	}
+
+	return filter, nil
+}
+
+// operatorContext returns the context for changes made by the user running this tool
+func (c *command) operatorContext() context.Context {
+	return broker.WithOriginatingIdentity(c.broker.Context, broker.OriginatingIdentity{
+		Platform: adminPlatform,
+		UserID:   operator(),
+	})
+}
+
+// print writes value as JSON or with printTable, depending on the output format
+func (c *command) print(value interface{}, printTable func(io.Writer, interface{})) error {
+	if c.output == outputJSON {
+		return printJSON(os.Stdout, value)
+	}
+
+	printTable(os.Stdout, value)
+	return nil
+}
+
+// newBroker creates a broker for the configuration at configPath. It doesn't
+// serve requests; it's only used to manage instances and records events for
+// the changes it makes.
+func newBroker(configPath, kubeConfigPath string, logger lager.Logger) (*broker.KubeVolumeBroker, error) {
+	if configPath == "" {
+		return nil, errors.New("no broker configuration, use -config or BROKER_CONFIG_PATH")
+	}
+
+	brokerConfig, err := config.ParseConfig(configPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading broker configuration")
+	}
+
+	kubeConfig, err := kube.NewKubeConfigGetter(logger).Get(kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Kubernetes client")
+	}
+
+	usageSource, err := usage.NewSource(brokerConfig.UsageConfiguration, clientset)
+	if err != nil {
+		return nil, errors.Wrap(err, "error configuring volume usage reporting")
+	}
+
+	serviceBroker := &broker.KubeVolumeBroker{
+		KubeClient: clientset,
+		Config:     brokerConfig,
+		Context:    context.Background(),
+		Logger:     logger.Session("broker"),
+		Usage:      usageSource,
+	}
+	serviceBroker.Recorder = &eventRecorder{
+		kubeClient: clientset,
+		namespace:  brokerConfig.Namespace,
+		timeout:    serviceBroker.KubeTimeout(),
+		logger:     logger.Session("events"),
+	}
+
+	return serviceBroker, nil
+}
+
+// operator returns the name of the user running this tool
+func operator() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/eirini-persi-broker/admin"
+	"code.cloudfoundry.org/eirini-persi-broker/log"
+	"code.cloudfoundry.org/eirini-persi-broker/reconciler"
+)
+
+// Output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func printJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func printInstances(w io.Writer, value interface{}) {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "INSTANCE\tNAME\tPLAN\tORG\tSPACE\tSIZE\tPHASE\tBINDINGS")
+	for _, instance := range value.([]admin.Instance) {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n",
+			instance.ID,
+			instance.Name,
+			planName(instance),
+			nameOrID(instance.OrganizationName, instance.OrganizationID),
+			nameOrID(instance.SpaceName, instance.SpaceID),
+			instance.Size,
+			instance.Phase,
+			len(instance.Bindings),
+		)
+	}
+	table.Flush()
+}
+
+// field is a labelled value shown for a single instance. Fields with an
+// empty value aren't shown.
+type field struct {
+	label string
+	value string
+}
+
+func printInstance(w io.Writer, value interface{}) {
+	instance := value.(admin.Instance)
+
+	fields := []field{
+		{"instance", instance.ID},
+		{"claim", instance.ClaimName},
+		{"name", instance.Name},
+		{"plan", planName(instance)},
+		{"organization", nameAndID(instance.OrganizationName, instance.OrganizationID)},
+		{"space", nameAndID(instance.SpaceName, instance.SpaceID)},
+		{"storage class", instance.StorageClass},
+		{"access mode", instance.AccessMode},
+		{"size", instance.Size},
+		{"capacity", instance.Capacity},
+		{"phase", instance.Phase},
+		{"volume", instance.VolumeName},
+		{"created at", formatTime(instance.CreatedAt)},
+		{"created by", instance.CreatedBy},
+		{"modified by", instance.LastModifiedBy},
+	}
+	if instance.DeletedAt != nil {
+		fields = append(fields, field{"deleted at", formatTime(*instance.DeletedAt)})
+	}
+	if instance.Usage != nil {
+		fields = append(fields,
+			field{"used bytes", strconv.FormatInt(instance.Usage.UsedBytes, 10)},
+			field{"available bytes", strconv.FormatInt(instance.Usage.AvailableBytes, 10)},
+		)
+	}
+
+	for _, field := range fields {
+		if field.value != "" {
+			fmt.Fprintln(w, log.CliLine(field.label, field.value))
+		}
+	}
+	for _, binding := range instance.Bindings {
+		description := binding.ID + " at " + binding.Directory
+		if binding.Protocol != "" {
+			description = binding.ID + " with " + binding.Protocol + " access"
+		}
+		if binding.Shared {
+			description += " shared with space " + binding.SpaceID
+		}
+		fmt.Fprintln(w, log.CliLine("binding", description))
+	}
+	for _, backup := range instance.Backups {
+		fmt.Fprintln(w, log.CliLine("backup", backup.ID+" at "+backup.Time.Format(time.RFC3339)+", "+backup.State))
+	}
+}
+
+func printIssues(w io.Writer, value interface{}) {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "KIND\tRESOURCE\tNAME\tBINDING\tMESSAGE")
+	for _, issue := range value.([]reconciler.Issue) {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", issue.Kind, issue.Resource, issue.Name, issue.BindingID, issue.Message)
+	}
+	table.Flush()
+}
+
+func printEvents(w io.Writer, value interface{}) {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tTYPE\tREASON\tCOUNT\tMESSAGE")
+	for _, event := range value.([]admin.Event) {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\n", event.Time.Format(time.RFC3339), event.Type, event.Reason, event.Count, event.Message)
+	}
+	table.Flush()
+}
+
+func printImportResults(w io.Writer, value interface{}) {
+	for _, result := range value.([]admin.ImportResult) {
+		fmt.Fprintln(w, log.CliLine(result.Outcome, result.ID))
+	}
+}
+
+// planName returns the name of the plan of instance, or its ID if the plan
+// isn't configured
+func planName(instance admin.Instance) string {
+	if instance.PlanName != "" {
+		return instance.PlanName
+	}
+	return instance.PlanID
+}
+
+// nameOrID returns the name of an organization or space if the platform sent
+// it, or its GUID otherwise
+func nameOrID(name, id string) string {
+	if name != "" {
+		return name
+	}
+	return id
+}
+
+// nameAndID returns the name of an organization or space followed by its GUID
+func nameAndID(name, id string) string {
+	if name != "" {
+		return name + " (" + id + ")"
+	}
+	return id
+}
+
+// formatTime returns t in RFC 3339, or an empty string if it's the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
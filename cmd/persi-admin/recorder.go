@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/reference"
+)
+
+// eventRecorder records the events of instance changes made with this tool.
+// Events are created right away instead of being queued like those of the
+// broker, since the tool exits as soon as a command is done.
+type eventRecorder struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	timeout    time.Duration
+	logger     lager.Logger
+}
+
+func (r *eventRecorder) Event(object runtime.Object, eventType, reason, message string) {
+	r.AnnotatedEventf(object, nil, eventType, reason, "%s", message)
+}
+
+func (r *eventRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	r.AnnotatedEventf(object, nil, eventType, reason, messageFmt, args...)
+}
+
+func (r *eventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+	ref, err := reference.GetReference(scheme.Scheme, object)
+	if err != nil {
+		r.logger.Error("record-event", err, lager.Data{"reason": reason})
+		return
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = r.namespace
+	}
+
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: adminPlatform},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	if _, err := r.kubeClient.CoreV1().Events(namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		r.logger.Error("record-event", err, lager.Data{"reason": reason})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+
+	"code.cloudfoundry.org/eirini-persi-broker/files"
+)
+
+// Environment variables holding the credentials clients must present
+const (
+	tokenEnv    = "PERSI_FILES_TOKEN"
+	usernameEnv = "PERSI_FILES_USERNAME"
+	passwordEnv = "PERSI_FILES_PASSWORD"
+)
+
+// persi-files serves the files of a volume to the broker dashboard. The
+// broker runs it in short-lived helper pods that mount the volume. With
+// -webdav, it serves the volume over WebDAV to the clients of a service key,
+// over TLS if -cert and -key are set.
+func main() {
+	root := flag.String("root", "/data", "directory to serve")
+	listen := flag.String("listen", ":8080", "address to listen on")
+	webdav := flag.Bool("webdav", false, "serve WebDAV with basic authentication")
+	certFile := flag.String("cert", "", "certificate to serve TLS with")
+	keyFile := flag.String("key", "", "key of the certificate to serve TLS with")
+	flag.Parse()
+
+	logger := lager.NewLogger("persi-files")
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.INFO))
+
+	var handler http.Handler
+	if *webdav {
+		username, password := os.Getenv(usernameEnv), os.Getenv(passwordEnv)
+		if username == "" || password == "" {
+			logger.Fatal("missing-credentials", errors.New(usernameEnv+" and "+passwordEnv+" must be set"))
+		}
+
+		server := &files.WebDAVServer{
+			Root:     *root,
+			Username: username,
+			Password: password,
+			Logger:   logger,
+		}
+		handler = server.Handler()
+	} else {
+		token := os.Getenv(tokenEnv)
+		if token == "" {
+			logger.Fatal("missing-token", errors.New(tokenEnv+" isn't set"))
+		}
+
+		server := &files.Server{
+			Root:   *root,
+			Token:  token,
+			Logger: logger,
+		}
+		handler = server.Handler()
+	}
+
+	tls := *certFile != "" && *keyFile != ""
+	logger.Info("serving", lager.Data{"root": *root, "listen": *listen, "webdav": *webdav, "tls": tls})
+
+	var err error
+	if tls {
+		err = http.ListenAndServeTLS(*listen, *certFile, *keyFile, handler)
+	} else {
+		err = http.ListenAndServe(*listen, handler)
+	}
+	if err != nil {
+		logger.Fatal("http-serve", err)
+	}
+}
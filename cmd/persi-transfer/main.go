@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
+	"code.cloudfoundry.org/eirini-persi-broker/transfer"
+)
+
+// persi-transfer moves the contents of volumes in and out of the jobs the
+// broker runs for instances. Errors are printed last, so they end up in the
+// log tail the broker reports.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: persi-transfer seed|backup|restore [flags]")
+		os.Exit(2)
+	}
+
+	logger := lager.NewLogger("persi-transfer")
+	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.INFO))
+
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "seed":
+		err = seed(logger, args)
+	case "backup":
+		err = backup(logger, args)
+	case "restore":
+		err = restore(logger, args)
+	default:
+		fmt.Fprintln(os.Stderr, "unknown command "+command)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		logger.Error("failed", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// seed extracts the archive described by the environment into a volume
+func seed(logger lager.Logger, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	dir := flags.String("dir", "/data", "directory to extract the archive into")
+	format := flags.String("format", "", "format of the archive, guessed from its name if empty")
+	_ = flags.Parse(args)
+
+	source := transfer.SourceFromEnv(os.Getenv)
+	logger.Info("seeding", lager.Data{"source": source.String(), "dir": *dir})
+
+	if err := transfer.Seed(context.Background(), source, *format, *dir, nil); err != nil {
+		return err
+	}
+
+	logger.Info("seeded", lager.Data{"source": source.String()})
+	return nil
+}
+
+// backup uploads the contents of a volume to the object store described by
+// the environment
+func backup(logger lager.Logger, args []string) error {
+	metadata := metadataFlag{}
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := flags.String("dir", "/data", "directory to back up")
+	key := flags.String("key", "", "key of the backup object")
+	flags.Var(metadata, "metadata", "name=value metadata of the backup object, may be repeated")
+	_ = flags.Parse(args)
+
+	store := objectstore.ConfigFromEnv(os.Getenv)
+	logger.Info("backing-up", lager.Data{"dir": *dir, "bucket": store.Bucket, "key": *key})
+
+	if err := transfer.Backup(context.Background(), store, *key, *dir, metadata, nil); err != nil {
+		return err
+	}
+
+	logger.Info("backed-up", lager.Data{"bucket": store.Bucket, "key": *key})
+	return nil
+}
+
+// restore replaces the contents of a volume with a backup in the object
+// store described by the environment
+func restore(logger lager.Logger, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	dir := flags.String("dir", "/data", "directory to restore into")
+	key := flags.String("key", "", "key of the backup object")
+	_ = flags.Parse(args)
+
+	store := objectstore.ConfigFromEnv(os.Getenv)
+	logger.Info("restoring", lager.Data{"dir": *dir, "bucket": store.Bucket, "key": *key})
+
+	if err := transfer.Restore(context.Background(), store, *key, *dir, nil); err != nil {
+		return err
+	}
+
+	logger.Info("restored", lager.Data{"bucket": store.Bucket, "key": *key})
+	return nil
+}
+
+// metadataFlag collects name=value flags
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for name, value := range m {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m metadataFlag) Set(pair string) error {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("metadata must be name=value, not %s", pair)
+	}
+
+	m[parts[0]] = parts[1]
+	return nil
+}
@@ -2,17 +2,36 @@ package config
 
 import (
 	"io/ioutil"
+	"time"
 
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
 )
 
 // Config represents the configuration for the entire server
 type Config struct {
-	ServiceConfiguration ServiceConfiguration `yaml:"service"`
-	AuthConfiguration    AuthConfiguration    `yaml:"auth"`
-	Host                 string               `yaml:"backend_host"`
-	Port                 string               `yaml:"backend_port"`
-	Namespace            string               `yaml:"namespace"`
+	ServiceConfiguration        ServiceConfiguration        `yaml:"service"`
+	AuthConfiguration           AuthConfiguration           `yaml:"auth"`
+	Host                        string                      `yaml:"backend_host"`
+	Port                        string                      `yaml:"backend_port"`
+	Namespace                   string                      `yaml:"namespace"`
+	TLSConfiguration            TLSConfiguration            `yaml:"tls"`
+	ShutdownTimeout             time.Duration               `yaml:"shutdown_timeout"`
+	KubeTimeout                 time.Duration               `yaml:"kube_timeout"`
+	HealthConfiguration         HealthConfiguration         `yaml:"health"`
+	LogConfiguration            LogConfiguration            `yaml:"log"`
+	UsageConfiguration          UsageConfiguration          `yaml:"usage"`
+	MonitorConfiguration        MonitorConfiguration        `yaml:"monitor"`
+	ReconcilerConfiguration     ReconcilerConfiguration     `yaml:"reconciler"`
+	LeaderElectionConfiguration LeaderElectionConfiguration `yaml:"leader_election"`
+	SoftDeleteConfiguration     SoftDeleteConfiguration     `yaml:"soft_delete"`
+	AdminConfiguration          AdminConfiguration          `yaml:"admin"`
+	DashboardConfiguration      DashboardConfiguration      `yaml:"dashboard"`
+	ServiceKeyConfiguration     ServiceKeyConfiguration     `yaml:"service_keys"`
+	JobConfiguration            JobConfiguration            `yaml:"jobs"`
 }
 
 // AuthConfiguration contains credentials for authenticating with the broker
@@ -21,13 +40,199 @@ type AuthConfiguration struct {
 	Username string `yaml:"username"`
 }
 
-// ServiceConfiguration represents the configuration for the Eirini Kubernetes Volume Broker
+// AdminConfiguration contains the credentials of the admin API. They are
+// separate from the credentials the platform uses for the broker API; the
+// admin API is only served if they are set.
+type AdminConfiguration struct {
+	Password string `yaml:"password"`
+	Username string `yaml:"username"`
+}
+
+// Enabled returns true if the broker should serve the admin API
+func (c AdminConfiguration) Enabled() bool {
+	return c.Username != "" && c.Password != ""
+}
+
+// DashboardConfiguration contains the settings of the instance dashboards.
+// URL is the external URL browsers reach the broker at. Cloud Foundry
+// registers the dashboard client with UAA from the catalog; users sign in
+// with UAA at UAAURL and the Cloud Controller at CloudControllerURL decides
+// what they may see. Session cookies are encrypted with SessionSecret, or
+// with the client secret if it isn't set. The dashboard is only served if URL
+// and the client are set. If Port is set, the dashboard is served on Port
+// instead of the broker API listener, with the broker's certificate but
+// without requiring client certificates browsers don't have.
+type DashboardConfiguration struct {
+	URL                string                   `yaml:"url"`
+	Port               string                   `yaml:"port"`
+	ClientID           string                   `yaml:"client_id"`
+	ClientSecret       string                   `yaml:"client_secret"`
+	UAAURL             string                   `yaml:"uaa_url"`
+	CloudControllerURL string                   `yaml:"cloud_controller_url"`
+	SessionSecret      string                   `yaml:"session_secret"`
+	SessionTTL         time.Duration            `yaml:"session_ttl"`
+	FileBrowser        FileBrowserConfiguration `yaml:"file_browser"`
+}
+
+// Enabled returns true if the broker should serve the instance dashboards
+func (c DashboardConfiguration) Enabled() bool {
+	return c.URL != "" && c.ClientID != "" && c.ClientSecret != ""
+}
+
+// FileBrowserConfiguration contains the settings of the helper pods that
+// let space developers browse, upload and download the files of an instance.
+// The pods run Image with Command, which must serve the files of the volume
+// like persi-files does, and stop after Timeout.
+type FileBrowserConfiguration struct {
+	Enabled        bool          `yaml:"enabled"`
+	Image          string        `yaml:"image"`
+	Command        []string      `yaml:"command"`
+	Timeout        time.Duration `yaml:"timeout"`
+	StartupTimeout time.Duration `yaml:"startup_timeout"`
+}
+
+// ServiceKeyConfiguration contains the settings of the access servers the
+// broker runs for service keys, bindings without an app. Each key gets a
+// server that mounts the volume of its instance and serves it over WebDAV or
+// SFTP with credentials of its own. A protocol is offered if its image is set;
+// users pick one with the protocol parameter, WebDAV being the default.
+// Clients reach the servers through a service of ServiceType at Host, which
+// defaults to the cluster DNS name of the service.
+type ServiceKeyConfiguration struct {
+	ServiceType string                    `yaml:"service_type"`
+	Host        string                    `yaml:"host"`
+	WebDAV      AccessServerConfiguration `yaml:"webdav"`
+	SFTP        AccessServerConfiguration `yaml:"sftp"`
+}
+
+// Enabled returns true if the broker should run access servers for service
+// keys
+func (c ServiceKeyConfiguration) Enabled() bool {
+	return c.WebDAV.Image != "" || c.SFTP.Image != ""
+}
+
+// AccessServerConfiguration contains the image of an access server and its
+// command. WebDAV servers must behave like persi-files -webdav, SFTP servers
+// like the atmoz/sftp image. WebDAV servers serve over TLS with the
+// certificate of the kubernetes.io/tls secret TLSSecret in the broker's
+// namespace. WebDAV clients send their credentials with every request, so
+// WebDAV servers are only exposed outside the cluster, with a LoadBalancer or
+// NodePort service type, if TLSSecret is set.
+type AccessServerConfiguration struct {
+	Image     string   `yaml:"image"`
+	Command   []string `yaml:"command"`
+	TLSSecret string   `yaml:"tls_secret"`
+}
+
+// JobConfiguration contains the settings of the jobs that move the contents
+// of volumes, like seeding new instances. Jobs run Image with Command, which
+// must behave like persi-transfer, and fail after Timeout. Finished jobs are
+// deleted once TTLAfterFinished is over. Instances can only be seeded if the
+// image is set.
+type JobConfiguration struct {
+	Image            string        `yaml:"image"`
+	Command          []string      `yaml:"command"`
+	Timeout          time.Duration `yaml:"timeout"`
+	TTLAfterFinished time.Duration `yaml:"ttl_after_finished"`
+}
+
+// Enabled returns true if the broker can run jobs
+func (c JobConfiguration) Enabled() bool {
+	return c.Image != ""
+}
+
+// TLSConfiguration contains the certificates and protocol settings used to
+// serve the broker API over TLS. TLS is enabled when a certificate is set.
+type TLSConfiguration struct {
+	CertFile     string   `yaml:"cert_file"`
+	KeyFile      string   `yaml:"key_file"`
+	ClientCAFile string   `yaml:"client_ca_file"`
+	MinVersion   string   `yaml:"min_version"`
+	CipherSuites []string `yaml:"cipher_suites"`
+}
+
+// Enabled returns true if the broker should serve its API over TLS
+func (c TLSConfiguration) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// HealthConfiguration contains the settings for the readiness checks. If
+// Port is set, the health checks and metrics are served over plain HTTP on
+// Port instead of the broker API listener, so probes and scrapes don't need
+// the client certificates the broker API may require.
+type HealthConfiguration struct {
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	Port     string        `yaml:"port"`
+}
+
+// LogConfiguration contains the level and format of the broker's logs.
+// The format is one of json, rfc3339 or cli.
+type LogConfiguration struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// UsageConfiguration selects where the volume usage reported for instances
+// comes from: kubelet (default), prometheus or none. Usage is only added to
+// the instances the platform fetches if InstanceParameters is set, since
+// looking it up holds up the platform's request.
+type UsageConfiguration struct {
+	Source             string `yaml:"source"`
+	PrometheusURL      string `yaml:"prometheus_url"`
+	InstanceParameters bool   `yaml:"instance_parameters"`
+}
+
+// MonitorConfiguration contains the settings of the background monitor that
+// checks the volume usage of all instances. Thresholds are fractions of the
+// volume space; a warning event is recorded when usage crosses one of them.
+type MonitorConfiguration struct {
+	Interval   time.Duration `yaml:"interval"`
+	Thresholds []float64     `yaml:"thresholds"`
+}
+
+// ReconcilerConfiguration contains the settings of the background reconciler
+// that looks for orphaned and drifted persistent volume claims. Issues are
+// only reported unless Repair is set.
+type ReconcilerConfiguration struct {
+	Interval       time.Duration `yaml:"interval"`
+	PendingTimeout time.Duration `yaml:"pending_timeout"`
+	Repair         bool          `yaml:"repair"`
+}
+
+// LeaderElectionConfiguration contains the settings of the Kubernetes lease
+// used to choose the replica that runs background workers. Without leader
+// election, every replica runs them.
+type LeaderElectionConfiguration struct {
+	Enabled       bool          `yaml:"enabled"`
+	LeaseName     string        `yaml:"lease_name"`
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	RenewDeadline time.Duration `yaml:"renew_deadline"`
+	RetryPeriod   time.Duration `yaml:"retry_period"`
+}
+
+// SoftDeleteConfiguration contains how long the persistent volume claims of
+// deprovisioned instances are retained, so that operators can restore them.
+// Claims are deleted right away without a retention.
+type SoftDeleteConfiguration struct {
+	Retention time.Duration `yaml:"retention"`
+}
+
+// ServiceConfiguration represents the configuration for the Eirini Kubernetes
+// Volume Broker. Labels and Annotations are set on the persistent volume
+// claims of all plans; their values are Go templates, see Plan. Users may set
+// the labels listed in UserLabels with the labels parameter. ClaimName is the
+// template of the names of the claims, see Plan.
 type ServiceConfiguration struct {
 	ServiceName string `yaml:"service_name"`
 	ServiceID   string `yaml:"service_id"`
 
 	Plans []Plan `yaml:"plans"`
 
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	ClaimName   string            `yaml:"claim_name"`
+	UserLabels  []string          `yaml:"user_labels"`
+
 	Description         string `yaml:"description"`
 	LongDescription     string `yaml:"long_description"`
 	ProviderDisplayName string `yaml:"provider_display_name"`
@@ -37,7 +242,22 @@ type ServiceConfiguration struct {
 	IconImage           string `yaml:"icon_image"`
 }
 
-// Plan represents a Broker plan for a Kubernetes storage class
+// Plan represents a Broker plan for a Kubernetes storage class. Instances of
+// shareable plans can be shared with other spaces and are always ReadWriteMany.
+//
+// Labels and Annotations are added to those of the service. Their values are
+// Go templates that can refer to .InstanceID, .InstanceName, .OrganizationID,
+// .OrganizationName, .SpaceID, .SpaceName, .ServiceID, .PlanID and .PlanName;
+// names are empty if the platform doesn't send them.
+//
+// ClaimName is a template of the same kind for the names of the claims of the
+// plan's instances, overriding that of the service. Claims are named after
+// their instance ID if neither is set. Rendered names are lowercased and
+// shortened into valid names; provisioning fails if the name is taken.
+//
+// Instances are backed up to BackupStore, if set, on request of their users
+// and at the times of BackupSchedule, a cron expression. Backups beyond the
+// BackupRetention are deleted.
 type Plan struct {
 	ID                string  `yaml:"plan_id"`
 	Name              string  `yaml:"plan_name"`
@@ -46,9 +266,93 @@ type Plan struct {
 	Free              bool    `yaml:"free"`
 	DefaultSize       string  `yaml:"default_size"`
 	DefaultAccessMode string  `yaml:"default_access_mode"`
+	MaxSize           string  `yaml:"max_size"`
+	Shareable         bool    `yaml:"shareable"`
+
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	ClaimName   string            `yaml:"claim_name"`
+
+	Autogrow *AutogrowPolicy `yaml:"autogrow"`
+
+	BackupStore     *objectstore.Config `yaml:"backup_store"`
+	BackupSchedule  string              `yaml:"backup_schedule"`
+	BackupRetention *RetentionPolicy    `yaml:"backup_retention"`
 }
 
-// ParseConfig parses a config file
+// RetentionPolicy keeps the Count latest successful backups of an instance
+// and those younger than MaxAge. The latest successful backup is always kept.
+// Zero values don't limit the backups.
+type RetentionPolicy struct {
+	Count  int           `yaml:"count"`
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// AutogrowPolicy lets the broker expand the volumes of a plan by Increment
+// once their usage crosses Threshold, a ratio, up to Ceiling and the plan's
+// max size. At least one of the limits is required.
+type AutogrowPolicy struct {
+	Threshold float64 `yaml:"threshold"`
+	Increment string  `yaml:"increment"`
+	Ceiling   string  `yaml:"ceiling"`
+}
+
+// validate returns an error unless the policy sets a threshold, a positive
+// increment and a limit, either its ceiling or the max size of its plan
+func (p AutogrowPolicy) validate(maxSize string) error {
+	if p.Threshold <= 0 || p.Threshold > 1 {
+		return errors.New("threshold must be above 0 and at most 1")
+	}
+
+	increment, err := resource.ParseQuantity(p.Increment)
+	if err != nil {
+		return errors.Wrap(err, "invalid increment")
+	}
+	if increment.Sign() <= 0 {
+		return errors.New("increment must be positive")
+	}
+
+	if p.Ceiling == "" && maxSize == "" {
+		return errors.New("ceiling or max size of the plan required")
+	}
+	for _, limit := range []string{p.Ceiling, maxSize} {
+		if limit == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(limit); err != nil {
+			return errors.Wrapf(err, "invalid limit %s", limit)
+		}
+	}
+
+	return nil
+}
+
+// Validate returns an error for settings the broker can't act on safely
+func (c Config) Validate() error {
+	if c.TLSConfiguration.ClientCAFile != "" && c.HealthConfiguration.Port == "" {
+		return errors.New("health port required when client certificates are required, probes and scrapes can't present them")
+	}
+	if c.TLSConfiguration.ClientCAFile != "" && c.DashboardConfiguration.Enabled() && c.DashboardConfiguration.Port == "" {
+		return errors.New("dashboard port required when client certificates are required, browsers can't present them")
+	}
+	if keys := c.ServiceKeyConfiguration; keys.WebDAV.Image != "" && keys.WebDAV.TLSSecret == "" &&
+		(keys.ServiceType == "LoadBalancer" || keys.ServiceType == "NodePort") {
+		return errors.Errorf("webdav tls secret required for service type %s, credentials would cross the network in cleartext", keys.ServiceType)
+	}
+
+	for _, plan := range c.ServiceConfiguration.Plans {
+		if plan.Autogrow == nil {
+			continue
+		}
+		if err := plan.Autogrow.validate(plan.MaxSize); err != nil {
+			return errors.Wrapf(err, "invalid autogrow policy of plan %s", plan.Name)
+		}
+	}
+
+	return nil
+}
+
+// ParseConfig parses and validates a config file
 func ParseConfig(path string) (Config, error) {
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -60,5 +364,9 @@ func ParseConfig(path string) (Config, error) {
 		return Config{}, err
 	}
 
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+
 	return config, nil
 }
@@ -4,11 +4,13 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	brokerconfig "code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
 )
 
 var _ = Describe("parsing the broker config file", func() {
@@ -65,11 +67,137 @@ var _ = Describe("parsing the broker config file", func() {
 				Ω(config.Port).Should(Equal("3000"))
 			})
 
+			It("loads the shutdown timeout", func() {
+				Ω(config.ShutdownTimeout).Should(Equal(45 * time.Second))
+			})
+
+			It("loads the kubernetes call timeout", func() {
+				Ω(config.KubeTimeout).Should(Equal(5 * time.Second))
+			})
+
+			It("loads the health check settings", func() {
+				Ω(config.HealthConfiguration.CacheTTL).Should(Equal(15 * time.Second))
+				Ω(config.HealthConfiguration.Port).Should(Equal("8081"))
+			})
+
+			It("loads the log configuration", func() {
+				Ω(config.LogConfiguration.Level).Should(Equal("info"))
+				Ω(config.LogConfiguration.Format).Should(Equal("rfc3339"))
+			})
+
+			It("loads the usage configuration", func() {
+				Ω(config.UsageConfiguration.Source).Should(Equal("prometheus"))
+				Ω(config.UsageConfiguration.PrometheusURL).Should(Equal("http://prometheus.monitoring:9090"))
+				Ω(config.UsageConfiguration.InstanceParameters).Should(BeTrue())
+			})
+
+			It("loads the monitor configuration", func() {
+				Ω(config.MonitorConfiguration.Interval).Should(Equal(10 * time.Minute))
+				Ω(config.MonitorConfiguration.Thresholds).Should(Equal([]float64{0.8, 0.95}))
+			})
+
+			It("loads the reconciler configuration", func() {
+				Ω(config.ReconcilerConfiguration.Interval).Should(Equal(30 * time.Minute))
+				Ω(config.ReconcilerConfiguration.PendingTimeout).Should(Equal(20 * time.Minute))
+				Ω(config.ReconcilerConfiguration.Repair).Should(BeTrue())
+			})
+
+			It("loads the leader election configuration", func() {
+				Ω(config.LeaderElectionConfiguration).Should(Equal(brokerconfig.LeaderElectionConfiguration{
+					Enabled:       true,
+					LeaseName:     "persi-broker-leader",
+					LeaseDuration: 20 * time.Second,
+					RenewDeadline: 15 * time.Second,
+					RetryPeriod:   3 * time.Second,
+				}))
+			})
+
+			It("loads the soft delete configuration", func() {
+				Ω(config.SoftDeleteConfiguration.Retention).Should(Equal(168 * time.Hour))
+			})
+
 			It("loads the auth credentials", func() {
 				Ω(config.AuthConfiguration.Username).To(Equal("admin"))
 				Ω(config.AuthConfiguration.Password).To(Equal("secret"))
 			})
 
+			It("loads the admin API credentials", func() {
+				Ω(config.AdminConfiguration.Username).To(Equal("operator"))
+				Ω(config.AdminConfiguration.Password).To(Equal("admin-secret"))
+				Ω(config.AdminConfiguration.Enabled()).To(BeTrue())
+			})
+
+			It("loads the dashboard configuration", func() {
+				Ω(config.DashboardConfiguration).To(Equal(brokerconfig.DashboardConfiguration{
+					URL:                "https://persi-broker.example.com:8443",
+					Port:               "8443",
+					ClientID:           "persi-dashboard",
+					ClientSecret:       "dashboard-secret",
+					UAAURL:             "https://uaa.example.com",
+					CloudControllerURL: "https://api.example.com",
+					SessionSecret:      "cookie-secret",
+					SessionTTL:         time.Hour,
+					FileBrowser: brokerconfig.FileBrowserConfiguration{
+						Enabled:        true,
+						Image:          "eirini/persi-broker",
+						Command:        []string{"/bin/persi-files"},
+						Timeout:        15 * time.Minute,
+						StartupTimeout: time.Minute,
+					},
+				}))
+				Ω(config.DashboardConfiguration.Enabled()).To(BeTrue())
+			})
+
+			It("loads the job configuration", func() {
+				Ω(config.JobConfiguration).To(Equal(brokerconfig.JobConfiguration{
+					Image:            "eirini/persi-broker",
+					Command:          []string{"/bin/persi-transfer"},
+					Timeout:          2 * time.Hour,
+					TTLAfterFinished: 48 * time.Hour,
+				}))
+				Ω(config.JobConfiguration.Enabled()).To(BeTrue())
+			})
+
+			It("loads the service key configuration", func() {
+				Ω(config.ServiceKeyConfiguration).To(Equal(brokerconfig.ServiceKeyConfiguration{
+					ServiceType: "NodePort",
+					Host:        "nodes.example.com",
+					WebDAV: brokerconfig.AccessServerConfiguration{
+						Image:     "eirini/persi-broker",
+						Command:   []string{"/bin/persi-files"},
+						TLSSecret: "persi-webdav-tls",
+					},
+					SFTP: brokerconfig.AccessServerConfiguration{
+						Image: "atmoz/sftp",
+					},
+				}))
+				Ω(config.ServiceKeyConfiguration.Enabled()).To(BeTrue())
+			})
+
+			It("loads the tls configuration", func() {
+				Ω(config.TLSConfiguration.Enabled()).To(BeTrue())
+				Ω(config.TLSConfiguration.CertFile).To(Equal("/etc/broker/tls/tls.crt"))
+				Ω(config.TLSConfiguration.KeyFile).To(Equal("/etc/broker/tls/tls.key"))
+				Ω(config.TLSConfiguration.ClientCAFile).To(Equal("/etc/broker/tls/ca.crt"))
+				Ω(config.TLSConfiguration.MinVersion).To(Equal("1.2"))
+				Ω(config.TLSConfiguration.CipherSuites).To(Equal([]string{
+					"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+					"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+				}))
+			})
+
+			It("loads the labels and annotations of the service", func() {
+				Ω(config.ServiceConfiguration.Labels).To(Equal(map[string]string{
+					"cost-center": "storage",
+					"space":       "{{.SpaceName}}",
+				}))
+				Ω(config.ServiceConfiguration.Annotations).To(Equal(map[string]string{
+					"example.com/owner": "{{.OrganizationID}}/{{.SpaceID}}",
+				}))
+				Ω(config.ServiceConfiguration.UserLabels).To(Equal([]string{"team", "app.example.com/component"}))
+				Ω(config.ServiceConfiguration.ClaimName).To(Equal("{{.SpaceName}}-{{.InstanceName}}"))
+			})
+
 			It("loads plans", func() {
 				persistent := "persistent"
 				gold := "gold"
@@ -88,12 +216,43 @@ var _ = Describe("parsing the broker config file", func() {
 							StorageClass: &gold,
 							Free:         false,
 							Description:  "this is another description",
+							MaxSize:      "100Gi",
+							Shareable:    true,
+							Labels:       map[string]string{"tier": "gold"},
+							Annotations:  map[string]string{"backup.example.com/schedule": "daily"},
+							ClaimName:    "gold-{{.InstanceID}}",
+							Autogrow: &brokerconfig.AutogrowPolicy{
+								Threshold: 0.9,
+								Increment: "5Gi",
+								Ceiling:   "50Gi",
+							},
+							BackupStore: &objectstore.Config{
+								Endpoint:        "https://minio.example.com",
+								Bucket:          "gold-backups",
+								AccessKeyID:     "backup",
+								SecretAccessKey: "backup-secret",
+							},
+							BackupSchedule: "0 2 * * *",
+							BackupRetention: &brokerconfig.RetentionPolicy{
+								Count:  7,
+								MaxAge: 720 * time.Hour,
+							},
 						},
 					},
 				))
 			})
 		})
 
+		Context("when an autogrow policy is incomplete", func() {
+			BeforeEach(func() {
+				configPath = "test_config.yml-invalid-autogrow"
+			})
+
+			It("returns an error", func() {
+				Ω(parseConfigErr).Should(MatchError(ContainSubstring("invalid autogrow policy of plan somename")))
+			})
+		})
+
 		Context("when the configuration is invalid", func() {
 
 			BeforeEach(func() {
@@ -105,4 +264,82 @@ var _ = Describe("parsing the broker config file", func() {
 			})
 		})
 	})
+	Describe("Validate", func() {
+		var config brokerconfig.Config
+
+		BeforeEach(func() {
+			config = brokerconfig.Config{
+				ServiceConfiguration: brokerconfig.ServiceConfiguration{
+					Plans: []brokerconfig.Plan{{
+						Name:    "growing",
+						MaxSize: "10Gi",
+						Autogrow: &brokerconfig.AutogrowPolicy{
+							Threshold: 0.9,
+							Increment: "1Gi",
+						},
+					}},
+				},
+			}
+		})
+
+		It("accepts complete autogrow policies", func() {
+			Ω(config.Validate()).Should(Succeed())
+		})
+
+		It("requires a threshold", func() {
+			config.ServiceConfiguration.Plans[0].Autogrow.Threshold = 0
+			Ω(config.Validate()).Should(MatchError(ContainSubstring("threshold must be above 0")))
+		})
+
+		It("requires a valid increment", func() {
+			config.ServiceConfiguration.Plans[0].Autogrow.Increment = ""
+			Ω(config.Validate()).Should(MatchError(ContainSubstring("invalid increment")))
+
+			config.ServiceConfiguration.Plans[0].Autogrow.Increment = "0"
+			Ω(config.Validate()).Should(MatchError(ContainSubstring("increment must be positive")))
+		})
+
+		It("requires a limit", func() {
+			config.ServiceConfiguration.Plans[0].MaxSize = ""
+			Ω(config.Validate()).Should(MatchError(ContainSubstring("ceiling or max size of the plan required")))
+
+			config.ServiceConfiguration.Plans[0].Autogrow.Ceiling = "lots"
+			Ω(config.Validate()).Should(MatchError(ContainSubstring("invalid limit lots")))
+		})
+
+		It("requires a health port when client certificates are required", func() {
+			config.TLSConfiguration.ClientCAFile = "/etc/broker/tls/ca.crt"
+			Ω(config.Validate()).Should(MatchError(ContainSubstring("health port required")))
+
+			config.HealthConfiguration.Port = "8081"
+			Ω(config.Validate()).Should(Succeed())
+		})
+
+		It("requires a dashboard port when client certificates are required", func() {
+			config.TLSConfiguration.ClientCAFile = "/etc/broker/tls/ca.crt"
+			config.HealthConfiguration.Port = "8081"
+			config.DashboardConfiguration = brokerconfig.DashboardConfiguration{
+				URL:          "https://persi-broker.example.com:8443",
+				ClientID:     "persi-dashboard",
+				ClientSecret: "dashboard-secret",
+			}
+			Ω(config.Validate()).Should(MatchError(ContainSubstring("dashboard port required")))
+
+			config.DashboardConfiguration.Port = "8443"
+			Ω(config.Validate()).Should(Succeed())
+		})
+
+		It("requires tls for webdav servers exposed outside the cluster", func() {
+			config.ServiceKeyConfiguration = brokerconfig.ServiceKeyConfiguration{
+				WebDAV: brokerconfig.AccessServerConfiguration{Image: "eirini/persi-broker"},
+			}
+			Ω(config.Validate()).Should(Succeed())
+
+			config.ServiceKeyConfiguration.ServiceType = "LoadBalancer"
+			Ω(config.Validate()).Should(MatchError(ContainSubstring("webdav tls secret required for service type LoadBalancer")))
+
+			config.ServiceKeyConfiguration.WebDAV.TLSSecret = "persi-webdav-tls"
+			Ω(config.Validate()).Should(Succeed())
+		})
+	})
 })
@@ -0,0 +1,234 @@
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/files"
+)
+
+// FileBrowserLabel is set on helper pods to the ID of the instance they serve
+const FileBrowserLabel = "persi-file-browser-for"
+
+const (
+	filesPort     = 8080
+	filesMount    = "/data"
+	filesTokenEnv = "PERSI_FILES_TOKEN"
+
+	defaultFilesTimeout   = 15 * time.Minute
+	defaultStartupTimeout = time.Minute
+	startupPollInterval   = time.Second
+)
+
+// defaultFilesCommand runs persi-files from the broker image
+var defaultFilesCommand = []string{"/bin/persi-files"}
+
+// Browser runs the helper pods that serve the files of instances to the
+// dashboard. Helper pods mount the volume of their instance, so volumes that
+// are ReadWriteOnce can only be browsed while no app on another node mounts
+// them. Pods stop after the configured timeout and are replaced on the next
+// visit.
+type Browser struct {
+	KubeClient kubernetes.Interface
+	Namespace  string
+	Config     config.FileBrowserConfiguration
+	Logger     lager.Logger
+}
+
+// Files returns a client for the files of an instance, starting a helper pod
+// if none is running
+func (b *Browser) Files(ctx context.Context, instanceID string) (*files.Client, error) {
+	pod, err := b.runningPod(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var token string
+	for _, env := range pod.Spec.Containers[0].Env {
+		if env.Name == filesTokenEnv {
+			token = env.Value
+		}
+	}
+
+	return &files.Client{
+		BaseURL: "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(filesPort)),
+		Token:   token,
+	}, nil
+}
+
+// runningPod returns a ready helper pod for the instance. Stopped pods are
+// deleted.
+func (b *Browser) runningPod(ctx context.Context, instanceID string) (*corev1.Pod, error) {
+	pods := b.KubeClient.CoreV1().Pods(b.Namespace)
+
+	list, err := pods.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{FileBrowserLabel: instanceID}).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing helper pods")
+	}
+
+	var pod *corev1.Pod
+	for i := range list.Items {
+		candidate := &list.Items[i]
+		switch {
+		case stopped(candidate):
+			if err := pods.Delete(ctx, candidate.Name, metav1.DeleteOptions{}); err != nil {
+				b.Logger.Error("delete-stopped-pod", err, lager.Data{"pod": candidate.Name})
+			}
+		case pod == nil || ready(candidate):
+			pod = candidate
+		}
+	}
+
+	if pod == nil {
+		pod, err = b.startPod(ctx, instanceID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.waitUntilReady(ctx, pod)
+}
+
+// startPod creates a helper pod mounting the volume of an instance. The pod
+// belongs to the claim, so it is removed together with the instance.
+func (b *Browser) startPod(ctx context.Context, instanceID string) (*corev1.Pod, error) {
+	pvc, err := broker.FindClaim(ctx, b.KubeClient.CoreV1().PersistentVolumeClaims(b.Namespace), instanceID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting persistent volume claim")
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	suffix, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := b.Config.Timeout
+	if timeout == 0 {
+		timeout = defaultFilesTimeout
+	}
+	deadline := int64(timeout.Seconds())
+	command := b.Config.Command
+	if len(command) == 0 {
+		command = defaultFilesCommand
+	}
+	automountToken := false
+
+	pod, err := b.KubeClient.CoreV1().Pods(b.Namespace).Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   fmt.Sprintf("persi-files-%s-%s", instanceID, suffix[:8]),
+			Labels: map[string]string{FileBrowserLabel: instanceID},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "PersistentVolumeClaim",
+				Name:       pvc.Name,
+				UID:        pvc.UID,
+			}},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:                corev1.RestartPolicyNever,
+			ActiveDeadlineSeconds:        &deadline,
+			AutomountServiceAccountToken: &automountToken,
+			Containers: []corev1.Container{{
+				Name:    "files",
+				Image:   b.Config.Image,
+				Command: command,
+				Args:    []string{"-root", filesMount, "-listen", ":" + strconv.Itoa(filesPort)},
+				Env:     []corev1.EnvVar{{Name: filesTokenEnv, Value: token}},
+				Ports:   []corev1.ContainerPort{{ContainerPort: filesPort}},
+				ReadinessProbe: &corev1.Probe{
+					Handler: corev1.Handler{
+						TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(filesPort)},
+					},
+				},
+				VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: filesMount}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.Name},
+				},
+			}},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating helper pod")
+	}
+
+	b.Logger.Info("started-helper-pod", lager.Data{"instance-id": instanceID, "pod": pod.Name})
+
+	return pod, nil
+}
+
+// waitUntilReady polls pod until it serves files, fails or the startup
+// timeout passes
+func (b *Browser) waitUntilReady(ctx context.Context, pod *corev1.Pod) (*corev1.Pod, error) {
+	startupTimeout := b.Config.StartupTimeout
+	if startupTimeout == 0 {
+		startupTimeout = defaultStartupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, startupTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(startupPollInterval)
+	defer ticker.Stop()
+
+	for {
+		switch {
+		case ready(pod):
+			return pod, nil
+		case stopped(pod):
+			return nil, errors.Errorf("helper pod %s stopped", pod.Name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Errorf("helper pod %s didn't start within %s", pod.Name, startupTimeout)
+		case <-ticker.C:
+		}
+
+		var err error
+		pod, err = b.KubeClient.CoreV1().Pods(b.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting helper pod")
+		}
+	}
+}
+
+func ready(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+func stopped(pod *corev1.Pod) bool {
+	return pod.DeletionTimestamp != nil ||
+		pod.Status.Phase == corev1.PodSucceeded ||
+		pod.Status.Phase == corev1.PodFailed
+}
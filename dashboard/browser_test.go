@@ -0,0 +1,124 @@
+package dashboard_test
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/dashboard"
+)
+
+var _ = Describe("Browser", func() {
+	var (
+		objects    []runtime.Object
+		kubeClient *fake.Clientset
+		browser    *dashboard.Browser
+	)
+
+	helperPod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "eirini",
+				Labels:    map[string]string{dashboard.FileBrowserLabel: "instance-id"},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name: "files",
+					Env:  []corev1.EnvVar{{Name: "PERSI_FILES_TOKEN", Value: "token"}},
+				}},
+			},
+			Status: corev1.PodStatus{
+				Phase:      phase,
+				PodIP:      "10.0.0.5",
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	listPods := func() []corev1.Pod {
+		pods, err := kubeClient.CoreV1().Pods("eirini").List(context.Background(), metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pods.Items
+	}
+
+	BeforeEach(func() {
+		objects = []runtime.Object{
+			&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "instance-id", Namespace: "eirini", UID: types.UID("claim-uid")},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset(objects...)
+		browser = &dashboard.Browser{
+			KubeClient: kubeClient,
+			Namespace:  "eirini",
+			Config: config.FileBrowserConfiguration{
+				Enabled:        true,
+				Image:          "eirini/persi-broker",
+				Timeout:        10 * time.Minute,
+				StartupTimeout: 10 * time.Millisecond,
+			},
+			Logger: lagertest.NewTestLogger("browser"),
+		}
+	})
+
+	It("starts a helper pod mounting the volume", func() {
+		_, err := browser.Files(context.Background(), "instance-id")
+		Expect(err).To(MatchError(ContainSubstring("didn't start within 10ms")))
+
+		pods := listPods()
+		Expect(pods).To(HaveLen(1))
+		pod := pods[0]
+		Expect(pod.Labels).To(HaveKeyWithValue(dashboard.FileBrowserLabel, "instance-id"))
+		Expect(pod.OwnerReferences).To(HaveLen(1))
+		Expect(pod.OwnerReferences[0].UID).To(Equal(types.UID("claim-uid")))
+		Expect(*pod.Spec.ActiveDeadlineSeconds).To(Equal(int64(600)))
+		Expect(pod.Spec.RestartPolicy).To(Equal(corev1.RestartPolicyNever))
+		Expect(pod.Spec.Containers[0].Image).To(Equal("eirini/persi-broker"))
+		Expect(pod.Spec.Containers[0].Command).To(Equal([]string{"/bin/persi-files"}))
+		Expect(pod.Spec.Containers[0].Env[0].Value).To(HaveLen(32))
+		Expect(pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("instance-id"))
+	})
+
+	Context("when a helper pod is running", func() {
+		BeforeEach(func() {
+			objects = append(objects, helperPod("persi-files-instance-id-running", corev1.PodRunning))
+		})
+
+		It("returns a client for it", func() {
+			client, err := browser.Files(context.Background(), "instance-id")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(client.BaseURL).To(Equal("http://10.0.0.5:8080"))
+			Expect(client.Token).To(Equal("token"))
+			Expect(listPods()).To(HaveLen(1))
+		})
+	})
+
+	Context("when the helper pod stopped", func() {
+		BeforeEach(func() {
+			objects = append(objects, helperPod("persi-files-instance-id-stopped", corev1.PodFailed))
+		})
+
+		It("replaces it", func() {
+			_, err := browser.Files(context.Background(), "instance-id")
+			Expect(err).To(HaveOccurred())
+
+			pods := listPods()
+			Expect(pods).To(HaveLen(1))
+			Expect(pods[0].Name).NotTo(Equal("persi-files-instance-id-stopped"))
+		})
+	})
+})
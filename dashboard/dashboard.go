@@ -0,0 +1,289 @@
+package dashboard
+
+import (
+	"crypto/subtle"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"code.cloudfoundry.org/eirini-persi-broker/admin"
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/files"
+)
+
+// instancePath is the route of the dashboard of an instance
+const instancePath = broker.DashboardInstancesPath + "{instance_id}"
+
+// maxFormFieldSize limits the form fields of uploads other than the file
+const maxFormFieldSize = 4096
+
+// Dashboard serves a page per instance showing its state to the Cloud
+// Foundry users that can see the instance. If Browser is set, users that can
+// manage the instance may also browse, upload and download its files.
+type Dashboard struct {
+	Admin      *admin.Admin
+	Browser    *Browser
+	Config     config.DashboardConfiguration
+	HTTPClient *http.Client
+	Logger     lager.Logger
+
+	cookies *cookieCodec
+}
+
+// visit is an authorized request for the dashboard of an instance
+type visit struct {
+	instanceID  string
+	session     session
+	permissions Permissions
+}
+
+// Handler returns the handler of all dashboard routes
+func (d *Dashboard) Handler() (http.Handler, error) {
+	secret := d.Config.SessionSecret
+	if secret == "" {
+		secret = d.Config.ClientSecret
+	}
+
+	var err error
+	d.cookies, err = newCookieCodec(secret, isSecure(d.Config.URL))
+	if err != nil {
+		return nil, err
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(broker.DashboardCallbackPath, d.callback).Methods(http.MethodGet)
+	router.HandleFunc(instancePath, d.authorized(canRead, d.show)).Methods(http.MethodGet)
+	if d.Browser != nil {
+		router.HandleFunc(instancePath+"/files", d.authorized(canManage, d.listFiles)).Methods(http.MethodGet)
+		router.HandleFunc(instancePath+"/files/download", d.authorized(canManage, d.download)).Methods(http.MethodGet)
+		router.HandleFunc(instancePath+"/files/upload", d.authorized(canManage, d.upload)).Methods(http.MethodPost)
+	}
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d.renderError(w, http.StatusNotFound, "This page doesn't exist.")
+	})
+
+	return router, nil
+}
+
+// authorized only passes requests of signed in users with the permissions
+// allowed requires on the instance to handler. Others are sent to sign in or
+// refused.
+func (d *Dashboard) authorized(allowed func(Permissions) bool, handler func(http.ResponseWriter, *http.Request, visit)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var s session
+		if !d.cookies.get(req, sessionCookie, &s) || time.Now().After(s.Expiry) {
+			d.signIn(w, req)
+			return
+		}
+
+		instanceID := mux.Vars(req)["instance_id"]
+		permissions, err := d.permissions(req.Context(), s.AccessToken, instanceID)
+		if err == errSessionRejected {
+			d.cookies.clear(w, sessionCookie)
+			d.signIn(w, req)
+			return
+		}
+		if err != nil {
+			d.Logger.Error("get-permissions", err, lager.Data{"instance-id": instanceID})
+			d.renderError(w, http.StatusBadGateway, "Your permissions couldn't be checked, please try again later.")
+			return
+		}
+		if !allowed(permissions) {
+			d.renderError(w, http.StatusForbidden, "You aren't allowed to do this with service instance "+instanceID+".")
+			return
+		}
+
+		handler(w, req, visit{instanceID: instanceID, session: s, permissions: permissions})
+	}
+}
+
+func (d *Dashboard) show(w http.ResponseWriter, req *http.Request, v visit) {
+	instance, err := d.Admin.Get(req.Context(), v.instanceID)
+	if err != nil {
+		d.respondError(w, "show", err)
+		return
+	}
+
+	// The page is still shown if snapshots can't be listed
+	snapshots, err := d.Admin.Broker.Snapshots(req.Context(), instance.ClaimName)
+	if err != nil {
+		d.Logger.Error("list-snapshots", err, lager.Data{"instance-id": v.instanceID})
+	}
+
+	d.render(w, http.StatusOK, instanceTemplate, instancePage{
+		Instance:     instance,
+		Snapshots:    snapshots,
+		FilesEnabled: d.Browser != nil && v.permissions.Manage,
+	})
+}
+
+func (d *Dashboard) listFiles(w http.ResponseWriter, req *http.Request, v visit) {
+	dir := cleanPath(req.URL.Query().Get("path"))
+
+	client, err := d.Browser.Files(req.Context(), v.instanceID)
+	if err != nil {
+		d.respondError(w, "list-files", err)
+		return
+	}
+
+	entries, err := client.List(req.Context(), dir)
+	if err != nil {
+		d.respondError(w, "list-files", err)
+		return
+	}
+
+	d.render(w, http.StatusOK, filesTemplate, filesPage{
+		InstanceID: v.instanceID,
+		Path:       dir,
+		Parent:     parentPath(dir),
+		Entries:    entries,
+		CSRFToken:  v.session.CSRFToken,
+	})
+}
+
+func (d *Dashboard) download(w http.ResponseWriter, req *http.Request, v visit) {
+	name := cleanPath(req.URL.Query().Get("path"))
+
+	client, err := d.Browser.Files(req.Context(), v.instanceID)
+	if err != nil {
+		d.respondError(w, "download", err)
+		return
+	}
+
+	response, err := client.Download(req.Context(), name)
+	if err != nil {
+		d.respondError(w, "download", err)
+		return
+	}
+	defer response.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Content-Length", "Last-Modified"} {
+		if value := response.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+
+	if _, err := io.Copy(w, response.Body); err != nil {
+		d.Logger.Error("download", err, lager.Data{"instance-id": v.instanceID})
+	}
+}
+
+// upload streams a file from a multipart form to the volume. The CSRF token
+// and the directory precede the file in the form, so the file doesn't have to
+// be buffered.
+func (d *Dashboard) upload(w http.ResponseWriter, req *http.Request, v visit) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		d.renderError(w, http.StatusBadRequest, "The upload isn't a form.")
+		return
+	}
+
+	var csrfToken, dir string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			d.renderError(w, http.StatusBadRequest, "The upload doesn't contain a file.")
+			return
+		}
+		if err != nil {
+			d.renderError(w, http.StatusBadRequest, "The upload couldn't be read.")
+			return
+		}
+
+		if part.FormName() != "file" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFormFieldSize))
+			if err != nil {
+				d.renderError(w, http.StatusBadRequest, "The upload couldn't be read.")
+				return
+			}
+			switch part.FormName() {
+			case "csrf_token":
+				csrfToken = string(value)
+			case "path":
+				dir = cleanPath(string(value))
+			}
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(csrfToken), []byte(v.session.CSRFToken)) != 1 {
+			d.renderError(w, http.StatusForbidden, "The upload didn't come from the dashboard.")
+			return
+		}
+		if part.FileName() == "" {
+			d.renderError(w, http.StatusBadRequest, "The upload doesn't contain a file.")
+			return
+		}
+
+		client, err := d.Browser.Files(req.Context(), v.instanceID)
+		if err != nil {
+			d.respondError(w, "upload", err)
+			return
+		}
+
+		if err := client.Upload(req.Context(), path.Join(dir, path.Base(part.FileName())), part); err != nil {
+			d.respondError(w, "upload", err)
+			return
+		}
+
+		d.Logger.Info("uploaded", lager.Data{"instance-id": v.instanceID, "path": path.Join(dir, path.Base(part.FileName()))})
+		http.Redirect(w, req, filesURL(v.instanceID, dir), http.StatusSeeOther)
+		return
+	}
+}
+
+// respondError renders the page for err, logging unexpected errors
+func (d *Dashboard) respondError(w http.ResponseWriter, action string, err error) {
+	if adminErr, ok := errors.Cause(err).(*admin.Error); ok {
+		d.renderError(w, adminErr.StatusCode, adminErr.Message)
+		return
+	}
+
+	if filesErr, ok := errors.Cause(err).(*files.StatusError); ok {
+		switch filesErr.StatusCode {
+		case http.StatusNotFound:
+			d.renderError(w, http.StatusNotFound, "The file or directory doesn't exist.")
+			return
+		case http.StatusForbidden:
+			d.renderError(w, http.StatusForbidden, "The file or directory can't be accessed.")
+			return
+		case http.StatusBadRequest:
+			d.renderError(w, http.StatusBadRequest, filesErr.Message)
+			return
+		}
+	}
+
+	d.fail(w, action, err)
+}
+
+// fail logs an unexpected error and renders a generic error page
+func (d *Dashboard) fail(w http.ResponseWriter, action string, err error) {
+	d.Logger.Error(action, err)
+	d.renderError(w, http.StatusInternalServerError, "Something went wrong, please try again later.")
+}
+
+// cleanPath returns path as absolute slash separated path
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// parentPath returns the parent of dir, or an empty string for the root
+func parentPath(dir string) string {
+	if dir == "/" {
+		return ""
+	}
+	return path.Dir(dir)
+}
+
+func filesURL(instanceID, dir string) string {
+	return broker.DashboardInstancesPath + url.PathEscape(instanceID) + "/files?" + url.Values{"path": {dir}}.Encode()
+}
@@ -0,0 +1,303 @@
+package dashboard_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/admin"
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/dashboard"
+)
+
+const instanceURL = "/dashboard/instances/instance-id"
+
+var _ = Describe("Dashboard", func() {
+	var (
+		uaa             *httptest.Server
+		cloudController *httptest.Server
+		permissions     dashboard.Permissions
+		kubeClient      *fake.Clientset
+		instance        *dashboard.Dashboard
+		handler         http.Handler
+	)
+
+	request := func(method, target string, body *bytes.Buffer, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		var req *http.Request
+		if body == nil {
+			req = httptest.NewRequest(method, target, nil)
+		} else {
+			req = httptest.NewRequest(method, target, body)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	cookie := func(recorder *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, cookie := range recorder.Result().Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	signIn := func() *http.Cookie {
+		response := request(http.MethodGet, instanceURL, nil)
+		Expect(response.Code).To(Equal(http.StatusFound))
+
+		authorizeURL, err := url.Parse(response.Header().Get("Location"))
+		Expect(err).NotTo(HaveOccurred())
+
+		callback := "/dashboard/callback?" + url.Values{
+			"code":  {"authorization-code"},
+			"state": {authorizeURL.Query().Get("state")},
+		}.Encode()
+		response = request(http.MethodGet, callback, nil, cookie(response, "persi-dashboard-login"))
+		Expect(response.Code).To(Equal(http.StatusFound))
+		Expect(response.Header().Get("Location")).To(Equal(instanceURL))
+
+		session := cookie(response, "persi-dashboard-session")
+		Expect(session).NotTo(BeNil())
+		return session
+	}
+
+	BeforeEach(func() {
+		permissions = dashboard.Permissions{Read: true, Manage: true}
+
+		uaa = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			clientID, secret, _ := req.BasicAuth()
+			if req.URL.Path != "/oauth/token" || clientID != "persi-dashboard" || secret != "client-secret" || req.FormValue("code") != "authorization-code" {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token": "user-token", "token_type": "bearer", "expires_in": 3600}`))
+		}))
+
+		cloudController = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") != "bearer user-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if req.URL.Path != "/v2/service_instances/instance-id/permissions" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(permissions)
+		}))
+
+		storageClass := "gold"
+		kubeClient = fake.NewSimpleClientset(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "instance-id",
+				Namespace: "eirini",
+				Labels: map[string]string{
+					broker.ServiceIDLabel:      "service-id",
+					broker.PlanIDLabel:         "plan-id",
+					broker.OrganizationIDLabel: "org-id",
+					broker.SpaceIDLabel:        "space-id",
+				},
+				Annotations: map[string]string{
+					broker.InstanceNameAnnotation:         "uploads",
+					broker.SpaceNameAnnotation:            "production",
+					"eirini-broker-binding-binding":       "/var/vcap/data/uploads",
+					broker.BackupAnnotation("1760788800"): "succeeded",
+				},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClass,
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{"storage": resource.MustParse("1Gi")},
+				},
+			},
+			Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		})
+
+		dashboardConfig := config.DashboardConfiguration{
+			URL:                "https://persi-broker.example.com",
+			ClientID:           "persi-dashboard",
+			ClientSecret:       "client-secret",
+			UAAURL:             uaa.URL,
+			CloudControllerURL: cloudController.URL,
+		}
+		instance = &dashboard.Dashboard{
+			Admin: &admin.Admin{
+				Broker: &broker.KubeVolumeBroker{
+					KubeClient: kubeClient,
+					Config: config.Config{
+						Namespace: "eirini",
+						ServiceConfiguration: config.ServiceConfiguration{
+							ServiceID: "service-id",
+							Plans:     []config.Plan{{ID: "plan-id", Name: "gold"}},
+						},
+						DashboardConfiguration: dashboardConfig,
+					},
+					SnapshotClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), &unstructured.Unstructured{Object: map[string]interface{}{
+						"apiVersion": "snapshot.storage.k8s.io/v1beta1",
+						"kind":       "VolumeSnapshot",
+						"metadata":   map[string]interface{}{"name": "uploads-nightly", "namespace": "eirini"},
+						"spec": map[string]interface{}{
+							"source": map[string]interface{}{"persistentVolumeClaimName": "instance-id"},
+						},
+						"status": map[string]interface{}{"creationTime": "2026-10-18T02:00:00Z", "readyToUse": true},
+					}}),
+				},
+			},
+			Config: dashboardConfig,
+			Logger: lagertest.NewTestLogger("dashboard"),
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		handler, err = instance.Handler()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		uaa.Close()
+		cloudController.Close()
+	})
+
+	It("sends users without a session to UAA", func() {
+		response := request(http.MethodGet, instanceURL, nil)
+
+		Expect(response.Code).To(Equal(http.StatusFound))
+		location, err := url.Parse(response.Header().Get("Location"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(location.Scheme + "://" + location.Host + location.Path).To(Equal(uaa.URL + "/oauth/authorize"))
+		Expect(location.Query().Get("client_id")).To(Equal("persi-dashboard"))
+		Expect(location.Query().Get("redirect_uri")).To(Equal("https://persi-broker.example.com/dashboard/callback"))
+		Expect(location.Query().Get("scope")).To(ContainSubstring("cloud_controller_service_permissions.read"))
+		Expect(location.Query().Get("state")).NotTo(BeEmpty())
+
+		login := cookie(response, "persi-dashboard-login")
+		Expect(login.HttpOnly).To(BeTrue())
+		Expect(login.Secure).To(BeTrue())
+	})
+
+	It("shows the instance to signed in users", func() {
+		response := request(http.MethodGet, instanceURL, nil, signIn())
+
+		Expect(response.Code).To(Equal(http.StatusOK))
+		body := response.Body.String()
+		Expect(body).To(ContainSubstring("<h1>uploads</h1>"))
+		Expect(body).To(ContainSubstring("production"))
+		Expect(body).To(ContainSubstring("Bound"))
+		Expect(body).To(ContainSubstring("1Gi"))
+		Expect(body).To(ContainSubstring("/var/vcap/data/uploads"))
+		Expect(body).To(ContainSubstring("<td>1760788800</td><td>2025-10-18 12:00:00 UTC</td><td>succeeded</td>"))
+		Expect(body).To(ContainSubstring("<td>uploads-nightly</td><td>2026-10-18 02:00:00 UTC</td><td>ready</td>"))
+		Expect(body).NotTo(ContainSubstring("Browse files"))
+	})
+
+	It("refuses callbacks with the wrong state", func() {
+		response := request(http.MethodGet, instanceURL, nil)
+
+		callback := "/dashboard/callback?" + url.Values{"code": {"authorization-code"}, "state": {"forged"}}.Encode()
+		response = request(http.MethodGet, callback, nil, cookie(response, "persi-dashboard-login"))
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+		Expect(cookie(response, "persi-dashboard-session")).To(BeNil())
+	})
+
+	It("refuses forged sessions", func() {
+		session := signIn()
+		session.Value = strings.ToUpper(session.Value)
+
+		response := request(http.MethodGet, instanceURL, nil, session)
+		Expect(response.Code).To(Equal(http.StatusFound))
+	})
+
+	Context("when the user can't see the instance", func() {
+		BeforeEach(func() {
+			permissions = dashboard.Permissions{}
+		})
+
+		It("refuses to show it", func() {
+			response := request(http.MethodGet, instanceURL, nil, signIn())
+			Expect(response.Code).To(Equal(http.StatusForbidden))
+			Expect(response.Body.String()).NotTo(ContainSubstring("uploads"))
+		})
+	})
+
+	Context("when the file browser is enabled", func() {
+		BeforeEach(func() {
+			instance.Browser = &dashboard.Browser{
+				KubeClient: kubeClient,
+				Namespace:  "eirini",
+				Logger:     lagertest.NewTestLogger("browser"),
+			}
+		})
+
+		It("links to the files of the instance", func() {
+			response := request(http.MethodGet, instanceURL, nil, signIn())
+			Expect(response.Body.String()).To(ContainSubstring("Browse files"))
+		})
+
+		It("refuses uploads without the CSRF token of the session", func() {
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			Expect(form.WriteField("csrf_token", "forged")).To(Succeed())
+			Expect(form.WriteField("path", "/")).To(Succeed())
+			file, err := form.CreateFormFile("file", "evil.sh")
+			Expect(err).NotTo(HaveOccurred())
+			_, _ = file.Write([]byte("rm -rf /"))
+			Expect(form.Close()).To(Succeed())
+
+			req := httptest.NewRequest(http.MethodPost, instanceURL+"/files/upload", &body)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			req.AddCookie(signIn())
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, req)
+
+			Expect(response.Code).To(Equal(http.StatusForbidden))
+			pods, err := kubeClient.CoreV1().Pods("eirini").List(req.Context(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pods.Items).To(BeEmpty())
+		})
+
+		Context("and the user can only read the instance", func() {
+			BeforeEach(func() {
+				permissions = dashboard.Permissions{Read: true}
+			})
+
+			It("doesn't let them browse files", func() {
+				session := signIn()
+
+				response := request(http.MethodGet, instanceURL, nil, session)
+				Expect(response.Body.String()).NotTo(ContainSubstring("Browse files"))
+
+				response = request(http.MethodGet, instanceURL+"/files?path=/", nil, session)
+				Expect(response.Code).To(Equal(http.StatusForbidden))
+			})
+		})
+	})
+
+	It("doesn't serve files without the file browser", func() {
+		response := request(http.MethodGet, instanceURL+"/files?path=/", nil, signIn())
+		Expect(response.Code).To(Equal(http.StatusNotFound))
+	})
+})
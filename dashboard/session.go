@@ -0,0 +1,141 @@
+package dashboard
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Cookies set by the dashboard
+const (
+	sessionCookie = "persi-dashboard-session"
+	loginCookie   = "persi-dashboard-login"
+)
+
+// loginTTL is how long users have to sign in with UAA
+const loginTTL = 10 * time.Minute
+
+// session is what the dashboard knows about a signed in user. The access
+// token is only used to ask the Cloud Controller for the user's permissions.
+type session struct {
+	AccessToken string    `json:"access_token"`
+	Expiry      time.Time `json:"expiry"`
+	CSRFToken   string    `json:"csrf_token"`
+}
+
+// login is the state of a sign in with UAA
+type login struct {
+	State    string `json:"state"`
+	ReturnTo string `json:"return_to"`
+}
+
+// cookieCodec encrypts and authenticates cookie values, so users can neither
+// read the access token in their session nor forge one
+type cookieCodec struct {
+	aead   cipher.AEAD
+	secure bool
+}
+
+func newCookieCodec(secret string, secure bool) (*cookieCodec, error) {
+	key := sha256.Sum256([]byte(secret))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating session cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating session cipher")
+	}
+
+	return &cookieCodec{aead: aead, secure: secure}, nil
+}
+
+// set stores value encrypted in the cookie name until expiry
+func (c *cookieCodec) set(w http.ResponseWriter, name string, value interface{}, expiry time.Time) error {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling cookie")
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return errors.Wrap(err, "error creating nonce")
+	}
+
+	// The cookie name is authenticated too, so values can't be swapped
+	sealed := c.aead.Seal(nonce, nonce, plaintext, []byte(name))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    base64.RawURLEncoding.EncodeToString(sealed),
+		Path:     "/dashboard",
+		Expires:  expiry,
+		Secure:   c.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// get decrypts the cookie name into value. It returns false if the cookie is
+// missing or was tampered with.
+func (c *cookieCodec) get(r *http.Request, name string, value interface{}) bool {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return false
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(plaintext, value) == nil
+}
+
+// clear removes the cookie name
+func (c *cookieCodec) clear(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     "/dashboard",
+		MaxAge:   -1,
+		Secure:   c.secure,
+		HttpOnly: true,
+	})
+}
+
+// randomToken returns a random hex token for states, CSRF protection and
+// helper pods
+func randomToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, token); err != nil {
+		return "", errors.Wrap(err, "error creating random token")
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// isSecure returns true if the dashboard URL is served over TLS, so cookies
+// must only be sent over TLS
+func isSecure(dashboardURL string) bool {
+	return strings.HasPrefix(dashboardURL, "https://")
+}
@@ -0,0 +1,179 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+)
+
+// defaultSessionTTL is how long users stay signed in unless configured
+const defaultSessionTTL = time.Hour
+
+// scopes requested from UAA. The Cloud Controller only reports permissions on
+// service instances to tokens with the permissions scope.
+var scopes = []string{"openid", "cloud_controller_service_permissions.read"}
+
+// errSessionRejected is returned when the Cloud Controller doesn't accept
+// the access token of a session anymore
+var errSessionRejected = errors.New("access token rejected by the cloud controller")
+
+// Permissions of a user on a service instance. Users that can read an
+// instance see its dashboard, users that can manage it can browse its files.
+type Permissions struct {
+	Read   bool `json:"read"`
+	Manage bool `json:"manage"`
+}
+
+func canRead(p Permissions) bool {
+	return p.Read
+}
+
+func canManage(p Permissions) bool {
+	return p.Manage
+}
+
+func (d *Dashboard) oauthConfig() *oauth2.Config {
+	uaa := strings.TrimSuffix(d.Config.UAAURL, "/")
+
+	return &oauth2.Config{
+		ClientID:     d.Config.ClientID,
+		ClientSecret: d.Config.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   uaa + "/oauth/authorize",
+			TokenURL:  uaa + "/oauth/token",
+			AuthStyle: oauth2.AuthStyleInHeader,
+		},
+		RedirectURL: strings.TrimSuffix(d.Config.URL, "/") + broker.DashboardCallbackPath,
+		Scopes:      scopes,
+	}
+}
+
+// signIn sends the user to UAA and back to the page they requested
+func (d *Dashboard) signIn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		d.renderError(w, http.StatusUnauthorized, "Your session expired, please reload the page.")
+		return
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		d.fail(w, "sign-in", err)
+		return
+	}
+
+	l := login{State: state, ReturnTo: r.URL.RequestURI()}
+	if err := d.cookies.set(w, loginCookie, l, time.Now().Add(loginTTL)); err != nil {
+		d.fail(w, "sign-in", err)
+		return
+	}
+
+	http.Redirect(w, r, d.oauthConfig().AuthCodeURL(state), http.StatusFound)
+}
+
+// callback completes signing in with UAA and starts a session
+func (d *Dashboard) callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var l login
+	if !d.cookies.get(r, loginCookie, &l) || l.State == "" || query.Get("state") != l.State {
+		d.renderError(w, http.StatusBadRequest, "Signing in took too long or was started elsewhere, please try again.")
+		return
+	}
+	d.cookies.clear(w, loginCookie)
+
+	if query.Get("error") != "" {
+		d.Logger.Info("sign-in-denied", lager.Data{"error": query.Get("error")})
+		d.renderError(w, http.StatusForbidden, "Signing in was denied.")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, d.httpClient())
+	token, err := d.oauthConfig().Exchange(ctx, query.Get("code"))
+	if err != nil {
+		d.Logger.Error("exchange-code", err)
+		d.renderError(w, http.StatusBadGateway, "Signing in with UAA failed, please try again.")
+		return
+	}
+
+	csrfToken, err := randomToken()
+	if err != nil {
+		d.fail(w, "callback", err)
+		return
+	}
+
+	ttl := d.Config.SessionTTL
+	if ttl == 0 {
+		ttl = defaultSessionTTL
+	}
+	expiry := time.Now().Add(ttl)
+	if !token.Expiry.IsZero() && token.Expiry.Before(expiry) {
+		expiry = token.Expiry
+	}
+
+	s := session{AccessToken: token.AccessToken, Expiry: expiry, CSRFToken: csrfToken}
+	if err := d.cookies.set(w, sessionCookie, s, expiry); err != nil {
+		d.fail(w, "callback", err)
+		return
+	}
+
+	returnTo := l.ReturnTo
+	if !strings.HasPrefix(returnTo, broker.DashboardInstancesPath) {
+		returnTo = broker.DashboardInstancesPath
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// permissions asks the Cloud Controller what the user of accessToken may do
+// with an instance. Instances the Cloud Controller doesn't know grant nothing.
+func (d *Dashboard) permissions(ctx context.Context, accessToken, instanceID string) (Permissions, error) {
+	permissionsURL := strings.TrimSuffix(d.Config.CloudControllerURL, "/") + "/v2/service_instances/" + url.PathEscape(instanceID) + "/permissions"
+
+	request, err := http.NewRequest(http.MethodGet, permissionsURL, nil)
+	if err != nil {
+		return Permissions{}, errors.Wrap(err, "error creating permissions request")
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Authorization", "bearer "+accessToken)
+
+	response, err := d.httpClient().Do(request)
+	if err != nil {
+		return Permissions{}, errors.Wrap(err, "error getting permissions from the cloud controller")
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return Permissions{}, errSessionRejected
+	case http.StatusForbidden, http.StatusNotFound:
+		return Permissions{}, nil
+	default:
+		return Permissions{}, errors.Errorf("cloud controller responded with %d to permissions request", response.StatusCode)
+	}
+
+	var permissions Permissions
+	if err := json.NewDecoder(response.Body).Decode(&permissions); err != nil {
+		return Permissions{}, errors.Wrap(err, "error decoding permissions")
+	}
+
+	// Older Cloud Controllers only report whether users can manage instances
+	permissions.Read = permissions.Read || permissions.Manage
+
+	return permissions, nil
+}
+
+func (d *Dashboard) httpClient() *http.Client {
+	if d.HTTPClient != nil {
+		return d.HTTPClient
+	}
+	return http.DefaultClient
+}
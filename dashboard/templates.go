@@ -0,0 +1,168 @@
+package dashboard
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+
+	"code.cloudfoundry.org/eirini-persi-broker/admin"
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/files"
+)
+
+// instancePage is rendered as the dashboard of an instance
+type instancePage struct {
+	Instance     admin.Instance
+	Snapshots    []broker.Snapshot
+	FilesEnabled bool
+}
+
+// filesPage is rendered for a directory of an instance's volume
+type filesPage struct {
+	InstanceID string
+	Path       string
+	Parent     string
+	Entries    []files.Entry
+	CSRFToken  string
+}
+
+// errorPage is rendered for failed requests
+type errorPage struct {
+	Status  string
+	Message string
+}
+
+var templateFuncs = template.FuncMap{
+	"bytes": formatBytes,
+	"instanceURL": func(instanceID string) string {
+		return broker.DashboardInstancesPath + url.PathEscape(instanceID)
+	},
+	"filesURL": filesURL,
+	"downloadURL": func(instanceID, name string) string {
+		return broker.DashboardInstancesPath + url.PathEscape(instanceID) + "/files/download?" + url.Values{"path": {name}}.Encode()
+	},
+	"join": path.Join,
+}
+
+const layout = `{{define "layout"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{template "title" .}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; }
+th { width: 12em; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+{{template "content" .}}
+</body>
+</html>{{end}}`
+
+var instanceTemplate = template.Must(template.New("instance").Funcs(templateFuncs).Parse(layout + `
+{{define "title"}}{{with .Instance}}{{if .Name}}{{.Name}}{{else}}{{.ID}}{{end}}{{end}}{{end}}
+{{define "content"}}{{with .Instance}}
+<h1>{{if .Name}}{{.Name}}{{else}}{{.ID}}{{end}}</h1>
+<table>
+<tr><th>Instance</th><td>{{.ID}}</td></tr>
+<tr><th>Plan</th><td>{{if .PlanName}}{{.PlanName}}{{else}}{{.PlanID}}{{end}}</td></tr>
+<tr><th>Organization</th><td>{{if .OrganizationName}}{{.OrganizationName}} {{end}}<span class="muted">{{.OrganizationID}}</span></td></tr>
+<tr><th>Space</th><td>{{if .SpaceName}}{{.SpaceName}} {{end}}<span class="muted">{{.SpaceID}}</span></td></tr>
+<tr><th>Status</th><td>{{.Phase}}{{if .DeletedAt}}, deleted at {{.DeletedAt.Format "2006-01-02 15:04:05 MST"}}{{end}}</td></tr>
+<tr><th>Size</th><td>{{.Size}}{{if .Capacity}} <span class="muted">({{.Capacity}} provisioned)</span>{{end}}</td></tr>
+<tr><th>Access mode</th><td>{{.AccessMode}}</td></tr>
+{{with .Usage}}<tr><th>Usage</th><td>{{bytes .UsedBytes}} used, {{bytes .AvailableBytes}} available</td></tr>{{end}}
+<tr><th>Created</th><td>{{.CreatedAt.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+</table>
+<h2>Bindings</h2>
+{{if .Bindings}}<table>
+<tr><th>Binding</th><th>Directory</th><th>Space</th></tr>
+{{range .Bindings}}<tr><td>{{.ID}}</td><td>{{.Directory}}</td><td>{{if .Shared}}{{.SpaceID}}{{else}}<span class="muted">same space</span>{{end}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">The instance isn't bound to any app.</p>{{end}}
+<h2>Backups</h2>
+{{if .Backups}}<table>
+<tr><th>Backup</th><th>Started</th><th>State</th></tr>
+{{range .Backups}}<tr><td>{{.ID}}</td><td>{{.Time.Format "2006-01-02 15:04:05 MST"}}</td><td>{{.State}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">The instance hasn't been backed up.</p>{{end}}
+{{end}}
+<h2>Snapshots</h2>
+{{if .Snapshots}}<table>
+<tr><th>Snapshot</th><th>Taken</th><th>State</th></tr>
+{{range .Snapshots}}<tr><td>{{.Name}}</td><td>{{.Time.Format "2006-01-02 15:04:05 MST"}}</td><td>{{if .Ready}}ready{{else}}<span class="muted">not ready</span>{{end}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">The instance has no volume snapshots.</p>{{end}}
+{{if .FilesEnabled}}<p><a href="{{filesURL .Instance.ID "/"}}">Browse files</a></p>{{end}}
+{{end}}`))
+
+var filesTemplate = template.Must(template.New("files").Funcs(templateFuncs).Parse(layout + `
+{{define "title"}}{{.Path}}{{end}}
+{{define "content"}}
+<p><a href="{{instanceURL .InstanceID}}">Instance {{.InstanceID}}</a></p>
+<h1>{{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if .Parent}}<tr><td><a href="{{filesURL .InstanceID .Parent}}">..</a></td><td></td><td></td></tr>{{end}}
+{{$instanceID := .InstanceID}}{{$path := .Path}}
+{{range .Entries}}<tr>
+<td>{{if .Dir}}<a href="{{filesURL $instanceID (join $path .Name)}}">{{.Name}}/</a>{{else}}<a href="{{downloadURL $instanceID (join $path .Name)}}">{{.Name}}</a>{{end}}</td>
+<td>{{if not .Dir}}{{bytes .Size}}{{end}}</td>
+<td>{{.ModTime.Format "2006-01-02 15:04:05 MST"}}</td>
+</tr>
+{{end}}</table>
+<h2>Upload</h2>
+<form method="post" action="{{instanceURL .InstanceID}}/files/upload" enctype="multipart/form-data">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="path" value="{{.Path}}">
+<input type="file" name="file" required>
+<button type="submit">Upload to {{.Path}}</button>
+</form>
+{{end}}`))
+
+var errorTemplate = template.Must(template.New("error").Funcs(templateFuncs).Parse(layout + `
+{{define "title"}}{{.Status}}{{end}}
+{{define "content"}}
+<h1>{{.Status}}</h1>
+<p>{{.Message}}</p>
+{{end}}`))
+
+// render writes a page. Pages are rendered into a buffer first, so template
+// errors don't leave half a page behind.
+func (d *Dashboard) render(w http.ResponseWriter, status int, tmpl *template.Template, page interface{}) {
+	var body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&body, "layout", page); err != nil {
+		d.Logger.Error("render", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	_, _ = body.WriteTo(w)
+}
+
+func (d *Dashboard) renderError(w http.ResponseWriter, status int, message string) {
+	d.render(w, status, errorTemplate, errorPage{Status: http.StatusText(status), Message: message})
+}
+
+// formatBytes returns a byte count in binary units
+func formatBytes(count int64) string {
+	const unit = 1024
+	if count < unit {
+		return fmt.Sprintf("%d B", count)
+	}
+
+	div, exp := int64(unit), 0
+	for n := count / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(count)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,90 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Client accesses the files served by a Server at BaseURL
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// StatusError is returned when the server responds with an error
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("file server responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// List returns the entries of the directory at path
+func (c *Client) List(ctx context.Context, path string) ([]Entry, error) {
+	response, err := c.do(ctx, http.MethodGet, "/list", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var entries []Entry
+	if err := json.NewDecoder(response.Body).Decode(&entries); err != nil {
+		return nil, errors.Wrap(err, "error decoding listing")
+	}
+
+	return entries, nil
+}
+
+// Download returns the response serving the file at path. The caller must
+// close its body.
+func (c *Client) Download(ctx context.Context, path string) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, "/download", path, nil)
+}
+
+// Upload writes content to the file at path
+func (c *Client) Upload(ctx context.Context, path string, content io.Reader) error {
+	response, err := c.do(ctx, http.MethodPut, "/upload", path, content)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+func (c *Client) do(ctx context.Context, method, endpoint, path string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequest(method, c.BaseURL+endpoint+"?"+url.Values{"path": {path}}.Encode(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set(TokenHeader, c.Token)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "error calling file server")
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		defer response.Body.Close()
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return nil, &StatusError{StatusCode: response.StatusCode, Message: string(bytes.TrimSpace(message))}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,215 @@
+package files
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+)
+
+// TokenHeader carries the token clients authenticate with
+const TokenHeader = "X-Persi-Files-Token"
+
+// Entry is a file or directory in a listing
+type Entry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Server serves the files below Root to clients presenting Token. Paths are
+// slash separated and relative to Root; they can't leave it, not even
+// through symbolic links.
+type Server struct {
+	Root   string
+	Token  string
+	Logger lager.Logger
+}
+
+// Handler returns the handler serving the list, download and upload endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/list", s.authenticated(http.MethodGet, s.list))
+	mux.HandleFunc("/download", s.authenticated(http.MethodGet, s.download))
+	mux.HandleFunc("/upload", s.authenticated(http.MethodPut, s.upload))
+	return mux
+}
+
+func (s *Server) authenticated(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(TokenHeader)), []byte(s.Token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	dir, err := s.resolve(r.URL.Query().Get("path"))
+	if err != nil {
+		s.respondError(w, "list", err)
+		return
+	}
+
+	infos, err := readDir(dir)
+	if err != nil {
+		s.respondError(w, "list", err)
+		return
+	}
+
+	entries := make([]Entry, len(infos))
+	for i, info := range infos {
+		entries[i] = Entry{
+			Name:    info.Name(),
+			Dir:     info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	name, err := s.resolve(r.URL.Query().Get("path"))
+	if err != nil {
+		s.respondError(w, "download", err)
+		return
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		s.respondError(w, "download", err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		s.respondError(w, "download", err)
+		return
+	}
+	if info.IsDir() {
+		http.Error(w, "can't download a directory", http.StatusBadRequest)
+		return
+	}
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// upload writes the request body to a file. The file is written next to its
+// destination first and then renamed, so a failed upload leaves the existing
+// file untouched.
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	relative := r.URL.Query().Get("path")
+	if path.Clean("/"+relative) == "/" {
+		http.Error(w, "can't upload to the root directory", http.StatusBadRequest)
+		return
+	}
+
+	name, err := s.resolve(relative)
+	if err != nil {
+		s.respondError(w, "upload", err)
+		return
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".upload-")
+	if err != nil {
+		s.respondError(w, "upload", err)
+		return
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = io.Copy(temp, r.Body)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), name)
+	}
+	if err != nil {
+		s.respondError(w, "upload", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// resolve returns the file name of a path relative to the root
+func (s *Server) resolve(relative string) (string, error) {
+	return resolve(s.Root, relative)
+}
+
+// resolve returns the file name of a path relative to rootDir. Paths
+// leading out of rootDir, also through symbolic links, aren't permitted.
+func resolve(rootDir, relative string) (string, error) {
+	root, err := filepath.EvalSymlinks(rootDir)
+	if err != nil {
+		return "", errors.Wrap(err, "error resolving root directory")
+	}
+
+	name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+relative)))
+
+	// Files that don't exist yet are resolved through their directory
+	resolved, err := filepath.EvalSymlinks(name)
+	if os.IsNotExist(err) {
+		dir, dirErr := filepath.EvalSymlinks(filepath.Dir(name))
+		if dirErr != nil {
+			return "", dirErr
+		}
+		resolved, err = filepath.Join(dir, filepath.Base(name)), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", os.ErrPermission
+	}
+
+	return resolved, nil
+}
+
+func (s *Server) respondError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case os.IsNotExist(errors.Cause(err)):
+		http.Error(w, "not found", http.StatusNotFound)
+	case os.IsPermission(errors.Cause(err)):
+		http.Error(w, "permission denied", http.StatusForbidden)
+	default:
+		s.Logger.Error(action, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// readDir returns the entries of dir, directories first and sorted by name
+func readDir(dir string) ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].IsDir() && !infos[j].IsDir()
+	})
+
+	return infos, nil
+}
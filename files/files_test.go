@@ -0,0 +1,192 @@
+package files_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/eirini-persi-broker/files"
+)
+
+var _ = Describe("Files", func() {
+	var (
+		root       string
+		httpServer *httptest.Server
+		client     *files.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "files")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.Mkdir(filepath.Join(root, "logs"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(root, "config.yml"), []byte("debug: true\n"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(root, "logs", "app.log"), []byte("started\n"), 0644)).To(Succeed())
+
+		server := &files.Server{
+			Root:   root,
+			Token:  "token",
+			Logger: lagertest.NewTestLogger("files"),
+		}
+		httpServer = httptest.NewServer(server.Handler())
+		client = &files.Client{BaseURL: httpServer.URL, Token: "token"}
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	statusCode := func(err error) int {
+		Expect(err).To(BeAssignableToTypeOf(&files.StatusError{}))
+		return err.(*files.StatusError).StatusCode
+	}
+
+	It("lists directories first", func() {
+		entries, err := client.List(context.Background(), "/")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Name).To(Equal("logs"))
+		Expect(entries[0].Dir).To(BeTrue())
+		Expect(entries[1].Name).To(Equal("config.yml"))
+		Expect(entries[1].Size).To(Equal(int64(12)))
+	})
+
+	It("lists subdirectories", func() {
+		entries, err := client.List(context.Background(), "logs")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name).To(Equal("app.log"))
+	})
+
+	It("downloads files", func() {
+		response, err := client.Download(context.Background(), "/logs/app.log")
+		Expect(err).NotTo(HaveOccurred())
+		defer response.Body.Close()
+
+		content, err := ioutil.ReadAll(response.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("started\n"))
+	})
+
+	It("uploads files", func() {
+		Expect(client.Upload(context.Background(), "/logs/new.log", strings.NewReader("hello"))).To(Succeed())
+
+		content, err := ioutil.ReadFile(filepath.Join(root, "logs", "new.log"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("hello"))
+	})
+
+	It("replaces existing files", func() {
+		Expect(client.Upload(context.Background(), "config.yml", strings.NewReader("debug: false\n"))).To(Succeed())
+
+		content, err := ioutil.ReadFile(filepath.Join(root, "config.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("debug: false\n"))
+
+		entries, err := client.List(context.Background(), "/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+	})
+
+	It("keeps paths inside the root", func() {
+		entries, err := client.List(context.Background(), "../../logs")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries[0].Name).To(Equal("app.log"))
+	})
+
+	It("doesn't follow symbolic links out of the root", func() {
+		Expect(os.Symlink("/etc", filepath.Join(root, "etc"))).To(Succeed())
+
+		_, err := client.List(context.Background(), "etc")
+		Expect(statusCode(err)).To(Equal(http.StatusForbidden))
+
+		err = client.Upload(context.Background(), "etc/passwd", strings.NewReader("root"))
+		Expect(statusCode(err)).To(Equal(http.StatusForbidden))
+	})
+
+	It("reports missing files", func() {
+		_, err := client.Download(context.Background(), "missing")
+		Expect(statusCode(err)).To(Equal(http.StatusNotFound))
+	})
+
+	It("refuses clients without the token", func() {
+		client.Token = "wrong"
+
+		_, err := client.List(context.Background(), "/")
+		Expect(statusCode(err)).To(Equal(http.StatusUnauthorized))
+	})
+})
+
+var _ = Describe("WebDAV", func() {
+	var (
+		root       string
+		httpServer *httptest.Server
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "webdav")
+		Expect(err).NotTo(HaveOccurred())
+
+		server := &files.WebDAVServer{
+			Root:     root,
+			Username: "persi-key",
+			Password: "secret",
+			Logger:   lagertest.NewTestLogger("webdav"),
+		}
+		httpServer = httptest.NewServer(server.Handler())
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	request := func(method, name, password string, body string) *http.Response {
+		req, err := http.NewRequest(method, httpServer.URL+name, strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth("persi-key", password)
+
+		response, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		response.Body.Close()
+		return response
+	}
+
+	It("stores and serves files", func() {
+		Expect(request("MKCOL", "/backups", "secret", "").StatusCode).To(Equal(http.StatusCreated))
+		Expect(request(http.MethodPut, "/backups/db.sql", "secret", "select 1;").StatusCode).To(Equal(http.StatusCreated))
+
+		content, err := ioutil.ReadFile(filepath.Join(root, "backups", "db.sql"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("select 1;"))
+
+		Expect(request(http.MethodDelete, "/backups", "secret", "").StatusCode).To(Equal(http.StatusNoContent))
+		Expect(filepath.Join(root, "backups")).NotTo(BeADirectory())
+	})
+
+	It("doesn't follow symbolic links out of the root", func() {
+		Expect(os.Symlink("/etc", filepath.Join(root, "etc"))).To(Succeed())
+
+		// The WebDAV handler reports all files it can't stat as missing
+		Expect(request(http.MethodGet, "/etc/passwd", "secret", "").StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("refuses clients with wrong credentials", func() {
+		response := request(http.MethodGet, "/", "wrong", "")
+		Expect(response.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(response.Header.Get("WWW-Authenticate")).To(ContainSubstring("Basic"))
+	})
+})
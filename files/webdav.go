@@ -0,0 +1,112 @@
+package files
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVServer serves the files below Root over WebDAV to clients signing in
+// with Username and Password. Like Server, it doesn't let paths leave Root.
+type WebDAVServer struct {
+	Root     string
+	Username string
+	Password string
+	Logger   lager.Logger
+}
+
+// Handler returns the WebDAV handler
+func (s *WebDAVServer) Handler() http.Handler {
+	handler := &webdav.Handler{
+		FileSystem: rootFileSystem(s.Root),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				s.Logger.Info("request-failed", lager.Data{"method": r.Method, "path": r.URL.Path, "error": err.Error()})
+			}
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(s.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="persi-files"`)
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// rootFileSystem is a webdav.FileSystem that resolves names like Server
+type rootFileSystem string
+
+func (root rootFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	dir, err := root.resolveEntry(name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(dir, perm)
+}
+
+func (root rootFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	resolved, err := resolve(string(root), name)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(resolved, flag, perm)
+}
+
+func (root rootFileSystem) RemoveAll(ctx context.Context, name string) error {
+	resolved, err := root.resolveEntry(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(resolved)
+}
+
+func (root rootFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldResolved, err := root.resolveEntry(oldName)
+	if err != nil {
+		return err
+	}
+	newResolved, err := root.resolveEntry(newName)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldResolved, newResolved)
+}
+
+func (root rootFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	resolved, err := resolve(string(root), name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(resolved)
+}
+
+// resolveEntry resolves the directory of name but not name itself, so
+// symbolic links are removed and renamed rather than their targets. The root
+// itself can't be changed.
+func (root rootFileSystem) resolveEntry(name string) (string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "", os.ErrPermission
+	}
+
+	dir, err := resolve(string(root), path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, path.Base(name)), nil
+}
@@ -0,0 +1,168 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultCacheTTL is used when no cache TTL is configured
+const DefaultCacheTTL = 10 * time.Second
+
+const checkTimeout = 5 * time.Second
+
+// Result is the outcome of the readiness checks
+type Result struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Checker checks whether the broker can serve requests: the Kubernetes API
+// server is reachable, its namespace exists, the broker can read the
+// persistent volume claims in it and is allowed to manage them. Results are
+// cached so frequent probes don't put load on the API server.
+type Checker struct {
+	KubeClient kubernetes.Interface
+	Namespace  string
+	CacheTTL   time.Duration
+	Logger     lager.Logger
+
+	mutex     sync.Mutex
+	result    Result
+	checkedAt time.Time
+}
+
+// Check returns the cached result, or runs the checks if it has expired
+func (c *Checker) Check(ctx context.Context) Result {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ttl := c.CacheTTL
+	if ttl == 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < ttl {
+		return c.result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	c.result = Result{Ready: true, Checks: map[string]string{}}
+	c.record("api-server", c.checkAPIServer(ctx))
+	c.record("namespace", c.checkNamespace(ctx))
+	c.record("claims", c.checkClaims(ctx))
+	c.record("rbac", c.checkPermissions(ctx))
+	c.checkedAt = time.Now()
+
+	return c.result
+}
+
+// LivenessHandler reports that the process is alive
+func (c *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	})
+}
+
+// ReadinessHandler reports the result of the readiness checks. It responds
+// with 503 Service Unavailable if any check failed.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		result := c.Check(req.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		if !result.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+func (c *Checker) record(check string, err error) {
+	if err != nil {
+		c.Logger.Error("readiness-check-failed", err, lager.Data{"check": check})
+		c.result.Ready = false
+		c.result.Checks[check] = err.Error()
+		return
+	}
+
+	c.result.Checks[check] = "ok"
+}
+
+// checkAPIServer gets the server version. The discovery client doesn't take
+// a context, so the check gives up on it once ctx is done.
+func (c *Checker) checkAPIServer(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.KubeClient.Discovery().ServerVersion()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return errors.Wrap(err, "error reaching the api server")
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "error reaching the api server")
+	}
+}
+
+// checkNamespace gets the namespace. Brokers are usually only allowed to
+// access their own namespace, so if getting namespaces is forbidden, the
+// check gets the default service account of the namespace instead, which
+// Kubernetes creates in every namespace. Listing claims in a namespace that
+// doesn't exist succeeds, so checkClaims can't tell. If neither is allowed,
+// the namespace is assumed to exist.
+func (c *Checker) checkNamespace(ctx context.Context) error {
+	_, err := c.KubeClient.CoreV1().Namespaces().Get(ctx, c.Namespace, metav1.GetOptions{})
+	if apierrors.IsForbidden(err) {
+		_, err = c.KubeClient.CoreV1().ServiceAccounts(c.Namespace).Get(ctx, "default", metav1.GetOptions{})
+		if apierrors.IsForbidden(err) {
+			c.Logger.Debug("namespace-check-skipped", lager.Data{"namespace": c.Namespace})
+			return nil
+		}
+	}
+	if apierrors.IsNotFound(err) {
+		return errors.Errorf("namespace %s doesn't exist", c.Namespace)
+	}
+
+	return errors.Wrapf(err, "error getting namespace %s", c.Namespace)
+}
+
+func (c *Checker) checkClaims(ctx context.Context) error {
+	_, err := c.KubeClient.CoreV1().PersistentVolumeClaims(c.Namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	return errors.Wrapf(err, "error listing persistent volume claims in namespace %s", c.Namespace)
+}
+
+func (c *Checker) checkPermissions(ctx context.Context) error {
+	for _, verb := range []string{"get", "list", "watch", "create"} {
+		review, err := c.KubeClient.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: c.Namespace,
+					Verb:      verb,
+					Resource:  "persistentvolumeclaims",
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return errors.Wrap(err, "error reviewing access")
+		}
+
+		if !review.Status.Allowed {
+			return errors.Errorf("not allowed to %s persistentvolumeclaims in namespace %s", verb, c.Namespace)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"code.cloudfoundry.org/eirini-persi-broker/health"
+)
+
+// discoveryClient replaces the discovery client of a fake clientset
+type discoveryClient struct {
+	*fake.Clientset
+	discovery discovery.DiscoveryInterface
+}
+
+func (c *discoveryClient) Discovery() discovery.DiscoveryInterface {
+	return c.discovery
+}
+
+var _ = Describe("Checker", func() {
+	var (
+		kubeClient *fake.Clientset
+		checker    *health.Checker
+		denied     map[string]bool
+		reviews    int
+	)
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "eirini"},
+		})
+
+		denied = map[string]bool{}
+		reviews = 0
+		kubeClient.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			review.Status.Allowed = !denied[review.Spec.ResourceAttributes.Verb]
+			reviews++
+			return true, review, nil
+		})
+
+		checker = &health.Checker{
+			KubeClient: kubeClient,
+			Namespace:  "eirini",
+			CacheTTL:   time.Hour,
+			Logger:     lagertest.NewTestLogger("health"),
+		}
+	})
+
+	readiness := func() (int, health.Result) {
+		recorder := httptest.NewRecorder()
+		checker.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+		var result health.Result
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &result)).To(Succeed())
+		return recorder.Code, result
+	}
+
+	It("reports liveness", func() {
+		recorder := httptest.NewRecorder()
+		checker.LivenessHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+
+	It("is ready when all checks pass", func() {
+		code, result := readiness()
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(result.Ready).To(BeTrue())
+		Expect(result.Checks).To(Equal(map[string]string{
+			"api-server": "ok",
+			"namespace":  "ok",
+			"claims":     "ok",
+			"rbac":       "ok",
+		}))
+	})
+
+	It("is not ready when the claims in the namespace can't be read", func() {
+		kubeClient.PrependReactor("list", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(corev1.Resource("persistentvolumeclaims"), "", errors.New("denied"))
+		})
+
+		code, result := readiness()
+
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(result.Ready).To(BeFalse())
+		Expect(result.Checks["claims"]).To(ContainSubstring("error listing persistent volume claims in namespace eirini"))
+	})
+
+	It("is not ready when the namespace doesn't exist", func() {
+		checker.Namespace = "missing"
+
+		code, result := readiness()
+
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(result.Checks["namespace"]).To(Equal("namespace missing doesn't exist"))
+		Expect(result.Checks["claims"]).To(Equal("ok"))
+	})
+
+	Context("when getting namespaces is forbidden", func() {
+		BeforeEach(func() {
+			kubeClient.PrependReactor("get", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(corev1.Resource("namespaces"), "", errors.New("denied"))
+			})
+		})
+
+		It("is ready when the default service account of the namespace exists", func() {
+			_, err := kubeClient.CoreV1().ServiceAccounts("eirini").Create(context.Background(), &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, result := readiness()
+
+			Expect(result.Checks["namespace"]).To(Equal("ok"))
+		})
+
+		It("is not ready when the namespace has no default service account", func() {
+			_, result := readiness()
+
+			Expect(result.Checks["namespace"]).To(Equal("namespace eirini doesn't exist"))
+		})
+	})
+
+	It("gives up on an api server that doesn't respond", func() {
+		release := make(chan struct{})
+		defer close(release)
+		hungDiscovery := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+		hungDiscovery.AddReactor("get", "version", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			<-release
+			return false, nil, nil
+		})
+		checker.KubeClient = &discoveryClient{Clientset: kubeClient, discovery: hungDiscovery}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		result := checker.Check(ctx)
+
+		Expect(result.Ready).To(BeFalse())
+		Expect(result.Checks["api-server"]).To(ContainSubstring("context deadline exceeded"))
+	})
+
+	It("is not ready when the broker may not create claims", func() {
+		denied["create"] = true
+
+		code, result := readiness()
+
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(result.Checks["rbac"]).To(Equal("not allowed to create persistentvolumeclaims in namespace eirini"))
+	})
+
+	It("is not ready when the broker may not watch claims", func() {
+		denied["watch"] = true
+
+		code, result := readiness()
+
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(result.Checks["rbac"]).To(Equal("not allowed to watch persistentvolumeclaims in namespace eirini"))
+	})
+
+	It("caches the result", func() {
+		checker.Check(context.Background())
+		Expect(reviews).To(Equal(4))
+
+		denied["get"] = true
+		result := checker.Check(context.Background())
+
+		Expect(reviews).To(Equal(4))
+		Expect(result.Ready).To(BeTrue())
+	})
+
+	It("checks again once the cached result expires", func() {
+		checker.CacheTTL = time.Nanosecond
+		checker.Check(context.Background())
+
+		denied["get"] = true
+		time.Sleep(time.Millisecond)
+		result := checker.Check(context.Background())
+
+		Expect(reviews).To(Equal(5))
+		Expect(result.Ready).To(BeFalse())
+	})
+})
@@ -0,0 +1,219 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+)
+
+// Defaults used for settings missing from the configuration
+const (
+	DefaultLeaseName     = "eirini-persi-broker"
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// Elector runs background workers only on the replica holding the broker's
+// lease. Workers are cancelled when leadership is lost. On shutdown, the
+// lease is released once the workers have returned, so another replica can
+// take over right away without running workers alongside them.
+type Elector struct {
+	KubeClient kubernetes.Interface
+	Namespace  string
+	Identity   string
+	Config     config.LeaderElectionConfiguration
+	Metrics    *metrics.Metrics
+	Logger     lager.Logger
+	Workers    []func(context.Context)
+
+	// terms tracks the workers started by the leader election callback,
+	// which runs in its own goroutine
+	mutex   sync.Mutex
+	done    *sync.Cond
+	running int
+	stopped bool
+}
+
+// Run runs the workers until ctx is done. With leader election enabled, it
+// campaigns for the lease again whenever leadership is lost.
+func (e *Elector) Run(ctx context.Context) {
+	if !e.Config.Enabled {
+		e.runWorkers(ctx)
+		return
+	}
+
+	electionConfig, err := e.electionConfig()
+	if err != nil {
+		e.Logger.Error("leader-election-config", err)
+		return
+	}
+
+	e.mutex.Lock()
+	e.done = sync.NewCond(&e.mutex)
+	e.mutex.Unlock()
+
+	for ctx.Err() == nil {
+		elector, err := leaderelection.NewLeaderElector(electionConfig)
+		if err != nil {
+			e.Logger.Error("leader-election-config", err)
+			break
+		}
+
+		elector.Run(ctx)
+
+		// Workers of a lost term must be done before campaigning again or
+		// letting another replica take over
+		e.waitForTerms(false)
+		e.release(electionConfig)
+	}
+
+	e.waitForTerms(true)
+}
+
+// IsLeader returns true while this replica runs the workers. Without leader
+// election, every replica does.
+func (e *Elector) IsLeader() bool {
+	if !e.Config.Enabled {
+		return true
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.running > 0
+}
+
+// lead runs the workers for a term of leadership, unless Run is returning
+func (e *Elector) lead(ctx context.Context) {
+	e.mutex.Lock()
+	if e.stopped {
+		e.mutex.Unlock()
+		return
+	}
+	e.running++
+	e.mutex.Unlock()
+
+	defer func() {
+		e.mutex.Lock()
+		e.running--
+		e.done.Broadcast()
+		e.mutex.Unlock()
+	}()
+
+	e.Logger.Info("started-leading", lager.Data{"identity": e.Identity})
+	e.Metrics.Leader.Set(1)
+	e.runWorkers(ctx)
+}
+
+// waitForTerms waits until no workers run. Once stopped, no more terms start.
+func (e *Elector) waitForTerms(stop bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.stopped = e.stopped || stop
+	for e.running > 0 {
+		e.done.Wait()
+	}
+}
+
+// release gives up the lease if this replica still holds it. The leader
+// elector doesn't release it on cancel, since it would do so before the
+// workers have returned.
+func (e *Elector) release(electionConfig leaderelection.LeaderElectionConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), electionConfig.RenewDeadline)
+	defer cancel()
+
+	record, _, err := electionConfig.Lock.Get(ctx)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			e.Logger.Error("release-lease", err)
+		}
+		return
+	}
+	if record.HolderIdentity != e.Identity {
+		return
+	}
+
+	if err := electionConfig.Lock.Update(ctx, resourcelock.LeaderElectionRecord{
+		LeaderTransitions: record.LeaderTransitions,
+	}); err != nil {
+		e.Logger.Error("release-lease", err)
+		return
+	}
+
+	e.Logger.Info("released-lease", lager.Data{"identity": e.Identity})
+}
+
+// runWorkers runs all workers and waits for them to return
+func (e *Elector) runWorkers(ctx context.Context) {
+	var workers sync.WaitGroup
+	for _, worker := range e.Workers {
+		workers.Add(1)
+		go func(worker func(context.Context)) {
+			defer workers.Done()
+			worker(ctx)
+		}(worker)
+	}
+	workers.Wait()
+}
+
+func (e *Elector) electionConfig() (leaderelection.LeaderElectionConfig, error) {
+	if e.Identity == "" {
+		return leaderelection.LeaderElectionConfig{}, errors.New("leader election requires an identity")
+	}
+
+	leaseName := e.Config.LeaseName
+	if leaseName == "" {
+		leaseName = DefaultLeaseName
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: e.Namespace,
+		},
+		Client: e.KubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: e.Identity,
+		},
+	}
+
+	return leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            leaseName,
+		LeaseDuration:   durationOrDefault(e.Config.LeaseDuration, DefaultLeaseDuration),
+		RenewDeadline:   durationOrDefault(e.Config.RenewDeadline, DefaultRenewDeadline),
+		RetryPeriod:     durationOrDefault(e.Config.RetryPeriod, DefaultRetryPeriod),
+		ReleaseOnCancel: false,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: e.lead,
+			OnStoppedLeading: func() {
+				e.Logger.Info("stopped-leading", lager.Data{"identity": e.Identity})
+				e.Metrics.Leader.Set(0)
+			},
+			OnNewLeader: func(identity string) {
+				e.Logger.Info("new-leader", lager.Data{"leader": identity})
+			},
+		},
+	}, nil
+}
+
+func durationOrDefault(duration, defaultDuration time.Duration) time.Duration {
+	if duration == 0 {
+		return defaultDuration
+	}
+
+	return duration
+}
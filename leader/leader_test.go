@@ -0,0 +1,189 @@
+package leader_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/leader"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+)
+
+// replica runs an elector whose single worker records when it runs
+type replica struct {
+	elector *leader.Elector
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	mutex   sync.Mutex
+	working bool
+}
+
+func (r *replica) worker(ctx context.Context) {
+	r.mutex.Lock()
+	r.working = true
+	r.mutex.Unlock()
+
+	<-ctx.Done()
+
+	r.mutex.Lock()
+	r.working = false
+	r.mutex.Unlock()
+}
+
+func (r *replica) isWorking() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.working
+}
+
+func (r *replica) stop() {
+	r.cancel()
+	Eventually(r.stopped).Should(BeClosed())
+}
+
+var _ = Describe("Elector", func() {
+	var kubeClient *fake.Clientset
+
+	startReplica := func(identity string, c config.LeaderElectionConfiguration) *replica {
+		r := &replica{stopped: make(chan struct{})}
+		r.elector = &leader.Elector{
+			KubeClient: kubeClient,
+			Namespace:  "eirini",
+			Identity:   identity,
+			Config:     c,
+			Metrics:    metrics.New(prometheus.NewRegistry()),
+			Logger:     lagertest.NewTestLogger(identity),
+			Workers:    []func(context.Context){r.worker},
+		}
+
+		var ctx context.Context
+		ctx, r.cancel = context.WithCancel(context.Background())
+		go func() {
+			defer close(r.stopped)
+			r.elector.Run(ctx)
+		}()
+
+		return r
+	}
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+	})
+
+	Context("when leader election is disabled", func() {
+		It("runs the workers on every replica", func() {
+			first := startReplica("first", config.LeaderElectionConfiguration{})
+			second := startReplica("second", config.LeaderElectionConfiguration{})
+
+			Eventually(first.isWorking).Should(BeTrue())
+			Eventually(second.isWorking).Should(BeTrue())
+			Expect(first.elector.IsLeader()).To(BeTrue())
+			Expect(second.elector.IsLeader()).To(BeTrue())
+
+			first.stop()
+			second.stop()
+			Expect(first.isWorking()).To(BeFalse())
+		})
+	})
+
+	Context("when leader election is enabled", func() {
+		electionConfig := config.LeaderElectionConfiguration{
+			Enabled:       true,
+			LeaseDuration: 2 * time.Second,
+			RenewDeadline: time.Second,
+			RetryPeriod:   50 * time.Millisecond,
+		}
+
+		It("runs the workers only on the leader", func() {
+			first := startReplica("first", electionConfig)
+			Eventually(first.isWorking).Should(BeTrue())
+			Expect(testutil.ToFloat64(first.elector.Metrics.Leader)).To(Equal(1.0))
+
+			second := startReplica("second", electionConfig)
+			Consistently(second.isWorking, 300*time.Millisecond).Should(BeFalse())
+			Expect(first.elector.IsLeader()).To(BeTrue())
+			Expect(second.elector.IsLeader()).To(BeFalse())
+
+			lease, err := kubeClient.CoordinationV1().Leases("eirini").Get(context.Background(), leader.DefaultLeaseName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*lease.Spec.HolderIdentity).To(Equal("first"))
+
+			first.stop()
+			second.stop()
+		})
+
+		It("hands over to another replica on shutdown", func() {
+			first := startReplica("first", electionConfig)
+			Eventually(first.isWorking).Should(BeTrue())
+			second := startReplica("second", electionConfig)
+
+			first.stop()
+			Expect(first.isWorking()).To(BeFalse())
+			Expect(testutil.ToFloat64(first.elector.Metrics.Leader)).To(Equal(0.0))
+
+			// The lease is released, so the second replica doesn't wait for it to expire
+			Eventually(second.isWorking, time.Second).Should(BeTrue())
+
+			second.stop()
+		})
+
+		It("keeps the lease until the workers have returned", func() {
+			returning := make(chan struct{})
+			working := make(chan struct{})
+			elector := &leader.Elector{
+				KubeClient: kubeClient,
+				Namespace:  "eirini",
+				Identity:   "slow",
+				Config:     electionConfig,
+				Metrics:    metrics.New(prometheus.NewRegistry()),
+				Logger:     lagertest.NewTestLogger("slow"),
+				Workers: []func(context.Context){func(ctx context.Context) {
+					close(working)
+					<-ctx.Done()
+					<-returning
+				}},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				elector.Run(ctx)
+			}()
+			Eventually(working).Should(BeClosed())
+
+			holder := func() string {
+				lease, err := kubeClient.CoordinationV1().Leases("eirini").Get(context.Background(), leader.DefaultLeaseName, metav1.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				if lease.Spec.HolderIdentity == nil {
+					return ""
+				}
+				return *lease.Spec.HolderIdentity
+			}
+
+			cancel()
+			Consistently(holder, 200*time.Millisecond).Should(Equal("slow"))
+
+			close(returning)
+			Eventually(stopped).Should(BeClosed())
+			Expect(holder()).To(BeEmpty())
+		})
+
+		It("requires an identity", func() {
+			anonymous := startReplica("", electionConfig)
+
+			Eventually(anonymous.stopped).Should(BeClosed())
+			Expect(anonymous.isWorking()).To(BeFalse())
+		})
+	})
+})
@@ -34,18 +34,24 @@ func (s *CliSink) Log(format lager.LogFormat) {
 		return
 	}
 
-	if format.Message == "" || format.Data == nil || format.Data["event"] == "" {
+	event, ok := format.Data["event"]
+	if format.Message == "" || !ok || event == "" {
 		return
 	}
 
-	fmt.Fprintln(s.writer, prettify(format.Message, format.Data["event"]))
+	fmt.Fprintln(s.writer, prettify(format.Message, event))
 }
 
 func prettify(message string, event interface{}) string {
+	return CliLine(splitLagerMessage(message), event)
+}
+
+// CliLine formats a labelled value the way the cli log format shows events
+func CliLine(label string, value interface{}) string {
 	return fmt.Sprintf(
-		"%15s -> %s",
-		splitLagerMessage(message),
-		event,
+		"%15s -> %v",
+		label,
+		value,
 	)
 }
 
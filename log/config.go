@@ -0,0 +1,80 @@
+package log
+
+import (
+	"io"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+// Log formats supported in the configuration
+const (
+	FormatJSON    = "json"
+	FormatRFC3339 = "rfc3339"
+	FormatCli     = "cli"
+)
+
+// sensitiveKeyPatterns match log data keys whose values are redacted
+var sensitiveKeyPatterns = []string{
+	"[Pp]wd",
+	"[Pp]ass",
+	"[Ss]ecret",
+	"[Tt]oken",
+	"[Cc]redential",
+	"[Aa]ccess[-_]?[Kk]ey",
+	"[Pp]rivate[-_]?[Kk]ey",
+}
+
+// Configure registers a sink for the configured level and format with the
+// broker logger. Values of sensitive keys are redacted from all log data.
+func Configure(c config.LogConfiguration) error {
+	sink, err := NewSink(c, os.Stdout)
+	if err != nil {
+		return err
+	}
+	Logger().RegisterSink(sink)
+
+	if c.Format == "" || c.Format == FormatJSON {
+		errorSink, err := lager.NewRedactingSink(lager.NewWriterSink(os.Stderr, lager.ERROR), sensitiveKeyPatterns, nil)
+		if err != nil {
+			return errors.Wrap(err, "error creating redacting sink")
+		}
+		Logger().RegisterSink(errorSink)
+	}
+
+	return nil
+}
+
+// NewSink creates a redacting sink writing to writer with the configured level and format
+func NewSink(c config.LogConfiguration, writer io.Writer) (lager.Sink, error) {
+	level := lager.DEBUG
+	if c.Level != "" {
+		var err error
+		level, err = lager.LogLevelFromString(c.Level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sink lager.Sink
+	switch c.Format {
+	case "", FormatJSON:
+		sink = lager.NewWriterSink(writer, level)
+	case FormatRFC3339:
+		sink = lager.NewPrettySink(writer, level)
+	case FormatCli:
+		sink = &CliSink{writer: writer, minLogLevel: level}
+	default:
+		return nil, errors.Errorf("invalid log format: %s", c.Format)
+	}
+
+	redactingSink, err := lager.NewRedactingSink(sink, sensitiveKeyPatterns, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating redacting sink")
+	}
+
+	return redactingSink, nil
+}
@@ -0,0 +1,91 @@
+package log_test
+
+import (
+	"code.cloudfoundry.org/lager"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/log"
+)
+
+var _ = Describe("NewSink", func() {
+	var (
+		buffer *gbytes.Buffer
+		logger lager.Logger
+	)
+
+	BeforeEach(func() {
+		buffer = gbytes.NewBuffer()
+		logger = lager.NewLogger("test")
+	})
+
+	register := func(c config.LogConfiguration) {
+		sink, err := log.NewSink(c, buffer)
+		Expect(err).NotTo(HaveOccurred())
+		logger.RegisterSink(sink)
+	}
+
+	It("defaults to lager json at debug level", func() {
+		register(config.LogConfiguration{})
+
+		logger.Debug("some-action", lager.Data{"instance-id": "foo"})
+
+		Expect(buffer).To(gbytes.Say(`"log_level":0`))
+		Expect(buffer).To(gbytes.Say(`"instance-id":"foo"`))
+	})
+
+	It("filters by the configured level", func() {
+		register(config.LogConfiguration{Level: "error"})
+
+		logger.Info("some-action")
+
+		Expect(buffer.Contents()).To(BeEmpty())
+	})
+
+	It("writes rfc3339 timestamps", func() {
+		register(config.LogConfiguration{Format: log.FormatRFC3339})
+
+		logger.Info("some-action")
+
+		Expect(buffer).To(gbytes.Say(`"timestamp":"\d{4}-\d{2}-\d{2}T`))
+		Expect(buffer).To(gbytes.Say(`"level":"info"`))
+	})
+
+	It("writes human readable events with the cli format", func() {
+		register(config.LogConfiguration{Format: log.FormatCli})
+
+		logger.Info("provision", lager.Data{"event": "Provisioned instance foo"})
+
+		Expect(string(buffer.Contents())).To(Equal("      provision -> Provisioned instance foo\n"))
+	})
+
+	It("redacts sensitive data", func() {
+		register(config.LogConfiguration{})
+
+		logger.Info("some-action", lager.Data{
+			"parameters": map[string]interface{}{
+				"size":       "1Gi",
+				"password":   "hunter2",
+				"api_token":  "abc",
+				"access_key": "AKIA",
+			},
+		})
+
+		Expect(buffer.Contents()).To(ContainSubstring(`"size":"1Gi"`))
+		Expect(buffer.Contents()).NotTo(ContainSubstring("hunter2"))
+		Expect(buffer.Contents()).NotTo(ContainSubstring(`"abc"`))
+		Expect(buffer.Contents()).NotTo(ContainSubstring(`"AKIA"`))
+	})
+
+	It("rejects an invalid level", func() {
+		_, err := log.NewSink(config.LogConfiguration{Level: "verbose"}, buffer)
+		Expect(err).To(MatchError(ContainSubstring("invalid log level")))
+	})
+
+	It("rejects an invalid format", func() {
+		_, err := log.NewSink(config.LogConfiguration{Format: "xml"}, buffer)
+		Expect(err).To(MatchError(ContainSubstring("invalid log format")))
+	})
+})
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// InstrumentedBroker records metrics for every operation of the wrapped broker
+type InstrumentedBroker struct {
+	Broker  brokerapi.ServiceBroker
+	Metrics *Metrics
+}
+
+// Services returns the catalog of the wrapped broker
+func (b *InstrumentedBroker) Services(ctx context.Context) (services []brokerapi.Service, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("catalog", start, err) }(time.Now())
+	return b.Broker.Services(ctx)
+}
+
+// Provision provisions an instance with the wrapped broker
+func (b *InstrumentedBroker) Provision(ctx context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (spec brokerapi.ProvisionedServiceSpec, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("provision", start, err) }(time.Now())
+	return b.Broker.Provision(ctx, instanceID, details, asyncAllowed)
+}
+
+// Deprovision deprovisions an instance with the wrapped broker
+func (b *InstrumentedBroker) Deprovision(ctx context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (spec brokerapi.DeprovisionServiceSpec, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("deprovision", start, err) }(time.Now())
+	return b.Broker.Deprovision(ctx, instanceID, details, asyncAllowed)
+}
+
+// GetInstance fetches an instance from the wrapped broker
+func (b *InstrumentedBroker) GetInstance(ctx context.Context, instanceID string) (spec brokerapi.GetInstanceDetailsSpec, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("get_instance", start, err) }(time.Now())
+	return b.Broker.GetInstance(ctx, instanceID)
+}
+
+// Update updates an instance with the wrapped broker
+func (b *InstrumentedBroker) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (spec brokerapi.UpdateServiceSpec, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("update", start, err) }(time.Now())
+	return b.Broker.Update(ctx, instanceID, details, asyncAllowed)
+}
+
+// LastOperation polls an instance operation of the wrapped broker
+func (b *InstrumentedBroker) LastOperation(ctx context.Context, instanceID string, details brokerapi.PollDetails) (op brokerapi.LastOperation, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("last_operation", start, err) }(time.Now())
+	return b.Broker.LastOperation(ctx, instanceID, details)
+}
+
+// Bind creates a binding with the wrapped broker
+func (b *InstrumentedBroker) Bind(ctx context.Context, instanceID, bindingID string, details brokerapi.BindDetails, asyncAllowed bool) (binding brokerapi.Binding, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("bind", start, err) }(time.Now())
+	return b.Broker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+// Unbind deletes a binding with the wrapped broker
+func (b *InstrumentedBroker) Unbind(ctx context.Context, instanceID, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool) (spec brokerapi.UnbindSpec, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("unbind", start, err) }(time.Now())
+	return b.Broker.Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+// GetBinding fetches a binding from the wrapped broker
+func (b *InstrumentedBroker) GetBinding(ctx context.Context, instanceID, bindingID string) (spec brokerapi.GetBindingSpec, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("get_binding", start, err) }(time.Now())
+	return b.Broker.GetBinding(ctx, instanceID, bindingID)
+}
+
+// LastBindingOperation polls a binding operation of the wrapped broker
+func (b *InstrumentedBroker) LastBindingOperation(ctx context.Context, instanceID, bindingID string, details brokerapi.PollDetails) (op brokerapi.LastOperation, err error) {
+	defer func(start time.Time) { b.Metrics.ObserveOperation("last_binding_operation", start, err) }(time.Now())
+	return b.Broker.LastBindingOperation(ctx, instanceID, bindingID, details)
+}
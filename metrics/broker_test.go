@@ -0,0 +1,78 @@
+package metrics_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+)
+
+var (
+	storageClass = "storageClass"
+	testConfig   = config.Config{
+		Namespace: "eirini",
+		ServiceConfiguration: config.ServiceConfiguration{
+			ServiceID: "service-id",
+			Plans: []config.Plan{
+				{ID: "plan-id", StorageClass: &storageClass, DefaultSize: "1Gi"},
+			},
+		},
+	}
+)
+
+func provisionDetails(org, space string) brokerapi.ProvisionDetails {
+	return brokerapi.ProvisionDetails{
+		ServiceID:        "service-id",
+		PlanID:           "plan-id",
+		OrganizationGUID: org,
+		SpaceGUID:        space,
+	}
+}
+
+var _ = Describe("InstrumentedBroker", func() {
+	var (
+		m                  *metrics.Metrics
+		instrumentedBroker *metrics.InstrumentedBroker
+	)
+
+	BeforeEach(func() {
+		m = metrics.New(prometheus.NewRegistry())
+		instrumentedBroker = &metrics.InstrumentedBroker{
+			Broker: &broker.KubeVolumeBroker{
+				KubeClient: fake.NewSimpleClientset(),
+				Config:     testConfig,
+			},
+			Metrics: m,
+		}
+	})
+
+	It("counts successful operations", func() {
+		_, err := instrumentedBroker.Provision(context.Background(), "instance", provisionDetails("org", "space"), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(testutil.ToFloat64(m.Operations.WithLabelValues("provision", metrics.OutcomeSuccess))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(m.Operations.WithLabelValues("provision", metrics.OutcomeFailure))).To(Equal(0.0))
+	})
+
+	It("counts failed operations", func() {
+		_, err := instrumentedBroker.Deprovision(context.Background(), "missing", brokerapi.DeprovisionDetails{}, false)
+		Expect(err).To(HaveOccurred())
+
+		Expect(testutil.ToFloat64(m.Operations.WithLabelValues("deprovision", metrics.OutcomeFailure))).To(Equal(1.0))
+	})
+
+	It("records operation latency", func() {
+		_, err := instrumentedBroker.GetInstance(context.Background(), "missing")
+		Expect(err).To(HaveOccurred())
+
+		Expect(testutil.CollectAndCount(m.OperationDuration)).To(Equal(1))
+	})
+})
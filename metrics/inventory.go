@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"code.cloudfoundry.org/lager"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var inventoryLabels = []string{"plan_id", "organization_id", "space_id"}
+
+// InventoryCollector reports the service instances, their bindings and the
+// storage they request, derived from the labels of the persistent volume
+// claims provisioned by the broker. The claims are read from the informer
+// cache, so scrapes don't put load on the API server.
+type InventoryCollector struct {
+	ClaimLister corev1listers.PersistentVolumeClaimLister
+	Config      config.Config
+	Logger      lager.Logger
+
+	instances *prometheus.Desc
+	bindings  *prometheus.Desc
+	storage   *prometheus.Desc
+	errors    prometheus.Counter
+}
+
+// NewInventoryCollector creates a collector for the broker's instances
+func NewInventoryCollector(claimLister corev1listers.PersistentVolumeClaimLister, c config.Config, logger lager.Logger) *InventoryCollector {
+	return &InventoryCollector{
+		ClaimLister: claimLister,
+		Config:      c,
+		Logger:      logger,
+
+		instances: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "instances"),
+			"Number of service instances by plan, organization and space.",
+			inventoryLabels, nil,
+		),
+		bindings: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "bindings"),
+			"Number of service bindings by plan, organization and space.",
+			inventoryLabels, nil,
+		),
+		storage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "requested_storage_bytes"),
+			"Storage requested by service instances by plan, organization and space.",
+			inventoryLabels, nil,
+		),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "inventory_errors_total",
+			Help:      "Number of failed attempts to list service instances for metrics.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *InventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.instances
+	ch <- c.bindings
+	ch <- c.storage
+	c.errors.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *InventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	defer c.errors.Collect(ch)
+
+	selector, err := labels.Parse(broker.InstanceSelector(c.Config))
+	if err != nil {
+		c.Logger.Error("list-instances", err)
+		c.errors.Inc()
+		return
+	}
+
+	pvcs, err := c.ClaimLister.PersistentVolumeClaims(c.Config.Namespace).List(selector)
+	if err != nil {
+		c.Logger.Error("list-instances", err)
+		c.errors.Inc()
+		return
+	}
+
+	type key struct{ plan, org, space string }
+	instances := map[key]float64{}
+	bindings := map[key]float64{}
+	storage := map[key]float64{}
+
+	for _, pvc := range pvcs {
+		k := key{
+			plan:  pvc.Labels[broker.PlanIDLabel],
+			org:   pvc.Labels[broker.OrganizationIDLabel],
+			space: pvc.Labels[broker.SpaceIDLabel],
+		}
+
+		instances[k]++
+
+		for annotation := range pvc.Annotations {
+			if broker.IsBindingIDAnnotation(annotation) {
+				bindings[k]++
+			}
+		}
+
+		if requested, ok := pvc.Spec.Resources.Requests["storage"]; ok {
+			storage[k] += float64(requested.Value())
+		}
+	}
+
+	for k, count := range instances {
+		ch <- prometheus.MustNewConstMetric(c.instances, prometheus.GaugeValue, count, k.plan, k.org, k.space)
+		ch <- prometheus.MustNewConstMetric(c.bindings, prometheus.GaugeValue, bindings[k], k.plan, k.org, k.space)
+		ch <- prometheus.MustNewConstMetric(c.storage, prometheus.GaugeValue, storage[k], k.plan, k.org, k.space)
+	}
+}
@@ -0,0 +1,123 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+)
+
+// failingLister fails to list claims
+type failingLister struct {
+	corev1listers.PersistentVolumeClaimLister
+}
+
+func (failingLister) PersistentVolumeClaims(string) corev1listers.PersistentVolumeClaimNamespaceLister {
+	return failingNamespaceLister{}
+}
+
+type failingNamespaceLister struct {
+	corev1listers.PersistentVolumeClaimNamespaceLister
+}
+
+func (failingNamespaceLister) List(labels.Selector) ([]*corev1.PersistentVolumeClaim, error) {
+	return nil, errors.New("cache unavailable")
+}
+
+var _ = Describe("InventoryCollector", func() {
+	var (
+		kubeClient *fake.Clientset
+		indexer    cache.Indexer
+		collector  *metrics.InventoryCollector
+	)
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		indexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		collector = metrics.NewInventoryCollector(corev1listers.NewPersistentVolumeClaimLister(indexer), testConfig, lagertest.NewTestLogger("inventory"))
+
+		testBroker := &broker.KubeVolumeBroker{KubeClient: kubeClient, Config: testConfig}
+		for _, instance := range []string{"a", "b"} {
+			_, err := testBroker.Provision(context.Background(), instance, provisionDetails("org", "space"), false)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := testBroker.Provision(context.Background(), "c", provisionDetails("org", "other-space"), false)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = testBroker.Bind(context.Background(), "a", "binding", brokerapi.BindDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		pvcs, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").List(context.Background(), metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		for i := range pvcs.Items {
+			Expect(indexer.Add(&pvcs.Items[i])).To(Succeed())
+		}
+		kubeClient.ClearActions()
+	})
+
+	It("reports instances, bindings and storage by plan, org and space", func() {
+		expected := `
+# HELP eirini_persi_broker_bindings Number of service bindings by plan, organization and space.
+# TYPE eirini_persi_broker_bindings gauge
+eirini_persi_broker_bindings{organization_id="org",plan_id="plan-id",space_id="other-space"} 0
+eirini_persi_broker_bindings{organization_id="org",plan_id="plan-id",space_id="space"} 1
+# HELP eirini_persi_broker_instances Number of service instances by plan, organization and space.
+# TYPE eirini_persi_broker_instances gauge
+eirini_persi_broker_instances{organization_id="org",plan_id="plan-id",space_id="other-space"} 1
+eirini_persi_broker_instances{organization_id="org",plan_id="plan-id",space_id="space"} 2
+# HELP eirini_persi_broker_requested_storage_bytes Storage requested by service instances by plan, organization and space.
+# TYPE eirini_persi_broker_requested_storage_bytes gauge
+eirini_persi_broker_requested_storage_bytes{organization_id="org",plan_id="plan-id",space_id="other-space"} 1.073741824e+09
+eirini_persi_broker_requested_storage_bytes{organization_id="org",plan_id="plan-id",space_id="space"} 2.147483648e+09
+`
+		Expect(testutil.CollectAndCompare(collector, strings.NewReader(expected),
+			"eirini_persi_broker_instances",
+			"eirini_persi_broker_bindings",
+			"eirini_persi_broker_requested_storage_bytes",
+		)).To(Succeed())
+	})
+
+	It("only reports claims provisioned for the service", func() {
+		for name, labels := range map[string]map[string]string{
+			"other-service": {broker.ServiceIDLabel: "other-service-id", broker.SpaceIDLabel: "space"},
+			"deleted":       {broker.ServiceIDLabel: "service-id", broker.SpaceIDLabel: "space", broker.DeletedLabel: "true"},
+		} {
+			Expect(indexer.Add(&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "eirini", Labels: labels},
+			})).To(Succeed())
+		}
+
+		Expect(testutil.CollectAndCount(collector, "eirini_persi_broker_instances")).To(Equal(2))
+	})
+
+	It("reads the claims from the cache", func() {
+		testutil.CollectAndCount(collector)
+
+		Expect(kubeClient.Actions()).To(BeEmpty())
+	})
+
+	It("counts failures to list instances", func() {
+		collector.ClaimLister = failingLister{}
+
+		Expect(testutil.CollectAndCount(collector, "eirini_persi_broker_instances")).To(Equal(0))
+		Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP eirini_persi_broker_inventory_errors_total Number of failed attempts to list service instances for metrics.
+# TYPE eirini_persi_broker_inventory_errors_total counter
+eirini_persi_broker_inventory_errors_total 2
+`), "eirini_persi_broker_inventory_errors_total")).To(Succeed())
+	})
+})
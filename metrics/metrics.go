@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "eirini_persi_broker"
+
+// Outcomes of a broker operation
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Metrics holds the collectors for broker operations, Kubernetes API calls,
+// the volume usage checked by the monitor and scheduled backups
+type Metrics struct {
+	Operations        *prometheus.CounterVec
+	OperationDuration *prometheus.HistogramVec
+	KubeRequests      *prometheus.CounterVec
+	KubeDuration      *prometheus.HistogramVec
+
+	VolumeUsedBytes      *prometheus.GaugeVec
+	VolumeAvailableBytes *prometheus.GaugeVec
+	VolumeUsageRatio     *prometheus.GaugeVec
+	ThresholdCrossings   *prometheus.CounterVec
+	Autogrows            *prometheus.CounterVec
+
+	DriftIssues *prometheus.GaugeVec
+	Repairs     *prometheus.CounterVec
+
+	BackupLastSuccess *prometheus.GaugeVec
+	ScheduledBackups  *prometheus.CounterVec
+
+	Leader prometheus.Gauge
+}
+
+// New creates the broker metrics and registers them with registerer
+func New(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		Operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Number of Open Service Broker API operations by operation and outcome.",
+		}, []string{"operation", "outcome"}),
+		OperationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of Open Service Broker API operations by operation and outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "outcome"}),
+		KubeRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kubernetes_requests_total",
+			Help:      "Number of Kubernetes API requests by method, resource and status code.",
+		}, []string{"method", "resource", "code"}),
+		KubeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "kubernetes_request_duration_seconds",
+			Help:      "Duration of Kubernetes API requests by method and resource.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "resource"}),
+		VolumeUsedBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_used_bytes",
+			Help:      "Bytes used on the volume of a service instance.",
+		}, []string{"instance_id", "plan_id"}),
+		VolumeAvailableBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_available_bytes",
+			Help:      "Bytes available on the volume of a service instance.",
+		}, []string{"instance_id", "plan_id"}),
+		VolumeUsageRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "volume_usage_ratio",
+			Help:      "Fraction of the volume of a service instance that is used.",
+		}, []string{"instance_id", "plan_id"}),
+		ThresholdCrossings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "volume_threshold_crossings_total",
+			Help:      "Number of times a volume's usage crossed a configured threshold, by threshold.",
+		}, []string{"threshold"}),
+		Autogrows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "volume_autogrows_total",
+			Help:      "Number of automatic volume expansions by outcome.",
+		}, []string{"outcome"}),
+		DriftIssues: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "reconciler_issues",
+			Help:      "Number of orphaned or drifted resources found by the last reconciliation, by kind.",
+		}, []string{"kind"}),
+		Repairs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconciler_repairs_total",
+			Help:      "Number of repairs made by the reconciler by kind and outcome.",
+		}, []string{"kind", "outcome"}),
+		BackupLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backup_last_success_timestamp_seconds",
+			Help:      "Unix time of the latest successful backup of a service instance.",
+		}, []string{"instance_id", "plan_id"}),
+		ScheduledBackups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "scheduled_backups_total",
+			Help:      "Number of finished scheduled backups by outcome.",
+		}, []string{"outcome"}),
+		Leader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "leader",
+			Help:      "Whether this replica is the leader running background workers.",
+		}),
+	}
+
+	registerer.MustRegister(
+		m.Operations, m.OperationDuration, m.KubeRequests, m.KubeDuration,
+		m.VolumeUsedBytes, m.VolumeAvailableBytes, m.VolumeUsageRatio, m.ThresholdCrossings, m.Autogrows,
+		m.DriftIssues, m.Repairs, m.BackupLastSuccess, m.ScheduledBackups, m.Leader,
+	)
+
+	return m
+}
+
+// ObserveOperation records the outcome and duration of a broker operation
+func (m *Metrics) ObserveOperation(operation string, start time.Time, err error) {
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeFailure
+	}
+
+	m.Operations.WithLabelValues(operation, outcome).Inc()
+	m.OperationDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+// InstrumentRoundTripper wraps the transport of the Kubernetes client so every
+// API request is counted and timed. It can be used as rest.Config.WrapTransport.
+func (m *Metrics) InstrumentRoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resource := resourceFromPath(req.URL.Path)
+		start := time.Now()
+
+		resp, err := next.RoundTrip(req)
+
+		m.KubeDuration.WithLabelValues(req.Method, resource).Observe(time.Since(start).Seconds())
+
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+		m.KubeRequests.WithLabelValues(req.Method, resource, code).Inc()
+
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// resourceFromPath extracts the resource type from a Kubernetes API path such
+// as /api/v1/namespaces/eirini/persistentvolumeclaims/name, so that metric
+// labels don't contain object names.
+func resourceFromPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	// Skip the api prefix and version: /api/v1 or /apis/group/version
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return "other"
+	}
+
+	if len(parts) == 0 {
+		return "discovery"
+	}
+
+	if parts[0] == "namespaces" && len(parts) >= 3 {
+		parts = parts[2:]
+	}
+
+	resource := parts[0]
+	if len(parts) >= 3 {
+		resource += "/" + parts[2]
+	}
+
+	return resource
+}
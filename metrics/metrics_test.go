@@ -0,0 +1,90 @@
+package metrics_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var _ = Describe("Metrics", func() {
+	var m *metrics.Metrics
+
+	BeforeEach(func() {
+		m = metrics.New(prometheus.NewRegistry())
+	})
+
+	Describe("InstrumentRoundTripper", func() {
+		var (
+			status int
+			err    error
+		)
+
+		BeforeEach(func() {
+			status = http.StatusOK
+			err = nil
+		})
+
+		roundTrip := func(method, path string) {
+			next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if err != nil {
+					return nil, err
+				}
+				return &http.Response{StatusCode: status}, nil
+			})
+
+			m.InstrumentRoundTripper(next).RoundTrip(httptest.NewRequest(method, path, nil))
+		}
+
+		It("counts requests by resource without object names", func() {
+			roundTrip("GET", "/api/v1/namespaces/eirini/persistentvolumeclaims/some-instance")
+			roundTrip("GET", "/api/v1/namespaces/eirini/persistentvolumeclaims/other-instance")
+
+			Expect(testutil.ToFloat64(m.KubeRequests.WithLabelValues("GET", "persistentvolumeclaims", "200"))).To(Equal(2.0))
+		})
+
+		It("labels subresources", func() {
+			roundTrip("GET", "/api/v1/nodes/node-1/proxy/stats/summary")
+
+			Expect(testutil.ToFloat64(m.KubeRequests.WithLabelValues("GET", "nodes/proxy", "200"))).To(Equal(1.0))
+		})
+
+		It("labels resources of api groups", func() {
+			roundTrip("POST", "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews")
+
+			Expect(testutil.ToFloat64(m.KubeRequests.WithLabelValues("POST", "selfsubjectaccessreviews", "200"))).To(Equal(1.0))
+		})
+
+		It("records the status code", func() {
+			status = http.StatusNotFound
+			roundTrip("DELETE", "/api/v1/namespaces/eirini/persistentvolumeclaims/some-instance")
+
+			Expect(testutil.ToFloat64(m.KubeRequests.WithLabelValues("DELETE", "persistentvolumeclaims", "404"))).To(Equal(1.0))
+		})
+
+		It("records transport errors", func() {
+			err = errors.New("connection refused")
+			roundTrip("GET", "/api/v1/namespaces/eirini")
+
+			Expect(testutil.ToFloat64(m.KubeRequests.WithLabelValues("GET", "namespaces", "error"))).To(Equal(1.0))
+		})
+
+		It("records the request duration", func() {
+			roundTrip("GET", "/version")
+
+			Expect(testutil.CollectAndCount(m.KubeDuration)).To(Equal(1))
+		})
+	})
+})
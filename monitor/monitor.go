@@ -0,0 +1,265 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+)
+
+// DefaultInterval is the time between two checks when no interval is configured
+const DefaultInterval = 5 * time.Minute
+
+// DefaultThresholds are used when no thresholds are configured
+var DefaultThresholds = []float64{0.8, 0.9, 0.95}
+
+// Reasons of the events recorded by the monitor
+const (
+	ReasonLowSpace             = "LowSpace"
+	ReasonAutogrowLimitReached = "AutogrowLimitReached"
+)
+
+// Monitor periodically checks the volume usage of all service instances. It
+// records metrics, warns when usage crosses a threshold and grows the volumes
+// of plans with an autogrow policy.
+type Monitor struct {
+	Broker  *broker.KubeVolumeBroker
+	Metrics *metrics.Metrics
+	Config  config.MonitorConfiguration
+	Logger  lager.Logger
+
+	mutex sync.Mutex
+	// crossed holds the highest threshold crossed by each instance
+	crossed map[string]float64
+	// limitReported holds the size at which each instance reached its autogrow limit
+	limitReported map[string]string
+	// series holds the plan of the usage series of each instance
+	series map[string]string
+}
+
+// Run checks the volume usage every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context) {
+	interval := m.Config.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Check(ctx); err != nil {
+			m.Logger.Error("check", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check checks the volume usage of all service instances once
+func (m *Monitor) Check(ctx context.Context) error {
+	if m.Broker.Usage == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.crossed == nil {
+		m.crossed = map[string]float64{}
+		m.limitReported = map[string]string{}
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, m.Broker.KubeTimeout())
+	defer cancel()
+
+	pvcs, err := m.Broker.KubeClient.CoreV1().PersistentVolumeClaims(m.Broker.Config.Namespace).List(listCtx, metav1.ListOptions{
+		LabelSelector: broker.InstanceSelector(m.Broker.Config),
+	})
+	if err != nil {
+		return err
+	}
+
+	previous := m.series
+	m.series = map[string]string{}
+
+	seen := map[string]bool{}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		seen[broker.InstanceID(pvc)] = true
+		m.checkInstance(ctx, pvc)
+	}
+
+	// Drop the series of deleted instances and of those whose usage is gone
+	// once all instances were checked, so scrapes during a check still see
+	// the previous values
+	for instanceID, planID := range previous {
+		if m.series[instanceID] != planID {
+			m.Metrics.VolumeUsedBytes.DeleteLabelValues(instanceID, planID)
+			m.Metrics.VolumeAvailableBytes.DeleteLabelValues(instanceID, planID)
+			m.Metrics.VolumeUsageRatio.DeleteLabelValues(instanceID, planID)
+		}
+	}
+
+	for name := range m.crossed {
+		if !seen[name] {
+			delete(m.crossed, name)
+			delete(m.limitReported, name)
+		}
+	}
+
+	return nil
+}
+
+func (m *Monitor) checkInstance(ctx context.Context, pvc *corev1.PersistentVolumeClaim) {
+	instanceID := broker.InstanceID(pvc)
+	logger := m.Logger.Session("check-instance", lager.Data{"instance-id": instanceID})
+
+	usageCtx, cancel := context.WithTimeout(ctx, m.Broker.KubeTimeout())
+	defer cancel()
+
+	volumeUsage, err := m.Broker.Usage.Usage(usageCtx, pvc)
+	if err != nil {
+		logger.Error("get-usage", err)
+		return
+	}
+	if volumeUsage == nil {
+		return
+	}
+
+	total := volumeUsage.UsedBytes + volumeUsage.AvailableBytes
+	if total <= 0 {
+		return
+	}
+	ratio := float64(volumeUsage.UsedBytes) / float64(total)
+
+	planID := pvc.Labels[broker.PlanIDLabel]
+	m.Metrics.VolumeUsedBytes.WithLabelValues(instanceID, planID).Set(float64(volumeUsage.UsedBytes))
+	m.Metrics.VolumeAvailableBytes.WithLabelValues(instanceID, planID).Set(float64(volumeUsage.AvailableBytes))
+	m.Metrics.VolumeUsageRatio.WithLabelValues(instanceID, planID).Set(ratio)
+	m.series[instanceID] = planID
+
+	m.checkThresholds(pvc, ratio, volumeUsage.UsedBytes, total)
+	m.autogrow(ctx, logger, pvc, ratio)
+}
+
+// checkThresholds warns when usage crosses a higher threshold than at the
+// previous check. Once usage drops, crossing the threshold again warns again.
+func (m *Monitor) checkThresholds(pvc *corev1.PersistentVolumeClaim, ratio float64, used, total int64) {
+	instanceID := broker.InstanceID(pvc)
+	thresholds := append([]float64{}, m.Config.Thresholds...)
+	if len(thresholds) == 0 {
+		thresholds = DefaultThresholds
+	}
+	sort.Float64s(thresholds)
+
+	highest := 0.0
+	for _, threshold := range thresholds {
+		if ratio >= threshold {
+			highest = threshold
+		}
+	}
+
+	previous := m.crossed[instanceID]
+	m.crossed[instanceID] = highest
+	if highest <= previous {
+		return
+	}
+
+	m.Metrics.ThresholdCrossings.WithLabelValues(strconv.FormatFloat(highest, 'g', -1, 64)).Inc()
+	m.recordWarning(pvc, ReasonLowSpace, fmt.Sprintf(
+		"Service instance %s is %.0f%% full, %s of %s used",
+		instanceID,
+		ratio*100,
+		resource.NewQuantity(used, resource.BinarySI).String(),
+		resource.NewQuantity(total, resource.BinarySI).String(),
+	))
+}
+
+// autogrow expands the volume by the increment of the plan's autogrow policy
+// once usage crosses its threshold, without exceeding its ceiling or the
+// plan's max size
+func (m *Monitor) autogrow(ctx context.Context, logger lager.Logger, pvc *corev1.PersistentVolumeClaim, ratio float64) {
+	instanceID := broker.InstanceID(pvc)
+	plan := m.Broker.Plan(pvc.Labels[broker.PlanIDLabel])
+	if plan == nil || plan.Autogrow == nil || ratio < plan.Autogrow.Threshold {
+		return
+	}
+
+	current := pvc.Spec.Resources.Requests["storage"]
+
+	// Wait for a previous expansion to finish before growing again
+	capacity, ok := pvc.Status.Capacity["storage"]
+	if !ok || capacity.Cmp(current) < 0 {
+		logger.Debug("resize-in-progress")
+		return
+	}
+
+	increment, err := resource.ParseQuantity(plan.Autogrow.Increment)
+	if err != nil {
+		logger.Error("invalid-autogrow-increment", err, lager.Data{"plan-id": plan.ID})
+		return
+	}
+
+	target := current.DeepCopy()
+	target.Add(increment)
+
+	for _, limit := range []string{plan.Autogrow.Ceiling, plan.MaxSize} {
+		if limit == "" {
+			continue
+		}
+		limitQuantity, err := resource.ParseQuantity(limit)
+		if err != nil {
+			logger.Error("invalid-autogrow-limit", err, lager.Data{"plan-id": plan.ID})
+			return
+		}
+		if target.Cmp(limitQuantity) > 0 {
+			target = limitQuantity
+		}
+	}
+
+	if target.Cmp(current) <= 0 {
+		if m.limitReported[instanceID] != current.String() {
+			m.limitReported[instanceID] = current.String()
+			m.recordWarning(pvc, ReasonAutogrowLimitReached, fmt.Sprintf(
+				"Service instance %s can't grow beyond %s", instanceID, current.String(),
+			))
+		}
+		return
+	}
+
+	growCtx, cancel := context.WithTimeout(ctx, m.Broker.KubeTimeout())
+	defer cancel()
+
+	if _, err := m.Broker.Grow(growCtx, pvc, target); err != nil {
+		logger.Error("autogrow", err)
+		m.Metrics.Autogrows.WithLabelValues(metrics.OutcomeFailure).Inc()
+		return
+	}
+
+	logger.Info("autogrow", lager.Data{"from": current.String(), "to": target.String()})
+	m.Metrics.Autogrows.WithLabelValues(metrics.OutcomeSuccess).Inc()
+}
+
+func (m *Monitor) recordWarning(pvc *corev1.PersistentVolumeClaim, reason, message string) {
+	if m.Broker.Recorder == nil {
+		return
+	}
+
+	m.Broker.Recorder.Event(pvc, corev1.EventTypeWarning, reason, message)
+}
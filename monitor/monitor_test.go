@@ -0,0 +1,252 @@
+package monitor_test
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+	"code.cloudfoundry.org/eirini-persi-broker/monitor"
+	"code.cloudfoundry.org/eirini-persi-broker/usage"
+)
+
+const mebibyte = 1024 * 1024
+
+type fakeUsageSource map[string]*usage.Usage
+
+func (s fakeUsageSource) Usage(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*usage.Usage, error) {
+	return s[pvc.Name], nil
+}
+
+// observedUsageSource calls observe before returning each usage
+type observedUsageSource struct {
+	fakeUsageSource
+	observe func()
+}
+
+func (s observedUsageSource) Usage(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*usage.Usage, error) {
+	s.observe()
+	return s.fakeUsageSource.Usage(ctx, pvc)
+}
+
+var _ = Describe("Monitor", func() {
+	var (
+		storageClass  = "storageClass"
+		kubeClient    *fake.Clientset
+		recorder      *record.FakeRecorder
+		usageSource   fakeUsageSource
+		brokerMetrics *metrics.Metrics
+		testBroker    *broker.KubeVolumeBroker
+		volumeMonitor *monitor.Monitor
+	)
+
+	drainEvents := func() []string {
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		return events
+	}
+
+	provision := func(instanceID, planID string) {
+		_, err := testBroker.Provision(context.Background(), instanceID, brokerapi.ProvisionDetails{
+			ServiceID: "service-id",
+			PlanID:    planID,
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		setCapacity(kubeClient, instanceID, "1Gi")
+	}
+
+	// use sets the usage of an instance in MiB
+	use := func(instanceID string, used, available int64) {
+		usageSource[instanceID] = &usage.Usage{UsedBytes: used * mebibyte, AvailableBytes: available * mebibyte}
+	}
+
+	requested := func(instanceID string) string {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").Get(context.Background(), instanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		quantity := pvc.Spec.Resources.Requests["storage"]
+		return quantity.String()
+	}
+
+	BeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset()
+		recorder = record.NewFakeRecorder(20)
+		usageSource = fakeUsageSource{}
+		brokerMetrics = metrics.New(prometheus.NewRegistry())
+
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: config.Config{
+				Namespace: "eirini",
+				ServiceConfiguration: config.ServiceConfiguration{
+					ServiceID: "service-id",
+					Plans: []config.Plan{
+						{ID: "fixed", StorageClass: &storageClass, DefaultSize: "1Gi"},
+						{
+							ID:           "growing",
+							StorageClass: &storageClass,
+							DefaultSize:  "1Gi",
+							MaxSize:      "3Gi",
+							Autogrow: &config.AutogrowPolicy{
+								Threshold: 0.9,
+								Increment: "1536Mi",
+								Ceiling:   "5Gi",
+							},
+						},
+					},
+				},
+			},
+			Recorder: recorder,
+			Usage:    usageSource,
+		}
+
+		volumeMonitor = &monitor.Monitor{
+			Broker:  testBroker,
+			Metrics: brokerMetrics,
+			Config:  config.MonitorConfiguration{Thresholds: []float64{0.95, 0.8}},
+			Logger:  lagertest.NewTestLogger("monitor"),
+		}
+
+		provision("fixed-instance", "fixed")
+		provision("growing-instance", "growing")
+		drainEvents()
+	})
+
+	It("records the volume usage of every instance", func() {
+		use("fixed-instance", 250, 750)
+
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+		Expect(testutil.ToFloat64(brokerMetrics.VolumeUsedBytes.WithLabelValues("fixed-instance", "fixed"))).To(Equal(250.0 * mebibyte))
+		Expect(testutil.ToFloat64(brokerMetrics.VolumeAvailableBytes.WithLabelValues("fixed-instance", "fixed"))).To(Equal(750.0 * mebibyte))
+		Expect(testutil.ToFloat64(brokerMetrics.VolumeUsageRatio.WithLabelValues("fixed-instance", "fixed"))).To(Equal(0.25))
+		Expect(testutil.CollectAndCount(brokerMetrics.VolumeUsageRatio)).To(Equal(1))
+		Expect(drainEvents()).To(BeEmpty())
+	})
+
+	It("keeps the usage of all instances while checking", func() {
+		use("fixed-instance", 250, 750)
+		use("growing-instance", 100, 900)
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+		var counts []int
+		testBroker.Usage = observedUsageSource{fakeUsageSource: usageSource, observe: func() {
+			counts = append(counts, testutil.CollectAndCount(brokerMetrics.VolumeUsageRatio))
+		}}
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+		Expect(counts).To(Equal([]int{2, 2}))
+	})
+
+	It("drops the usage of deleted instances", func() {
+		use("fixed-instance", 250, 750)
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+		_, err := testBroker.Deprovision(context.Background(), "fixed-instance", brokerapi.DeprovisionDetails{}, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+		Expect(testutil.CollectAndCount(brokerMetrics.VolumeUsageRatio)).To(Equal(0))
+	})
+
+	It("warns once when usage crosses a threshold", func() {
+		use("fixed-instance", 850, 150)
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+		Expect(drainEvents()).To(ConsistOf("Warning LowSpace Service instance fixed-instance is 85% full, 850Mi of 1000Mi used"))
+		Expect(testutil.ToFloat64(brokerMetrics.ThresholdCrossings.WithLabelValues("0.8"))).To(Equal(1.0))
+
+		use("fixed-instance", 960, 40)
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+		Expect(drainEvents()).To(ConsistOf(HavePrefix("Warning LowSpace Service instance fixed-instance is 96% full")))
+		Expect(testutil.ToFloat64(brokerMetrics.ThresholdCrossings.WithLabelValues("0.95"))).To(Equal(1.0))
+	})
+
+	It("warns again after usage dropped", func() {
+		use("fixed-instance", 850, 150)
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+		use("fixed-instance", 100, 900)
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+		use("fixed-instance", 850, 150)
+		Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+		Expect(drainEvents()).To(HaveLen(2))
+	})
+
+	Describe("autogrow", func() {
+		It("doesn't grow plans without a policy", func() {
+			use("fixed-instance", 990, 10)
+			Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+			Expect(requested("fixed-instance")).To(Equal("1Gi"))
+		})
+
+		It("doesn't grow below the threshold", func() {
+			use("growing-instance", 850, 150)
+			Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+			Expect(requested("growing-instance")).To(Equal("1Gi"))
+		})
+
+		It("grows the volume by the increment", func() {
+			use("growing-instance", 950, 50)
+			Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+			Expect(requested("growing-instance")).To(Equal("2560Mi"))
+			Expect(drainEvents()).To(ContainElement("Normal Resized Automatically resized service instance growing-instance from 1Gi to 2560Mi"))
+			Expect(testutil.ToFloat64(brokerMetrics.Autogrows.WithLabelValues(metrics.OutcomeSuccess))).To(Equal(1.0))
+		})
+
+		It("waits for a resize to finish", func() {
+			use("growing-instance", 950, 50)
+			Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+			Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+			Expect(requested("growing-instance")).To(Equal("2560Mi"))
+		})
+
+		It("doesn't grow beyond the plan's max size", func() {
+			use("growing-instance", 950, 50)
+			Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+			setCapacity(kubeClient, "growing-instance", "2560Mi")
+			Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+			Expect(requested("growing-instance")).To(Equal("3Gi"))
+
+			setCapacity(kubeClient, "growing-instance", "3Gi")
+			drainEvents()
+			Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+			Expect(volumeMonitor.Check(context.Background())).To(Succeed())
+
+			Expect(requested("growing-instance")).To(Equal("3Gi"))
+			Expect(drainEvents()).To(ConsistOf("Warning AutogrowLimitReached Service instance growing-instance can't grow beyond 3Gi"))
+		})
+	})
+})
+
+// setCapacity sets the capacity of a claim like the persistent volume controller would
+func setCapacity(kubeClient *fake.Clientset, instanceID, capacity string) {
+	pvc, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").Get(context.Background(), instanceID, metav1.GetOptions{})
+	Expect(err).NotTo(HaveOccurred())
+
+	pvc.Status.Phase = corev1.ClaimBound
+	pvc.Status.Capacity = corev1.ResourceList{"storage": resource.MustParse(capacity)}
+	_, err = kubeClient.CoreV1().PersistentVolumeClaims("eirini").UpdateStatus(context.Background(), pvc, metav1.UpdateOptions{})
+	Expect(err).NotTo(HaveOccurred())
+}
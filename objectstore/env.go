@@ -0,0 +1,32 @@
+package objectstore
+
+// Environment variables a Config is passed to jobs in
+const (
+	EndpointEnv        = "PERSI_S3_ENDPOINT"
+	RegionEnv          = "PERSI_S3_REGION"
+	BucketEnv          = "PERSI_S3_BUCKET"
+	AccessKeyIDEnv     = "PERSI_S3_ACCESS_KEY_ID"
+	SecretAccessKeyEnv = "PERSI_S3_SECRET_ACCESS_KEY"
+)
+
+// Env returns the environment variables holding c
+func (c Config) Env() map[string]string {
+	return map[string]string{
+		EndpointEnv:        c.Endpoint,
+		RegionEnv:          c.Region,
+		BucketEnv:          c.Bucket,
+		AccessKeyIDEnv:     c.AccessKeyID,
+		SecretAccessKeyEnv: c.SecretAccessKey,
+	}
+}
+
+// ConfigFromEnv reads a Config from the environment variables getenv returns
+func ConfigFromEnv(getenv func(string) string) Config {
+	return Config{
+		Endpoint:        getenv(EndpointEnv),
+		Region:          getenv(RegionEnv),
+		Bucket:          getenv(BucketEnv),
+		AccessKeyID:     getenv(AccessKeyIDEnv),
+		SecretAccessKey: getenv(SecretAccessKeyEnv),
+	}
+}
@@ -0,0 +1,139 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPartSize is the size of the parts objects are uploaded in. Stores
+// require at least 5 MiB for all parts but the last.
+const DefaultPartSize = 16 << 20
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// completeMultipartUploadResult is either a result or, if the upload failed
+// after the store started responding, an error
+type completeMultipartUploadResult struct {
+	XMLName xml.Name
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// Put stores the content read from r as object key with the given user
+// metadata. The content is uploaded in parts, so its size needn't be known
+// in advance; failed uploads are aborted.
+func (c *Client) Put(ctx context.Context, key string, r io.Reader, metadata map[string]string) error {
+	header := http.Header{}
+	for name, value := range metadata {
+		header.Set(metadataPrefix+name, value)
+	}
+
+	response, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return errors.Wrapf(err, "error starting upload of object %s", key)
+	}
+	var upload initiateMultipartUploadResult
+	err = decodeXML(response, &upload)
+	if err != nil {
+		return errors.Wrapf(err, "error starting upload of object %s", key)
+	}
+
+	if err := c.uploadParts(ctx, key, upload.UploadID, r); err != nil {
+		// Stores keep the parts of uploads that are neither completed nor
+		// aborted
+		if response, abortErr := c.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {upload.UploadID}}, nil, nil); abortErr == nil {
+			response.Body.Close()
+		}
+		return errors.Wrapf(err, "error uploading object %s", key)
+	}
+
+	return nil
+}
+
+// uploadParts uploads the content read from r in parts and completes the
+// upload
+func (c *Client) uploadParts(ctx context.Context, key, uploadID string, r io.Reader) error {
+	partSize := c.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+
+	buffer := make([]byte, partSize)
+	var parts []completedPart
+	for {
+		n, readErr := io.ReadFull(r, buffer)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return errors.Wrap(readErr, "error reading content")
+		}
+		// Empty objects are uploaded as a single empty part
+		if n == 0 && len(parts) > 0 {
+			break
+		}
+
+		number := len(parts) + 1
+		response, err := c.do(ctx, http.MethodPut, key, url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}, nil, bytes.NewReader(buffer[:n]))
+		if err != nil {
+			return errors.Wrapf(err, "error uploading part %d", number)
+		}
+		response.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: response.Header.Get("ETag")})
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return errors.Wrap(err, "error marshaling parts")
+	}
+
+	response, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "error completing upload")
+	}
+	var result completeMultipartUploadResult
+	if err := decodeXML(response, &result); err != nil {
+		return errors.Wrap(err, "error completing upload")
+	}
+	if result.XMLName.Local == "Error" {
+		return &StatusError{StatusCode: http.StatusInternalServerError, Message: result.Code + ": " + result.Message}
+	}
+
+	return nil
+}
+
+// decodeXML decodes and closes the body of response
+func decodeXML(response *http.Response, v interface{}) error {
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return errors.Wrap(err, "error reading response")
+	}
+
+	return errors.Wrap(xml.Unmarshal(body, v), "error decoding response")
+}
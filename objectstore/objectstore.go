@@ -0,0 +1,150 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRegion is signed for if no region is configured. S3 compatible
+// stores like MinIO accept it.
+const DefaultRegion = "us-east-1"
+
+// metadataPrefix starts the headers holding the user metadata of objects
+const metadataPrefix = "x-amz-meta-"
+
+// Config is the location of a bucket in an S3 compatible object store and
+// the credentials to access it with
+type Config struct {
+	Endpoint        string `yaml:"endpoint" json:"endpoint"`
+	Region          string `yaml:"region" json:"region"`
+	Bucket          string `yaml:"bucket" json:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id" json:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key" json:"secret_access_key"`
+}
+
+// Client accesses the objects of a bucket. Buckets are addressed by path, so
+// the endpoint doesn't need a DNS name per bucket. Objects are uploaded in
+// parts of PartSize bytes, DefaultPartSize if it's zero.
+type Client struct {
+	Config     Config
+	HTTPClient *http.Client
+	PartSize   int
+}
+
+// StatusError is returned when the store responds with an unexpected status
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return "object store responded with " + http.StatusText(e.StatusCode) + ": " + e.Message
+}
+
+// IsNotFound returns true if err reports a missing object or bucket
+func IsNotFound(err error) bool {
+	statusErr, ok := errors.Cause(err).(*StatusError)
+	return ok && statusErr.StatusCode == http.StatusNotFound
+}
+
+// Object describes a stored object. Metadata holds the user metadata of the
+// object by lower case names.
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// Get returns the content of the object key. The caller must close it.
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	response, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting object %s", key)
+	}
+
+	return response.Body, nil
+}
+
+// Stat returns the size and metadata of the object key
+func (c *Client) Stat(ctx context.Context, key string) (*Object, error) {
+	response, err := c.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting object %s", key)
+	}
+	response.Body.Close()
+
+	object := &Object{Key: key, Size: response.ContentLength, Metadata: map[string]string{}}
+	object.LastModified, _ = http.ParseTime(response.Header.Get("Last-Modified"))
+	for name, values := range response.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, metadataPrefix) && len(values) > 0 {
+			object.Metadata[strings.TrimPrefix(name, metadataPrefix)] = values[0]
+		}
+	}
+
+	return object, nil
+}
+
+// Delete deletes the object key. Deleting missing objects succeeds.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	response, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting object %s", key)
+	}
+
+	return response.Body.Close()
+}
+
+// do sends a signed request for key and returns the response if it succeeded
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequest(method, c.objectURL(key, query), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+	request = request.WithContext(ctx)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	Sign(request, c.Config, UnsignedPayload, time.Now())
+
+	response, err := c.httpClient().Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		defer response.Body.Close()
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return nil, &StatusError{StatusCode: response.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	return response, nil
+}
+
+// objectURL returns the path style URL of key
+func (c *Client) objectURL(key string, query url.Values) string {
+	u := strings.TrimSuffix(c.Config.Endpoint, "/") + "/" + escapePath(c.Config.Bucket)
+	if key != "" {
+		u += "/" + escapePath(key)
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	return u
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
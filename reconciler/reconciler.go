@@ -0,0 +1,394 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+)
+
+// DefaultInterval is the time between two reconciliations when no interval is configured
+const DefaultInterval = 10 * time.Minute
+
+// DefaultPendingTimeout is the time after which a pending claim is reported
+// when no timeout is configured
+const DefaultPendingTimeout = 15 * time.Minute
+
+// Kinds of issues found by the reconciler. They are also the reasons of the
+// events recorded for them.
+const (
+	KindMissingLabels  = "MissingLabels"
+	KindUnknownPlan    = "UnknownPlan"
+	KindStuckPending   = "StuckPending"
+	KindReleasedVolume = "ReleasedVolume"
+	// KindUnmountedBinding is a binding whose claim no pod or stateful set
+	// mounts. That's normal for stopped apps, apps scaled to zero and apps
+	// that haven't been started since they were bound, so it's only reported
+	// with normal events and never repaired.
+	KindUnmountedBinding = "UnmountedBinding"
+)
+
+// Kinds lists all kinds of issues
+var Kinds = []string{KindMissingLabels, KindUnknownPlan, KindStuckPending, KindReleasedVolume, KindUnmountedBinding}
+
+// ReasonRepaired is the reason of the events recorded for repaired issues
+const ReasonRepaired = "Repaired"
+
+// Issue is an orphaned or drifted resource found by the reconciler
+type Issue struct {
+	Kind       string `json:"kind"`
+	Resource   string `json:"resource"`
+	Name       string `json:"name"`
+	InstanceID string `json:"instance_id,omitempty"`
+	BindingID  string `json:"binding_id,omitempty"`
+	Message    string `json:"message"`
+	Repaired   bool   `json:"repaired"`
+}
+
+// Reconciler periodically compares the persistent volume claims of the broker
+// with the configuration and their consumers, and reports or repairs drift
+type Reconciler struct {
+	Broker  *broker.KubeVolumeBroker
+	Metrics *metrics.Metrics
+	Config  config.ReconcilerConfiguration
+	Logger  lager.Logger
+
+	mutex sync.Mutex
+}
+
+// Run reconciles and purges the retained claims of deprovisioned instances
+// every interval until ctx is done
+func (r *Reconciler) Run(ctx context.Context) {
+	interval := r.Config.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Reconcile(ctx); err != nil {
+			r.Logger.Error("reconcile", err)
+		}
+
+		purged, err := r.Broker.PurgeDeleted(ctx)
+		if err != nil {
+			r.Logger.Error("purge", err)
+		}
+		if len(purged) > 0 {
+			r.Logger.Info("purged", lager.Data{"instances": purged})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile looks for issues once, repairs them if configured to and returns them
+func (r *Reconciler) Reconcile(ctx context.Context) ([]Issue, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	kubeCtx, cancel := context.WithTimeout(ctx, r.Broker.KubeTimeout())
+	defer cancel()
+
+	pvcs, err := r.listClaims(kubeCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	consumers, err := r.consumedClaims(kubeCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []Issue
+	for _, pvc := range pvcs {
+		issues = append(issues, r.checkClaim(ctx, pvc, consumers)...)
+	}
+
+	volumeIssues, err := r.checkVolumes(ctx, pvcs)
+	if err != nil {
+		return nil, err
+	}
+	issues = append(issues, volumeIssues...)
+
+	counts := map[string]float64{}
+	for _, issue := range issues {
+		if !issue.Repaired {
+			counts[issue.Kind]++
+		}
+	}
+	for _, kind := range Kinds {
+		r.Metrics.DriftIssues.WithLabelValues(kind).Set(counts[kind])
+	}
+
+	r.Logger.Info("reconciled", lager.Data{"issues": len(issues)})
+
+	return issues, nil
+}
+
+// listClaims returns the persistent volume claims of the instances that
+// aren't deleted: those with the labels of the service, and those with an
+// instance label that lost the others
+func (r *Reconciler) listClaims(ctx context.Context) ([]*corev1.PersistentVolumeClaim, error) {
+	claims := r.Broker.KubeClient.CoreV1().PersistentVolumeClaims(r.Broker.Config.Namespace)
+
+	var pvcs []*corev1.PersistentVolumeClaim
+	seen := map[string]bool{}
+	for _, selector := range []string{broker.InstanceSelector(r.Broker.Config), broker.InstanceIDSelector()} {
+		list, err := claims.List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, errors.Wrap(err, "error listing persistent volume claims")
+		}
+
+		for i := range list.Items {
+			pvc := &list.Items[i]
+			serviceID, hasService := pvc.Labels[broker.ServiceIDLabel]
+			if seen[pvc.Name] || (hasService && serviceID != r.Broker.Config.ServiceConfiguration.ServiceID) {
+				continue
+			}
+			seen[pvc.Name] = true
+			pvcs = append(pvcs, pvc)
+		}
+	}
+
+	return pvcs, nil
+}
+
+// checkClaim reports the issues of an instance persistent volume claim
+func (r *Reconciler) checkClaim(ctx context.Context, pvc *corev1.PersistentVolumeClaim, consumers map[string]bool) []Issue {
+	var issues []Issue
+
+	planID, hasPlan := pvc.Labels[broker.PlanIDLabel]
+	switch {
+	case !hasPlan || pvc.Labels[broker.ServiceIDLabel] == "" ||
+		pvc.Labels[broker.OrganizationIDLabel] == "" || pvc.Labels[broker.SpaceIDLabel] == "":
+		issue := r.newClaimIssue(pvc, KindMissingLabels, "Persistent volume claim is missing broker labels")
+		if !hasPlan {
+			issue.Repaired = r.repairPlanLabel(ctx, pvc)
+		}
+		issues = append(issues, issue)
+	case r.Broker.Plan(planID) == nil:
+		issues = append(issues, r.newClaimIssue(pvc, KindUnknownPlan, fmt.Sprintf("Plan %s isn't configured", planID)))
+	}
+
+	pendingTimeout := r.Config.PendingTimeout
+	if pendingTimeout == 0 {
+		pendingTimeout = DefaultPendingTimeout
+	}
+	if pvc.Status.Phase == corev1.ClaimPending && time.Since(pvc.CreationTimestamp.Time) > pendingTimeout {
+		issues = append(issues, r.newClaimIssue(pvc, KindStuckPending, fmt.Sprintf("Persistent volume claim has been pending for more than %s", pendingTimeout)))
+	}
+
+	if !consumers[pvc.Name] {
+		for annotation := range pvc.Annotations {
+			if !broker.IsBindingIDAnnotation(annotation) {
+				continue
+			}
+
+			issue := r.newClaimIssue(pvc, KindUnmountedBinding, "No pod or stateful set mounts the persistent volume claim of the binding")
+			issue.BindingID = broker.BindingIDFromAnnotation(annotation)
+			issues = append(issues, issue)
+		}
+	}
+
+	for _, issue := range issues {
+		r.report(pvc, issue)
+	}
+
+	return issues
+}
+
+// checkVolumes labels the persistent volumes bound to pvcs and reports the
+// released volumes of the broker. Only volumes labelled by the broker are
+// considered, the claims that released the others may never have been the
+// broker's.
+func (r *Reconciler) checkVolumes(ctx context.Context, pvcs []*corev1.PersistentVolumeClaim) ([]Issue, error) {
+	kubeCtx, cancel := context.WithTimeout(ctx, r.Broker.KubeTimeout())
+	defer cancel()
+
+	pvs, err := r.Broker.KubeClient.CoreV1().PersistentVolumes().List(kubeCtx, metav1.ListOptions{
+		LabelSelector: broker.VolumeSelector(r.Broker.Config),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing persistent volumes")
+	}
+
+	labelled := map[string]bool{}
+	for _, pv := range pvs.Items {
+		labelled[pv.Name] = true
+	}
+	for _, pvc := range pvcs {
+		if pvc.Spec.VolumeName == "" || labelled[pvc.Spec.VolumeName] {
+			continue
+		}
+		if err := r.Broker.LabelVolume(ctx, pvc); err != nil {
+			r.Logger.Error("label-volume", err, lager.Data{"instance-id": broker.InstanceID(pvc)})
+		}
+	}
+
+	var issues []Issue
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Status.Phase != corev1.VolumeReleased || pv.Spec.ClaimRef == nil {
+			continue
+		}
+
+		instanceID := pv.Labels[broker.InstanceIDLabel]
+		issue := Issue{
+			Kind:       KindReleasedVolume,
+			Resource:   "persistentvolume",
+			Name:       pv.Name,
+			InstanceID: instanceID,
+			Message:    fmt.Sprintf("Persistent volume was released by claim %s of instance %s and isn't used anymore", pv.Spec.ClaimRef.Name, instanceID),
+		}
+		if pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain {
+			issue.Message += ", it's kept because of its Retain reclaim policy"
+		} else {
+			issue.Repaired = r.repairReleasedVolume(ctx, pv)
+		}
+		r.report(pv, issue)
+		issues = append(issues, issue)
+	}
+
+	return issues, nil
+}
+
+// consumedClaims returns the names of the claims mounted by pods or stateful sets
+func (r *Reconciler) consumedClaims(ctx context.Context) (map[string]bool, error) {
+	namespace := r.Broker.Config.Namespace
+	consumers := map[string]bool{}
+
+	addVolumes := func(volumes []corev1.Volume) {
+		for _, volume := range volumes {
+			if volume.PersistentVolumeClaim != nil {
+				consumers[volume.PersistentVolumeClaim.ClaimName] = true
+			}
+		}
+	}
+
+	pods, err := r.Broker.KubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing pods")
+	}
+	for _, pod := range pods.Items {
+		addVolumes(pod.Spec.Volumes)
+	}
+
+	statefulSets, err := r.Broker.KubeClient.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing stateful sets")
+	}
+	for _, statefulSet := range statefulSets.Items {
+		addVolumes(statefulSet.Spec.Template.Spec.Volumes)
+	}
+
+	return consumers, nil
+}
+
+// repairPlanLabel restores a missing plan label if only one plan uses the
+// storage class of the claim
+func (r *Reconciler) repairPlanLabel(ctx context.Context, pvc *corev1.PersistentVolumeClaim) bool {
+	if !r.Config.Repair || pvc.Spec.StorageClassName == nil {
+		return false
+	}
+
+	var candidates []string
+	for _, plan := range r.Broker.Config.ServiceConfiguration.Plans {
+		if plan.StorageClass != nil && *plan.StorageClass == *pvc.Spec.StorageClassName {
+			candidates = append(candidates, plan.ID)
+		}
+	}
+	if len(candidates) != 1 {
+		return false
+	}
+
+	return r.repair(ctx, KindMissingLabels, pvc, "Restored plan label "+candidates[0], func(ctx context.Context) error {
+		patch := fmt.Sprintf(`{"metadata":{"labels":{%q:%q}}}`, broker.PlanIDLabel, candidates[0])
+		_, err := r.Broker.KubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(ctx, pvc.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+		return err
+	})
+}
+
+// repairReleasedVolume deletes a released persistent volume. The storage
+// backing it is deleted or recycled according to its reclaim policy; volumes
+// with the Retain policy are never repaired.
+func (r *Reconciler) repairReleasedVolume(ctx context.Context, pv *corev1.PersistentVolume) bool {
+	if !r.Config.Repair {
+		return false
+	}
+
+	return r.repair(ctx, KindReleasedVolume, pv, "Deleted released persistent volume "+pv.Name, func(ctx context.Context) error {
+		return r.Broker.KubeClient.CoreV1().PersistentVolumes().Delete(ctx, pv.Name, metav1.DeleteOptions{})
+	})
+}
+
+func (r *Reconciler) repair(ctx context.Context, kind string, object runtime.Object, message string, repair func(context.Context) error) bool {
+	kubeCtx, cancel := context.WithTimeout(ctx, r.Broker.KubeTimeout())
+	defer cancel()
+
+	if err := repair(kubeCtx); err != nil {
+		r.Logger.Error("repair", err, lager.Data{"kind": kind, "message": message})
+		r.Metrics.Repairs.WithLabelValues(kind, metrics.OutcomeFailure).Inc()
+		return false
+	}
+
+	r.Logger.Info("repair", lager.Data{"kind": kind, "message": message})
+	r.Metrics.Repairs.WithLabelValues(kind, metrics.OutcomeSuccess).Inc()
+	if r.Broker.Recorder != nil {
+		r.Broker.Recorder.Event(object, corev1.EventTypeNormal, ReasonRepaired, message)
+	}
+
+	return true
+}
+
+// report logs an issue and records an event for it, unless it was repaired.
+// Events are warnings unless the issue may be expected.
+func (r *Reconciler) report(object runtime.Object, issue Issue) {
+	if issue.Repaired {
+		return
+	}
+
+	r.Logger.Info("issue", lager.Data{
+		"kind":        issue.Kind,
+		"resource":    issue.Resource,
+		"name":        issue.Name,
+		"instance-id": issue.InstanceID,
+		"binding-id":  issue.BindingID,
+		"message":     issue.Message,
+	})
+
+	if r.Broker.Recorder != nil {
+		eventType := corev1.EventTypeWarning
+		if issue.Kind == KindUnmountedBinding {
+			eventType = corev1.EventTypeNormal
+		}
+		r.Broker.Recorder.Event(object, eventType, issue.Kind, issue.Message)
+	}
+}
+
+func (r *Reconciler) newClaimIssue(pvc *corev1.PersistentVolumeClaim, kind, message string) Issue {
+	return Issue{
+		Kind:       kind,
+		Resource:   "persistentvolumeclaim",
+		Name:       pvc.Name,
+		InstanceID: broker.InstanceID(pvc),
+		Message:    message,
+	}
+}
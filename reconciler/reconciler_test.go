@@ -0,0 +1,304 @@
+package reconciler_test
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+	"code.cloudfoundry.org/eirini-persi-broker/reconciler"
+)
+
+var (
+	gold   = "gold"
+	silver = "silver"
+)
+
+func claim(name string, labels map[string]string, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "eirini",
+			Labels:            labels,
+			Annotations:       annotations,
+			CreationTimestamp: metav1.Now(),
+		},
+		Spec:   corev1.PersistentVolumeClaimSpec{StorageClassName: &gold},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+}
+
+func instanceLabels(planID string) map[string]string {
+	return map[string]string{
+		broker.ServiceIDLabel:      "service-id",
+		broker.PlanIDLabel:         planID,
+		broker.OrganizationIDLabel: "org",
+		broker.SpaceIDLabel:        "space",
+	}
+}
+
+func statefulSetMounting(claimName string) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-" + claimName, Namespace: "eirini"},
+		Spec: appsv1.StatefulSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+var _ = Describe("Reconciler", func() {
+	var (
+		objects       []runtime.Object
+		kubeClient    *fake.Clientset
+		recorder      *record.FakeRecorder
+		brokerMetrics *metrics.Metrics
+		driftRecon    *reconciler.Reconciler
+		repair        bool
+		issues        []reconciler.Issue
+	)
+
+	BeforeEach(func() {
+		repair = false
+		objects = []runtime.Object{
+			claim("healthy", instanceLabels("gold-plan"), map[string]string{"eirini-broker-binding-b1": "/data"}),
+			statefulSetMounting("healthy"),
+		}
+	})
+
+	JustBeforeEach(func() {
+		kubeClient = fake.NewSimpleClientset(objects...)
+		recorder = record.NewFakeRecorder(20)
+		brokerMetrics = metrics.New(prometheus.NewRegistry())
+
+		driftRecon = &reconciler.Reconciler{
+			Broker: &broker.KubeVolumeBroker{
+				KubeClient: kubeClient,
+				Config: config.Config{
+					Namespace: "eirini",
+					ServiceConfiguration: config.ServiceConfiguration{
+						ServiceID: "service-id",
+						Plans: []config.Plan{
+							{ID: "gold-plan", StorageClass: &gold},
+							{ID: "silver-plan", StorageClass: &silver},
+							{ID: "other-silver-plan", StorageClass: &silver},
+						},
+					},
+				},
+				Recorder: recorder,
+			},
+			Metrics: brokerMetrics,
+			Config:  config.ReconcilerConfiguration{PendingTimeout: time.Hour, Repair: repair},
+			Logger:  lagertest.NewTestLogger("reconciler"),
+		}
+
+		var err error
+		issues, err = driftRecon.Reconcile(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	getClaim := func(name string) *corev1.PersistentVolumeClaim {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").Get(context.Background(), name, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pvc
+	}
+
+	It("finds no issues for healthy instances", func() {
+		Expect(issues).To(BeEmpty())
+		Expect(recorder.Events).To(BeEmpty())
+		Expect(testutil.ToFloat64(brokerMetrics.DriftIssues.WithLabelValues(reconciler.KindUnmountedBinding))).To(Equal(0.0))
+	})
+
+	Context("with drifted claims", func() {
+		BeforeEach(func() {
+			missingPlan := instanceLabels("")
+			delete(missingPlan, broker.PlanIDLabel)
+
+			pending := claim("pending", instanceLabels("gold-plan"), nil)
+			pending.Status.Phase = corev1.ClaimPending
+			pending.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+
+			recentlyPending := claim("recently-pending", instanceLabels("gold-plan"), nil)
+			recentlyPending.Status.Phase = corev1.ClaimPending
+
+			objects = append(objects,
+				claim("missing-plan", missingPlan, nil),
+				claim("unknown-plan", instanceLabels("bronze-plan"), nil),
+				claim("unmounted", instanceLabels("gold-plan"), map[string]string{"eirini-broker-binding-b2": "/data"}),
+				pending,
+				recentlyPending,
+				claim("not-ours", map[string]string{broker.ServiceIDLabel: "other-service"}, nil),
+				claim("instance-label-only", map[string]string{
+					broker.InstanceIDLabel:     "labelled",
+					broker.PlanIDLabel:         "gold-plan",
+					broker.OrganizationIDLabel: "org",
+					broker.SpaceIDLabel:        "space",
+				}, nil),
+				claim("other-service-instance", map[string]string{broker.ServiceIDLabel: "other-service", broker.InstanceIDLabel: "other"}, nil),
+			)
+		})
+
+		It("reports every issue", func() {
+			Expect(issues).To(ConsistOf(
+				MatchFields(IgnoreExtras, Fields{"Kind": Equal(reconciler.KindMissingLabels), "Name": Equal("missing-plan"), "Repaired": BeFalse()}),
+				MatchFields(IgnoreExtras, Fields{"Kind": Equal(reconciler.KindUnknownPlan), "Name": Equal("unknown-plan")}),
+				MatchFields(IgnoreExtras, Fields{"Kind": Equal(reconciler.KindUnmountedBinding), "Name": Equal("unmounted"), "BindingID": Equal("b2"), "Repaired": BeFalse()}),
+				MatchFields(IgnoreExtras, Fields{"Kind": Equal(reconciler.KindStuckPending), "Name": Equal("pending")}),
+				MatchFields(IgnoreExtras, Fields{"Kind": Equal(reconciler.KindMissingLabels), "Name": Equal("instance-label-only"), "InstanceID": Equal("labelled")}),
+			))
+
+			Expect(testutil.ToFloat64(brokerMetrics.DriftIssues.WithLabelValues(reconciler.KindUnmountedBinding))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(brokerMetrics.DriftIssues.WithLabelValues(reconciler.KindReleasedVolume))).To(Equal(0.0))
+			Expect(recorder.Events).To(HaveLen(5))
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			Expect(events).To(ContainElement(HavePrefix("Warning MissingLabels ")))
+			Expect(events).To(ContainElement(HavePrefix("Normal UnmountedBinding ")))
+		})
+
+		It("doesn't change anything without repair", func() {
+			Expect(getClaim("missing-plan").Labels).NotTo(HaveKey(broker.PlanIDLabel))
+			Expect(getClaim("unmounted").Annotations).To(HaveKey("eirini-broker-binding-b2"))
+		})
+
+		Context("with repair", func() {
+			BeforeEach(func() {
+				repair = true
+			})
+
+			It("restores plan labels", func() {
+				Expect(getClaim("missing-plan").Labels).To(HaveKeyWithValue(broker.PlanIDLabel, "gold-plan"))
+				Expect(testutil.ToFloat64(brokerMetrics.Repairs.WithLabelValues(reconciler.KindMissingLabels, metrics.OutcomeSuccess))).To(Equal(1.0))
+			})
+
+			It("keeps bindings of claims nobody mounts", func() {
+				Expect(getClaim("unmounted").Annotations).To(HaveKey("eirini-broker-binding-b2"))
+				Expect(testutil.ToFloat64(brokerMetrics.DriftIssues.WithLabelValues(reconciler.KindUnmountedBinding))).To(Equal(1.0))
+				Expect(issues).To(ContainElement(MatchFields(IgnoreExtras, Fields{"Kind": Equal(reconciler.KindUnmountedBinding), "Repaired": BeFalse()})))
+			})
+		})
+	})
+
+	Context("with an ambiguous storage class", func() {
+		BeforeEach(func() {
+			repair = true
+			labels := instanceLabels("")
+			delete(labels, broker.PlanIDLabel)
+			pvc := claim("ambiguous", labels, nil)
+			pvc.Spec.StorageClassName = &silver
+			objects = append(objects, pvc, statefulSetMounting("ambiguous"))
+		})
+
+		It("doesn't guess the plan", func() {
+			Expect(issues).To(ConsistOf(MatchFields(IgnoreExtras, Fields{"Kind": Equal(reconciler.KindMissingLabels), "Repaired": BeFalse()})))
+			Expect(getClaim("ambiguous").Labels).NotTo(HaveKey(broker.PlanIDLabel))
+		})
+	})
+
+	Context("with bound volumes", func() {
+		BeforeEach(func() {
+			pvc := claim("named-claim", instanceLabels("gold-plan"), nil)
+			pvc.Labels[broker.InstanceIDLabel] = "bound-instance"
+			pvc.Spec.VolumeName = "pv-bound"
+			objects = append(objects, pvc, statefulSetMounting("named-claim"),
+				&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-bound"}})
+		})
+
+		It("labels them with their instance", func() {
+			pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), "pv-bound", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pv.Labels).To(Equal(map[string]string{
+				broker.ServiceIDLabel:  "service-id",
+				broker.InstanceIDLabel: "bound-instance",
+			}))
+		})
+	})
+
+	Context("with released volumes", func() {
+		BeforeEach(func() {
+			released := func(name string, labels map[string]string, policy corev1.PersistentVolumeReclaimPolicy) *corev1.PersistentVolume {
+				return &corev1.PersistentVolume{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+					Spec: corev1.PersistentVolumeSpec{
+						StorageClassName:              gold,
+						PersistentVolumeReclaimPolicy: policy,
+						ClaimRef:                      &corev1.ObjectReference{Namespace: "eirini", Name: "claim-" + name},
+					},
+					Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeReleased},
+				}
+			}
+			ours := func(instanceID string) map[string]string {
+				return map[string]string{broker.ServiceIDLabel: "service-id", broker.InstanceIDLabel: instanceID}
+			}
+
+			objects = append(objects,
+				released("pv-ours", ours("deleted-instance"), corev1.PersistentVolumeReclaimDelete),
+				released("pv-retained", ours("retained-instance"), corev1.PersistentVolumeReclaimRetain),
+				released("pv-unlabelled", nil, corev1.PersistentVolumeReclaimDelete),
+				released("pv-other-service", map[string]string{broker.ServiceIDLabel: "other-service", broker.InstanceIDLabel: "other"}, corev1.PersistentVolumeReclaimDelete),
+			)
+		})
+
+		It("reports volumes released by the broker's claims", func() {
+			Expect(issues).To(ConsistOf(
+				MatchFields(IgnoreExtras, Fields{
+					"Kind":       Equal(reconciler.KindReleasedVolume),
+					"Name":       Equal("pv-ours"),
+					"InstanceID": Equal("deleted-instance"),
+					"Message":    ContainSubstring("released by claim claim-pv-ours of instance deleted-instance"),
+				}),
+				MatchFields(IgnoreExtras, Fields{
+					"Kind":       Equal(reconciler.KindReleasedVolume),
+					"Name":       Equal("pv-retained"),
+					"InstanceID": Equal("retained-instance"),
+				}),
+			))
+		})
+
+		Context("with repair", func() {
+			BeforeEach(func() {
+				repair = true
+			})
+
+			It("deletes them unless they're retained", func() {
+				pvs, err := kubeClient.CoreV1().PersistentVolumes().List(context.Background(), metav1.ListOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				var names []string
+				for _, pv := range pvs.Items {
+					names = append(names, pv.Name)
+				}
+				Expect(names).To(ConsistOf("pv-retained", "pv-unlabelled", "pv-other-service"))
+				Expect(<-recorder.Events).To(Equal("Normal Repaired Deleted released persistent volume pv-ours"))
+				Expect(issues).To(ContainElement(MatchFields(IgnoreExtras, Fields{
+					"Name":     Equal("pv-retained"),
+					"Repaired": BeFalse(),
+					"Message":  ContainSubstring("Retain reclaim policy"),
+				})))
+			})
+		})
+	})
+})
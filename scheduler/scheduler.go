@@ -0,0 +1,279 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+)
+
+// Interval is the time between two checks, the resolution of cron schedules
+const Interval = time.Minute
+
+// ReasonScheduledBackupFailed is the reason of the events recorded when a
+// scheduled backup can't be started. Backups that fail later are reported
+// by the broker.
+const ReasonScheduledBackupFailed = "ScheduledBackupFailed"
+
+// Scheduler backs up the instances of plans with a backup schedule, tracks
+// running backups and deletes the backups beyond the retention of a plan.
+// A backup is due once the schedule of its plan has passed a run since the
+// latest backup of an instance, so runs missed while no replica was leading
+// are caught up once.
+type Scheduler struct {
+	Broker  *broker.KubeVolumeBroker
+	Metrics *metrics.Metrics
+	Logger  lager.Logger
+
+	mutex sync.Mutex
+	// scheduled holds the ID of the running scheduled backup of each instance
+	scheduled map[string]string
+	// failed holds the time a scheduled backup of each instance last failed
+	// to start, so it's retried at the next run rather than at every check
+	failed map[string]time.Time
+	// invalid holds the plans whose invalid schedule was reported
+	invalid map[string]bool
+	// series holds the plan of the last success series of each instance
+	series map[string]string
+}
+
+// Run checks the backups of all instances every interval until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Check(ctx, time.Now()); err != nil {
+			s.Logger.Error("check", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check starts the backups due at now, records the state of running
+// backups and deletes expired backups of all instances once
+func (s *Scheduler) Check(ctx context.Context, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.scheduled == nil {
+		s.scheduled = map[string]string{}
+		s.failed = map[string]time.Time{}
+		s.invalid = map[string]bool{}
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, s.Broker.KubeTimeout())
+	defer cancel()
+
+	pvcs, err := s.Broker.KubeClient.CoreV1().PersistentVolumeClaims(s.Broker.Config.Namespace).List(listCtx, metav1.ListOptions{
+		LabelSelector: broker.InstanceSelector(s.Broker.Config),
+	})
+	if err != nil {
+		return err
+	}
+
+	schedules := s.schedules()
+	previous := s.series
+	s.series = map[string]string{}
+
+	seen := map[string]bool{}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		seen[broker.InstanceID(pvc)] = true
+		s.checkInstance(ctx, pvc, schedules[pvc.Labels[broker.PlanIDLabel]], now)
+	}
+
+	// Drop the series of deleted instances and of those without successful
+	// backups once all instances were checked, so scrapes during a check
+	// still see them
+	for instanceID, planID := range previous {
+		if s.series[instanceID] != planID {
+			s.Metrics.BackupLastSuccess.DeleteLabelValues(instanceID, planID)
+		}
+	}
+
+	for name := range s.failed {
+		if !seen[name] {
+			delete(s.failed, name)
+		}
+	}
+	for name := range s.scheduled {
+		if !seen[name] {
+			delete(s.scheduled, name)
+		}
+	}
+
+	return nil
+}
+
+// schedules returns the parsed backup schedules by plan ID
+func (s *Scheduler) schedules() map[string]cron.Schedule {
+	schedules := map[string]cron.Schedule{}
+	for _, plan := range s.Broker.Config.ServiceConfiguration.Plans {
+		if plan.BackupSchedule == "" {
+			continue
+		}
+
+		schedule, err := cron.ParseStandard(plan.BackupSchedule)
+		if err != nil {
+			if !s.invalid[plan.ID] {
+				s.invalid[plan.ID] = true
+				s.Logger.Error("invalid-backup-schedule", err, lager.Data{"plan-id": plan.ID})
+			}
+			continue
+		}
+		schedules[plan.ID] = schedule
+	}
+
+	return schedules
+}
+
+func (s *Scheduler) checkInstance(ctx context.Context, pvc *corev1.PersistentVolumeClaim, schedule cron.Schedule, now time.Time) {
+	instanceID := broker.InstanceID(pvc)
+	plan := s.Broker.Plan(pvc.Labels[broker.PlanIDLabel])
+	if plan == nil || plan.BackupStore == nil {
+		return
+	}
+
+	logger := s.Logger.Session("check-instance", lager.Data{"instance-id": instanceID})
+	backups := s.trackBackups(ctx, logger, pvc)
+
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].State == brokerapi.Succeeded {
+			s.Metrics.BackupLastSuccess.WithLabelValues(instanceID, plan.ID).Set(float64(backups[i].Time.Unix()))
+			s.series[instanceID] = plan.ID
+			break
+		}
+	}
+
+	s.expire(ctx, logger, pvc, plan, backups, now)
+
+	if schedule != nil {
+		s.backupIfDue(ctx, logger, pvc, schedule, backups, now)
+	}
+}
+
+// trackBackups records the state of the running backups of pvc and returns
+// its backups with their current state
+func (s *Scheduler) trackBackups(ctx context.Context, logger lager.Logger, pvc *corev1.PersistentVolumeClaim) []broker.Backup {
+	instanceID := broker.InstanceID(pvc)
+	backups := broker.Backups(pvc)
+	for i, backup := range backups {
+		if backup.State != brokerapi.InProgress {
+			continue
+		}
+
+		operation, err := s.Broker.BackupState(ctx, pvc, backup.ID)
+		if err != nil {
+			logger.Error("get-backup-state", err, lager.Data{"backup": backup.ID})
+			continue
+		}
+		backups[i].State = operation.State
+
+		if s.scheduled[instanceID] != backup.ID || operation.State == brokerapi.InProgress {
+			continue
+		}
+		delete(s.scheduled, instanceID)
+
+		if operation.State == brokerapi.Succeeded {
+			logger.Info("backed-up", lager.Data{"backup": backup.ID})
+			s.Metrics.ScheduledBackups.WithLabelValues(metrics.OutcomeSuccess).Inc()
+		} else {
+			logger.Info("backup-failed", lager.Data{"backup": backup.ID, "description": operation.Description})
+			s.Metrics.ScheduledBackups.WithLabelValues(metrics.OutcomeFailure).Inc()
+		}
+	}
+
+	return backups
+}
+
+// expire deletes the successful backups beyond the retention of plan and the
+// failed backups older than the latest successful one. The latest successful
+// backup is always kept.
+func (s *Scheduler) expire(ctx context.Context, logger lager.Logger, pvc *corev1.PersistentVolumeClaim, plan *config.Plan, backups []broker.Backup, now time.Time) {
+	retention := plan.BackupRetention
+	if retention == nil {
+		return
+	}
+
+	kept := 0
+	for i := len(backups) - 1; i >= 0; i-- {
+		backup := backups[i]
+
+		expired := false
+		switch backup.State {
+		case brokerapi.Succeeded:
+			kept++
+			expired = kept > 1 &&
+				((retention.Count > 0 && kept > retention.Count) ||
+					(retention.MaxAge > 0 && now.Sub(backup.Time) > retention.MaxAge))
+		case brokerapi.Failed:
+			expired = kept > 0
+		}
+		if !expired {
+			continue
+		}
+
+		if err := s.Broker.DeleteBackup(ctx, pvc, backup.ID); err != nil {
+			logger.Error("delete-backup", err, lager.Data{"backup": backup.ID})
+			continue
+		}
+		logger.Info("deleted-backup", lager.Data{"backup": backup.ID})
+	}
+}
+
+// backupIfDue starts a backup if schedule had a run since the latest backup
+// of pvc or, without backups, since pvc was created
+func (s *Scheduler) backupIfDue(ctx context.Context, logger lager.Logger, pvc *corev1.PersistentVolumeClaim, schedule cron.Schedule, backups []broker.Backup, now time.Time) {
+	instanceID := broker.InstanceID(pvc)
+	since := pvc.CreationTimestamp.Time
+	if len(backups) > 0 {
+		since = backups[len(backups)-1].Time
+	}
+	if failed := s.failed[instanceID]; failed.After(since) {
+		since = failed
+	}
+	if schedule.Next(since).After(now) {
+		return
+	}
+
+	id, err := s.Broker.Backup(ctx, pvc)
+	if err == brokerapi.ErrConcurrentInstanceAccess {
+		// Try again once the running job finished
+		logger.Debug("backup-postponed")
+		return
+	}
+	if err != nil {
+		logger.Error("backup", err)
+		s.failed[instanceID] = now
+		s.Metrics.ScheduledBackups.WithLabelValues(metrics.OutcomeFailure).Inc()
+		s.recordWarning(pvc, ReasonScheduledBackupFailed, "Failed to start scheduled backup of service instance "+instanceID+": "+err.Error())
+		return
+	}
+
+	logger.Info("backing-up", lager.Data{"backup": id})
+	s.scheduled[instanceID] = id
+	delete(s.failed, instanceID)
+}
+
+func (s *Scheduler) recordWarning(pvc *corev1.PersistentVolumeClaim, reason, message string) {
+	if s.Broker.Recorder == nil {
+		return
+	}
+
+	s.Broker.Recorder.Event(pvc, corev1.EventTypeWarning, reason, message)
+}
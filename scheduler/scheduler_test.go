@@ -0,0 +1,266 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	"code.cloudfoundry.org/eirini-persi-broker/broker"
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/metrics"
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
+	"code.cloudfoundry.org/eirini-persi-broker/scheduler"
+)
+
+var _ = Describe("Scheduler", func() {
+	var (
+		storageClass  = "storageClass"
+		kubeClient    *fake.Clientset
+		recorder      *record.FakeRecorder
+		brokerMetrics *metrics.Metrics
+		httpServer    *httptest.Server
+		deleted       []string
+		mutex         sync.Mutex
+		testBroker    *broker.KubeVolumeBroker
+		backups       *scheduler.Scheduler
+		now           time.Time
+	)
+
+	drainEvents := func() []string {
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		return events
+	}
+
+	getPVC := func(instanceID string) *corev1.PersistentVolumeClaim {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").Get(context.Background(), instanceID, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return pvc
+	}
+
+	// provision creates an instance of plan at created
+	provision := func(instanceID, planID string, created time.Time) {
+		_, err := testBroker.Provision(context.Background(), instanceID, brokerapi.ProvisionDetails{
+			ServiceID: "service-id",
+			PlanID:    planID,
+			SpaceGUID: "space-id",
+		}, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		pvc := getPVC(instanceID)
+		pvc.CreationTimestamp = metav1.NewTime(created)
+		_, err = kubeClient.CoreV1().PersistentVolumeClaims("eirini").Update(context.Background(), pvc, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	// recordBackup records a backup taken age before now on an instance
+	recordBackup := func(instanceID string, age time.Duration, state brokerapi.LastOperationState) string {
+		id := strconv.FormatInt(now.Add(-age).Unix(), 10)
+		pvc := getPVC(instanceID)
+		pvc.Annotations[broker.BackupAnnotation(id)] = string(state)
+		_, err := kubeClient.CoreV1().PersistentVolumeClaims("eirini").Update(context.Background(), pvc, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return id
+	}
+
+	listJobs := func() []batchv1.Job {
+		jobs, err := kubeClient.BatchV1().Jobs("eirini").List(context.Background(), metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return jobs.Items
+	}
+
+	finishJob := func(job batchv1.Job, conditionType batchv1.JobConditionType) {
+		job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: "Job has reached the specified backoff limit"}}
+		_, err := kubeClient.BatchV1().Jobs("eirini").UpdateStatus(context.Background(), &job, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		now = time.Now()
+		deleted = nil
+		httpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if r.Method == http.MethodDelete {
+				deleted = append(deleted, r.URL.Path)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		store := &objectstore.Config{Endpoint: httpServer.URL, Bucket: "backups"}
+
+		kubeClient = fake.NewSimpleClientset()
+		recorder = record.NewFakeRecorder(20)
+		brokerMetrics = metrics.New(prometheus.NewRegistry())
+
+		testBroker = &broker.KubeVolumeBroker{
+			KubeClient: kubeClient,
+			Config: config.Config{
+				Namespace: "eirini",
+				ServiceConfiguration: config.ServiceConfiguration{
+					ServiceID: "service-id",
+					Plans: []config.Plan{
+						{ID: "unprotected", StorageClass: &storageClass, DefaultSize: "1Gi"},
+						{ID: "nightly", StorageClass: &storageClass, DefaultSize: "1Gi", BackupStore: store, BackupSchedule: "0 2 * * *"},
+						{
+							ID:              "retained",
+							StorageClass:    &storageClass,
+							DefaultSize:     "1Gi",
+							BackupStore:     store,
+							BackupRetention: &config.RetentionPolicy{Count: 2},
+						},
+						{
+							ID:              "young",
+							StorageClass:    &storageClass,
+							DefaultSize:     "1Gi",
+							BackupStore:     store,
+							BackupRetention: &config.RetentionPolicy{MaxAge: time.Hour},
+						},
+					},
+				},
+				JobConfiguration: config.JobConfiguration{Image: "eirini/persi-broker"},
+			},
+			Recorder: recorder,
+		}
+
+		backups = &scheduler.Scheduler{
+			Broker:  testBroker,
+			Metrics: brokerMetrics,
+			Logger:  lagertest.NewTestLogger("scheduler"),
+		}
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+	})
+
+	Context("when a scheduled backup is due", func() {
+		BeforeEach(func() {
+			provision("nightly-instance", "nightly", now.Add(-25*time.Hour))
+			provision("unprotected-instance", "unprotected", now.Add(-25*time.Hour))
+			drainEvents()
+
+			Expect(backups.Check(context.Background(), now)).To(Succeed())
+		})
+
+		It("starts a backup of the instance", func() {
+			jobs := listJobs()
+			Expect(jobs).To(HaveLen(1))
+			Expect(jobs[0].Labels).To(HaveKeyWithValue(broker.JobLabel, "nightly-instance"))
+			recorded := broker.Backups(getPVC("nightly-instance"))
+			Expect(recorded).To(HaveLen(1))
+			Expect(recorded[0].State).To(Equal(brokerapi.InProgress))
+		})
+
+		It("doesn't start another backup before the next run", func() {
+			Expect(backups.Check(context.Background(), now.Add(time.Minute))).To(Succeed())
+			Expect(listJobs()).To(HaveLen(1))
+		})
+
+		It("records successful backups", func() {
+			finishJob(listJobs()[0], batchv1.JobComplete)
+
+			Expect(backups.Check(context.Background(), now.Add(time.Minute))).To(Succeed())
+
+			backup := broker.Backups(getPVC("nightly-instance"))[0]
+			Expect(backup.State).To(Equal(brokerapi.Succeeded))
+			Expect(testutil.ToFloat64(brokerMetrics.BackupLastSuccess.WithLabelValues("nightly-instance", "nightly"))).To(Equal(float64(backup.Time.Unix())))
+			Expect(testutil.ToFloat64(brokerMetrics.ScheduledBackups.WithLabelValues(metrics.OutcomeSuccess))).To(Equal(1.0))
+			Expect(drainEvents()).To(ConsistOf(ContainSubstring("Normal BackedUp")))
+		})
+
+		It("reports failed backups", func() {
+			finishJob(listJobs()[0], batchv1.JobFailed)
+
+			Expect(backups.Check(context.Background(), now.Add(time.Minute))).To(Succeed())
+
+			Expect(testutil.ToFloat64(brokerMetrics.ScheduledBackups.WithLabelValues(metrics.OutcomeFailure))).To(Equal(1.0))
+			Expect(testutil.CollectAndCount(brokerMetrics.BackupLastSuccess)).To(Equal(0))
+			Expect(drainEvents()).To(ConsistOf(ContainSubstring("Warning BackupFailed")))
+		})
+	})
+
+	It("keeps the last success of all instances while checking", func() {
+		provision("nightly-instance", "nightly", now.Add(-25*time.Hour))
+		provision("other-nightly-instance", "nightly", now.Add(-25*time.Hour))
+		recordBackup("nightly-instance", 48*time.Hour, brokerapi.Succeeded)
+		recordBackup("other-nightly-instance", 48*time.Hour, brokerapi.Succeeded)
+		Expect(backups.Check(context.Background(), now)).To(Succeed())
+
+		var counts []int
+		kubeClient.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			counts = append(counts, testutil.CollectAndCount(brokerMetrics.BackupLastSuccess))
+			return false, nil, nil
+		})
+		Expect(backups.Check(context.Background(), now.Add(time.Minute))).To(Succeed())
+
+		Expect(counts).To(Equal([]int{2, 2}))
+	})
+
+	It("waits for the first run after an instance was created", func() {
+		provision("nightly-instance", "nightly", now)
+
+		Expect(backups.Check(context.Background(), now)).To(Succeed())
+		Expect(listJobs()).To(BeEmpty())
+	})
+
+	It("reports backups that can't be started once per run", func() {
+		provision("nightly-instance", "nightly", now.Add(-25*time.Hour))
+		drainEvents()
+		kubeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("quota exceeded")
+		})
+
+		Expect(backups.Check(context.Background(), now)).To(Succeed())
+		Expect(backups.Check(context.Background(), now.Add(time.Minute))).To(Succeed())
+
+		Expect(drainEvents()).To(ConsistOf(And(ContainSubstring("Warning ScheduledBackupFailed"), ContainSubstring("quota exceeded"))))
+		Expect(testutil.ToFloat64(brokerMetrics.ScheduledBackups.WithLabelValues(metrics.OutcomeFailure))).To(Equal(1.0))
+	})
+
+	It("deletes backups beyond the retention count", func() {
+		provision("retained-instance", "retained", now.Add(-10*24*time.Hour))
+		oldest := recordBackup("retained-instance", 5*24*time.Hour, brokerapi.Succeeded)
+		failed := recordBackup("retained-instance", 4*24*time.Hour, brokerapi.Failed)
+		recordBackup("retained-instance", 3*24*time.Hour, brokerapi.Succeeded)
+		recordBackup("retained-instance", 2*24*time.Hour, brokerapi.Succeeded)
+		recordBackup("retained-instance", 24*time.Hour, brokerapi.Failed)
+
+		Expect(backups.Check(context.Background(), now)).To(Succeed())
+
+		Expect(deleted).To(ConsistOf("/backups/retained-instance/"+oldest+".tar.gz", "/backups/retained-instance/"+failed+".tar.gz"))
+		Expect(broker.Backups(getPVC("retained-instance"))).To(HaveLen(3))
+	})
+
+	It("keeps the latest successful backup regardless of its age", func() {
+		provision("young-instance", "young", now.Add(-10*24*time.Hour))
+		old := recordBackup("young-instance", 3*time.Hour, brokerapi.Succeeded)
+		latest := recordBackup("young-instance", 2*time.Hour, brokerapi.Succeeded)
+
+		Expect(backups.Check(context.Background(), now)).To(Succeed())
+
+		Expect(deleted).To(ConsistOf("/backups/young-instance/" + old + ".tar.gz"))
+		recorded := broker.Backups(getPVC("young-instance"))
+		Expect(recorded).To(HaveLen(1))
+		Expect(recorded[0].ID).To(Equal(latest))
+	})
+})
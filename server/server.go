@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+)
+
+// DefaultShutdownTimeout is used when no shutdown timeout is configured
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Server runs the broker HTTP server and its background workers. When the
+// context passed to Run is cancelled, it stops accepting connections and
+// waits for in-flight requests and workers to finish.
+type Server struct {
+	HTTPServer      *http.Server
+	ShutdownTimeout time.Duration
+	Logger          lager.Logger
+
+	workers sync.WaitGroup
+}
+
+// Go starts a background worker. The worker must return once ctx is
+// cancelled; Run waits for it during shutdown.
+func (s *Server) Go(ctx context.Context, worker func(context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		worker(ctx)
+	}()
+}
+
+// Run listens on the configured address and serves until ctx is cancelled
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.HTTPServer.Addr)
+	if err != nil {
+		return errors.Wrap(err, "error listening")
+	}
+
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves on listener until ctx is cancelled, then shuts down gracefully.
+// It returns an error if in-flight requests or workers did not finish within
+// the shutdown timeout. The connections of requests still in flight then are
+// closed.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.HTTPServer.TLSConfig != nil {
+			// The certificate is provided by the TLS config so it can be reloaded
+			serveErr <- s.HTTPServer.ServeTLS(listener, "", "")
+		} else {
+			serveErr <- s.HTTPServer.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return errors.Wrap(err, "error serving")
+	case <-ctx.Done():
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s.Logger.Info("shutdown-started", lager.Data{"timeout": timeout.String()})
+
+	if err := s.HTTPServer.Shutdown(shutdownCtx); err != nil {
+		// Close the connections of the requests that are still running
+		if closeErr := s.HTTPServer.Close(); closeErr != nil {
+			s.Logger.Error("close", closeErr)
+		}
+		return errors.Wrap(err, "error draining in-flight requests")
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		return errors.Wrap(shutdownCtx.Err(), "error waiting for background workers")
+	}
+
+	s.Logger.Info("shutdown-completed")
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package server_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/eirini-persi-broker/server"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		srv      *server.Server
+		listener net.Listener
+		ctx      context.Context
+		cancel   context.CancelFunc
+		started  chan struct{}
+		release  chan struct{}
+		unblock  func()
+		runErr   chan error
+	)
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		// Handlers and workers may outlive their spec, so they use the
+		// channels of their own spec
+		handlerStarted := make(chan struct{})
+		handlerRelease := make(chan struct{})
+		var once sync.Once
+		started, release = handlerStarted, handlerRelease
+		unblock = func() { once.Do(func() { close(handlerRelease) }) }
+
+		srv = &server.Server{
+			HTTPServer: &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					close(handlerStarted)
+					<-handlerRelease
+					w.Write([]byte("done"))
+				}),
+			},
+			ShutdownTimeout: time.Second,
+			Logger:          lagertest.NewTestLogger("server"),
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+		runErr = make(chan error, 1)
+	})
+
+	AfterEach(func() {
+		cancel()
+		unblock()
+	})
+
+	serve := func() {
+		srv, ctx, listener, runErr := srv, ctx, listener, runErr
+		go func() {
+			runErr <- srv.Serve(ctx, listener)
+		}()
+	}
+
+	It("finishes in-flight requests before returning", func() {
+		serve()
+
+		response := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			resp, err := http.Get("http://" + listener.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			body, _ := ioutil.ReadAll(resp.Body)
+			response <- string(body)
+		}()
+
+		Eventually(started).Should(BeClosed())
+		cancel()
+		Consistently(runErr, 100*time.Millisecond).ShouldNot(Receive())
+
+		unblock()
+		Eventually(response).Should(Receive(Equal("done")))
+		Eventually(runErr).Should(Receive(BeNil()))
+	})
+
+	It("stops accepting new connections", func() {
+		serve()
+		cancel()
+		Eventually(runErr).Should(Receive(BeNil()))
+
+		_, err := http.Get("http://" + listener.Addr().String())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error and closes connections when in-flight requests exceed the shutdown timeout", func() {
+		srv.ShutdownTimeout = 50 * time.Millisecond
+		serve()
+
+		getErr := make(chan error, 1)
+		go func() {
+			_, err := http.Get("http://" + listener.Addr().String())
+			getErr <- err
+		}()
+		Eventually(started).Should(BeClosed())
+		cancel()
+
+		Eventually(runErr).Should(Receive(MatchError(ContainSubstring("draining in-flight requests"))))
+		Eventually(getErr).Should(Receive(HaveOccurred()))
+	})
+
+	It("waits for background workers", func() {
+		workerDone := make(chan struct{})
+		release := release
+		srv.Go(ctx, func(ctx context.Context) {
+			<-ctx.Done()
+			<-release
+			close(workerDone)
+		})
+		serve()
+		cancel()
+
+		Consistently(runErr, 100*time.Millisecond).ShouldNot(Receive())
+		unblock()
+
+		Eventually(runErr).Should(Receive(BeNil()))
+		Expect(workerDone).To(BeClosed())
+	})
+
+	It("returns an error when workers exceed the shutdown timeout", func() {
+		srv.ShutdownTimeout = 50 * time.Millisecond
+		release := release
+		srv.Go(ctx, func(ctx context.Context) {
+			<-release
+		})
+		serve()
+		cancel()
+
+		Eventually(runErr).Should(Receive(MatchError(ContainSubstring("background workers"))))
+	})
+})
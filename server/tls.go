@@ -0,0 +1,199 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pkg/errors"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig builds the TLS configuration for the broker API. The server
+// certificate is reloaded from disk whenever the certificate or key file
+// changes. If a client CA bundle is configured, clients must present a
+// certificate signed by it.
+func NewTLSConfig(c config.TLSConfiguration, logger lager.Logger) (*tls.Config, error) {
+	if c.KeyFile == "" {
+		return nil, errors.New("tls key_file required when cert_file is set")
+	}
+
+	reloader, err := NewCertificateReloader(c.CertFile, c.KeyFile, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	minVersion, err := parseTLSVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherSuites, err := parseCipherSuites(c.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading client ca file")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in client ca file %s", c.ClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// CertificateReloader serves a certificate key pair loaded from disk and
+// reloads it when either file has been modified.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	logger   lager.Logger
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertificateReloader loads the key pair and returns a reloader for it
+func NewCertificateReloader(certFile, keyFile string, logger lager.Logger) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate. If the files on disk
+// changed since the last load, the key pair is reloaded first. When reloading
+// fails, the previous certificate continues to be served until the files
+// change again.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	modTime, err := r.latestModTime()
+	if err == nil && r.changedSince(modTime) {
+		if err := r.load(modTime); err != nil {
+			r.logger.Error("reload-certificate", err, lager.Data{"cert-file": r.certFile})
+			r.mutex.Lock()
+			r.modTime = modTime
+			r.mutex.Unlock()
+		} else {
+			r.logger.Info("reloaded-certificate", lager.Data{"cert-file": r.certFile})
+		}
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.cert, nil
+}
+
+func (r *CertificateReloader) changedSince(modTime time.Time) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return !modTime.Equal(r.modTime)
+}
+
+func (r *CertificateReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "error loading tls key pair")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+func (r *CertificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, errors.Wrap(err, "error reading tls key pair")
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, errors.Errorf("unsupported tls min_version %q", version)
+	}
+
+	return v, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, errors.Errorf("unsupported tls cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,177 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/server"
+)
+
+func writeKeyPair(dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).To(Succeed())
+	Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)).To(Succeed())
+
+	return certFile, keyFile
+}
+
+func commonName(cert *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	Expect(err).NotTo(HaveOccurred())
+	return parsed.Subject.CommonName
+}
+
+var _ = Describe("TLS", func() {
+	var (
+		dir      string
+		certFile string
+		keyFile  string
+		logger   *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "broker-tls")
+		Expect(err).NotTo(HaveOccurred())
+
+		certFile, keyFile = writeKeyPair(dir, "first")
+		logger = lagertest.NewTestLogger("tls")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("NewTLSConfig", func() {
+		It("defaults to TLS 1.2", func() {
+			tlsConfig, err := server.NewTLSConfig(config.TLSConfiguration{
+				CertFile: certFile,
+				KeyFile:  keyFile,
+			}, logger)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tlsConfig.MinVersion).To(Equal(uint16(tls.VersionTLS12)))
+			Expect(tlsConfig.CipherSuites).To(BeNil())
+			Expect(tlsConfig.ClientAuth).To(Equal(tls.NoClientCert))
+		})
+
+		It("uses the configured min version and cipher suites", func() {
+			tlsConfig, err := server.NewTLSConfig(config.TLSConfiguration{
+				CertFile:     certFile,
+				KeyFile:      keyFile,
+				MinVersion:   "1.3",
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			}, logger)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tlsConfig.MinVersion).To(Equal(uint16(tls.VersionTLS13)))
+			Expect(tlsConfig.CipherSuites).To(Equal([]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}))
+		})
+
+		It("requires client certificates when a client ca is configured", func() {
+			tlsConfig, err := server.NewTLSConfig(config.TLSConfiguration{
+				CertFile:     certFile,
+				KeyFile:      keyFile,
+				ClientCAFile: certFile,
+			}, logger)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tlsConfig.ClientAuth).To(Equal(tls.RequireAndVerifyClientCert))
+			Expect(tlsConfig.ClientCAs).NotTo(BeNil())
+		})
+
+		It("rejects an unknown min version", func() {
+			_, err := server.NewTLSConfig(config.TLSConfiguration{
+				CertFile:   certFile,
+				KeyFile:    keyFile,
+				MinVersion: "2.0",
+			}, logger)
+
+			Expect(err).To(MatchError(ContainSubstring("unsupported tls min_version")))
+		})
+
+		It("rejects an unknown cipher suite", func() {
+			_, err := server.NewTLSConfig(config.TLSConfiguration{
+				CertFile:     certFile,
+				KeyFile:      keyFile,
+				CipherSuites: []string{"TLS_NOT_A_CIPHER"},
+			}, logger)
+
+			Expect(err).To(MatchError(ContainSubstring("unsupported tls cipher suite")))
+		})
+
+		It("rejects a missing key file", func() {
+			_, err := server.NewTLSConfig(config.TLSConfiguration{
+				CertFile: certFile,
+			}, logger)
+
+			Expect(err).To(MatchError(ContainSubstring("key_file required")))
+		})
+	})
+
+	Describe("CertificateReloader", func() {
+		It("reloads the certificate when the files change", func() {
+			reloader, err := server.NewCertificateReloader(certFile, keyFile, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			cert, err := reloader.GetCertificate(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commonName(cert)).To(Equal("first"))
+
+			writeKeyPair(dir, "second")
+			later := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(certFile, later, later)).To(Succeed())
+
+			cert, err = reloader.GetCertificate(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commonName(cert)).To(Equal("second"))
+		})
+
+		It("keeps serving the previous certificate if reloading fails", func() {
+			reloader, err := server.NewCertificateReloader(certFile, keyFile, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(certFile, []byte("garbage"), 0600)).To(Succeed())
+			later := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(certFile, later, later)).To(Succeed())
+
+			cert, err := reloader.GetCertificate(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commonName(cert)).To(Equal("first"))
+		})
+	})
+})
@@ -0,0 +1,250 @@
+package transfer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Formats of the archives volumes are seeded from
+const (
+	FormatTar     = "tar"
+	FormatTarGzip = "tar.gz"
+	FormatZip     = "zip"
+)
+
+// Formats lists the supported archive formats
+var Formats = []string{FormatTar, FormatTarGzip, FormatZip}
+
+// FormatOf guesses the format of an archive from its name
+func FormatOf(name string) (string, error) {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGzip, nil
+	case strings.HasSuffix(name, ".tar"):
+		return FormatTar, nil
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip, nil
+	}
+
+	return "", errors.Errorf("can't tell the archive format of %s, formats are %s", name, strings.Join(Formats, ", "))
+}
+
+// Extract unpacks the archive read from r into dir. Entries are never
+// written outside of dir, not even through symbolic links.
+func Extract(r io.Reader, format, dir string) error {
+	switch format {
+	case FormatTar:
+		return extractTar(r, dir)
+	case FormatTarGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Wrap(err, "error reading gzip archive")
+		}
+		defer gz.Close()
+		return extractTar(gz, dir)
+	case FormatZip:
+		return extractZip(r, dir)
+	}
+
+	return errors.Errorf("unknown archive format %s, formats are %s", format, strings.Join(Formats, ", "))
+}
+
+func extractTar(r io.Reader, dir string) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "error reading tar archive")
+		}
+
+		mode := header.FileInfo().Mode()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = writeDir(dir, header.Name, mode)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(dir, header.Name, mode, archive)
+		case tar.TypeSymlink:
+			err = writeSymlink(dir, header.Name, header.Linkname)
+		default:
+			// Devices, pipes and hard links have no place on a volume
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip spools the archive to a temporary file first, as zip archives
+// are read from their end
+func extractZip(r io.Reader, dir string) error {
+	spool, err := ioutil.TempFile("", "seed-*.zip")
+	if err != nil {
+		return errors.Wrap(err, "error creating temporary file")
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, r)
+	if err != nil {
+		return errors.Wrap(err, "error downloading zip archive")
+	}
+
+	archive, err := zip.NewReader(spool, size)
+	if err != nil {
+		return errors.Wrap(err, "error reading zip archive")
+	}
+
+	for _, file := range archive.File {
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			err = writeDir(dir, file.Name, mode)
+		case mode.IsRegular():
+			err = extractZipFile(dir, file)
+		case mode&os.ModeSymlink != 0:
+			err = extractZipSymlink(dir, file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractZipFile(dir string, file *zip.File) error {
+	content, err := file.Open()
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", file.Name)
+	}
+	defer content.Close()
+
+	return writeFile(dir, file.Name, file.Mode(), content)
+}
+
+func extractZipSymlink(dir string, file *zip.File) error {
+	content, err := file.Open()
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", file.Name)
+	}
+	defer content.Close()
+
+	target, err := ioutil.ReadAll(io.LimitReader(content, 4096))
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", file.Name)
+	}
+
+	return writeSymlink(dir, file.Name, string(target))
+}
+
+func writeDir(dir, name string, mode os.FileMode) error {
+	target, err := entryPath(dir, name)
+	if err != nil || target == dir {
+		return err
+	}
+
+	if err := os.MkdirAll(target, mode.Perm()|0700); err != nil {
+		return errors.Wrapf(err, "error creating directory %s", name)
+	}
+	return nil
+}
+
+func writeFile(dir, name string, mode os.FileMode, content io.Reader) error {
+	target, err := entryPath(dir, name)
+	if err != nil || target == dir {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return errors.Wrapf(err, "error creating directory of %s", name)
+	}
+	// Replace rather than write through existing symbolic links
+	if err := removeSymlink(target); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return errors.Wrapf(err, "error creating %s", name)
+	}
+
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrapf(err, "error writing %s", name)
+}
+
+func writeSymlink(dir, name, linkname string) error {
+	target, err := entryPath(dir, name)
+	if err != nil || target == dir {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return errors.Wrapf(err, "error creating directory of %s", name)
+	}
+	if err := removeSymlink(target); err != nil {
+		return err
+	}
+
+	return errors.Wrapf(os.Symlink(linkname, target), "error creating symbolic link %s", name)
+}
+
+// entryPath returns where the entry name of an archive is extracted to. The
+// directories on the way must not be symbolic links, so nothing is written
+// outside of dir.
+func entryPath(dir, name string) (string, error) {
+	clean := path.Clean("/" + filepath.ToSlash(name))
+	if clean == "/" {
+		return dir, nil
+	}
+
+	current := dir
+	parts := strings.Split(strings.TrimPrefix(clean, "/"), "/")
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "error checking %s", name)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", errors.Errorf("%s leads through a symbolic link", name)
+		}
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+func removeSymlink(name string) error {
+	info, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error checking %s", name)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	return errors.Wrapf(os.Remove(name), "error replacing symbolic link %s", name)
+}
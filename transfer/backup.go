@@ -0,0 +1,207 @@
+package transfer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
+)
+
+// lostAndFound is created by mkfs on the root of ext volumes. It's neither
+// backed up nor replaced on restore.
+const lostAndFound = "lost+found"
+
+// Directories on the root of a volume holding the extracted archive and the
+// previous contents while a backup is restored
+const (
+	restoreStaging  = ".persi-restore"
+	restorePrevious = ".persi-restore-previous"
+)
+
+// Archive writes the contents of dir to w as gzipped tar archive. Devices,
+// pipes and sockets are left out.
+func Archive(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s", name)
+		}
+
+		relative, err := filepath.Rel(dir, name)
+		if err != nil {
+			return errors.Wrapf(err, "error archiving %s", name)
+		}
+		if relative == "." {
+			return nil
+		}
+		if isReserved(relative) && info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return archiveEntry(archive, name, filepath.ToSlash(relative), info)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return errors.Wrap(err, "error writing tar archive")
+	}
+	return errors.Wrap(gz.Close(), "error writing gzip archive")
+}
+
+func archiveEntry(archive *tar.Writer, name, relative string, info os.FileInfo) error {
+	var linkname string
+	switch mode := info.Mode(); {
+	case mode.IsDir(), mode.IsRegular():
+	case mode&os.ModeSymlink != 0:
+		var err error
+		if linkname, err = os.Readlink(name); err != nil {
+			return errors.Wrapf(err, "error reading symbolic link %s", relative)
+		}
+	default:
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(info, linkname)
+	if err != nil {
+		return errors.Wrapf(err, "error archiving %s", relative)
+	}
+	header.Name = relative
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := archive.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "error archiving %s", relative)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s", relative)
+	}
+	defer file.Close()
+
+	// Files growing while they are archived are cut at their size in header
+	_, err = io.CopyN(archive, file, header.Size)
+	return errors.Wrapf(err, "error archiving %s", relative)
+}
+
+// Backup streams the contents of dir as gzipped tar archive to the object key
+// in store, with the given user metadata
+func Backup(ctx context.Context, store objectstore.Config, key, dir string, metadata map[string]string, client *http.Client) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(Archive(writer, dir))
+	}()
+
+	err := (&objectstore.Client{Config: store, HTTPClient: client}).Put(ctx, key, reader, metadata)
+	// Stop archiving if the upload failed
+	reader.CloseWithError(errors.New("upload stopped"))
+
+	return err
+}
+
+// Restore replaces the contents of dir with the gzipped tar archive stored
+// as object key in store. The archive is extracted into a staging directory
+// next to the contents first, so they're only replaced once it's complete.
+func Restore(ctx context.Context, store objectstore.Config, key, dir string, client *http.Client) error {
+	archive, err := (&objectstore.Client{Config: store, HTTPClient: client}).Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	// A failed restore may have left its staging directory behind
+	staging := filepath.Join(dir, restoreStaging)
+	if err := os.RemoveAll(staging); err != nil {
+		return errors.Wrap(err, "error removing staging directory")
+	}
+	if err := os.Mkdir(staging, 0755); err != nil {
+		return errors.Wrap(err, "error creating staging directory")
+	}
+
+	if err := Extract(archive, FormatTarGzip, staging); err != nil {
+		_ = os.RemoveAll(staging)
+		return err
+	}
+
+	return swapDir(dir, staging)
+}
+
+// swapDir replaces the contents of dir with those of staging, a directory
+// in dir. Entries are only renamed, so the contents of dir are mixed only if
+// the restore is killed while swapping. The previous contents are moved
+// aside first and kept by a failed swap; a restore started again replaces
+// them as well.
+func swapDir(dir, staging string) error {
+	previous := filepath.Join(dir, restorePrevious)
+	if err := os.MkdirAll(previous, 0700); err != nil {
+		return errors.Wrap(err, "error creating directory for the previous contents")
+	}
+
+	entries, err := restoredEntries(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// Left behind by an earlier swap, and replaced anyway
+		if err := os.RemoveAll(filepath.Join(previous, entry)); err != nil {
+			return errors.Wrapf(err, "error removing previous %s", entry)
+		}
+		if err := os.Rename(filepath.Join(dir, entry), filepath.Join(previous, entry)); err != nil {
+			return errors.Wrapf(err, "error moving %s aside", entry)
+		}
+	}
+
+	entries, err = restoredEntries(staging)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(staging, entry), filepath.Join(dir, entry)); err != nil {
+			return errors.Wrapf(err, "error restoring %s", entry)
+		}
+	}
+
+	if err := os.RemoveAll(staging); err != nil {
+		return errors.Wrap(err, "error removing staging directory")
+	}
+	return errors.Wrap(os.RemoveAll(previous), "error removing previous contents")
+}
+
+// restoredEntries returns the names of the entries of dir a restore replaces
+func restoredEntries(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", dir)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !isReserved(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// isReserved returns true for the entries on the root of a volume that are
+// neither backed up nor restored
+func isReserved(name string) bool {
+	return name == lostAndFound || name == restoreStaging || name == restorePrevious
+}
@@ -0,0 +1,127 @@
+package transfer
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
+)
+
+// Environment variables the source of a seed is passed to jobs in. Objects
+// are located with the object store variables and SeedKeyEnv.
+const (
+	SeedURLEnv = "PERSI_SEED_URL"
+	SeedKeyEnv = "PERSI_SEED_KEY"
+)
+
+// Source is the archive a volume is seeded from. It is downloaded from URL
+// if set, otherwise it's the object Key in Store.
+type Source struct {
+	URL   string
+	Store objectstore.Config
+	Key   string
+}
+
+// SourceFromEnv reads a Source from the environment variables getenv returns
+func SourceFromEnv(getenv func(string) string) Source {
+	return Source{
+		URL:   getenv(SeedURLEnv),
+		Store: objectstore.ConfigFromEnv(getenv),
+		Key:   getenv(SeedKeyEnv),
+	}
+}
+
+// Env returns the environment variables holding s
+func (s Source) Env() map[string]string {
+	if s.URL != "" {
+		return map[string]string{SeedURLEnv: s.URL}
+	}
+
+	env := s.Store.Env()
+	env[SeedKeyEnv] = s.Key
+	return env
+}
+
+// String describes s without the credentials it may contain
+func (s Source) String() string {
+	if s.URL == "" {
+		return "s3://" + s.Store.Bucket + "/" + s.Key
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "invalid URL"
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
+
+// Name returns the file name of the archive
+func (s Source) Name() string {
+	if s.URL == "" {
+		return path.Base(s.Key)
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return ""
+	}
+	return path.Base(u.Path)
+}
+
+// Seed downloads the archive at source and extracts it into dir. The format
+// is guessed from the name of the archive if it's empty.
+func Seed(ctx context.Context, source Source, format, dir string, client *http.Client) error {
+	if format == "" {
+		var err error
+		if format, err = FormatOf(source.Name()); err != nil {
+			return err
+		}
+	}
+
+	archive, err := open(ctx, source, client)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	return Extract(archive, format, dir)
+}
+
+// open returns the content of the archive at source
+func open(ctx context.Context, source Source, client *http.Client) (io.ReadCloser, error) {
+	if source.URL == "" {
+		store := &objectstore.Client{Config: source.Store, HTTPClient: client}
+		return store.Get(ctx, source.Key)
+	}
+
+	request, err := http.NewRequest(http.MethodGet, source.URL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid URL %s", source)
+	}
+	request = request.WithContext(ctx)
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		// Errors of the client contain the full URL
+		return nil, errors.Errorf("error downloading %s: %s", source, strings.Replace(err.Error(), source.URL, source.String(), -1))
+	}
+
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return nil, errors.Errorf("error downloading %s: %s %s", source, response.Status, strings.TrimSpace(string(message)))
+	}
+
+	return response.Body, nil
+}
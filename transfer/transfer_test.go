@@ -0,0 +1,324 @@
+package transfer_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/eirini-persi-broker/objectstore"
+	"code.cloudfoundry.org/eirini-persi-broker/transfer"
+)
+
+type entry struct {
+	name     string
+	content  string
+	linkname string
+}
+
+func tarArchive(entries ...entry) []byte {
+	var buffer bytes.Buffer
+	archive := tar.NewWriter(&buffer)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		switch {
+		case e.linkname != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.linkname, 0
+		case e.name[len(e.name)-1] == '/':
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+		Expect(archive.WriteHeader(header)).To(Succeed())
+		_, err := archive.Write([]byte(e.content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(archive.Close()).To(Succeed())
+	return buffer.Bytes()
+}
+
+func gzipped(content []byte) []byte {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	_, err := gz.Write(content)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(gz.Close()).To(Succeed())
+	return buffer.Bytes()
+}
+
+func zipArchive(entries ...entry) []byte {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, e := range entries {
+		file, err := archive.Create(e.name)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.Write([]byte(e.content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(archive.Close()).To(Succeed())
+	return buffer.Bytes()
+}
+
+// memoryStore is an in-memory stand-in for an S3 compatible object store
+// that takes multipart uploads
+type memoryStore struct {
+	mutex   sync.Mutex
+	objects map[string]string
+	parts   map[string][]string
+}
+
+func (s *memoryStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		s.parts[r.URL.Path] = nil
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", r.URL.Path)
+	case r.Method == http.MethodPut:
+		part, _ := ioutil.ReadAll(r.Body)
+		s.parts[r.URL.Path] = append(s.parts[r.URL.Path], string(part))
+	case r.Method == http.MethodPost:
+		s.objects[r.URL.Path] = strings.Join(s.parts[r.URL.Path], "")
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodGet:
+		content, ok := s.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	default:
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+var _ = Describe("Transfer", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "transfer")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	readFile := func(name string) string {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		Expect(err).NotTo(HaveOccurred())
+		return string(content)
+	}
+
+	Describe("FormatOf", func() {
+		It("guesses formats from file names", func() {
+			for name, format := range map[string]string{
+				"data.tar":    transfer.FormatTar,
+				"data.tar.gz": transfer.FormatTarGzip,
+				"DATA.TGZ":    transfer.FormatTarGzip,
+				"data.zip":    transfer.FormatZip,
+			} {
+				Expect(transfer.FormatOf(name)).To(Equal(format), name)
+			}
+
+			_, err := transfer.FormatOf("data.rar")
+			Expect(err).To(MatchError(ContainSubstring("formats are tar, tar.gz, zip")))
+		})
+	})
+
+	Describe("Extract", func() {
+		It("extracts tar archives", func() {
+			archive := tarArchive(entry{name: "reference/"}, entry{name: "reference/cities.csv", content: "Berlin"}, entry{name: "latest", linkname: "reference/cities.csv"})
+
+			Expect(transfer.Extract(bytes.NewReader(archive), transfer.FormatTar, dir)).To(Succeed())
+
+			Expect(readFile("reference/cities.csv")).To(Equal("Berlin"))
+			Expect(os.Readlink(filepath.Join(dir, "latest"))).To(Equal("reference/cities.csv"))
+		})
+
+		It("extracts gzipped tar archives", func() {
+			archive := gzipped(tarArchive(entry{name: "cities.csv", content: "Nuremberg"}))
+
+			Expect(transfer.Extract(bytes.NewReader(archive), transfer.FormatTarGzip, dir)).To(Succeed())
+			Expect(readFile("cities.csv")).To(Equal("Nuremberg"))
+		})
+
+		It("extracts zip archives", func() {
+			archive := zipArchive(entry{name: "reference/cities.csv", content: "Hamburg"})
+
+			Expect(transfer.Extract(bytes.NewReader(archive), transfer.FormatZip, dir)).To(Succeed())
+			Expect(readFile("reference/cities.csv")).To(Equal("Hamburg"))
+		})
+
+		It("keeps entries inside the directory", func() {
+			archive := tarArchive(entry{name: "../../escaped.txt", content: "nope"})
+
+			Expect(transfer.Extract(bytes.NewReader(archive), transfer.FormatTar, dir)).To(Succeed())
+			Expect(readFile("escaped.txt")).To(Equal("nope"))
+		})
+
+		It("doesn't write through symbolic links", func() {
+			outside, err := ioutil.TempDir("", "outside")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(outside)
+
+			archive := tarArchive(entry{name: "link", linkname: outside}, entry{name: "link/file.txt", content: "nope"})
+
+			err = transfer.Extract(bytes.NewReader(archive), transfer.FormatTar, dir)
+			Expect(err).To(MatchError("link/file.txt leads through a symbolic link"))
+			Expect(filepath.Join(outside, "file.txt")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("Archive", func() {
+		It("archives directories", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "reference"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "reference", "cities.csv"), []byte("Leipzig"), 0644)).To(Succeed())
+			Expect(os.Symlink("reference/cities.csv", filepath.Join(dir, "latest"))).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(dir, "lost+found"), 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "lost+found", "#12"), []byte("fsck"), 0644)).To(Succeed())
+
+			var archive bytes.Buffer
+			Expect(transfer.Archive(&archive, dir)).To(Succeed())
+
+			extracted, err := ioutil.TempDir("", "extracted")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(extracted)
+
+			Expect(transfer.Extract(&archive, transfer.FormatTarGzip, extracted)).To(Succeed())
+			Expect(ioutil.ReadFile(filepath.Join(extracted, "reference", "cities.csv"))).To(Equal([]byte("Leipzig")))
+			Expect(os.Readlink(filepath.Join(extracted, "latest"))).To(Equal("reference/cities.csv"))
+			Expect(filepath.Join(extracted, "lost+found")).NotTo(BeADirectory())
+		})
+	})
+
+	Describe("Backup and Restore", func() {
+		var (
+			store      *memoryStore
+			httpServer *httptest.Server
+			config     objectstore.Config
+		)
+
+		BeforeEach(func() {
+			store = &memoryStore{objects: map[string]string{}, parts: map[string][]string{}}
+			httpServer = httptest.NewServer(store)
+			config = objectstore.Config{Endpoint: httpServer.URL, Bucket: "backups", AccessKeyID: "key", SecretAccessKey: "secret"}
+		})
+
+		AfterEach(func() {
+			httpServer.Close()
+		})
+
+		It("restores backups in place of the contents", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "cities.csv"), []byte("Cologne"), 0644)).To(Succeed())
+
+			Expect(transfer.Backup(context.Background(), config, "instance/1.tar.gz", dir, nil, nil)).To(Succeed())
+			Expect(store.objects).To(HaveKey("/backups/instance/1.tar.gz"))
+
+			Expect(ioutil.WriteFile(filepath.Join(dir, "cities.csv"), []byte("Bonn"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "later.csv"), []byte("Dortmund"), 0644)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(dir, "lost+found"), 0700)).To(Succeed())
+
+			Expect(transfer.Restore(context.Background(), config, "instance/1.tar.gz", dir, nil)).To(Succeed())
+			Expect(readFile("cities.csv")).To(Equal("Cologne"))
+			Expect(filepath.Join(dir, "later.csv")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(dir, "lost+found")).To(BeADirectory())
+
+			entries, err := ioutil.ReadDir(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+		})
+
+		It("keeps the contents if the backup can't be extracted", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "cities.csv"), []byte("Aachen"), 0644)).To(Succeed())
+			store.objects["/backups/instance/3.tar.gz"] = "not an archive"
+
+			err := transfer.Restore(context.Background(), config, "instance/3.tar.gz", dir, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(readFile("cities.csv")).To(Equal("Aachen"))
+
+			entries, err := ioutil.ReadDir(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+
+		It("keeps the contents if the backup is missing", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "cities.csv"), []byte("Essen"), 0644)).To(Succeed())
+
+			err := transfer.Restore(context.Background(), config, "instance/2.tar.gz", dir, nil)
+			Expect(objectstore.IsNotFound(err)).To(BeTrue())
+			Expect(readFile("cities.csv")).To(Equal("Essen"))
+		})
+	})
+
+	Describe("Seed", func() {
+		var httpServer *httptest.Server
+
+		BeforeEach(func() {
+			archive := gzipped(tarArchive(entry{name: "cities.csv", content: "Munich"}))
+			httpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/data.tar.gz" && r.URL.Query().Get("token") == "secret":
+					_, _ = w.Write(archive)
+				case r.URL.Path == "/bucket/seeds/data.tar.gz" && r.Header.Get("Authorization") != "":
+					_, _ = w.Write(archive)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+		})
+
+		AfterEach(func() {
+			httpServer.Close()
+		})
+
+		It("seeds from URLs", func() {
+			source := transfer.Source{URL: httpServer.URL + "/data.tar.gz?token=secret"}
+
+			Expect(transfer.Seed(context.Background(), source, "", dir, nil)).To(Succeed())
+			Expect(readFile("cities.csv")).To(Equal("Munich"))
+		})
+
+		It("seeds from objects", func() {
+			source := transfer.Source{
+				Store: objectstore.Config{Endpoint: httpServer.URL, Bucket: "bucket", AccessKeyID: "key", SecretAccessKey: "secret"},
+				Key:   "seeds/data.tar.gz",
+			}
+
+			Expect(transfer.Seed(context.Background(), source, "", dir, nil)).To(Succeed())
+			Expect(readFile("cities.csv")).To(Equal("Munich"))
+		})
+
+		It("reports failed downloads without credentials", func() {
+			source := transfer.Source{URL: httpServer.URL + "/missing.tar?token=secret"}
+
+			err := transfer.Seed(context.Background(), source, "", dir, nil)
+			Expect(err).To(MatchError(ContainSubstring("404 Not Found")))
+			Expect(err.Error()).NotTo(ContainSubstring("secret"))
+		})
+
+		It("passes sources through the environment", func() {
+			source := transfer.Source{
+				Store: objectstore.Config{Endpoint: "https://s3.example.com", Bucket: "bucket", AccessKeyID: "key", SecretAccessKey: "secret"},
+				Key:   "seeds/data.tar.gz",
+			}
+
+			env := source.Env()
+			Expect(transfer.SourceFromEnv(func(name string) string { return env[name] })).To(Equal(source))
+			Expect(source.String()).To(Equal("s3://bucket/seeds/data.tar.gz"))
+		})
+	})
+})
@@ -0,0 +1,141 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ClaimIndex is the name of the pod index by the claims running pods mount
+const ClaimIndex = "claim"
+
+// KubeletSource reads volume usage from the summary API of the kubelet
+// running a pod that mounts the claim, through the API server's node proxy.
+// Pods are looked up in the Pods index by ClaimIndex, or listed from the API
+// server if there's no index.
+type KubeletSource struct {
+	KubeClient kubernetes.Interface
+	Pods       cache.Indexer
+}
+
+// IndexPodsByClaim adds ClaimIndex to a pod informer, so it can serve as the
+// Pods of a KubeletSource
+func IndexPodsByClaim(informer cache.SharedIndexInformer) error {
+	return informer.AddIndexers(cache.Indexers{ClaimIndex: podClaims})
+}
+
+// podClaims returns the claims mounted by a pod if it's running on a node
+func podClaims(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" || pod.Status.Phase != corev1.PodRunning {
+		return nil, nil
+	}
+
+	var claims []string
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			claims = append(claims, volume.PersistentVolumeClaim.ClaimName)
+		}
+	}
+
+	return claims, nil
+}
+
+// summary is the part of the kubelet stats summary holding volume usage
+type summary struct {
+	Pods []struct {
+		Volumes []struct {
+			PVCRef *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef"`
+			UsedBytes      *int64 `json:"usedBytes"`
+			AvailableBytes *int64 `json:"availableBytes"`
+			CapacityBytes  *int64 `json:"capacityBytes"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+// Usage implements Source
+func (s *KubeletSource) Usage(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*Usage, error) {
+	nodeName, err := s.mountingNode(ctx, pvc)
+	if err != nil || nodeName == "" {
+		return nil, err
+	}
+
+	body, err := s.KubeClient.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/nodes", nodeName, "proxy", "stats", "summary").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting stats summary of node %s", nodeName)
+	}
+
+	var stats summary
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return nil, errors.Wrapf(err, "error unmarshaling stats summary of node %s", nodeName)
+	}
+
+	for _, pod := range stats.Pods {
+		for _, volume := range pod.Volumes {
+			if volume.PVCRef == nil || volume.PVCRef.Name != pvc.Name || volume.PVCRef.Namespace != pvc.Namespace {
+				continue
+			}
+			if volume.UsedBytes == nil || volume.AvailableBytes == nil {
+				continue
+			}
+
+			usage := &Usage{
+				UsedBytes:      *volume.UsedBytes,
+				AvailableBytes: *volume.AvailableBytes,
+			}
+			if volume.CapacityBytes != nil {
+				usage.CapacityBytes = *volume.CapacityBytes
+			}
+			return usage, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// mountingNode returns the node of a running pod that mounts pvc, or an
+// empty string if there is none
+func (s *KubeletSource) mountingNode(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (string, error) {
+	if s.Pods != nil {
+		objs, err := s.Pods.ByIndex(ClaimIndex, pvc.Name)
+		if err != nil {
+			return "", errors.Wrap(err, "error looking up pods by claim")
+		}
+		for _, obj := range objs {
+			if pod, ok := obj.(*corev1.Pod); ok && pod.Namespace == pvc.Namespace {
+				return pod.Spec.NodeName, nil
+			}
+		}
+		return "", nil
+	}
+
+	pods, err := s.KubeClient.CoreV1().Pods(pvc.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error listing pods")
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvc.Name {
+				return pod.Spec.NodeName, nil
+			}
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,70 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PrometheusSource reads volume usage from the kubelet_volume_stats metrics
+// scraped by a Prometheus server
+type PrometheusSource struct {
+	API promv1.API
+}
+
+// NewPrometheusSource creates a source querying the Prometheus server at address
+func NewPrometheusSource(address string) (*PrometheusSource, error) {
+	client, err := api.NewClient(api.Config{Address: address})
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating prometheus client")
+	}
+
+	return &PrometheusSource{API: promv1.NewAPI(client)}, nil
+}
+
+// Usage implements Source
+func (s *PrometheusSource) Usage(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*Usage, error) {
+	used, ok, err := s.query(ctx, "kubelet_volume_stats_used_bytes", pvc)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	available, ok, err := s.query(ctx, "kubelet_volume_stats_available_bytes", pvc)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	capacity, _, err := s.query(ctx, "kubelet_volume_stats_capacity_bytes", pvc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Usage{
+		UsedBytes:      used,
+		AvailableBytes: available,
+		CapacityBytes:  capacity,
+	}, nil
+}
+
+// query returns the latest value of metric for pvc and false if there is none
+func (s *PrometheusSource) query(ctx context.Context, metric string, pvc *corev1.PersistentVolumeClaim) (int64, bool, error) {
+	query := fmt.Sprintf(`max(%s{namespace=%q,persistentvolumeclaim=%q})`, metric, pvc.Namespace, pvc.Name)
+
+	value, _, err := s.API.Query(ctx, query, time.Now())
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "error querying %s", metric)
+	}
+
+	vector, ok := value.(model.Vector)
+	if !ok || len(vector) == 0 {
+		return 0, false, nil
+	}
+
+	return int64(vector[0].Value), true, nil
+}
@@ -0,0 +1,50 @@
+package usage
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+)
+
+// Sources of volume usage supported in the configuration
+const (
+	SourceKubelet    = "kubelet"
+	SourcePrometheus = "prometheus"
+	SourceNone       = "none"
+)
+
+// Usage is the space used on the volume of a persistent volume claim
+type Usage struct {
+	UsedBytes      int64 `json:"used_bytes"`
+	AvailableBytes int64 `json:"available_bytes"`
+	CapacityBytes  int64 `json:"capacity_bytes"`
+}
+
+// Source reports the volume usage of persistent volume claims. It returns
+// nil without an error if the usage of pvc isn't known, for example because
+// it isn't mounted by any pod.
+type Source interface {
+	Usage(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*Usage, error)
+}
+
+// NewSource creates the usage source selected in the configuration. It
+// returns nil if usage reporting is disabled.
+func NewSource(c config.UsageConfiguration, kubeClient kubernetes.Interface) (Source, error) {
+	switch c.Source {
+	case "", SourceKubelet:
+		return &KubeletSource{KubeClient: kubeClient}, nil
+	case SourcePrometheus:
+		if c.PrometheusURL == "" {
+			return nil, errors.New("prometheus_url is required for the prometheus usage source")
+		}
+		return NewPrometheusSource(c.PrometheusURL)
+	case SourceNone:
+		return nil, nil
+	default:
+		return nil, errors.Errorf("invalid usage source: %s", c.Source)
+	}
+}
@@ -0,0 +1,206 @@
+package usage_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	"code.cloudfoundry.org/eirini-persi-broker/config"
+	"code.cloudfoundry.org/eirini-persi-broker/usage"
+)
+
+var pvc = &corev1.PersistentVolumeClaim{
+	ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "eirini"},
+}
+
+var _ = Describe("KubeletSource", func() {
+	var (
+		pods   []corev1.Pod
+		listed bool
+		stats  string
+		server *httptest.Server
+		source *usage.KubeletSource
+	)
+
+	BeforeEach(func() {
+		pods = []corev1.Pod{{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "eirini"},
+			Spec: corev1.PodSpec{
+				NodeName: "node-1",
+				Volumes: []corev1.Volume{{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "instance"},
+					},
+				}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}}
+		listed = false
+		stats = `{"pods":[{"volume":[
+			{"name":"token"},
+			{"name":"data","pvcRef":{"name":"instance","namespace":"eirini"},"usedBytes":300,"availableBytes":700,"capacityBytes":1000}
+		]}]}`
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch req.URL.Path {
+			case "/api/v1/namespaces/eirini/pods":
+				Expect(req.URL.Query().Get("fieldSelector")).To(Equal("status.phase=Running"))
+				listed = true
+				Expect(json.NewEncoder(w).Encode(corev1.PodList{Items: pods})).To(Succeed())
+			case "/api/v1/nodes/node-1/proxy/stats/summary":
+				_, _ = w.Write([]byte(stats))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		source = &usage.KubeletSource{KubeClient: kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("reads the usage from the node of a pod mounting the claim", func() {
+		volumeUsage, err := source.Usage(context.Background(), pvc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumeUsage).To(Equal(&usage.Usage{UsedBytes: 300, AvailableBytes: 700, CapacityBytes: 1000}))
+	})
+
+	It("returns no usage when no pod mounts the claim", func() {
+		pods[0].Spec.Volumes = nil
+
+		volumeUsage, err := source.Usage(context.Background(), pvc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumeUsage).To(BeNil())
+	})
+
+	It("returns no usage when the kubelet doesn't report the volume", func() {
+		stats = `{"pods":[]}`
+
+		volumeUsage, err := source.Usage(context.Background(), pvc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumeUsage).To(BeNil())
+	})
+
+	It("fails when the summary can't be fetched", func() {
+		pods[0].Spec.NodeName = "node-2"
+
+		_, err := source.Usage(context.Background(), pvc)
+		Expect(err).To(MatchError(ContainSubstring("error getting stats summary of node node-2")))
+	})
+
+	Context("with a pod index", func() {
+		var podInformer cache.SharedIndexInformer
+
+		BeforeEach(func() {
+			podInformer = informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().Pods().Informer()
+			Expect(usage.IndexPodsByClaim(podInformer)).To(Succeed())
+			source.Pods = podInformer.GetIndexer()
+		})
+
+		It("looks up the pods mounting the claim in the index", func() {
+			Expect(source.Pods.Add(&pods[0])).To(Succeed())
+
+			volumeUsage, err := source.Usage(context.Background(), pvc)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volumeUsage).To(Equal(&usage.Usage{UsedBytes: 300, AvailableBytes: 700, CapacityBytes: 1000}))
+			Expect(listed).To(BeFalse())
+		})
+
+		It("ignores pods that aren't running", func() {
+			pods[0].Status.Phase = corev1.PodPending
+			Expect(source.Pods.Add(&pods[0])).To(Succeed())
+
+			volumeUsage, err := source.Usage(context.Background(), pvc)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volumeUsage).To(BeNil())
+			Expect(listed).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("PrometheusSource", func() {
+	var (
+		values map[string]string
+		server *httptest.Server
+		source *usage.PrometheusSource
+	)
+
+	BeforeEach(func() {
+		values = map[string]string{
+			"kubelet_volume_stats_used_bytes":      "300",
+			"kubelet_volume_stats_available_bytes": "700",
+			"kubelet_volume_stats_capacity_bytes":  "1000",
+		}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			Expect(req.ParseForm()).To(Succeed())
+			query := req.Form.Get("query")
+
+			result := "[]"
+			for metric, value := range values {
+				if query == `max(`+metric+`{namespace="eirini",persistentvolumeclaim="instance"})` {
+					result = `[{"metric":{},"value":[1600000000,"` + value + `"]}]`
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":` + result + `}}`))
+		}))
+
+		var err error
+		source, err = usage.NewPrometheusSource(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("queries the kubelet volume stats", func() {
+		volumeUsage, err := source.Usage(context.Background(), pvc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumeUsage).To(Equal(&usage.Usage{UsedBytes: 300, AvailableBytes: 700, CapacityBytes: 1000}))
+	})
+
+	It("returns no usage without metrics for the claim", func() {
+		delete(values, "kubelet_volume_stats_used_bytes")
+
+		volumeUsage, err := source.Usage(context.Background(), pvc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumeUsage).To(BeNil())
+	})
+})
+
+var _ = Describe("NewSource", func() {
+	It("defaults to the kubelet", func() {
+		source, err := usage.NewSource(config.UsageConfiguration{}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source).To(BeAssignableToTypeOf(&usage.KubeletSource{}))
+	})
+
+	It("requires an address for prometheus", func() {
+		_, err := usage.NewSource(config.UsageConfiguration{Source: usage.SourcePrometheus}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("can be disabled", func() {
+		source, err := usage.NewSource(config.UsageConfiguration{Source: usage.SourceNone}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(source).To(BeNil())
+	})
+})